@@ -0,0 +1,76 @@
+package gsprotocol
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+)
+
+// WithAllowedBuckets restricts the buckets that the Transport accesses to the ones matching patterns,
+// e.g. WithAllowedBuckets("assets", "team-a-*").
+// The patterns are the ones of path.Match.
+// The requests for the other buckets, including the sources of copies and the targets of symlinks, get 403 Forbidden
+// before calling Google Cloud Storage.
+// The patterns are matched against the bucket names resolved by WithBucketAlias.
+// If it is given more than once, the patterns are concatenated.
+// WithAllowedBuckets without patterns forbids all buckets.
+// WithAllowedBuckets in a BucketConfig is ignored.
+func WithAllowedBuckets(patterns ...string) Option {
+	return func(c *config) {
+		if c.allowedBuckets == nil {
+			c.allowedBuckets = []string{}
+		}
+		c.allowedBuckets = append(c.allowedBuckets, patterns...)
+	}
+}
+
+// WithDeniedBuckets forbids the Transport to access the buckets matching patterns,
+// in the same way as WithAllowedBuckets.
+// The denied buckets are forbidden even if they are allowed by WithAllowedBuckets.
+// WithDeniedBuckets in a BucketConfig is ignored.
+func WithDeniedBuckets(patterns ...string) Option {
+	return func(c *config) {
+		c.deniedBuckets = append(c.deniedBuckets, patterns...)
+	}
+}
+
+// bucketAccessError is returned if the access to the bucket is forbidden by WithAllowedBuckets or WithDeniedBuckets.
+type bucketAccessError struct {
+	bucket string
+	denied bool
+}
+
+func (err *bucketAccessError) Error() string {
+	if err.denied {
+		return fmt.Sprintf("gsprotocol: the access to the bucket %q is denied", err.bucket)
+	}
+	return fmt.Sprintf("gsprotocol: the access to the bucket %q is not allowed", err.bucket)
+}
+
+// checkBucketAccess returns a *bucketAccessError if the access to bucket is forbidden by WithAllowedBuckets or WithDeniedBuckets.
+func (c *config) checkBucketAccess(bucket string) error {
+	if matchBucket(c.deniedBuckets, bucket) {
+		return &bucketAccessError{bucket: bucket, denied: true}
+	}
+	if c.allowedBuckets != nil && !matchBucket(c.allowedBuckets, bucket) {
+		return &bucketAccessError{bucket: bucket}
+	}
+	return nil
+}
+
+// matchBucket reports whether bucket matches any of patterns.
+// The malformed patterns match nothing.
+func matchBucket(patterns []string, bucket string) bool {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, bucket); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+func newForbiddenResponse(err error) *http.Response {
+	resp := newErrorResponse(http.StatusForbidden, err.Error())
+	resp.Header.Set("x-gsprotocol-error", "bucket-forbidden")
+	return resp
+}
@@ -0,0 +1,185 @@
+package gsprotocol
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestConfig_CheckBucketAccess(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		bucket  string
+		allowed bool
+	}{
+		{"default", nil, "any-bucket", true},
+		{"allowed", []Option{WithAllowedBuckets("assets")}, "assets", true},
+		{"not allowed", []Option{WithAllowedBuckets("assets")}, "secrets", false},
+		{"glob", []Option{WithAllowedBuckets("team-a-*")}, "team-a-logs", true},
+		{"glob mismatch", []Option{WithAllowedBuckets("team-a-*")}, "team-b-logs", false},
+		{"concatenated", []Option{WithAllowedBuckets("assets"), WithAllowedBuckets("team-a-*")}, "team-a-logs", true},
+		{"no patterns", []Option{WithAllowedBuckets()}, "assets", false},
+		{"denied", []Option{WithDeniedBuckets("secrets")}, "secrets", false},
+		{"not denied", []Option{WithDeniedBuckets("secrets")}, "assets", true},
+		{"deny wins", []Option{WithAllowedBuckets("team-a-*"), WithDeniedBuckets("team-a-secrets")}, "team-a-secrets", false},
+		{"malformed pattern", []Option{WithAllowedBuckets("[")}, "[", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newConfig(tt.opts)
+			err := cfg.checkBucketAccess(tt.bucket)
+			if tt.allowed && err != nil {
+				t.Errorf("want allowed, got %v", err)
+			}
+			if !tt.allowed && err == nil {
+				t.Error("want forbidden, got nil")
+			}
+		})
+	}
+}
+
+func TestRoundTrip_BucketAccess(t *testing.T) {
+	objects := newStorageClientMockWithObjects(map[string]mockObject{
+		"team-a-assets/object-key": {
+			attrs:   &storage.ObjectAttrs{Generation: 1},
+			content: "Hello Google Cloud Storage!",
+		},
+	})
+	mock := &storageClientMock{
+		bucketFunc: func(mock *storageClientMock, name string) *bucketHandleMock {
+			if !strings.HasPrefix(name, "team-a-") {
+				t.Errorf("unexpected access to %s", name)
+			}
+			return objects.bucketFunc(mock, name)
+		},
+	}
+	tr := newTestTransport(mock,
+		WithAllowedBuckets("team-a-*"),
+		WithWriteMethods(),
+		WithBucketAlias(map[string]string{"secrets": "team-b-secrets"}),
+	)
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		header http.Header
+		status int
+	}{
+		{"allowed", http.MethodGet, "gs://team-a-assets/object-key", nil, http.StatusOK},
+		{"forbidden", http.MethodGet, "gs://team-b-assets/object-key", nil, http.StatusForbidden},
+		{"forbidden write", http.MethodPut, "gs://team-b-assets/object-key", nil, http.StatusForbidden},
+		{"forbidden alias", http.MethodGet, "gs://secrets/object-key", nil, http.StatusForbidden},
+		{
+			name:   "forbidden copy source",
+			method: http.MethodPut,
+			url:    "gs://team-a-assets/copied",
+			header: http.Header{"X-Goog-Copy-Source": {"/team-b-assets/object-key"}},
+			status: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Errorf("unexpected status: want %d, got %d", tt.status, resp.StatusCode)
+			}
+			if tt.status == http.StatusForbidden && !strings.Contains(string(body), "team-b-") {
+				t.Errorf("want the body naming the bucket, got %q", body)
+			}
+		})
+	}
+}
+
+func TestRoundTrip_BucketAccessSymlink(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/link": {
+			attrs: &storage.ObjectAttrs{Generation: 1, Metadata: map[string]string{symlinkMetadataKey: "gs://secret-bucket/t.txt"}},
+		},
+		"secret-bucket/t.txt": {
+			attrs:   &storage.ObjectAttrs{Generation: 2},
+			content: "secret",
+		},
+	})
+
+	for _, mode := range []SymlinkMode{SymlinkFollow, SymlinkRedirect} {
+		tr := newTestTransport(mock, WithAllowedBuckets("bucket-name"), WithSymlinks(mode))
+		req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/link", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("mode %d: unexpected status: want %d, got %d", mode, http.StatusForbidden, resp.StatusCode)
+		}
+		if string(body) == "secret" {
+			t.Errorf("mode %d: the target is leaked: %q", mode, body)
+		}
+		if got := resp.Header.Get("Location"); got != "" {
+			t.Errorf("mode %d: unexpected Location: %q", mode, got)
+		}
+	}
+}
+
+func TestTransport_OpenBucketAccess(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"team-a-assets/object-key": {
+			attrs:   &storage.ObjectAttrs{Generation: 1},
+			content: "Hello Google Cloud Storage!",
+		},
+		"team-b-secrets/object-key": {
+			attrs:   &storage.ObjectAttrs{Generation: 1},
+			content: "secret",
+		},
+	})
+	tr := newTestTransport(mock,
+		WithAllowedBuckets("team-a-*"),
+		WithBucketAlias(map[string]string{"assets": "team-a-assets", "secrets": "team-b-secrets"}),
+	)
+
+	_, body, err := tr.Open(context.Background(), "gs://assets/object-key", Validators{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body.Close()
+
+	for _, u := range []string{"gs://team-b-secrets/object-key", "gs://secrets/object-key"} {
+		_, body, err := tr.Open(context.Background(), u, Validators{})
+		if err == nil {
+			body.Close()
+			t.Errorf("%s: want an error, got nil", u)
+			continue
+		}
+		if _, ok := err.(*bucketAccessError); !ok {
+			t.Errorf("%s: want *bucketAccessError, got %v", u, err)
+		}
+	}
+}
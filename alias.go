@@ -0,0 +1,68 @@
+package gsprotocol
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// WithBucketAlias makes the Transport translate the bucket names in URLs through aliases before calling Google Cloud Storage,
+// e.g. with map[string]string{"assets": "my-company-assets-prod"}, gs://assets/[OBJECT_NAME] reads gs://my-company-assets-prod/[OBJECT_NAME].
+// The bucket names not in aliases are used as is, unless WithStrictAliases is given.
+// The responses have the x-gsprotocol-bucket header with the resolved bucket name.
+// The bucket names in the x-goog-copy-source header are translated in the same way.
+// WithBucketConfig applies to the resolved bucket names.
+// WithBucketAlias in a BucketConfig is ignored.
+func WithBucketAlias(aliases map[string]string) Option {
+	return func(c *config) {
+		if c.bucketAliases == nil {
+			c.bucketAliases = make(map[string]string, len(aliases))
+		}
+		for alias, bucket := range aliases {
+			c.bucketAliases[alias] = bucket
+		}
+	}
+}
+
+// WithStrictAliases makes the Transport respond 403 Forbidden to the URLs
+// whose bucket names are not in the aliases of WithBucketAlias.
+// WithStrictAliases in a BucketConfig is ignored.
+func WithStrictAliases() Option {
+	return func(c *config) {
+		c.strictAliases = true
+	}
+}
+
+// resolveBucket returns the bucket name that bucket is an alias of.
+// ok is false if bucket is not allowed by WithStrictAliases.
+func (c *config) resolveBucket(bucket string) (resolved string, ok bool) {
+	if resolved, ok := c.bucketAliases[bucket]; ok {
+		return resolved, true
+	}
+	return bucket, !c.strictAliases
+}
+
+// resolveAlias returns the copy of req whose bucket name is resolved by WithBucketAlias.
+func (c *config) resolveAlias(req *http.Request) (*http.Request, *http.Response) {
+	if c.bucketAliases == nil && !c.strictAliases {
+		return req, nil
+	}
+	bucket := bucketName(req)
+	resolved, ok := c.resolveBucket(bucket)
+	if !ok {
+		return nil, newNotAliasedResponse(bucket)
+	}
+	if resolved == bucket {
+		return req, nil
+	}
+	u := *req.URL
+	u.Host = resolved
+	r := req.WithContext(req.Context())
+	r.URL = &u
+	r.Host = resolved
+	return r, nil
+}
+
+// newNotAliasedResponse returns the 403 Forbidden response to the bucket rejected by WithStrictAliases.
+func newNotAliasedResponse(bucket string) *http.Response {
+	return newErrorResponse(http.StatusForbidden, fmt.Sprintf("gsprotocol: the bucket %q is not in the aliases", bucket))
+}
@@ -0,0 +1,200 @@
+package gsprotocol
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestRoundTrip_BucketAlias(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"my-company-assets-prod/object-key": {
+			attrs:   &storage.ObjectAttrs{Generation: 1},
+			content: "prod",
+		},
+		"other-bucket/object-key": {
+			attrs:   &storage.ObjectAttrs{Generation: 1},
+			content: "other",
+		},
+	})
+	aliases := map[string]string{"assets": "my-company-assets-prod"}
+
+	tests := []struct {
+		name   string
+		opts   []Option
+		url    string
+		status int
+		body   string
+		bucket string
+	}{
+		{
+			name:   "alias",
+			opts:   []Option{WithBucketAlias(aliases)},
+			url:    "gs://assets/object-key",
+			status: http.StatusOK,
+			body:   "prod",
+			bucket: "my-company-assets-prod",
+		},
+		{
+			name:   "pass through",
+			opts:   []Option{WithBucketAlias(aliases)},
+			url:    "gs://other-bucket/object-key",
+			status: http.StatusOK,
+			body:   "other",
+			bucket: "other-bucket",
+		},
+		{
+			name:   "strict alias",
+			opts:   []Option{WithBucketAlias(aliases), WithStrictAliases()},
+			url:    "gs://assets/object-key",
+			status: http.StatusOK,
+			body:   "prod",
+			bucket: "my-company-assets-prod",
+		},
+		{
+			name:   "strict",
+			opts:   []Option{WithBucketAlias(aliases), WithStrictAliases()},
+			url:    "gs://other-bucket/object-key",
+			status: http.StatusForbidden,
+			bucket: "other-bucket",
+		},
+		{
+			name:   "no alias",
+			url:    "gs://other-bucket/object-key",
+			status: http.StatusOK,
+			body:   "other",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTestTransport(mock, tt.opts...)
+			req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Errorf("unexpected status: want %d, got %d", tt.status, resp.StatusCode)
+			}
+			if tt.body != "" && string(body) != tt.body {
+				t.Errorf("unexpected body: want %q, got %q", tt.body, body)
+			}
+			if got := resp.Header.Get("X-Gsprotocol-Bucket"); got != tt.bucket {
+				t.Errorf("unexpected x-gsprotocol-bucket: want %q, got %q", tt.bucket, got)
+			}
+		})
+	}
+
+	// WithBucketConfig applies to the resolved bucket.
+	tr := newTestTransport(mock,
+		WithBucketAlias(aliases),
+		WithBucketConfig("my-company-assets-prod", BucketConfig{WithImmutableCacheControl(true)}),
+	)
+	req, err := http.NewRequest(http.MethodGet, "gs://assets/object-key#1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got, want := resp.Header.Get("Cache-Control"), "public, max-age=31536000, immutable"; got != want {
+		t.Errorf("unexpected Cache-Control: want %q, got %q", want, got)
+	}
+}
+
+func TestRoundTrip_BucketAliasCopySource(t *testing.T) {
+	var copied []string
+	names := make(map[*objectHandleMock]string)
+	mock := &storageClientMock{
+		bucketFunc: func(mock *storageClientMock, bucket string) *bucketHandleMock {
+			return &bucketHandleMock{
+				objectFunc: func(mock *bucketHandleMock, name string) *objectHandleMock {
+					object := &objectHandleMock{
+						copierFunc: func(dst *objectHandleMock, src *objectHandleMock) *storageCopierMock {
+							return &storageCopierMock{
+								runFunc: func(ctx context.Context, c *storageCopierMock) (*storage.ObjectAttrs, error) {
+									copied = append(copied, names[src]+" -> "+names[dst])
+									return &storage.ObjectAttrs{Bucket: "my-company-assets-prod", Name: "copied", Generation: 1}, nil
+								},
+							}
+						},
+					}
+					names[object] = bucket + "/" + name
+					return object
+				},
+			}
+		},
+	}
+	aliases := map[string]string{"assets": "my-company-assets-prod", "archive": "my-company-archive"}
+
+	tests := []struct {
+		name   string
+		opts   []Option
+		src    string
+		status int
+		copied []string
+	}{
+		{
+			name:   "alias",
+			opts:   []Option{WithBucketAlias(aliases)},
+			src:    "/archive/object-key",
+			status: http.StatusOK,
+			copied: []string{"my-company-archive/object-key -> my-company-assets-prod/copied"},
+		},
+		{
+			name:   "pass through",
+			opts:   []Option{WithBucketAlias(aliases)},
+			src:    "/other-bucket/object-key",
+			status: http.StatusOK,
+			copied: []string{"other-bucket/object-key -> my-company-assets-prod/copied"},
+		},
+		{
+			name:   "strict",
+			opts:   []Option{WithBucketAlias(aliases), WithStrictAliases()},
+			src:    "/other-bucket/object-key",
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "access to the resolved bucket",
+			opts:   []Option{WithBucketAlias(aliases), WithDeniedBuckets("my-company-archive")},
+			src:    "/archive/object-key",
+			status: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copied = nil
+			tr := newTestTransport(mock, append(tt.opts, WithWriteMethods())...)
+			req, err := http.NewRequest(http.MethodPut, "gs://assets/copied", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-Goog-Copy-Source", tt.src)
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("unexpected status: want %d, got %d", tt.status, resp.StatusCode)
+			}
+			if !reflect.DeepEqual(copied, tt.copied) {
+				t.Errorf("unexpected copies: want %v, got %v", tt.copied, copied)
+			}
+		})
+	}
+}
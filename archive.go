@@ -0,0 +1,202 @@
+package gsprotocol
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	// defaultArchiveMaxEntries is the default limit of the number of entries in an archive.
+	defaultArchiveMaxEntries = 1000
+
+	// defaultArchiveMaxBytes is the default limit of the total size of objects in an archive.
+	defaultArchiveMaxBytes = 1 << 30
+)
+
+// archiveContentTypes is the Content-Type for each archive format.
+var archiveContentTypes = map[string]string{
+	"tar": "application/x-tar",
+	"zip": "application/zip",
+}
+
+// getArchive serves the objects under the prefix as an archive, e.g. gs://[BUCKET_NAME]/[PREFIX]?archive=tar.
+// The archive is built on the fly, one object at a time.
+func (t *Transport) getArchive(req *http.Request, client storageClient, cfg *config, format string, withBody bool) (*http.Response, error) {
+	contentType, ok := archiveContentTypes[format]
+	if !ok {
+		return newErrorResponse(http.StatusBadRequest, fmt.Sprintf("gsprotocol: unsupported archive format %q", format)), nil
+	}
+
+	ctx := req.Context()
+	prefix := objectName(req.URL)
+	bucket := client.Bucket(bucketName(req))
+	maxEntries, maxBytes := cfg.archiveLimits()
+
+	q := &storage.Query{Prefix: prefix}
+	if err := q.SetAttrSelection([]string{"Name", "Size", "Updated", "Generation"}); err != nil {
+		return nil, err
+	}
+	var entries []archiveEntry
+	var total int64
+	it := bucket.Objects(ctx, q)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return handleError(err)
+		}
+		if strings.HasSuffix(attrs.Name, "/") {
+			// skip directory placeholders.
+			continue
+		}
+		name, ok := archiveEntryName(prefix, attrs.Name)
+		if !ok {
+			// skip the names that would be extracted outside of the archive.
+			continue
+		}
+		entries = append(entries, archiveEntry{name: name, attrs: attrs})
+		total += attrs.Size
+		if len(entries) > maxEntries {
+			msg := fmt.Sprintf("gsprotocol: the archive has more than %d entries", maxEntries)
+			return newErrorResponse(http.StatusRequestEntityTooLarge, msg), nil
+		}
+		if total > maxBytes {
+			msg := fmt.Sprintf("gsprotocol: the archive has more than %d bytes", maxBytes)
+			return newErrorResponse(http.StatusRequestEntityTooLarge, msg), nil
+		}
+	}
+
+	header := make(http.Header)
+	header.Set("Content-Type", contentType)
+	var body io.ReadCloser = http.NoBody
+	if withBody {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(writeArchive(ctx, pw, bucket, format, entries))
+		}()
+		body = pr
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.0",
+		ProtoMajor:    1,
+		ProtoMinor:    0,
+		Header:        header,
+		Body:          body,
+		ContentLength: -1,
+		Close:         true,
+	}, nil
+}
+
+func (c *config) archiveLimits() (int, int64) {
+	maxEntries := c.archiveMaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultArchiveMaxEntries
+	}
+	maxBytes := c.archiveMaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultArchiveMaxBytes
+	}
+	return maxEntries, maxBytes
+}
+
+// archiveEntry is an object in an archive.
+type archiveEntry struct {
+	// name is the name of the entry, relative to the prefix.
+	name  string
+	attrs *storage.ObjectAttrs
+}
+
+// writeArchive writes the archive of the objects to w.
+func writeArchive(ctx context.Context, w io.Writer, bucket bucketHandle, format string, entries []archiveEntry) error {
+	switch format {
+	case "tar":
+		tw := tar.NewWriter(w)
+		for _, entry := range entries {
+			err := tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     entry.name,
+				Size:     entry.attrs.Size,
+				Mode:     0644,
+				ModTime:  entry.attrs.Updated,
+			})
+			if err != nil {
+				return err
+			}
+			if err := writeArchiveEntry(ctx, tw, bucket, entry.attrs); err != nil {
+				return err
+			}
+		}
+		return tw.Close()
+	case "zip":
+		zw := zip.NewWriter(w)
+		for _, entry := range entries {
+			fw, err := zw.CreateHeader(&zip.FileHeader{
+				Name:     entry.name,
+				Method:   zip.Deflate,
+				Modified: entry.attrs.Updated,
+			})
+			if err != nil {
+				return err
+			}
+			if err := writeArchiveEntry(ctx, fw, bucket, entry.attrs); err != nil {
+				return err
+			}
+		}
+		return zw.Close()
+	}
+	return fmt.Errorf("gsprotocol: unsupported archive format %q", format)
+}
+
+// archiveEntryName returns the name of the object relative to the prefix.
+// It reports false if the name is absolute or escapes the prefix after cleaning, e.g. "../etc/passwd",
+// because extracting such entries would write outside of the destination directory.
+func archiveEntryName(prefix, name string) (string, bool) {
+	rel := strings.TrimPrefix(name, prefix)
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		// e.g. the prefix "results" of "results/a.txt".
+		rel = strings.TrimPrefix(rel, "/")
+	}
+	if rel == "" {
+		rel = path.Base(name)
+	}
+	if strings.HasPrefix(rel, "/") {
+		return "", false
+	}
+	rel = path.Clean(rel)
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", false
+	}
+	return rel, true
+}
+
+// writeArchiveEntry writes the content of the object to w.
+// It reads the bytes as they are stored, so that the objects with the gzip Content-Encoding match their sizes.
+func writeArchiveEntry(ctx context.Context, w io.Writer, bucket bucketHandle, attrs *storage.ObjectAttrs) error {
+	r, err := bucket.Object(attrs.Name).Generation(attrs.Generation).ReadCompressed(true).NewReader(ctx)
+	if err != nil {
+		return fmt.Errorf("gsprotocol: failed to archive %q: %w", attrs.Name, err)
+	}
+	defer r.Close()
+
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return fmt.Errorf("gsprotocol: failed to archive %q: %w", attrs.Name, err)
+	}
+	if n != attrs.Size {
+		return fmt.Errorf("gsprotocol: failed to archive %q: want %d bytes, got %d bytes", attrs.Name, attrs.Size, n)
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+package gsprotocol
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+var archiveTestObjects = map[string]mockObject{
+	"bucket-name/results/": {
+		attrs: &storage.ObjectAttrs{Generation: 1},
+	},
+	"bucket-name/results/a.txt": {
+		attrs:   &storage.ObjectAttrs{Generation: 2, Updated: time.Date(2020, time.April, 15, 0, 56, 0, 0, time.UTC)},
+		content: "content of a",
+	},
+	"bucket-name/results/sub/b.txt": {
+		attrs:   &storage.ObjectAttrs{Generation: 3, Updated: time.Date(2020, time.April, 16, 0, 56, 0, 0, time.UTC)},
+		content: "content of b",
+	},
+	"bucket-name/other/c.txt": {
+		attrs:   &storage.ObjectAttrs{Generation: 4},
+		content: "content of c",
+	},
+}
+
+func TestRoundTrip_ArchiveTar(t *testing.T) {
+	c := newTestClient(newStorageClientMockWithObjects(archiveTestObjects))
+	resp, err := c.Get("gs://bucket-name/results/?archive=tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "application/x-tar" {
+		t.Errorf("unexpected Content-Type: want %q, got %q", "application/x-tar", got)
+	}
+	if resp.ContentLength != -1 {
+		t.Errorf("unexpected ContentLength: want %d, got %d", -1, resp.ContentLength)
+	}
+
+	tr := tar.NewReader(resp.Body)
+	want := []struct {
+		name    string
+		content string
+		modtime time.Time
+	}{
+		{"a.txt", "content of a", time.Date(2020, time.April, 15, 0, 56, 0, 0, time.UTC)},
+		{"sub/b.txt", "content of b", time.Date(2020, time.April, 16, 0, 56, 0, 0, time.UTC)},
+	}
+	for _, w := range want {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != w.name {
+			t.Errorf("unexpected name: want %q, got %q", w.name, hdr.Name)
+		}
+		if !hdr.ModTime.Equal(w.modtime) {
+			t.Errorf("unexpected modtime: want %v, got %v", w.modtime, hdr.ModTime)
+		}
+		got, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != w.content {
+			t.Errorf("want %q, got %q", w.content, string(got))
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("want io.EOF, got %v", err)
+	}
+}
+
+func TestRoundTrip_ArchiveZip(t *testing.T) {
+	c := newTestClient(newStorageClientMockWithObjects(archiveTestObjects))
+	resp, err := c.Get("gs://bucket-name/results/?archive=zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("Content-Type"); got != "application/zip" {
+		t.Errorf("unexpected Content-Type: want %q, got %q", "application/zip", got)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 2 {
+		t.Fatalf("unexpected number of entries: want %d, got %d", 2, len(zr.File))
+	}
+	for i, name := range []string{"a.txt", "sub/b.txt"} {
+		if zr.File[i].Name != name {
+			t.Errorf("unexpected name: want %q, got %q", name, zr.File[i].Name)
+		}
+	}
+}
+
+func TestRoundTrip_ArchiveLimits(t *testing.T) {
+	tc := []struct {
+		name   string
+		opts   []Option
+		status int
+	}{
+		{"within limits", []Option{WithArchiveLimits(2, 24)}, http.StatusOK},
+		{"too many entries", []Option{WithArchiveLimits(1, 0)}, http.StatusRequestEntityTooLarge},
+		{"too many bytes", []Option{WithArchiveLimits(0, 23)}, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(newStorageClientMockWithObjects(archiveTestObjects), tt.opts...)
+			resp, err := c.Get("gs://bucket-name/results/?archive=tar")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("unexpected status: want %d, got %d", tt.status, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestRoundTrip_ArchiveUnsupported(t *testing.T) {
+	c := newTestClient(newStorageClientMockWithObjects(archiveTestObjects))
+	resp, err := c.Get("gs://bucket-name/results/?archive=rar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestRoundTrip_ArchiveAbort(t *testing.T) {
+	errBroken := errors.New("broken object")
+	mock := newStorageClientMockWithObjects(archiveTestObjects)
+	bucketFunc := mock.bucketFunc
+	mock.bucketFunc = func(mock *storageClientMock, name string) *bucketHandleMock {
+		bucket := bucketFunc(mock, name)
+		objectFunc := bucket.objectFunc
+		bucket.objectFunc = func(mock *bucketHandleMock, name string) *objectHandleMock {
+			object := objectFunc(mock, name)
+			if name == "results/sub/b.txt" {
+				object.newReaderFunc = func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+					return storage.ReaderObjectAttrs{}, nil, errBroken
+				}
+			}
+			return object
+		}
+		return bucket
+	}
+	c := newTestClient(mock)
+	resp, err := c.Get("gs://bucket-name/results/?archive=tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	_, err = io.ReadAll(resp.Body)
+	if !errors.Is(err, errBroken) {
+		t.Errorf("want errBroken, got %v", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "results/sub/b.txt") {
+		t.Errorf("the error doesn't name the object: %v", err)
+	}
+}
+
+func TestArchiveEntryName(t *testing.T) {
+	tc := []struct {
+		prefix, name string
+		want         string
+		ok           bool
+	}{
+		{"results/", "results/a.txt", "a.txt", true},
+		{"results/", "results/sub/b.txt", "sub/b.txt", true},
+		{"results", "results/a.txt", "a.txt", true},
+		{"results/a.txt", "results/a.txt", "a.txt", true},
+		{"results/", "results/sub/../a.txt", "a.txt", true},
+		{"results/", "results/../../etc/passwd", "", false},
+		{"results/", "results/..", "", false},
+		{"results/", "results//etc/passwd", "", false},
+		{"", "/etc/passwd", "", false},
+	}
+	for _, tt := range tc {
+		got, ok := archiveEntryName(tt.prefix, tt.name)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("archiveEntryName(%q, %q): want (%q, %t), got (%q, %t)", tt.prefix, tt.name, tt.want, tt.ok, got, ok)
+		}
+	}
+}
+
+func TestRoundTrip_ArchiveUnsafeNames(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/results/../../etc/passwd": {
+			attrs:   &storage.ObjectAttrs{Generation: 1},
+			content: "root:x:0:0",
+		},
+		"bucket-name/results//etc/passwd": {
+			attrs:   &storage.ObjectAttrs{Generation: 2},
+			content: "root:x:0:0",
+		},
+		"bucket-name/results/a.txt": {
+			attrs:   &storage.ObjectAttrs{Generation: 3},
+			content: "content of a",
+		},
+	})
+	c := newTestClient(mock)
+	resp, err := c.Get("gs://bucket-name/results/?archive=tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var names []string
+	tr := tar.NewReader(resp.Body)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if len(names) != 1 || names[0] != "a.txt" {
+		t.Errorf("want only a.txt, got %q", names)
+	}
+}
+
+func TestRoundTrip_ArchiveGzipEncoding(t *testing.T) {
+	const content = "Hello Google Cloud Storage!"
+	compressed := gzipString(t, content)
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/logs/app.log": {
+			attrs:   &storage.ObjectAttrs{ContentEncoding: "gzip", Generation: 1},
+			content: compressed,
+		},
+	})
+	bucketFunc := mock.bucketFunc
+	mock.bucketFunc = func(mock *storageClientMock, name string) *bucketHandleMock {
+		bucket := bucketFunc(mock, name)
+		objectFunc := bucket.objectFunc
+		bucket.objectFunc = func(mock *bucketHandleMock, name string) *objectHandleMock {
+			object := objectFunc(mock, name)
+			newReader := object.newReaderFunc
+			object.newReaderFunc = func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+				if !mock.readCompressed {
+					// Google Cloud Storage decompresses the object, like decompressive transcoding.
+					return storage.ReaderObjectAttrs{Size: int64(len(content))}, io.NopCloser(strings.NewReader(content)), nil
+				}
+				return newReader(ctx, mock)
+			}
+			return object
+		}
+		return bucket
+	}
+	c := newTestClient(mock)
+	resp, err := c.Get("gs://bucket-name/logs/?archive=tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	tr := tar.NewReader(resp.Body)
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Name != "app.log" {
+		t.Errorf("unexpected name: want %q, got %q", "app.log", hdr.Name)
+	}
+	got, err := io.ReadAll(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != compressed {
+		t.Errorf("want the stored bytes %q, got %q", compressed, got)
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("want io.EOF, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package gsprotocol
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// WithAttrsCache makes the Transport cache the attributes of objects for ttl,
+// for the workloads that request the same objects repeatedly.
+// The cached attributes answer HEAD requests and the conditional headers of GET requests without calling Google Cloud Storage,
+// and GET requests read the generation that the cached attributes describe.
+// The attributes are keyed by the bucket, the object and the generation in the URL, and at most maxEntries attributes are kept.
+//
+// The cached attributes of an object are dropped when a GET request finds a newer generation of it,
+// and they are replaced when a write request, e.g. PUT, DELETE or PATCH, modifies it through the Transport.
+// See WithCacheSubscriber for the caches outside of the Transport.
+// A GET request whose cached generation no longer exists retries with the fresh attributes.
+// The changes by the others are not noticed until ttl elapses.
+// The hits are reported by RequestStats.AttrsCacheHit.
+// Transport.Prefetch warms the cache before the requests.
+// It is disabled by default.
+func WithAttrsCache(maxEntries int, ttl time.Duration) Option {
+	return func(c *config) {
+		c.attrsCacheMaxEntries = maxEntries
+		c.attrsCacheTTL = ttl
+	}
+}
+
+// attrsCache is the cache of WithAttrsCache.
+// The zero value is an empty cache, and it is safe for concurrent use.
+type attrsCache struct {
+	mu      sync.Mutex
+	entries map[attrsCacheKey]*list.Element
+	lru     list.List
+}
+
+// attrsCacheKey is the key of attrsCache.
+// The zero generation is the live object.
+type attrsCacheKey struct {
+	bucket     string
+	object     string
+	generation int64
+}
+
+type attrsCacheEntry struct {
+	key     attrsCacheKey
+	attrs   *storage.ObjectAttrs
+	expires time.Time
+}
+
+// attrsCacheEnabled reports whether the configuration enables the attrs cache.
+func (c *config) attrsCacheEnabled() bool {
+	return c.attrsCacheTTL > 0 && c.attrsCacheMaxEntries > 0
+}
+
+// get returns a copy of the cached attributes.
+func (c *attrsCache) get(cfg *config, key attrsCacheKey) (*storage.ObjectAttrs, bool) {
+	if !cfg.attrsCacheEnabled() {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*attrsCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return copyObjectAttrs(entry.attrs), true
+}
+
+// add caches a copy of attrs, and evicts the least recently used attributes over the limit.
+func (c *attrsCache) add(cfg *config, key attrsCacheKey, attrs *storage.ObjectAttrs) {
+	if !cfg.attrsCacheEnabled() {
+		return
+	}
+	entry := &attrsCacheEntry{
+		key:     key,
+		attrs:   copyObjectAttrs(attrs),
+		expires: time.Now().Add(cfg.attrsCacheTTL),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[attrsCacheKey]*list.Element)
+	}
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > cfg.attrsCacheMaxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// invalidate drops the cached attributes of all the generations of the object.
+func (c *attrsCache) invalidate(bucket, object string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, elem := range c.entries {
+		if key.bucket == bucket && key.object == object {
+			c.remove(elem)
+		}
+	}
+}
+
+func (c *attrsCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*attrsCacheEntry)
+	delete(c.entries, entry.key)
+}
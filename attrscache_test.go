@@ -0,0 +1,197 @@
+package gsprotocol
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestAttrsCache_Eviction(t *testing.T) {
+	cfg := newConfig([]Option{WithAttrsCache(2, time.Hour)})
+	var c attrsCache
+	keyA := attrsCacheKey{bucket: "bucket-name", object: "a"}
+	keyB := attrsCacheKey{bucket: "bucket-name", object: "b"}
+	keyC := attrsCacheKey{bucket: "bucket-name", object: "c"}
+	c.add(&cfg, keyA, &storage.ObjectAttrs{Name: "a"})
+	c.add(&cfg, keyB, &storage.ObjectAttrs{Name: "b"})
+
+	// a is used recently, so b is evicted.
+	if _, ok := c.get(&cfg, keyA); !ok {
+		t.Fatal("a is not cached")
+	}
+	c.add(&cfg, keyC, &storage.ObjectAttrs{Name: "c"})
+	if _, ok := c.get(&cfg, keyB); ok {
+		t.Error("b is not evicted")
+	}
+	for _, key := range []attrsCacheKey{keyA, keyC} {
+		attrs, ok := c.get(&cfg, key)
+		if !ok {
+			t.Errorf("%s is evicted", key.object)
+			continue
+		}
+		if attrs.Name != key.object {
+			t.Errorf("unexpected attrs: want %s, got %s", key.object, attrs.Name)
+		}
+	}
+	if c.lru.Len() != 2 || len(c.entries) != 2 {
+		t.Errorf("want 2 entries, got %d, %d", c.lru.Len(), len(c.entries))
+	}
+
+	// the copies don't modify the cache.
+	attrs, _ := c.get(&cfg, keyA)
+	attrs.Name = "modified"
+	if attrs, _ := c.get(&cfg, keyA); attrs.Name != "a" {
+		t.Errorf("the cache is modified: %s", attrs.Name)
+	}
+}
+
+func TestAttrsCache_Expiration(t *testing.T) {
+	cfg := newConfig([]Option{WithAttrsCache(2, time.Millisecond)})
+	var c attrsCache
+	key := attrsCacheKey{bucket: "bucket-name", object: "a"}
+	c.add(&cfg, key, &storage.ObjectAttrs{Name: "a"})
+	time.Sleep(10 * time.Millisecond)
+	if _, ok := c.get(&cfg, key); ok {
+		t.Error("the expired attrs are returned")
+	}
+	if c.lru.Len() != 0 || len(c.entries) != 0 {
+		t.Errorf("the expired attrs are not removed: %d, %d", c.lru.Len(), len(c.entries))
+	}
+}
+
+func TestAttrsCache_Invalidate(t *testing.T) {
+	cfg := newConfig([]Option{WithAttrsCache(10, time.Hour)})
+	var c attrsCache
+	c.add(&cfg, attrsCacheKey{bucket: "bucket-name", object: "a"}, &storage.ObjectAttrs{})
+	c.add(&cfg, attrsCacheKey{bucket: "bucket-name", object: "a", generation: 1}, &storage.ObjectAttrs{})
+	c.add(&cfg, attrsCacheKey{bucket: "bucket-name", object: "b"}, &storage.ObjectAttrs{})
+	c.add(&cfg, attrsCacheKey{bucket: "other-bucket", object: "a"}, &storage.ObjectAttrs{})
+	c.invalidate("bucket-name", "a")
+	if len(c.entries) != 2 {
+		t.Errorf("want 2 entries, got %d", len(c.entries))
+	}
+	if _, ok := c.get(&cfg, attrsCacheKey{bucket: "bucket-name", object: "a", generation: 1}); ok {
+		t.Error("the generation is not invalidated")
+	}
+}
+
+func TestRoundTrip_AttrsCache(t *testing.T) {
+	objects := map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs: &storage.ObjectAttrs{
+				ContentType:    "text/plain",
+				Generation:     1587160158394554,
+				Metageneration: 1,
+				MD5:            []byte{0x0b, 0x46, 0xf3, 0x06, 0xe9, 0x2d, 0x88, 0x51, 0x5e, 0x06, 0xd4, 0x8a, 0x62, 0xdc, 0xc3, 0x19},
+			},
+			content: "Hello Google Cloud Storage!",
+		},
+	}
+	var counts rpcCounts
+	tr := newTestTransport(newRPCCountingClient(objects, &counts), WithAttrsCache(10, time.Hour), WithWriteMethods())
+	do := func(method string, header http.Header) (*http.Response, *RequestStats, string) {
+		t.Helper()
+		var stats RequestStats
+		req, err := http.NewRequestWithContext(WithStatsRecorder(context.Background(), &stats), method, "gs://bucket-name/object-key", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, &stats, string(body)
+	}
+
+	if _, stats, _ := do(http.MethodHead, nil); stats.AttrsCacheHit {
+		t.Error("the first request hits the cache")
+	}
+	if _, stats, _ := do(http.MethodHead, nil); !stats.AttrsCacheHit {
+		t.Error("the second request doesn't hit the cache")
+	}
+	resp, _, _ := do(http.MethodGet, http.Header{"If-None-Match": {`"0b46f306e92d88515e06d48a62dcc319"`}})
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusNotModified, resp.StatusCode)
+	}
+	if _, _, body := do(http.MethodGet, nil); body != "Hello Google Cloud Storage!" {
+		t.Errorf("unexpected body: %q", body)
+	}
+	if counts != (rpcCounts{attrs: 1, reader: 1}) {
+		t.Errorf("want one Attrs and one NewReader, got %+v", counts)
+	}
+
+	// overwrite the object behind the cache.
+	objects["bucket-name/object-key"] = mockObject{
+		attrs: &storage.ObjectAttrs{
+			ContentType:    "text/plain",
+			Generation:     1587160158394555,
+			Metageneration: 1,
+		},
+		content: "Hello, again!",
+	}
+	resp, stats, body := do(http.MethodGet, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if body != "Hello, again!" {
+		t.Errorf("unexpected body: %q", body)
+	}
+	if stats.Generation != 1587160158394555 {
+		t.Errorf("unexpected generation: %d", stats.Generation)
+	}
+	if _, stats, _ := do(http.MethodHead, nil); !stats.AttrsCacheHit || stats.Generation != 1587160158394555 {
+		t.Errorf("the new generation is not cached: %+v", stats)
+	}
+
+	// the write requests invalidate the cache.
+	resp, _, _ = do(http.MethodDelete, nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+	if _, stats, _ := do(http.MethodHead, nil); stats.AttrsCacheHit {
+		t.Error("the cache is not invalidated by DELETE")
+	}
+}
+
+func TestRoundTrip_AttrsCacheConcurrent(t *testing.T) {
+	tr := newTestTransport(newStorageClientMockWithObjects(singleRequestTestObjects), WithAttrsCache(1, time.Hour))
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			url := "gs://bucket-name/object-key"
+			if i%2 == 0 {
+				url += "#1587160158394554"
+			}
+			req, err := http.NewRequest(http.MethodHead, url, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("unexpected status: %d", resp.StatusCode)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
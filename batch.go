@@ -0,0 +1,190 @@
+package gsprotocol
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// the defaults of the batch metadata requests.
+const (
+	defaultBatchMaxKeys     = 100
+	batchMaxConcurrentCalls = 8
+)
+
+// WithBatchMetadataLimit limits the number of the keys in a batch metadata request,
+// e.g. gs://[BUCKET_NAME]/?objects=[KEY1],[KEY2]&alt=json.
+// The Transport responds 413 Request Entity Too Large if a request has more than maxKeys keys.
+// The default is 100 keys. Zero or negative maxKeys means the default.
+func WithBatchMetadataLimit(maxKeys int) Option {
+	return func(c *config) {
+		c.batchMaxKeys = maxKeys
+	}
+}
+
+// batchAttrs is the attributes of an object in the response of a batch metadata request.
+type batchAttrs struct {
+	Name           string    `json:"name"`
+	Size           int64     `json:"size"`
+	ContentType    string    `json:"contentType,omitempty"`
+	Updated        time.Time `json:"updated"`
+	Generation     int64     `json:"generation,string"`
+	Metageneration int64     `json:"metageneration,string"`
+	ETag           string    `json:"etag,omitempty"`
+}
+
+// batchError is the error of an object in the response of a batch metadata request.
+type batchError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// isBatchMetadataRequest reports whether req has the objects parameter.
+// It doesn't use url.Values, which drops the parameters with invalid encodings.
+func isBatchMetadataRequest(req *http.Request) bool {
+	for _, param := range strings.Split(req.URL.RawQuery, "&") {
+		if strings.HasPrefix(param, "objects=") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseBatchKeys returns the keys of the objects parameter.
+// Each key is percent-decoded individually, so that the keys can contain encoded commas.
+func parseBatchKeys(rawQuery string) ([]string, error) {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, param := range strings.Split(rawQuery, "&") {
+		if !strings.HasPrefix(param, "objects=") {
+			continue
+		}
+		for _, raw := range strings.Split(strings.TrimPrefix(param, "objects="), ",") {
+			key, err := url.QueryUnescape(raw)
+			if err != nil {
+				return nil, fmt.Errorf("gsprotocol: invalid key %q: %v", raw, err)
+			}
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// getBatchMetadata serves the metadata of the objects, e.g. gs://[BUCKET_NAME]/?objects=[KEY1],[KEY2]&alt=json.
+// The response is a JSON object from the keys to their attributes or errors, in the order of the request.
+func (t *Transport) getBatchMetadata(req *http.Request, client storageClient, cfg *config) (*http.Response, error) {
+	if alt := req.URL.Query().Get("alt"); alt != "json" {
+		return newErrorResponse(http.StatusBadRequest, fmt.Sprintf("gsprotocol: unsupported alt %q, only json is supported", alt)), nil
+	}
+	keys, err := parseBatchKeys(req.URL.RawQuery)
+	if err != nil {
+		return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	maxKeys := cfg.batchMaxKeys
+	if maxKeys <= 0 {
+		maxKeys = defaultBatchMaxKeys
+	}
+	if len(keys) > maxKeys {
+		msg := fmt.Sprintf("gsprotocol: too many keys: %d keys, the limit is %d", len(keys), maxKeys)
+		return newErrorResponse(http.StatusRequestEntityTooLarge, msg), nil
+	}
+
+	ctx := req.Context()
+	bucket := client.Bucket(bucketName(req))
+	results := make([]interface{}, len(keys))
+	sem := make(chan struct{}, batchMaxConcurrentCalls)
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, key string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = batchResult(ctx, bucket, key)
+		}(i, key)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(results[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+
+	header := make(http.Header)
+	header.Set("Content-Type", "application/json; charset=utf-8")
+	header.Set("Content-Length", strconv.Itoa(buf.Len()))
+	return cfg.compressResponse(req, &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.0",
+		ProtoMajor:    1,
+		ProtoMinor:    0,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(buf.Bytes())),
+		ContentLength: int64(buf.Len()),
+		Close:         true,
+	}), nil
+}
+
+func batchResult(ctx context.Context, bucket bucketHandle, key string) interface{} {
+	attrs, err := bucket.Object(key).Attrs(ctx)
+	if err != nil {
+		var result batchError
+		var apiErr *googleapi.Error
+		switch {
+		case errors.Is(err, storage.ErrObjectNotExist), errors.Is(err, storage.ErrBucketNotExist):
+			result.Error.Code = http.StatusNotFound
+		case errors.As(err, &apiErr):
+			result.Error.Code = apiErr.Code
+		default:
+			result.Error.Code = http.StatusInternalServerError
+		}
+		result.Error.Message = err.Error()
+		return &result
+	}
+	result := &batchAttrs{
+		Name:           attrs.Name,
+		Size:           attrs.Size,
+		ContentType:    attrs.ContentType,
+		Updated:        attrs.Updated,
+		Generation:     attrs.Generation,
+		Metageneration: attrs.Metageneration,
+	}
+	if len(attrs.MD5) > 0 {
+		result.ETag = formatETag(attrs.MD5)
+	}
+	return result
+}
@@ -0,0 +1,84 @@
+package gsprotocol
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestRoundTrip_BatchMetadata(t *testing.T) {
+	updated := time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/b.txt": {
+			attrs:   &storage.ObjectAttrs{ContentType: "text/plain", Generation: 2, Metageneration: 1, Updated: updated},
+			content: "bb",
+		},
+		"bucket-name/a,b.txt": {
+			attrs:   &storage.ObjectAttrs{ContentType: "text/plain", Generation: 1, Metageneration: 1, Updated: updated},
+			content: "a",
+		},
+	})
+	c := &http.Client{Transport: newTestTransport(mock, WithBatchMetadataLimit(3))}
+
+	resp, err := c.Get("gs://bucket-name/?objects=b.txt,missing,a%2Cb.txt,b.txt&alt=json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "application/json; charset=utf-8" {
+		t.Errorf("unexpected Content-Type: %q", got)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{` +
+		`"b.txt":{"name":"b.txt","size":2,"contentType":"text/plain","updated":"2021-01-01T00:00:00Z","generation":"2","metageneration":"1"},` +
+		`"missing":{"error":{"code":404,"message":"storage: object doesn't exist"}},` +
+		`"a,b.txt":{"name":"a,b.txt","size":1,"contentType":"text/plain","updated":"2021-01-01T00:00:00Z","generation":"1","metageneration":"1"}` +
+		`}`
+	if string(body) != want {
+		t.Errorf("unexpected body:\nwant %s\ngot  %s", want, string(body))
+	}
+}
+
+func TestRoundTrip_BatchMetadataErrors(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{})
+	c := &http.Client{Transport: newTestTransport(mock, WithBatchMetadataLimit(2))}
+
+	tc := []struct {
+		url     string
+		status  int
+		message string
+	}{
+		{"gs://bucket-name/?objects=a,b,c&alt=json", http.StatusRequestEntityTooLarge, "too many keys"},
+		{"gs://bucket-name/?objects=a", http.StatusBadRequest, "unsupported alt"},
+		{"gs://bucket-name/?objects=%zz&alt=json", http.StatusBadRequest, "invalid key"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.url, func(t *testing.T) {
+			resp, err := c.Get(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("unexpected status: want %d, got %d", tt.status, resp.StatusCode)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(body), tt.message) {
+				t.Errorf("unexpected body: %q", string(body))
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package gsprotocol
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// OperationBudget limits the number of the Google Cloud Storage operations per bucket.
+// See https://cloud.google.com/storage/pricing#operations-pricing for the classes of operations.
+//
+// The Transport counts the operations it calls for each bucket in fixed windows of Window.
+// Listing objects is a class A operation, and each page of 1000 objects counts as one.
+// Getting the metadata of an object and reading an object are class B operations.
+// Writing, copying, composing an object and updating its metadata are class A operations, and deleting an object is free.
+type OperationBudget struct {
+	// Window is the duration of the windows.
+	Window time.Duration
+
+	// SoftClassA and SoftClassB are the soft limits.
+	// If the counts of the window reach either of them, OnSoftLimit is called once in the window.
+	// Zero means no limit.
+	SoftClassA int
+	SoftClassB int
+
+	// HardClassA and HardClassB are the hard limits.
+	// If the counts of the window reach either of them, the Transport responds 429 Too Many Requests
+	// with Retry-After until the window resets, without calling Google Cloud Storage.
+	// Zero means no limit.
+	HardClassA int
+	HardClassB int
+
+	// OnSoftLimit is called when the counts reach the soft limits.
+	// It must not block.
+	OnSoftLimit func(bucket string, classA, classB int)
+}
+
+// WithOperationBudget configures the budget of operations.
+// Use it with WithBucketConfig to configure the budgets per bucket.
+// The counts are available by OperationCounts.
+func WithOperationBudget(budget OperationBudget) Option {
+	return func(c *config) {
+		c.budget = &budget
+	}
+}
+
+// budgetCounter is the counts of operations of a bucket in the current window.
+type budgetCounter struct {
+	start  time.Time
+	classA int
+	classB int
+	warned bool
+}
+
+// OperationCounts returns the counts of class A and class B operations for the bucket in the current window
+// of the budget configured by WithOperationBudget.
+func (t *Transport) OperationCounts(bucket string) (classA, classB int) {
+	cfg := t.config.forBucket(bucket)
+	if cfg.budget == nil {
+		return 0, 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counter := t.budgetCounter(bucket, cfg.budget, time.Now())
+	return counter.classA, counter.classB
+}
+
+// budgetCounter returns the counter of the current window. t.mu must be held.
+func (t *Transport) budgetCounter(bucket string, budget *OperationBudget, now time.Time) *budgetCounter {
+	if t.budgets == nil {
+		t.budgets = make(map[string]*budgetCounter)
+	}
+	counter, ok := t.budgets[bucket]
+	if !ok || !now.Before(counter.start.Add(budget.Window)) {
+		counter = &budgetCounter{start: now}
+		t.budgets[bucket] = counter
+	}
+	return counter
+}
+
+// checkBudget responds 429 Too Many Requests if the bucket reaches the hard limits.
+func (t *Transport) checkBudget(bucket string, cfg *config) *http.Response {
+	budget := cfg.budget
+	if budget == nil {
+		return nil
+	}
+
+	now := time.Now()
+	t.mu.Lock()
+	counter := t.budgetCounter(bucket, budget, now)
+	exhausted := (budget.HardClassA > 0 && counter.classA >= budget.HardClassA) ||
+		(budget.HardClassB > 0 && counter.classB >= budget.HardClassB)
+	reset := counter.start.Add(budget.Window).Sub(now)
+	t.mu.Unlock()
+	if !exhausted {
+		return nil
+	}
+
+	resp := newErrorResponse(http.StatusTooManyRequests, "gsprotocol: the operation budget of gs://"+bucket+" is exhausted")
+	retryAfter := int64((reset + time.Second - 1) / time.Second)
+	resp.Header.Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	return resp
+}
+
+// countOperations counts the operations against the budget of the bucket.
+func (t *Transport) countOperations(bucket string, budget *OperationBudget, classA, classB int) {
+	t.mu.Lock()
+	counter := t.budgetCounter(bucket, budget, time.Now())
+	counter.classA += classA
+	counter.classB += classB
+	soft := !counter.warned &&
+		((budget.SoftClassA > 0 && counter.classA >= budget.SoftClassA) ||
+			(budget.SoftClassB > 0 && counter.classB >= budget.SoftClassB))
+	if soft {
+		counter.warned = true
+	}
+	a, b := counter.classA, counter.classB
+	t.mu.Unlock()
+
+	if soft && budget.OnSoftLimit != nil {
+		budget.OnSoftLimit(bucket, a, b)
+	}
+}
+
+// budgetClient counts the operations of the storage client.
+type budgetClient struct {
+	storageClient
+	count func(classA, classB int)
+}
+
+func (c *budgetClient) unwrap() storageClient {
+	return c.storageClient
+}
+
+// budgetedClient returns the client that counts the operations against the budget of the bucket.
+func (t *Transport) budgetedClient(client storageClient, bucket string, cfg *config) storageClient {
+	budget := cfg.budget
+	if budget == nil {
+		return client
+	}
+	return &budgetClient{
+		storageClient: client,
+		count: func(classA, classB int) {
+			t.countOperations(bucket, budget, classA, classB)
+		},
+	}
+}
+
+func (c *budgetClient) Bucket(name string) bucketHandle {
+	return &budgetBucketHandle{
+		bucketHandle: c.storageClient.Bucket(name),
+		count:        c.count,
+	}
+}
+
+type budgetBucketHandle struct {
+	bucketHandle
+	count func(classA, classB int)
+}
+
+func (h *budgetBucketHandle) Object(name string) objectHandle {
+	return &budgetObjectHandle{
+		objectHandle: h.bucketHandle.Object(name),
+		count:        h.count,
+	}
+}
+
+func (h *budgetBucketHandle) UserProject(project string) bucketHandle {
+	return &budgetBucketHandle{
+		bucketHandle: h.bucketHandle.UserProject(project),
+		count:        h.count,
+	}
+}
+
+func (h *budgetBucketHandle) Objects(ctx context.Context, q *storage.Query) objectIterator {
+	return &budgetObjectIterator{
+		objectIterator: h.bucketHandle.Objects(ctx, q),
+		count:          h.count,
+	}
+}
+
+// budgetObjectIterator counts a class A operation for each page of 1000 objects.
+type budgetObjectIterator struct {
+	objectIterator
+	count func(classA, classB int)
+	n     int
+}
+
+func (it *budgetObjectIterator) Next() (*storage.ObjectAttrs, error) {
+	if it.n%1000 == 0 {
+		it.count(1, 0)
+	}
+	attrs, err := it.objectIterator.Next()
+	if err == nil {
+		it.n++
+	}
+	return attrs, err
+}
+
+type budgetObjectHandle struct {
+	objectHandle
+	count func(classA, classB int)
+}
+
+func (h *budgetObjectHandle) Attrs(ctx context.Context) (*storage.ObjectAttrs, error) {
+	h.count(0, 1)
+	return h.objectHandle.Attrs(ctx)
+}
+
+func (h *budgetObjectHandle) NewReader(ctx context.Context) (storageReader, error) {
+	h.count(0, 1)
+	return h.objectHandle.NewReader(ctx)
+}
+
+func (h *budgetObjectHandle) NewRangeReader(ctx context.Context, offset, length int64) (storageReader, error) {
+	h.count(0, 1)
+	return h.objectHandle.NewRangeReader(ctx, offset, length)
+}
+
+func (h *budgetObjectHandle) NewWriter(ctx context.Context) storageWriter {
+	h.count(1, 0)
+	return h.objectHandle.NewWriter(ctx)
+}
+
+func (h *budgetObjectHandle) Update(ctx context.Context, attrs storage.ObjectAttrsToUpdate) (*storage.ObjectAttrs, error) {
+	h.count(1, 0)
+	return h.objectHandle.Update(ctx, attrs)
+}
+
+// CopierFrom unwraps src, and counts a class A operation when the copy runs.
+func (h *budgetObjectHandle) CopierFrom(src objectHandle) storageCopier {
+	if b, ok := src.(*budgetObjectHandle); ok {
+		src = b.objectHandle
+	}
+	return &budgetCopier{
+		storageCopier: h.objectHandle.CopierFrom(src),
+		count:         h.count,
+	}
+}
+
+// ComposerFrom unwraps srcs, and counts a class A operation when the composition runs.
+func (h *budgetObjectHandle) ComposerFrom(srcs ...objectHandle) storageComposer {
+	unwrapped := make([]objectHandle, 0, len(srcs))
+	for _, src := range srcs {
+		if b, ok := src.(*budgetObjectHandle); ok {
+			src = b.objectHandle
+		}
+		unwrapped = append(unwrapped, src)
+	}
+	return &budgetComposer{
+		storageComposer: h.objectHandle.ComposerFrom(unwrapped...),
+		count:           h.count,
+	}
+}
+
+func (h *budgetObjectHandle) If(conds storage.Conditions) objectHandle {
+	return &budgetObjectHandle{
+		objectHandle: h.objectHandle.If(conds),
+		count:        h.count,
+	}
+}
+
+func (h *budgetObjectHandle) Generation(gen int64) objectHandle {
+	return &budgetObjectHandle{
+		objectHandle: h.objectHandle.Generation(gen),
+		count:        h.count,
+	}
+}
+
+func (h *budgetObjectHandle) Key(encryptionKey []byte) objectHandle {
+	return &budgetObjectHandle{
+		objectHandle: h.objectHandle.Key(encryptionKey),
+		count:        h.count,
+	}
+}
+
+func (h *budgetObjectHandle) ReadCompressed(compressed bool) objectHandle {
+	return &budgetObjectHandle{
+		objectHandle: h.objectHandle.ReadCompressed(compressed),
+		count:        h.count,
+	}
+}
+
+type budgetCopier struct {
+	storageCopier
+	count func(classA, classB int)
+}
+
+func (c *budgetCopier) Run(ctx context.Context) (*storage.ObjectAttrs, error) {
+	c.count(1, 0)
+	return c.storageCopier.Run(ctx)
+}
+
+func (c *budgetCopier) setProgressFunc(progress func(copiedBytes, totalBytes uint64)) {
+	if pc, ok := c.storageCopier.(progressCopier); ok {
+		pc.setProgressFunc(progress)
+	}
+}
+
+type budgetComposer struct {
+	storageComposer
+	count func(classA, classB int)
+}
+
+func (c *budgetComposer) Run(ctx context.Context) (*storage.ObjectAttrs, error) {
+	c.count(1, 0)
+	return c.storageComposer.Run(ctx)
+}
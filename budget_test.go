@@ -0,0 +1,153 @@
+package gsprotocol
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestRoundTrip_OperationBudget(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs:   &storage.ObjectAttrs{Generation: 1},
+			content: "Hello Google Cloud Storage!",
+		},
+		"bucket-name/prefix/a": {
+			attrs:   &storage.ObjectAttrs{Generation: 1},
+			content: "a",
+		},
+		"other-bucket/object-key": {
+			attrs:   &storage.ObjectAttrs{Generation: 1},
+			content: "Hello Google Cloud Storage!",
+		},
+	})
+	type softLimit struct {
+		bucket         string
+		classA, classB int
+	}
+	var soft []softLimit
+	tr := newTestTransport(mock,
+		WithBucketConfig("bucket-name", BucketConfig{
+			WithOperationBudget(OperationBudget{
+				Window:     time.Hour,
+				SoftClassB: 2,
+				HardClassB: 5,
+				OnSoftLimit: func(bucket string, classA, classB int) {
+					soft = append(soft, softLimit{bucket, classA, classB})
+				},
+			}),
+		}),
+	)
+	c := &http.Client{Transport: tr}
+
+	do := func(method, url string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// GET is Attrs and NewReader.
+	if resp := do(http.MethodGet, "gs://bucket-name/object-key"); resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if a, b := tr.OperationCounts("bucket-name"); a != 0 || b != 2 {
+		t.Errorf("unexpected counts: want (0, 2), got (%d, %d)", a, b)
+	}
+	if len(soft) != 1 || soft[0] != (softLimit{"bucket-name", 0, 2}) {
+		t.Errorf("unexpected soft limit calls: %v", soft)
+	}
+
+	// HEAD is Attrs.
+	do(http.MethodHead, "gs://bucket-name/object-key")
+	if a, b := tr.OperationCounts("bucket-name"); a != 0 || b != 3 {
+		t.Errorf("unexpected counts: want (0, 3), got (%d, %d)", a, b)
+	}
+
+	// an archive lists objects and reads each of them.
+	do(http.MethodGet, "gs://bucket-name/prefix/?archive=tar")
+	if a, b := tr.OperationCounts("bucket-name"); a != 1 || b != 4 {
+		t.Errorf("unexpected counts: want (1, 4), got (%d, %d)", a, b)
+	}
+	if len(soft) != 1 {
+		t.Errorf("the soft limit must be reported once in a window: %v", soft)
+	}
+
+	// reaches the hard limit.
+	do(http.MethodHead, "gs://bucket-name/object-key")
+	resp := do(http.MethodHead, "gs://bucket-name/object-key")
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusTooManyRequests, resp.StatusCode)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "3600" {
+		t.Errorf("unexpected Retry-After: want %q, got %q", "3600", got)
+	}
+	if a, b := tr.OperationCounts("bucket-name"); a != 1 || b != 5 {
+		t.Errorf("unexpected counts: want (1, 5), got (%d, %d)", a, b)
+	}
+
+	// the other bucket has no budget.
+	if resp := do(http.MethodGet, "gs://other-bucket/object-key"); resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if a, b := tr.OperationCounts("other-bucket"); a != 0 || b != 0 {
+		t.Errorf("unexpected counts: want (0, 0), got (%d, %d)", a, b)
+	}
+}
+
+func TestRoundTrip_OperationBudgetWindow(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs:   &storage.ObjectAttrs{Generation: 1},
+			content: "Hello Google Cloud Storage!",
+		},
+	})
+	tr := newTestTransport(mock,
+		WithOperationBudget(OperationBudget{
+			Window:     50 * time.Millisecond,
+			HardClassB: 1,
+		}),
+	)
+	c := &http.Client{Transport: tr}
+
+	resp, err := c.Head("gs://bucket-name/object-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	resp, err = c.Head("gs://bucket-name/object-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusTooManyRequests, resp.StatusCode)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "1" {
+		t.Errorf("unexpected Retry-After: want %q, got %q", "1", got)
+	}
+
+	// the window resets.
+	time.Sleep(60 * time.Millisecond)
+	resp, err = c.Head("gs://bucket-name/object-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+}
@@ -0,0 +1,320 @@
+package gsprotocol
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// bulkDeleteMaxConcurrentCalls is the number of the objects that a bulk delete request deletes concurrently.
+const bulkDeleteMaxConcurrentCalls = 8
+
+// ndjsonContentType is the Content-Type of the streamed results of bulk delete requests.
+const ndjsonContentType = "application/x-ndjson"
+
+// WithBulkDelete makes the DELETE requests with the recursive query parameter delete all the objects under the prefix,
+// e.g. DELETE gs://[BUCKET_NAME]/[PREFIX]?recursive=true.
+// It needs WithWriteMethods too.
+//
+// The response is a JSON object with the outcome of each object, "deleted", "skipped" or "failed",
+// and the summary of the numbers of them.
+// The failed objects have the status code and the message that the DELETE request of the object would respond.
+// The objects that are deleted or overwritten after they are listed are skipped,
+// so that a bulk delete never deletes a generation that it didn't list.
+// The status code is 200 OK if no object failed, and 207 Multi-Status otherwise.
+//
+// With the "Accept: application/x-ndjson" header, the response streams the outcomes one per line as soon as they are known,
+// and the last line is the summary.
+// The status code of the streamed response is always 200 OK.
+//
+// With the dry-run query parameter, e.g. ?recursive=true&dry-run=true,
+// the response lists the objects that would be deleted, without deleting them.
+// If the request is canceled, the Transport stops deleting, and the summary counts the objects handled until then.
+// It is disabled by default.
+func WithBulkDelete() Option {
+	return func(c *config) {
+		c.bulkDelete = true
+	}
+}
+
+// the outcomes of the objects in bulk delete requests.
+const (
+	bulkDeleteDeleted     = "deleted"
+	bulkDeleteSkipped     = "skipped"
+	bulkDeleteFailed      = "failed"
+	bulkDeleteWouldDelete = "would-delete"
+)
+
+// bulkDeleteResult is the outcome of an object in the response of a bulk delete request.
+type bulkDeleteResult struct {
+	Key        string `json:"key"`
+	Generation int64  `json:"generation,string"`
+	Outcome    string `json:"outcome"`
+
+	// the response that the DELETE request of the object would get, if it is not deleted.
+	Status int    `json:"status,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// bulkDeleteCounts is the summary of the outcomes of a bulk delete request.
+// Succeeded is the number of the deleted and skipped objects.
+type bulkDeleteCounts struct {
+	Attempted int `json:"attempted"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Deleted   int `json:"deleted"`
+	Skipped   int `json:"skipped"`
+}
+
+// bulkDeleteSummary is the response body of a bulk delete request,
+// and the last line of the streamed one, which doesn't have Results.
+type bulkDeleteSummary struct {
+	Prefix   string             `json:"prefix"`
+	DryRun   bool               `json:"dryRun,omitempty"`
+	Summary  bulkDeleteCounts   `json:"summary"`
+	Results  []bulkDeleteResult `json:"results,omitempty"`
+	Canceled bool               `json:"canceled,omitempty"`
+
+	// Error is the error of listing the objects, if it stops in the middle.
+	Error string `json:"error,omitempty"`
+}
+
+// add counts the result.
+func (s *bulkDeleteSummary) add(result bulkDeleteResult) {
+	switch result.Outcome {
+	case bulkDeleteDeleted:
+		s.Summary.Deleted++
+		s.Summary.Succeeded++
+	case bulkDeleteSkipped:
+		s.Summary.Skipped++
+		s.Summary.Succeeded++
+	case bulkDeleteFailed:
+		s.Summary.Failed++
+	default:
+		// the dry runs don't attempt.
+		return
+	}
+	s.Summary.Attempted++
+}
+
+// deletePrefix deletes the objects under the prefix of the request, e.g. gs://[BUCKET_NAME]/[PREFIX]?recursive=true.
+func (t *Transport) deletePrefix(req *http.Request, client storageClient) (*http.Response, error) {
+	query := req.URL.Query()
+	if recursive, err := strconv.ParseBool(query.Get("recursive")); err != nil || !recursive {
+		return newErrorResponse(http.StatusBadRequest, fmt.Sprintf("gsprotocol: invalid recursive %q", query.Get("recursive"))), nil
+	}
+	dryRun := false
+	if v := query.Get("dry-run"); v != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(v)
+		if err != nil {
+			return newErrorResponse(http.StatusBadRequest, fmt.Sprintf("gsprotocol: invalid dry-run %q", v)), nil
+		}
+	}
+	bucketName := bucketName(req)
+	cfg := t.config.forBucket(bucketName)
+	if !cfg.bulkDelete {
+		return newErrorResponse(http.StatusForbidden, "gsprotocol: bulk delete is disabled"), nil
+	}
+	if req.URL.Fragment != "" {
+		return newErrorResponse(http.StatusBadRequest, errWriteGeneration.Error()), nil
+	}
+	prefix := objectName(req.URL)
+	if prefix == "" {
+		// deleting the whole bucket is too dangerous to do by a typo.
+		return newErrorResponse(http.StatusBadRequest, "gsprotocol: bulk delete needs a prefix"), nil
+	}
+
+	ctx := req.Context()
+	q := &storage.Query{Prefix: prefix}
+	if err := q.SetAttrSelection([]string{"Name", "Generation"}); err != nil {
+		return nil, err
+	}
+	bucket := client.Bucket(bucketName)
+	d := &bulkDeleter{
+		t:          t,
+		req:        req,
+		bucket:     bucket,
+		bucketName: bucketName,
+		dryRun:     dryRun,
+		it:         bucket.Objects(ctx, q),
+	}
+	// the errors of the first page are the errors of the request.
+	var err error
+	d.first, err = d.it.Next()
+	if err != nil && err != iterator.Done {
+		return handleError(err)
+	}
+	summary := &bulkDeleteSummary{Prefix: prefix, DryRun: dryRun}
+
+	header := make(http.Header)
+	if strings.Contains(req.Header.Get("Accept"), ndjsonContentType) {
+		header.Set("Content-Type", ndjsonContentType)
+		pr, pw := io.Pipe()
+		go func() {
+			enc := json.NewEncoder(pw)
+			var mu sync.Mutex
+			var encErr error
+			d.run(ctx, summary, func(result bulkDeleteResult) {
+				mu.Lock()
+				defer mu.Unlock()
+				if encErr == nil {
+					encErr = enc.Encode(result)
+				}
+			})
+			if encErr == nil {
+				encErr = enc.Encode(summary)
+			}
+			pw.CloseWithError(encErr)
+		}()
+		return cfg.compressResponse(req, &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         "HTTP/1.0",
+			ProtoMajor:    1,
+			ProtoMinor:    0,
+			Header:        header,
+			Body:          pr,
+			ContentLength: -1,
+			Close:         true,
+		}), nil
+	}
+
+	var mu sync.Mutex
+	d.run(ctx, summary, func(result bulkDeleteResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		summary.Results = append(summary.Results, result)
+	})
+	sort.Slice(summary.Results, func(i, j int) bool {
+		return summary.Results[i].Key < summary.Results[j].Key
+	})
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return nil, err
+	}
+	statusCode := http.StatusOK
+	if summary.Summary.Failed > 0 || summary.Canceled || summary.Error != "" {
+		statusCode = http.StatusMultiStatus
+	}
+	header.Set("Content-Type", "application/json; charset=utf-8")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return cfg.compressResponse(req, &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.0",
+		ProtoMajor:    1,
+		ProtoMinor:    0,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Close:         true,
+	}), nil
+}
+
+// bulkDeleter deletes the objects that it lists.
+type bulkDeleter struct {
+	t          *Transport
+	req        *http.Request
+	bucket     bucketHandle
+	bucketName string
+	dryRun     bool
+
+	// it lists the objects, and first is the first one, or nil if there is none.
+	it    objectIterator
+	first *storage.ObjectAttrs
+}
+
+// run deletes the objects, and calls emit with the result of each object concurrently.
+// It returns after all the calls of emit return, with the summary updated.
+func (d *bulkDeleter) run(ctx context.Context, summary *bulkDeleteSummary, emit func(result bulkDeleteResult)) {
+	var mu sync.Mutex
+	report := func(result bulkDeleteResult) {
+		mu.Lock()
+		summary.add(result)
+		mu.Unlock()
+		emit(result)
+	}
+
+	sem := make(chan struct{}, bulkDeleteMaxConcurrentCalls)
+	var wg sync.WaitGroup
+	attrs := d.first
+	for attrs != nil && ctx.Err() == nil {
+		if d.dryRun {
+			report(bulkDeleteResult{Key: attrs.Name, Generation: attrs.Generation, Outcome: bulkDeleteWouldDelete})
+		} else {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			wg.Add(1)
+			go func(name string, gen int64) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				report(d.deleteObject(ctx, name, gen))
+			}(attrs.Name, attrs.Generation)
+		}
+
+		var err error
+		attrs, err = d.it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				summary.Error = err.Error()
+			}
+			break
+		}
+	}
+	wg.Wait()
+	summary.Canceled = ctx.Err() != nil
+}
+
+// deleteObject deletes the generation of the object,
+// and classifies the error in the same way as the DELETE request of the object.
+func (d *bulkDeleter) deleteObject(ctx context.Context, name string, gen int64) bulkDeleteResult {
+	result := bulkDeleteResult{Key: name, Generation: gen}
+	object := d.bucket.Object(name).If(storage.Conditions{GenerationMatch: gen})
+	err := object.Delete(ctx)
+	if err == nil {
+		d.t.publishWrite(d.req, d.bucketName, name, nil)
+		result.Outcome = bulkDeleteDeleted
+		return result
+	}
+
+	err = wrapRetentionError(ctx, object, err)
+	result.Error = err.Error()
+	resp, err := handleError(err)
+	if err != nil {
+		result.Outcome = bulkDeleteFailed
+		result.Status = http.StatusInternalServerError
+		result.Error = err.Error()
+		return result
+	}
+	resp.Body.Close()
+	result.Status = resp.StatusCode
+	result.Reason = resp.Header.Get("x-gsprotocol-error")
+	switch resp.StatusCode {
+	case http.StatusNotFound, http.StatusPreconditionFailed:
+		// the listed generation is deleted or overwritten by the others.
+		result.Outcome = bulkDeleteSkipped
+	default:
+		result.Outcome = bulkDeleteFailed
+	}
+	return result
+}
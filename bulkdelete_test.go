@@ -0,0 +1,307 @@
+package gsprotocol
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// bulkDeleteMock is the bucket of the bulk delete tests.
+type bulkDeleteMock struct {
+	mu      sync.Mutex
+	objects map[string]int64 // the names to the generations
+
+	// deleteFunc, if not nil, is called before deleting an object.
+	deleteFunc func(ctx context.Context, name string) error
+}
+
+func (b *bulkDeleteMock) client() *storageClientMock {
+	return &storageClientMock{
+		bucketFunc: func(mock *storageClientMock, name string) *bucketHandleMock {
+			return &bucketHandleMock{
+				objectFunc: func(mock *bucketHandleMock, name string) *objectHandleMock {
+					return &objectHandleMock{
+						deleteFunc: func(ctx context.Context, mock *objectHandleMock) error {
+							if b.deleteFunc != nil {
+								if err := b.deleteFunc(ctx, name); err != nil {
+									return err
+								}
+							}
+							b.mu.Lock()
+							defer b.mu.Unlock()
+							gen, ok := b.objects[name]
+							if !ok {
+								return storage.ErrObjectNotExist
+							}
+							if mock.conds.GenerationMatch != 0 && mock.conds.GenerationMatch != gen {
+								return &googleapi.Error{Code: http.StatusPreconditionFailed}
+							}
+							delete(b.objects, name)
+							return nil
+						},
+					}
+				},
+				objectsFunc: func(ctx context.Context, mock *bucketHandleMock, q *storage.Query) objectIterator {
+					b.mu.Lock()
+					defer b.mu.Unlock()
+					it := &objectIteratorMock{}
+					for name, gen := range b.objects {
+						if strings.HasPrefix(name, q.Prefix) {
+							it.attrs = append(it.attrs, &storage.ObjectAttrs{Name: name, Generation: gen})
+						}
+					}
+					sort.Slice(it.attrs, func(i, j int) bool { return it.attrs[i].Name < it.attrs[j].Name })
+					return it
+				},
+			}
+		},
+	}
+}
+
+func (b *bulkDeleteMock) names() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var names []string
+	for name := range b.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func doBulkDelete(t *testing.T, tr *Transport, ctx context.Context, url string) (*http.Response, *bulkDeleteSummary) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusMultiStatus {
+		return resp, nil
+	}
+	var summary bulkDeleteSummary
+	if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+		t.Fatal(err)
+	}
+	return resp, &summary
+}
+
+func TestRoundTrip_BulkDelete(t *testing.T) {
+	b := &bulkDeleteMock{
+		objects: map[string]int64{
+			"logs/a.txt":      1,
+			"logs/b.txt":      2,
+			"logs/held.txt":   3,
+			"logs/raced.txt":  4,
+			"logs/sub/c.txt":  5,
+			"other/keep.txt":  6,
+			"logs-keep/d.txt": 7,
+		},
+		deleteFunc: func(ctx context.Context, name string) error {
+			switch name {
+			case "logs/held.txt":
+				return &googleapi.Error{Code: http.StatusForbidden, Message: "object is under active hold"}
+			case "logs/raced.txt":
+				// the object is overwritten after it is listed.
+				return &googleapi.Error{Code: http.StatusPreconditionFailed}
+			}
+			return nil
+		},
+	}
+	tr := newTestTransport(b.client(), WithWriteMethods(), WithBulkDelete())
+
+	resp, summary := doBulkDelete(t, tr, context.Background(), "gs://bucket-name/logs/?recursive=true")
+	if resp.StatusCode != http.StatusMultiStatus {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusMultiStatus, resp.StatusCode)
+	}
+	want := &bulkDeleteSummary{
+		Prefix:  "logs/",
+		Summary: bulkDeleteCounts{Attempted: 5, Succeeded: 4, Failed: 1, Deleted: 3, Skipped: 1},
+		Results: []bulkDeleteResult{
+			{Key: "logs/a.txt", Generation: 1, Outcome: "deleted"},
+			{Key: "logs/b.txt", Generation: 2, Outcome: "deleted"},
+			{Key: "logs/held.txt", Generation: 3, Outcome: "failed", Status: http.StatusForbidden, Error: "googleapi: Error 403: object is under active hold"},
+			{Key: "logs/raced.txt", Generation: 4, Outcome: "skipped", Status: http.StatusPreconditionFailed, Error: "googleapi: got HTTP response code 412 with body: "},
+			{Key: "logs/sub/c.txt", Generation: 5, Outcome: "deleted"},
+		},
+	}
+	if !reflect.DeepEqual(summary, want) {
+		t.Errorf("unexpected summary: want %#v, got %#v", want, summary)
+	}
+	if got, want := b.names(), []string{"logs-keep/d.txt", "logs/held.txt", "logs/raced.txt", "other/keep.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected objects: want %v, got %v", want, got)
+	}
+}
+
+func TestRoundTrip_BulkDeleteDryRun(t *testing.T) {
+	b := &bulkDeleteMock{
+		objects: map[string]int64{
+			"logs/a.txt":     1,
+			"logs/b.txt":     2,
+			"other/keep.txt": 3,
+		},
+	}
+	tr := newTestTransport(b.client(), WithWriteMethods(), WithBulkDelete())
+
+	resp, summary := doBulkDelete(t, tr, context.Background(), "gs://bucket-name/logs/?recursive=true&dry-run=true")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	want := &bulkDeleteSummary{
+		Prefix: "logs/",
+		DryRun: true,
+		Results: []bulkDeleteResult{
+			{Key: "logs/a.txt", Generation: 1, Outcome: "would-delete"},
+			{Key: "logs/b.txt", Generation: 2, Outcome: "would-delete"},
+		},
+	}
+	if !reflect.DeepEqual(summary, want) {
+		t.Errorf("unexpected summary: want %#v, got %#v", want, summary)
+	}
+	if got := b.names(); len(got) != 3 {
+		t.Errorf("the dry run deleted the objects: %v", got)
+	}
+}
+
+func TestRoundTrip_BulkDeleteCancel(t *testing.T) {
+	const total = 16
+	objects := make(map[string]int64, total)
+	for i := 0; i < total; i++ {
+		objects[fmt.Sprintf("logs/%02d", i)] = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := &bulkDeleteMock{
+		objects: objects,
+		deleteFunc: func(ctx context.Context, name string) error {
+			if name == "logs/02" {
+				cancel()
+			}
+			if name >= "logs/02" {
+				// the deletions after the cancellation wait for it.
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			return nil
+		},
+	}
+	tr := newTestTransport(b.client(), WithWriteMethods(), WithBulkDelete())
+
+	resp, summary := doBulkDelete(t, tr, ctx, "gs://bucket-name/logs/?recursive=true")
+	if resp.StatusCode != http.StatusMultiStatus {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusMultiStatus, resp.StatusCode)
+	}
+	if !summary.Canceled {
+		t.Error("want canceled")
+	}
+	remaining := len(b.names())
+	if summary.Summary.Deleted != total-remaining {
+		t.Errorf("the summary doesn't match: deleted %d, remaining %d", summary.Summary.Deleted, remaining)
+	}
+	if summary.Summary.Attempted != len(summary.Results) {
+		t.Errorf("the summary doesn't match: attempted %d, results %d", summary.Summary.Attempted, len(summary.Results))
+	}
+	if summary.Summary.Attempted > bulkDeleteMaxConcurrentCalls+2 {
+		t.Errorf("the deletions didn't stop: %#v", summary)
+	}
+}
+
+func TestRoundTrip_BulkDeleteStream(t *testing.T) {
+	b := &bulkDeleteMock{
+		objects: map[string]int64{
+			"logs/a.txt":    1,
+			"logs/b.txt":    2,
+			"logs/held.txt": 3,
+		},
+		deleteFunc: func(ctx context.Context, name string) error {
+			if name == "logs/held.txt" {
+				return &googleapi.Error{Code: http.StatusForbidden, Message: "object is under active hold"}
+			}
+			return nil
+		},
+	}
+	tr := newTestTransport(b.client(), WithWriteMethods(), WithBulkDelete())
+
+	req, err := http.NewRequest(http.MethodDelete, "gs://bucket-name/logs/?recursive=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/x-ndjson")
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "application/x-ndjson" {
+		t.Errorf("unexpected Content-Type: %q", got)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	outcomes := make(map[string]string)
+	for i := 0; i < 3; i++ {
+		var result bulkDeleteResult
+		if err := dec.Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		outcomes[result.Key] = result.Outcome
+	}
+	wantOutcomes := map[string]string{"logs/a.txt": "deleted", "logs/b.txt": "deleted", "logs/held.txt": "failed"}
+	if !reflect.DeepEqual(outcomes, wantOutcomes) {
+		t.Errorf("unexpected outcomes: want %v, got %v", wantOutcomes, outcomes)
+	}
+	var summary bulkDeleteSummary
+	if err := dec.Decode(&summary); err != nil {
+		t.Fatal(err)
+	}
+	want := bulkDeleteSummary{
+		Prefix:  "logs/",
+		Summary: bulkDeleteCounts{Attempted: 3, Succeeded: 2, Failed: 1, Deleted: 2},
+	}
+	if !reflect.DeepEqual(summary, want) {
+		t.Errorf("unexpected summary: want %#v, got %#v", want, summary)
+	}
+	if dec.More() {
+		t.Error("unexpected lines after the summary")
+	}
+}
+
+func TestRoundTrip_BulkDeleteRejected(t *testing.T) {
+	b := &bulkDeleteMock{objects: map[string]int64{"logs/a.txt": 1}}
+	tests := []struct {
+		name   string
+		opts   []Option
+		url    string
+		status int
+	}{
+		{"disabled", []Option{WithWriteMethods()}, "gs://bucket-name/logs/?recursive=true", http.StatusForbidden},
+		{"read only", []Option{WithBulkDelete()}, "gs://bucket-name/logs/?recursive=true", http.StatusMethodNotAllowed},
+		{"no prefix", []Option{WithWriteMethods(), WithBulkDelete()}, "gs://bucket-name/?recursive=true", http.StatusBadRequest},
+		{"invalid recursive", []Option{WithWriteMethods(), WithBulkDelete()}, "gs://bucket-name/logs/?recursive=yes", http.StatusBadRequest},
+		{"invalid dry-run", []Option{WithWriteMethods(), WithBulkDelete()}, "gs://bucket-name/logs/?recursive=true&dry-run=maybe", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTestTransport(b.client(), tt.opts...)
+			resp, _ := doBulkDelete(t, tr, context.Background(), tt.url)
+			if resp.StatusCode != tt.status {
+				t.Errorf("unexpected status: want %d, got %d", tt.status, resp.StatusCode)
+			}
+		})
+	}
+	if got := b.names(); len(got) != 1 {
+		t.Errorf("the objects are deleted: %v", got)
+	}
+}
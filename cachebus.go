@@ -0,0 +1,76 @@
+package gsprotocol
+
+import (
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// WriteEvent describes an object modified by a write request through the Transport.
+type WriteEvent struct {
+	// Method is the method of the write request, e.g. PUT, DELETE, PATCH or POST.
+	// The copies are PUT requests.
+	Method string
+
+	Bucket string
+	Object string
+
+	// Prefix is the parent prefix of Object, e.g. "logs/2020/" of "logs/2020/app.log",
+	// for the caches of the listings. It is empty for the objects at the top of the bucket.
+	Prefix string
+
+	// Attrs is the attributes of the object written, e.g. by PUT or PATCH,
+	// or nil if the object is deleted.
+	// It must not be modified.
+	Attrs *storage.ObjectAttrs
+}
+
+// CacheSubscriber is notified of the writes through the Transport, to keep its caches of the objects fresh.
+type CacheSubscriber interface {
+	// ObjectWritten is called synchronously after the object is modified, before the Transport responds to the write request.
+	// It may be called concurrently.
+	ObjectWritten(ev *WriteEvent)
+}
+
+// WithCacheSubscriber makes the Transport notify s of the objects modified by the write requests through it,
+// in the same way as its own caches, e.g. WithAttrsCache and WithHeadMemo, are updated.
+// If WithCacheSubscriber is given more than once, all of the subscribers are notified in order.
+func WithCacheSubscriber(s CacheSubscriber) Option {
+	return func(c *config) {
+		c.cacheSubscribers = append(c.cacheSubscribers[:len(c.cacheSubscribers):len(c.cacheSubscribers)], s)
+	}
+}
+
+// publishWrite notifies the caches that the write request modified the object.
+// attrs is the attributes of the object written, or nil if it is deleted.
+func (t *Transport) publishWrite(req *http.Request, bucket, object string, attrs *storage.ObjectAttrs) {
+	cfg := t.config.forBucket(bucket)
+	t.invalidateCaches(cfg, bucket, object)
+	if attrs != nil {
+		// the next read gets the generation written, without looking it up.
+		t.attrsCache.add(cfg, attrsCacheKey{bucket: bucket, object: object}, attrs)
+	}
+
+	if len(cfg.cacheSubscribers) == 0 {
+		return
+	}
+	ev := &WriteEvent{
+		Method: req.Method,
+		Bucket: bucket,
+		Object: object,
+		Prefix: object[:strings.LastIndex(object, "/")+1],
+		Attrs:  attrs,
+	}
+	for _, s := range cfg.cacheSubscribers {
+		s.ObjectWritten(ev)
+	}
+}
+
+// invalidateCaches drops the cached attributes of the object.
+func (t *Transport) invalidateCaches(cfg *config, bucket, object string) {
+	t.attrsCache.invalidate(bucket, object)
+	t.forgetHead(bucket, object)
+	// PATCH requests modify the metadata of the generations.
+	cfg.generationCache.invalidate(bucket, object)
+}
@@ -0,0 +1,179 @@
+package gsprotocol
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// cacheBusMock is an object that the write requests modify.
+type cacheBusMock struct {
+	mu         sync.Mutex
+	generation int64
+	contents   map[int64]string // the generations to the contents
+}
+
+func (o *cacheBusMock) client() *storageClientMock {
+	object := &objectHandleMock{
+		attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			gen := mock.generation
+			if gen == 0 {
+				gen = o.generation
+			}
+			content, ok := o.contents[gen]
+			if !ok {
+				return nil, storage.ErrObjectNotExist
+			}
+			return &storage.ObjectAttrs{Bucket: "bucket-name", Name: "dir/object-key", Generation: gen, Size: int64(len(content))}, nil
+		},
+		newReaderFunc: func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			gen := mock.generation
+			if gen == 0 {
+				gen = o.generation
+			}
+			content, ok := o.contents[gen]
+			if !ok {
+				return storage.ReaderObjectAttrs{}, nil, storage.ErrObjectNotExist
+			}
+			attrs := storage.ReaderObjectAttrs{Generation: gen, Size: int64(len(content))}
+			return attrs, io.NopCloser(strings.NewReader(content)), nil
+		},
+		newWriterFunc: func(ctx context.Context, mock *objectHandleMock) *storageWriterMock {
+			return &storageWriterMock{
+				ctx: ctx,
+				closeFunc: func(w *storageWriterMock) (*storage.ObjectAttrs, error) {
+					o.mu.Lock()
+					defer o.mu.Unlock()
+					o.generation++
+					o.contents[o.generation] = w.buf.String()
+					attrs := w.attrs
+					attrs.Bucket = "bucket-name"
+					attrs.Name = "dir/object-key"
+					attrs.Generation = o.generation
+					attrs.Size = int64(w.buf.Len())
+					return &attrs, nil
+				},
+			}
+		},
+		updateFunc: func(ctx context.Context, mock *objectHandleMock, uattrs storage.ObjectAttrsToUpdate) (*storage.ObjectAttrs, error) {
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			return &storage.ObjectAttrs{Bucket: "bucket-name", Name: "dir/object-key", Generation: o.generation, Metageneration: 2}, nil
+		},
+		deleteFunc: func(ctx context.Context, mock *objectHandleMock) error {
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			delete(o.contents, o.generation)
+			return nil
+		},
+		generationFunc: withGeneration,
+	}
+	return newSingleObjectMock(object)
+}
+
+// cacheSubscriberFunc is a CacheSubscriber of a function.
+type cacheSubscriberFunc func(ev *WriteEvent)
+
+func (f cacheSubscriberFunc) ObjectWritten(ev *WriteEvent) {
+	f(ev)
+}
+
+func TestRoundTrip_GetAfterPut(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"attrs cache", []Option{WithAttrsCache(100, time.Hour)}},
+		{"head memo", []Option{WithHeadMemo(time.Hour, 100)}},
+		{"generation cache", []Option{WithGenerationCache(NewGenerationCache(1 << 20))}},
+		{"all", []Option{WithAttrsCache(100, time.Hour), WithHeadMemo(time.Hour, 100), WithGenerationCache(NewGenerationCache(1 << 20))}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &cacheBusMock{generation: 1, contents: map[int64]string{1: "version 1"}}
+			tr := newTestTransport(o.client(), append([]Option{WithWriteMethods()}, tt.opts...)...)
+			c := &http.Client{Transport: tr}
+
+			// warm the caches.
+			resp, err := c.Head("gs://bucket-name/dir/object-key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			for _, content := range []string{"version 2", "version 3"} {
+				req, err := http.NewRequest(http.MethodPut, "gs://bucket-name/dir/object-key", strings.NewReader(content))
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp, err := c.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+
+				resp, err = c.Get("gs://bucket-name/dir/object-key")
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != content {
+					t.Errorf("want %q, got %q", content, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRoundTrip_CacheSubscriber(t *testing.T) {
+	o := &cacheBusMock{generation: 1, contents: map[int64]string{1: "version 1"}}
+	var events []WriteEvent
+	tr := newTestTransport(o.client(),
+		WithWriteMethods(),
+		WithCacheSubscriber(cacheSubscriberFunc(func(ev *WriteEvent) {
+			events = append(events, *ev)
+		})),
+	)
+
+	for _, method := range []string{http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		req, err := http.NewRequest(method, "gs://bucket-name/dir/object-key", strings.NewReader("version 2"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("want 3 events, got %d", len(events))
+	}
+	for i, method := range []string{http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		ev := events[i]
+		if ev.Method != method || ev.Bucket != "bucket-name" || ev.Object != "dir/object-key" || ev.Prefix != "dir/" {
+			t.Errorf("unexpected event: %#v", ev)
+		}
+		if deleted := method == http.MethodDelete; (ev.Attrs == nil) != deleted {
+			t.Errorf("%s: unexpected attrs: %#v", method, ev.Attrs)
+		}
+	}
+	if events[0].Attrs.Generation != 2 {
+		t.Errorf("unexpected generation: want 2, got %d", events[0].Attrs.Generation)
+	}
+}
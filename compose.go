@@ -0,0 +1,105 @@
+package gsprotocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// maxComposeSources is the maximum number of the source objects that Google Cloud Storage composes in a request.
+const maxComposeSources = 32
+
+// maxComposeRequestSize is the maximum size of the body of a compose request.
+const maxComposeRequestSize = 1 << 20
+
+// composeRequest is the body of a compose request, the same as the one of the JSON API, e.g.
+// {"sourceObjects":[{"name":"part-1"},{"name":"part-2","generation":"1587160158394554"}]}.
+type composeRequest struct {
+	SourceObjects []composeSource `json:"sourceObjects"`
+}
+
+type composeSource struct {
+	Name       string `json:"name"`
+	Generation int64  `json:"generation,string,omitempty"`
+}
+
+// composeObject composes the source objects in the request body into the object of the request.
+// The source objects are in the same bucket as the object.
+// The Content-Type, Content-Language, Cache-Control, Content-Encoding, Content-Disposition and x-goog-meta-* headers
+// of the request are the attributes of the object.
+func (t *Transport) composeObject(req *http.Request, client storageClient) (*http.Response, error) {
+	if req.URL.Fragment != "" {
+		return newErrorResponse(http.StatusBadRequest, errWriteGeneration.Error()), nil
+	}
+	conds, hasConds, err := writeConditions(req.Header)
+	if err != nil {
+		return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	var body io.Reader = http.NoBody
+	if req.Body != nil {
+		body = req.Body
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxComposeRequestSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxComposeRequestSize {
+		msg := fmt.Sprintf("gsprotocol: the compose request is too large, the limit is %d bytes", maxComposeRequestSize)
+		return newErrorResponse(http.StatusRequestEntityTooLarge, msg), nil
+	}
+	var compose composeRequest
+	if err := json.Unmarshal(data, &compose); err != nil {
+		return newErrorResponse(http.StatusBadRequest, fmt.Sprintf("gsprotocol: invalid compose request: %v", err)), nil
+	}
+	if err := compose.validate(); err != nil {
+		return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+	}
+
+	bucket := client.Bucket(bucketName(req))
+	srcs := make([]objectHandle, 0, len(compose.SourceObjects))
+	for _, src := range compose.SourceObjects {
+		object := bucket.Object(src.Name)
+		if src.Generation != 0 {
+			object = object.Generation(src.Generation)
+		}
+		srcs = append(srcs, object)
+	}
+	dst := bucket.Object(objectName(req.URL))
+	if hasConds {
+		dst = dst.If(conds)
+	}
+	c := dst.ComposerFrom(srcs...)
+	setObjectAttrsFromHeader(c.ObjectAttrs(), req.Header)
+
+	attrs, err := c.Run(req.Context())
+	if err != nil {
+		return handleError(err)
+	}
+	t.publishWrite(req, bucketName(req), objectName(req.URL), attrs)
+	resp := newWrittenResponse(attrs)
+	if attrs.ComponentCount > 0 {
+		resp.Header.Set("X-Goog-Component-Count", strconv.FormatInt(attrs.ComponentCount, 10))
+	}
+	return resp, nil
+}
+
+// validate checks the request before any RPC.
+func (r *composeRequest) validate() error {
+	if len(r.SourceObjects) == 0 {
+		return fmt.Errorf("gsprotocol: no source objects to compose")
+	}
+	if len(r.SourceObjects) > maxComposeSources {
+		return fmt.Errorf("gsprotocol: too many source objects: %d objects, the limit is %d", len(r.SourceObjects), maxComposeSources)
+	}
+	for i, src := range r.SourceObjects {
+		if src.Name == "" {
+			return fmt.Errorf("gsprotocol: the source object %d has no name", i)
+		}
+		if src.Generation < 0 {
+			return fmt.Errorf("gsprotocol: the source object %q has an invalid generation %d", src.Name, src.Generation)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package gsprotocol
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestRoundTrip_Compose(t *testing.T) {
+	type composed struct {
+		dst         string
+		srcs        []string
+		contentType string
+	}
+	var runs []composed
+	names := make(map[*objectHandleMock]string)
+	newObject := func(name string) *objectHandleMock {
+		object := &objectHandleMock{
+			generationFunc: func(mock *objectHandleMock, gen int64) *objectHandleMock {
+				cp := *mock
+				cp.generation = gen
+				names[&cp] = fmt.Sprintf("%s#%d", names[mock], gen)
+				return &cp
+			},
+			composerFunc: func(dst *objectHandleMock, srcs []*objectHandleMock) *storageCopierMock {
+				return &storageCopierMock{
+					runFunc: func(ctx context.Context, c *storageCopierMock) (*storage.ObjectAttrs, error) {
+						run := composed{
+							dst:         names[dst],
+							contentType: c.attrs.ContentType,
+						}
+						for _, src := range srcs {
+							if names[src] == "missing-key" {
+								return nil, storage.ErrObjectNotExist
+							}
+							run.srcs = append(run.srcs, names[src])
+						}
+						runs = append(runs, run)
+						return &storage.ObjectAttrs{
+							Bucket:         "bucket-name",
+							Name:           names[dst],
+							ContentType:    c.attrs.ContentType,
+							Generation:     1587160158394555,
+							Metageneration: 1,
+							ComponentCount: int64(len(srcs)),
+						}, nil
+					},
+				}
+			},
+		}
+		names[object] = name
+		return object
+	}
+	var rpcs int
+	mock := &storageClientMock{
+		bucketFunc: func(mock *storageClientMock, bucket string) *bucketHandleMock {
+			return &bucketHandleMock{
+				objectFunc: func(mock *bucketHandleMock, name string) *objectHandleMock {
+					rpcs++
+					return newObject(name)
+				},
+			}
+		},
+	}
+	tr := newTestTransport(mock, WithWriteMethods())
+
+	t.Run("compose", func(t *testing.T) {
+		runs = nil
+		body := `{"sourceObjects":[{"name":"part-1"},{"name":"part-2","generation":"1587160158394554"}]}`
+		req, err := http.NewRequest(http.MethodPost, "gs://bucket-name/object-key", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "text/plain")
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		if got, want := resp.Header.Get("X-Goog-Generation"), "1587160158394555"; got != want {
+			t.Errorf("unexpected X-Goog-Generation: want %q, got %q", want, got)
+		}
+		if got, want := resp.Header.Get("X-Goog-Component-Count"), "2"; got != want {
+			t.Errorf("unexpected X-Goog-Component-Count: want %q, got %q", want, got)
+		}
+		want := []composed{{
+			dst:         "object-key",
+			srcs:        []string{"part-1", "part-2#1587160158394554"},
+			contentType: "text/plain",
+		}}
+		if !reflect.DeepEqual(runs, want) {
+			t.Errorf("unexpected compositions: want %#v, got %#v", want, runs)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, "gs://bucket-name/object-key", strings.NewReader(`{"sourceObjects":[{"name":"missing-key"}]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("unexpected status: want %d, got %d", http.StatusNotFound, resp.StatusCode)
+		}
+	})
+
+	var tooMany []string
+	for i := 0; i < maxComposeSources+1; i++ {
+		tooMany = append(tooMany, fmt.Sprintf(`{"name":"part-%d"}`, i))
+	}
+	tests := []struct {
+		name string
+		url  string
+		body string
+	}{
+		{"too many sources", "gs://bucket-name/object-key", `{"sourceObjects":[` + strings.Join(tooMany, ",") + `]}`},
+		{"no sources", "gs://bucket-name/object-key", `{"sourceObjects":[]}`},
+		{"no name", "gs://bucket-name/object-key", `{"sourceObjects":[{"generation":"1"}]}`},
+		{"invalid json", "gs://bucket-name/object-key", `{"sourceObjects":`},
+		{"generation", "gs://bucket-name/object-key#1587160158394554", `{"sourceObjects":[{"name":"part-1"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rpcs = 0
+			req, err := http.NewRequest(http.MethodPost, tt.url, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("unexpected status: want %d, got %d", http.StatusBadRequest, resp.StatusCode)
+			}
+			if rpcs != 0 {
+				t.Errorf("want no RPC, got %d object handles", rpcs)
+			}
+		})
+	}
+}
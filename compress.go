@@ -0,0 +1,88 @@
+package gsprotocol
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// WithResponseCompression makes the Transport gzip the JSON bodies that it builds by itself,
+// i.e. the metadata of ?objects requests and the results of bulk deletes,
+// if the request has the "Accept-Encoding: gzip" header.
+// The bodies smaller than minSize bytes are sent as they are, and the streamed bodies of unknown length are always compressed.
+// The compressed responses have "Content-Encoding: gzip" and no Content-Length,
+// and the responses that may be compressed have "Vary: Accept-Encoding".
+// The bodies of the objects are never compressed. It is disabled by default.
+func WithResponseCompression(minSize int) Option {
+	return func(c *config) {
+		c.responseCompression = true
+		c.compressionMinSize = minSize
+	}
+}
+
+// gzipWriterPool is the pool of the gzip writers of compressResponse.
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(io.Discard)
+	},
+}
+
+// acceptsGzip reports whether the Accept-Encoding header of the request accepts gzip.
+func acceptsGzip(req *http.Request) bool {
+	for _, v := range req.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(v, ",") {
+			name, params, _ := strings.Cut(coding, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != "gzip" && name != "x-gzip" && name != "*" {
+				continue
+			}
+			q := strings.TrimSpace(params)
+			if strings.HasPrefix(q, "q=") {
+				if f, err := strconv.ParseFloat(q[len("q="):], 64); err == nil && f == 0 {
+					continue
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// compressResponse gzips the body of resp that the Transport built by itself, if the request accepts gzip.
+// See WithResponseCompression.
+func (c *config) compressResponse(req *http.Request, resp *http.Response) *http.Response {
+	if !c.responseCompression {
+		return resp
+	}
+	resp.Header.Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(req) || resp.Header.Get("Content-Encoding") != "" {
+		return resp
+	}
+	if resp.ContentLength >= 0 && resp.ContentLength < int64(c.compressionMinSize) {
+		return resp
+	}
+
+	body := resp.Body
+	pr, pw := io.Pipe()
+	go func() {
+		zw := gzipWriterPool.Get().(*gzip.Writer)
+		zw.Reset(pw)
+		_, err := writeTo(zw, body)
+		if closeErr := zw.Close(); err == nil {
+			err = closeErr
+		}
+		// don't keep a reference to pw in the pool.
+		zw.Reset(io.Discard)
+		gzipWriterPool.Put(zw)
+		body.Close()
+		pw.CloseWithError(err)
+	}()
+	resp.Header.Set("Content-Encoding", "gzip")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Body = pr
+	return resp
+}
@@ -0,0 +1,168 @@
+package gsprotocol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header []string
+		want   bool
+	}{
+		{nil, false},
+		{[]string{"gzip"}, true},
+		{[]string{"deflate, GZIP;q=0.5"}, true},
+		{[]string{"br", "x-gzip"}, true},
+		{[]string{"*"}, true},
+		{[]string{"gzip;q=0"}, false},
+		{[]string{"identity"}, false},
+		{[]string{"gzipped"}, false},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header["Accept-Encoding"] = tt.header
+		if got := acceptsGzip(req); got != tt.want {
+			t.Errorf("%q: want %t, got %t", tt.header, tt.want, got)
+		}
+	}
+}
+
+func TestRoundTrip_ResponseCompression(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/a.txt": {
+			attrs:   &storage.ObjectAttrs{ContentType: "text/plain", Generation: 1, Metageneration: 1},
+			content: "a",
+		},
+	})
+	const url = "gs://bucket-name/?objects=a.txt,missing&alt=json"
+	get := func(t *testing.T, tr *Transport, acceptEncoding string) (*http.Response, []byte) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, body
+	}
+
+	tr := newTestTransport(mock, WithResponseCompression(0))
+	_, plain := get(t, tr, "")
+	if !json.Valid(plain) {
+		t.Fatalf("invalid JSON: %s", plain)
+	}
+
+	resp, body := get(t, tr, "gzip")
+	if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Errorf("unexpected Content-Encoding: %q", got)
+	}
+	if got := resp.Header.Get("Vary"); got != "Accept-Encoding" {
+		t.Errorf("unexpected Vary: %q", got)
+	}
+	if resp.ContentLength != -1 || resp.Header.Get("Content-Length") != "" {
+		t.Errorf("want unknown Content-Length, got %d %q", resp.ContentLength, resp.Header.Get("Content-Length"))
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(plain) {
+		t.Errorf("unexpected decompressed body:\nwant %s\ngot  %s", plain, got)
+	}
+
+	// the small bodies are sent as they are.
+	tr = newTestTransport(mock, WithResponseCompression(len(plain)+1))
+	resp, body = get(t, tr, "gzip")
+	if got := resp.Header.Get("Content-Encoding"); got != "" {
+		t.Errorf("unexpected Content-Encoding: %q", got)
+	}
+	if got := resp.Header.Get("Vary"); got != "Accept-Encoding" {
+		t.Errorf("unexpected Vary: %q", got)
+	}
+	if string(body) != string(plain) {
+		t.Errorf("unexpected body: %s", body)
+	}
+
+	// disabled by default.
+	tr = newTestTransport(mock)
+	resp, body = get(t, tr, "gzip")
+	if got := resp.Header.Get("Content-Encoding"); got != "" {
+		t.Errorf("unexpected Content-Encoding: %q", got)
+	}
+	if got := resp.Header.Get("Vary"); got != "" {
+		t.Errorf("unexpected Vary: %q", got)
+	}
+	if string(body) != string(plain) {
+		t.Errorf("unexpected body: %s", body)
+	}
+}
+
+func TestRoundTrip_BulkDeleteStreamCompression(t *testing.T) {
+	b := &bulkDeleteMock{
+		objects: map[string]int64{
+			"logs/a.txt": 1,
+			"logs/b.txt": 2,
+		},
+	}
+	tr := newTestTransport(b.client(), WithWriteMethods(), WithBulkDelete(), WithResponseCompression(1<<10))
+
+	req, err := http.NewRequest(http.MethodDelete, "gs://bucket-name/logs/?recursive=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/x-ndjson")
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// the streamed bodies have unknown lengths, so they are compressed regardless of the threshold.
+	if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("unexpected Content-Encoding: %q", got)
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(zr)
+	var lines int
+	for dec.More() {
+		var v map[string]interface{}
+		if err := dec.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		lines++
+	}
+	if lines != 3 {
+		t.Errorf("want 2 results and the summary, got %d lines", lines)
+	}
+	if names := b.names(); len(names) != 0 {
+		t.Errorf("want all objects deleted, got %v", names)
+	}
+}
@@ -0,0 +1,150 @@
+package gsprotocol
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// conflictingConditionalsError is returned if the conditional headers of a request contradict each other.
+type conflictingConditionalsError struct {
+	headers []string
+	reason  string
+}
+
+func (err *conflictingConditionalsError) Error() string {
+	return fmt.Sprintf("gsprotocol: conflicting conditional headers %s: %s", strings.Join(err.headers, " and "), err.reason)
+}
+
+// checkConflictingConditionals detects the conditional headers that can never be satisfied together.
+func checkConflictingConditionals(req *http.Request) error {
+	im := req.Header.Get("If-Match")
+	inm := req.Header.Get("If-None-Match")
+	if im != "" && inm != "" {
+		for _, a := range scanETagList(im) {
+			for _, b := range scanETagList(inm) {
+				if a == "*" && b == "*" {
+					return &conflictingConditionalsError{
+						headers: []string{"If-Match", "If-None-Match"},
+						reason:  "both are *",
+					}
+				}
+				if a != "*" && b != "*" && etagWeakMatch(a, b) {
+					return &conflictingConditionalsError{
+						headers: []string{"If-Match", "If-None-Match"},
+						reason:  "both have the ETag " + a,
+					}
+				}
+			}
+		}
+	}
+
+	ius, err1 := http.ParseTime(req.Header.Get("If-Unmodified-Since"))
+	ims, err2 := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err1 == nil && err2 == nil && ius.Before(ims) {
+		return &conflictingConditionalsError{
+			headers: []string{"If-Unmodified-Since", "If-Modified-Since"},
+			reason:  "If-Unmodified-Since is earlier than If-Modified-Since",
+		}
+	}
+	return nil
+}
+
+// scanETagList parses the list of ETags in the If-Match or If-None-Match header.
+// "*" is returned as is.
+func scanETagList(s string) []string {
+	var etags []string
+	for {
+		s = textproto.TrimString(s)
+		if len(s) == 0 {
+			break
+		}
+		if s[0] == ',' {
+			s = s[1:]
+			continue
+		}
+		if s[0] == '*' {
+			etags = append(etags, "*")
+			s = s[1:]
+			continue
+		}
+		etag, remain := scanETag(s)
+		if etag == "" {
+			break
+		}
+		etags = append(etags, etag)
+		s = remain
+	}
+	return etags
+}
+
+// pruneProfile is the set of the rules to prune the header of a response without the body.
+type pruneProfile int
+
+const (
+	// pruneNotModified is for 304 Not Modified.
+	// RFC 9110 section 15.4.5:
+	// a sender SHOULD NOT generate representation metadata other than the
+	// above listed fields unless said metadata exists for the purpose of
+	// guiding cache updates (e.g., Last-Modified might be useful if the
+	// response does not have an ETag field).
+	pruneNotModified pruneProfile = iota
+
+	// pruneExistenceProbe is for 304 Not Modified to If-None-Match: *.
+	// It keeps Last-Modified with ETag, so that the clients can learn the version of the object.
+	pruneExistenceProbe
+
+	// prunePreconditionFailed is for 412 Precondition Failed.
+	// It keeps only the validators and the diagnostics, and sets Content-Length to 0
+	// because some strict clients reject a non-zero Content-Length with the empty body.
+	prunePreconditionFailed
+
+	// pruneWritten is for the responses to write requests.
+	// It keeps the validators, the metageneration and the hashes of the object written,
+	// and sets Content-Length to 0 in the same way as prunePreconditionFailed.
+	pruneWritten
+)
+
+// keptHeaders are the headers that the responses of the profiles keep.
+// The x-gsprotocol-* headers are always kept.
+var keptHeaders = map[pruneProfile]map[string]bool{
+	prunePreconditionFailed: {
+		"Etag":              true,
+		"Last-Modified":     true,
+		"X-Goog-Generation": true,
+		requestIDHeader:     true,
+	},
+	pruneWritten: {
+		"Etag":                  true,
+		"Last-Modified":         true,
+		"X-Goog-Generation":     true,
+		"X-Goog-Metageneration": true,
+		"X-Goog-Hash":           true,
+		requestIDHeader:         true,
+
+		// the hash of the customer-supplied encryption key, but never the key itself.
+		"X-Goog-Encryption-Algorithm":  true,
+		"X-Goog-Encryption-Key-Sha256": true,
+	},
+}
+
+// pruneHeader removes the headers that the response of profile must not have.
+func pruneHeader(header http.Header, profile pruneProfile) {
+	switch profile {
+	case pruneNotModified, pruneExistenceProbe:
+		header.Del("Content-Type")
+		header.Del("Content-Length")
+		if profile == pruneNotModified && header.Get("Etag") != "" {
+			header.Del("Last-Modified")
+		}
+	case prunePreconditionFailed, pruneWritten:
+		kept := keptHeaders[profile]
+		for key := range header {
+			if !kept[key] && !strings.HasPrefix(key, "X-Gsprotocol-") {
+				delete(header, key)
+			}
+		}
+		header.Set("Content-Length", "0")
+	}
+}
@@ -0,0 +1,286 @@
+package gsprotocol
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+var conditionalTestObjects = map[string]mockObject{
+	"bucket-name/object-key": {
+		attrs: &storage.ObjectAttrs{
+			Generation: 1234567890,
+			MD5:        []byte{0x0b, 0x46, 0xf3, 0x06, 0xe9, 0x2d, 0x88, 0x51, 0x5e, 0x06, 0xd4, 0x8a, 0x62, 0xdc, 0xc3, 0x19},
+			Updated:    time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC),
+		},
+		content: "Hello Google Cloud Storage!",
+	},
+}
+
+const (
+	conditionalTestETag   = `"0b46f306e92d88515e06d48a62dcc319"`
+	conditionalTestOther  = `"ffffffffffffffffffffffffffffffff"`
+	conditionalTestBefore = "Thu, 31 Dec 2020 00:00:00 GMT"
+	conditionalTestAfter  = "Sat, 02 Jan 2021 00:00:00 GMT"
+)
+
+// TestRoundTrip_ConditionalOrder pins the evaluation order of the conditional headers.
+func TestRoundTrip_ConditionalOrder(t *testing.T) {
+	tc := []struct {
+		name   string
+		header map[string]string
+		want   int
+	}{
+		{
+			name: "If-Match fails before If-None-Match",
+			header: map[string]string{
+				"If-Match":      conditionalTestOther,
+				"If-None-Match": conditionalTestETag,
+			},
+			want: http.StatusPreconditionFailed,
+		},
+		{
+			name: "If-Match and If-None-Match with the same ETag",
+			header: map[string]string{
+				"If-Match":      conditionalTestETag,
+				"If-None-Match": conditionalTestETag,
+			},
+			want: http.StatusNotModified,
+		},
+		{
+			name: "If-Match takes precedence over If-Unmodified-Since",
+			header: map[string]string{
+				"If-Match":            conditionalTestETag,
+				"If-Unmodified-Since": conditionalTestBefore,
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "If-None-Match takes precedence over If-Modified-Since",
+			header: map[string]string{
+				"If-None-Match":     conditionalTestOther,
+				"If-Modified-Since": conditionalTestAfter,
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "If-Unmodified-Since earlier than If-Modified-Since",
+			header: map[string]string{
+				"If-Unmodified-Since": conditionalTestBefore,
+				"If-Modified-Since":   conditionalTestAfter,
+			},
+			want: http.StatusPreconditionFailed,
+		},
+		{
+			name: "If-Unmodified-Since fails before x-goog-if-generation-not-match",
+			header: map[string]string{
+				"If-Unmodified-Since":            conditionalTestBefore,
+				"x-goog-if-generation-not-match": "1234567890",
+			},
+			want: http.StatusPreconditionFailed,
+		},
+		{
+			name: "invalid dates are ignored",
+			header: map[string]string{
+				"If-Unmodified-Since": "yesterday",
+				"If-Modified-Since":   "tomorrow",
+			},
+			want: http.StatusOK,
+		},
+	}
+	c := newTestClient(newStorageClientMockWithObjects(conditionalTestObjects))
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/object-key", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("unexpected status: want %d, got %d", tt.want, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestRoundTrip_StrictConditionals(t *testing.T) {
+	tc := []struct {
+		name    string
+		header  map[string]string
+		want    int
+		message string
+	}{
+		{
+			name: "same ETag",
+			header: map[string]string{
+				"If-Match":      conditionalTestETag,
+				"If-None-Match": "W/" + conditionalTestETag,
+			},
+			want:    http.StatusBadRequest,
+			message: "If-Match and If-None-Match",
+		},
+		{
+			name: "both wildcards",
+			header: map[string]string{
+				"If-Match":      "*",
+				"If-None-Match": "*",
+			},
+			want:    http.StatusBadRequest,
+			message: "If-Match and If-None-Match",
+		},
+		{
+			name: "If-Unmodified-Since earlier than If-Modified-Since",
+			header: map[string]string{
+				"If-Unmodified-Since": conditionalTestBefore,
+				"If-Modified-Since":   conditionalTestAfter,
+			},
+			want:    http.StatusBadRequest,
+			message: "If-Unmodified-Since and If-Modified-Since",
+		},
+		{
+			name: "different ETags",
+			header: map[string]string{
+				"If-Match":      conditionalTestETag,
+				"If-None-Match": conditionalTestOther,
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "If-Modified-Since earlier than If-Unmodified-Since",
+			header: map[string]string{
+				"If-Unmodified-Since": conditionalTestAfter,
+				"If-Modified-Since":   conditionalTestBefore,
+			},
+			want: http.StatusOK,
+		},
+	}
+	c := newTestClient(newStorageClientMockWithObjects(conditionalTestObjects), WithStrictConditionals())
+	for _, tt := range tc {
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			t.Run(tt.name+" "+method, func(t *testing.T) {
+				req, err := http.NewRequest(method, "gs://bucket-name/object-key", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for k, v := range tt.header {
+					req.Header.Set(k, v)
+				}
+				resp, err := c.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+				if resp.StatusCode != tt.want {
+					t.Errorf("unexpected status: want %d, got %d", tt.want, resp.StatusCode)
+				}
+				body, err := io.ReadAll(resp.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if method == http.MethodGet && tt.message != "" && !strings.Contains(string(body), tt.message) {
+					t.Errorf("the body doesn't name the headers %q: %q", tt.message, string(body))
+				}
+			})
+		}
+	}
+}
+
+func TestPruneHeader(t *testing.T) {
+	newHeader := func() http.Header {
+		return http.Header{
+			"Content-Type":          {"text/plain"},
+			"Content-Length":        {"27"},
+			"Etag":                  {`"0b46f306e92d88515e06d48a62dcc319"`},
+			"Last-Modified":         {"Fri, 01 Jan 2021 00:00:00 GMT"},
+			"X-Goog-Generation":     {"1234567890"},
+			"X-Goog-Metageneration": {"1"},
+			"X-Goog-Hash":           {"crc32c=f3Yv4g==", "md5=C0bzBuktiFFeBtSKYtzDGQ=="},
+			"X-Goog-Meta-Foo":       {"bar"},
+			"X-Gsprotocol-Error":    {"generation-race"},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		profile pruneProfile
+		want    []string
+	}{
+		{
+			name:    "not modified",
+			profile: pruneNotModified,
+			want:    []string{"Etag", "X-Goog-Generation", "X-Goog-Metageneration", "X-Goog-Hash", "X-Goog-Meta-Foo", "X-Gsprotocol-Error"},
+		},
+		{
+			name:    "existence probe",
+			profile: pruneExistenceProbe,
+			want:    []string{"Etag", "Last-Modified", "X-Goog-Generation", "X-Goog-Metageneration", "X-Goog-Hash", "X-Goog-Meta-Foo", "X-Gsprotocol-Error"},
+		},
+		{
+			name:    "precondition failed",
+			profile: prunePreconditionFailed,
+			want:    []string{"Content-Length", "Etag", "Last-Modified", "X-Goog-Generation", "X-Gsprotocol-Error"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := newHeader()
+			pruneHeader(header, tt.profile)
+			if len(header) != len(tt.want) {
+				t.Errorf("want %d headers, got %v", len(tt.want), header)
+			}
+			for _, key := range tt.want {
+				if _, ok := header[key]; !ok {
+					t.Errorf("%s is removed", key)
+				}
+			}
+		})
+	}
+}
+
+func TestRoundTrip_PreconditionFailedHeader(t *testing.T) {
+	c := newTestClient(newStorageClientMockWithObjects(conditionalTestObjects))
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		req, err := http.NewRequest(method, "gs://bucket-name/object-key", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("If-Match", conditionalTestOther)
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != http.StatusPreconditionFailed {
+			t.Errorf("%s: unexpected status: want %d, got %d", method, http.StatusPreconditionFailed, resp.StatusCode)
+		}
+		if resp.ContentLength != 0 || resp.Header.Get("Content-Length") != "0" || len(body) != 0 {
+			t.Errorf("%s: want empty body, got ContentLength %d, Content-Length %q, body %q",
+				method, resp.ContentLength, resp.Header.Get("Content-Length"), body)
+		}
+		if got := resp.Header.Get("Etag"); got != conditionalTestETag {
+			t.Errorf("%s: want ETag %s, got %s", method, conditionalTestETag, got)
+		}
+		if got := resp.Header.Get("x-goog-generation"); got != "1234567890" {
+			t.Errorf("%s: want generation 1234567890, got %s", method, got)
+		}
+		if got := resp.Header.Get("x-goog-hash"); got != "" {
+			t.Errorf("%s: want no hash, got %s", method, got)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package gsprotocol
+
+import (
+	"io"
+	"sync"
+)
+
+// copyBufferSize is the size of the buffers of writeTo,
+// larger than the 32KB buffer of io.Copy to stream the objects in bigger chunks.
+const copyBufferSize = 256 << 10
+
+// copyBufferPool is the pool of the buffers of writeTo.
+var copyBufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, copyBufferSize)
+		return &buf
+	},
+}
+
+// writeTo copies r to w with a pooled buffer.
+// It is for the WriteTo methods of the readers, and never calls the WriteTo method of r.
+func writeTo(w io.Writer, r io.Reader) (int64, error) {
+	buf := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(buf)
+
+	// hide the WriteTo method of r from io.CopyBuffer, which would call writeTo again.
+	return io.CopyBuffer(w, struct{ io.Reader }{r}, *buf)
+}
@@ -0,0 +1,105 @@
+package gsprotocol
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestRoundTrip_WriteTo(t *testing.T) {
+	content := bytes.Repeat([]byte("Hello Google Cloud Storage!"), 20000)
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs:   &storage.ObjectAttrs{Generation: 1587160158394554},
+			content: string(content),
+		},
+	})
+	tr := newTestTransport(mock)
+	var stats RequestStats
+	req, err := http.NewRequestWithContext(WithStatsRecorder(context.Background(), &stats), http.MethodGet, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resp.Body.(io.WriterTo); !ok {
+		t.Fatalf("the body %T doesn't implement io.WriterTo", resp.Body)
+	}
+	var buf bytes.Buffer
+	n, err := io.Copy(onlyWriter{&buf}, resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if n != int64(len(content)) || !bytes.Equal(buf.Bytes(), content) {
+		t.Errorf("unexpected body: want %d bytes, got %d bytes", len(content), n)
+	}
+	if stats.BytesRead != int64(len(content)) {
+		t.Errorf("unexpected BytesRead: want %d, got %d", len(content), stats.BytesRead)
+	}
+}
+
+// onlyWriter hides the other methods than Write, e.g. ReadFrom.
+type onlyWriter struct {
+	io.Writer
+}
+
+// zeroReader reads zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// BenchmarkCopyBody copies the body of a 100MB object by io.Copy,
+// with WriteTo of the body and with Read only.
+func BenchmarkCopyBody(b *testing.B) {
+	const size = 100 << 20
+	object := &objectHandleMock{
+		attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+			return &storage.ObjectAttrs{Bucket: "bucket-name", Name: "object-key", Size: size, Generation: 1587160158394554}, nil
+		},
+		newReaderFunc: func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+			return storage.ReaderObjectAttrs{Size: size, Generation: 1587160158394554}, io.NopCloser(io.LimitReader(zeroReader{}, size)), nil
+		},
+		generationFunc: func(mock *objectHandleMock, gen int64) *objectHandleMock {
+			return mock
+		},
+	}
+	tr := newTestTransport(newSingleObjectMock(object))
+	run := func(b *testing.B, wrap func(body io.Reader) io.Reader) {
+		req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/object-key", nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.SetBytes(size)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := io.Copy(onlyWriter{io.Discard}, wrap(resp.Body)); err != nil {
+				b.Fatal(err)
+			}
+			resp.Body.Close()
+		}
+	}
+	b.Run("WriteTo", func(b *testing.B) {
+		run(b, func(body io.Reader) io.Reader { return body })
+	})
+	b.Run("Read", func(b *testing.B) {
+		run(b, func(body io.Reader) io.Reader { return struct{ io.Reader }{body} })
+	})
+}
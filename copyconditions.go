@@ -0,0 +1,107 @@
+package gsprotocol
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"cloud.google.com/go/storage"
+)
+
+// the headers of copy requests that are the preconditions of the source object.
+const (
+	copySourceIfGenerationMatchHeader = "X-Goog-Copy-Source-If-Generation-Match"
+	copySourceIfMatchHeader           = "X-Goog-Copy-Source-If-Match"
+	copySourceIfUnmodifiedSinceHeader = "X-Goog-Copy-Source-If-Unmodified-Since"
+)
+
+// the x-gsprotocol-error headers of the 412 Precondition Failed responses to copy requests.
+const (
+	sourcePreconditionFailedError      = "source-precondition-failed"
+	destinationPreconditionFailedError = "destination-precondition-failed"
+)
+
+// copySourceConditions is the preconditions of the source object of a copy request.
+// Google Cloud Storage checks only the generation of the source object,
+// so the Transport checks the others with the attributes of the source object,
+// and copies the generation that it checked.
+type copySourceConditions struct {
+	generationMatch   int64
+	ifMatch           string
+	ifUnmodifiedSince string
+}
+
+// parseCopySourceConditions returns the preconditions of the source object in the header.
+func parseCopySourceConditions(header http.Header) (copySourceConditions, error) {
+	var conds copySourceConditions
+	if v := header.Get(copySourceIfGenerationMatchHeader); v != "" {
+		gen, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || gen <= 0 {
+			return copySourceConditions{}, fmt.Errorf("gsprotocol: invalid x-goog-copy-source-if-generation-match %q", v)
+		}
+		conds.generationMatch = gen
+	}
+	if v := header.Get(copySourceIfMatchHeader); v != "" {
+		if v != "*" {
+			if etag, _ := scanETag(v); etag == "" {
+				return copySourceConditions{}, fmt.Errorf("gsprotocol: invalid x-goog-copy-source-if-match %q", v)
+			}
+		}
+		conds.ifMatch = v
+	}
+	if v := header.Get(copySourceIfUnmodifiedSinceHeader); v != "" {
+		if _, err := http.ParseTime(v); err != nil {
+			return copySourceConditions{}, fmt.Errorf("gsprotocol: invalid x-goog-copy-source-if-unmodified-since %q", v)
+		}
+		conds.ifUnmodifiedSince = v
+	}
+	return conds, nil
+}
+
+// empty reports whether there is no precondition.
+func (c copySourceConditions) empty() bool {
+	return c == copySourceConditions{}
+}
+
+// check returns the name of the header of the precondition that attrs doesn't satisfy,
+// or the empty string if it satisfies all of them.
+func (c copySourceConditions) check(attrs *storage.ObjectAttrs) string {
+	if c.generationMatch != 0 && c.generationMatch != attrs.Generation {
+		return copySourceIfGenerationMatchHeader
+	}
+
+	// checkIfMatch and checkIfUnmodifiedSince read the conditions from a request.
+	req := &http.Request{Header: make(http.Header)}
+	if c.ifMatch != "" {
+		req.Header.Set("If-Match", c.ifMatch)
+	}
+	if c.ifUnmodifiedSince != "" {
+		req.Header.Set("If-Unmodified-Since", c.ifUnmodifiedSince)
+	}
+	header := makeHeader(attrs)
+	if checkIfMatch(req, header, attrs) == condFalse {
+		return copySourceIfMatchHeader
+	}
+	if checkIfUnmodifiedSince(req, header, attrs) == condFalse {
+		return copySourceIfUnmodifiedSinceHeader
+	}
+	return ""
+}
+
+// newSourcePreconditionFailedResponse returns the 412 Precondition Failed response
+// to the copy request whose source object doesn't satisfy the precondition of the header.
+func newSourcePreconditionFailedResponse(header string) *http.Response {
+	resp := newErrorResponse(http.StatusPreconditionFailed, fmt.Sprintf("gsprotocol: the copy source doesn't satisfy %s", header))
+	resp.Header.Set("x-gsprotocol-error", sourcePreconditionFailedError)
+	return resp
+}
+
+// handleCopyError is handleError of the copy requests,
+// which tells that a 412 Precondition Failed response is of the destination object.
+func handleCopyError(err error) (*http.Response, error) {
+	resp, err := handleError(err)
+	if resp != nil && resp.StatusCode == http.StatusPreconditionFailed {
+		resp.Header.Set("x-gsprotocol-error", destinationPreconditionFailedError)
+	}
+	return resp, err
+}
@@ -0,0 +1,145 @@
+package gsprotocol
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+func TestRoundTrip_CopyPreconditions(t *testing.T) {
+	const srcGeneration = 1587160158394554
+	srcAttrs := &storage.ObjectAttrs{
+		Bucket:     "src-bucket",
+		Name:       "build.tar",
+		Generation: srcGeneration,
+		MD5:        []byte{0x0b, 0x46, 0xf3, 0x06, 0xe9, 0x2d, 0x88, 0x51, 0x5e, 0x06, 0xd4, 0x8a, 0x62, 0xdc, 0xc3, 0x19},
+		Updated:    time.Date(2020, time.April, 18, 12, 34, 56, 0, time.UTC),
+	}
+	var copiedGeneration int64
+	mock := &storageClientMock{
+		bucketFunc: func(mock *storageClientMock, bucket string) *bucketHandleMock {
+			return &bucketHandleMock{
+				objectFunc: func(mock *bucketHandleMock, name string) *objectHandleMock {
+					return &objectHandleMock{
+						attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+							if mock.generation != 0 && mock.generation != srcGeneration {
+								return nil, storage.ErrObjectNotExist
+							}
+							attrs := *srcAttrs
+							return &attrs, nil
+						},
+						generationFunc: withGeneration,
+						copierFunc: func(dst *objectHandleMock, src *objectHandleMock) *storageCopierMock {
+							return &storageCopierMock{
+								runFunc: func(ctx context.Context, c *storageCopierMock) (*storage.ObjectAttrs, error) {
+									if dst.conds.GenerationMatch != 0 && dst.conds.GenerationMatch != 1 {
+										return nil, &googleapi.Error{Code: http.StatusPreconditionFailed}
+									}
+									copiedGeneration = src.generation
+									return &storage.ObjectAttrs{Bucket: "bucket-name", Name: "release.tar", Generation: 2}, nil
+								},
+							}
+						},
+					}
+				},
+			}
+		},
+	}
+	tr := newTestTransport(mock, WithWriteMethods())
+
+	tests := []struct {
+		name       string
+		header     http.Header
+		status     int
+		errorType  string
+		generation int64
+	}{
+		{
+			name:       "no conditions",
+			status:     http.StatusOK,
+			generation: 0,
+		},
+		{
+			name: "source conditions",
+			header: http.Header{
+				"X-Goog-Copy-Source-If-Generation-Match": {"1587160158394554"},
+				"X-Goog-Copy-Source-If-Match":            {`"0b46f306e92d88515e06d48a62dcc319"`},
+				"X-Goog-Copy-Source-If-Unmodified-Since": {"Sat, 18 Apr 2020 12:34:56 GMT"},
+				"X-Goog-If-Generation-Match":             {"1"},
+			},
+			status:     http.StatusOK,
+			generation: srcGeneration,
+		},
+		{
+			name:      "source generation",
+			header:    http.Header{"X-Goog-Copy-Source-If-Generation-Match": {"1"}},
+			status:    http.StatusPreconditionFailed,
+			errorType: "source-precondition-failed",
+		},
+		{
+			name:      "source etag",
+			header:    http.Header{"X-Goog-Copy-Source-If-Match": {`"d41d8cd98f00b204e9800998ecf8427e"`}},
+			status:    http.StatusPreconditionFailed,
+			errorType: "source-precondition-failed",
+		},
+		{
+			name:      "source modified",
+			header:    http.Header{"X-Goog-Copy-Source-If-Unmodified-Since": {"Fri, 17 Apr 2020 12:34:56 GMT"}},
+			status:    http.StatusPreconditionFailed,
+			errorType: "source-precondition-failed",
+		},
+		{
+			name:      "destination generation",
+			header:    http.Header{"X-Goog-If-Generation-Match": {"99"}},
+			status:    http.StatusPreconditionFailed,
+			errorType: "destination-precondition-failed",
+		},
+		{
+			name:   "invalid generation",
+			header: http.Header{"X-Goog-Copy-Source-If-Generation-Match": {"latest"}},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "invalid etag",
+			header: http.Header{"X-Goog-Copy-Source-If-Match": {"0b46f306"}},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "invalid time",
+			header: http.Header{"X-Goog-Copy-Source-If-Unmodified-Since": {"yesterday"}},
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copiedGeneration = -1
+			req, err := http.NewRequest(http.MethodPut, "gs://bucket-name/release.tar", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-Goog-Copy-Source", "/src-bucket/build.tar")
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("unexpected status: want %d, got %d", tt.status, resp.StatusCode)
+			}
+			if got := resp.Header.Get("x-gsprotocol-error"); got != tt.errorType {
+				t.Errorf("unexpected x-gsprotocol-error: want %q, got %q", tt.errorType, got)
+			}
+			if tt.status == http.StatusOK && copiedGeneration != tt.generation {
+				t.Errorf("unexpected source generation: want %d, got %d", tt.generation, copiedGeneration)
+			}
+		})
+	}
+}
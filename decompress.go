@@ -0,0 +1,104 @@
+package gsprotocol
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+var errUnsupportedDecompression = errors.New("gsprotocol: unsupported decompress parameter")
+
+// decompression returns the decompression that the request asks for.
+// It returns an empty string if no decompression is needed.
+func (c *config) decompression(req *http.Request) (string, error) {
+	if !c.gzipDecompression {
+		return "", nil
+	}
+	switch v := req.URL.Query().Get("decompress"); v {
+	case "":
+		return "", nil
+	case "gzip":
+		return v, nil
+	}
+	return "", errUnsupportedDecompression
+}
+
+// checkDecompressible returns an error if the Transport can't decompress the object.
+// Objects stored with a Content-Encoding, such as br and zstd, are always served
+// as they are stored, so decompressing them again is refused.
+func checkDecompressible(attrs *storage.ObjectAttrs) error {
+	if enc := attrs.ContentEncoding; enc != "" && !strings.EqualFold(enc, "identity") {
+		return fmt.Errorf("gsprotocol: can't decompress the object stored with Content-Encoding %q", enc)
+	}
+	return nil
+}
+
+// decompressHeader rewrites the header for the decompressed content of the object.
+func decompressHeader(header http.Header, name string) {
+	contentType := mime.TypeByExtension(path.Ext(strings.TrimSuffix(name, ".gz")))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", contentType)
+	header.Del("Content-Length")
+
+	// the hashes and the ETag are computed from the stored bytes,
+	// so they don't match the decompressed content.
+	header.Del("x-goog-hash")
+	if etag := header.Get("Etag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("Etag", "W/"+etag)
+	}
+}
+
+// gzipBody is the decompressed body of a gzip-compressed object.
+type gzipBody struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+// newGzipBody wraps body with a gzip.Reader.
+// It returns an error without closing body if body isn't gzip-compressed.
+func newGzipBody(body io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(body)
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(magic) < 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+		return nil, &notGzipError{magic: magic}
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	return &gzipBody{
+		Reader: zr,
+		body:   body,
+	}, nil
+}
+
+func (b *gzipBody) Close() error {
+	err1 := b.Reader.Close()
+	err2 := b.body.Close()
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+// notGzipError is returned if the object isn't gzip-compressed.
+type notGzipError struct {
+	magic []byte
+}
+
+func (err *notGzipError) Error() string {
+	return fmt.Sprintf("gsprotocol: the object is not gzip-compressed: want magic bytes 1f 8b, got % x", err.magic)
+}
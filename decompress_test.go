@@ -0,0 +1,221 @@
+package gsprotocol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func gzipString(t *testing.T, s string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(zw, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func newDecompressTestMock(t *testing.T) *storageClientMock {
+	const content = `{"message":"Hello Google Cloud Storage!"}`
+	return newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/data.json.gz": {
+			attrs: &storage.ObjectAttrs{
+				ContentType: "application/gzip",
+				Generation:  1234567890,
+				MD5:         []byte{0x0b, 0x46, 0xf3, 0x06, 0xe9, 0x2d, 0x88, 0x51, 0x5e, 0x06, 0xd4, 0x8a, 0x62, 0xdc, 0xc3, 0x19},
+			},
+			content: gzipString(t, content),
+		},
+		"bucket-name/data.json": {
+			attrs: &storage.ObjectAttrs{
+				ContentType: "application/json",
+				Generation:  1234567890,
+			},
+			content: content,
+		},
+	})
+}
+
+func TestRoundTrip_DecompressGzip(t *testing.T) {
+	c := newTestClient(newDecompressTestMock(t), WithGzipDecompression())
+	resp, err := c.Get("gs://bucket-name/data.json.gz?decompress=gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"message":"Hello Google Cloud Storage!"}`; string(got) != want {
+		t.Errorf("want %q, got %q", want, string(got))
+	}
+	if got := resp.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("unexpected Content-Type: want %q, got %q", "application/json", got)
+	}
+	if resp.ContentLength != -1 {
+		t.Errorf("unexpected ContentLength: want %d, got %d", -1, resp.ContentLength)
+	}
+	if got := resp.Header.Get("Content-Length"); got != "" {
+		t.Errorf("unexpected Content-Length: %q", got)
+	}
+	if got := resp.Header.Values("x-goog-hash"); len(got) != 0 {
+		t.Errorf("unexpected x-goog-hash: %v", got)
+	}
+	if got, want := resp.Header.Get("Etag"), `W/"0b46f306e92d88515e06d48a62dcc319"`; got != want {
+		t.Errorf("unexpected ETag: want %q, got %q", want, got)
+	}
+}
+
+func TestRoundTrip_DecompressGzip_HEAD(t *testing.T) {
+	c := newTestClient(newDecompressTestMock(t), WithGzipDecompression())
+	resp, err := c.Head("gs://bucket-name/data.json.gz?decompress=gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("unexpected Content-Type: want %q, got %q", "application/json", got)
+	}
+	if got := resp.Header.Get("Content-Length"); got != "" {
+		t.Errorf("unexpected Content-Length: %q", got)
+	}
+}
+
+func TestRoundTrip_DecompressGzip_NotGzip(t *testing.T) {
+	c := newTestClient(newDecompressTestMock(t), WithGzipDecompression())
+	resp, err := c.Get("gs://bucket-name/data.json?decompress=gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(got), "got 7b 22") {
+		t.Errorf("the body doesn't name the magic bytes: %q", string(got))
+	}
+}
+
+func TestRoundTrip_DecompressGzip_Unsupported(t *testing.T) {
+	c := newTestClient(newDecompressTestMock(t), WithGzipDecompression())
+	resp, err := c.Get("gs://bucket-name/data.json.gz?decompress=bzip2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestRoundTrip_DecompressGzip_Disabled(t *testing.T) {
+	c := newTestClient(newDecompressTestMock(t))
+	resp, err := c.Get("gs://bucket-name/data.json.gz?decompress=gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("Content-Type"); got != "application/gzip" {
+		t.Errorf("unexpected Content-Type: want %q, got %q", "application/gzip", got)
+	}
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(got, []byte{0x1f, 0x8b}) {
+		t.Errorf("want the raw gzip-compressed content, got %q", string(got))
+	}
+}
+
+func TestRoundTrip_StoredContentEncoding(t *testing.T) {
+	const content = "\x1b\x1a\x00pre-compressed bytes"
+	for _, enc := range []string{"br", "zstd"} {
+		t.Run(enc, func(t *testing.T) {
+			mock := newStorageClientMockWithObjects(map[string]mockObject{
+				"bucket-name/app.js": {
+					attrs: &storage.ObjectAttrs{
+						ContentType:     "text/javascript",
+						ContentEncoding: enc,
+						Generation:      1234567890,
+						CRC32C:          crc32.Checksum([]byte(content), crc32.MakeTable(crc32.Castagnoli)),
+					},
+					content: content,
+				},
+			})
+			c := newTestClient(mock, WithGzipDecompression())
+
+			// the raw bytes are served even if the client doesn't accept the encoding.
+			req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/app.js", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Accept-Encoding", "identity")
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+			}
+			if got := resp.Header.Get("Content-Encoding"); got != enc {
+				t.Errorf("unexpected Content-Encoding: want %q, got %q", enc, got)
+			}
+			if resp.ContentLength != int64(len(content)) {
+				t.Errorf("unexpected ContentLength: want %d, got %d", len(content), resp.ContentLength)
+			}
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != content {
+				t.Errorf("want %q, got %q", content, string(got))
+			}
+			var crc [4]byte
+			binary.BigEndian.PutUint32(crc[:], crc32.Checksum(got, crc32.MakeTable(crc32.Castagnoli)))
+			want := "crc32c=" + base64.StdEncoding.EncodeToString(crc[:])
+			if hash := resp.Header.Values("x-goog-hash"); len(hash) == 0 || hash[0] != want {
+				t.Errorf("unexpected x-goog-hash: want %q, got %v", want, hash)
+			}
+
+			// the transport never decompresses them.
+			resp, err = c.Get("gs://bucket-name/app.js?decompress=gzip")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("unexpected status: want %d, got %d", http.StatusBadRequest, resp.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package gsprotocol
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// ifChangedHeader is the header of PUT requests that skips the upload if the object already has the same content,
+// comparing the checksum in it, "crc32c" or "md5".
+//
+// The checksum of the content is taken from the x-goog-hash header of the request, e.g. "x-goog-hash: crc32c=n03x6A==",
+// or computed from the body if Request.GetBody can rewind it.
+// Otherwise, the content is uploaded as usual.
+// If the object has the same checksum, the Transport responds 304 Not Modified with the generation of the object.
+//
+// The object may be overwritten between the check and the response, and then the skipped upload is lost.
+// With the x-goog-if-generation-match header, the Transport skips the upload only if the object is that generation,
+// and the upload has the precondition, so that one of them always sees the change.
+const ifChangedHeader = "X-Gsprotocol-If-Changed"
+
+// parseIfChanged returns the hash algorithm of the x-gsprotocol-if-changed header, or the empty string if it is absent.
+func parseIfChanged(header http.Header) (string, error) {
+	v := header.Get(ifChangedHeader)
+	if v == "" {
+		return "", nil
+	}
+	switch algorithm := strings.ToLower(v); algorithm {
+	case "crc32c", "md5":
+		return algorithm, nil
+	}
+	return "", fmt.Errorf("gsprotocol: invalid x-gsprotocol-if-changed %q: want crc32c or md5", v)
+}
+
+// unchangedObject returns the attributes of the object of req
+// if it has the same checksum of the algorithm as the request body.
+// It returns nil if the upload should go on.
+func unchangedObject(req *http.Request, client storageClient, algorithm string) (*storage.ObjectAttrs, error) {
+	want, err := requestChecksum(req, algorithm)
+	if err != nil || want == "" {
+		return nil, err
+	}
+
+	attrs, err := client.Bucket(bucketName(req)).Object(objectName(req.URL)).Attrs(req.Context())
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if v := req.Header.Get("X-Goog-If-Generation-Match"); v != "" && v != strconv.FormatInt(attrs.Generation, 10) {
+		// the upload fails with the precondition.
+		return nil, nil
+	}
+	for _, v := range appendHashValues(nil, attrs) {
+		if v == want {
+			return attrs, nil
+		}
+	}
+	return nil, nil
+}
+
+// requestChecksum returns the checksum of the request body in the form of x-goog-hash, e.g. "crc32c=n03x6A==".
+// It returns the empty string if the checksum is not available.
+func requestChecksum(req *http.Request, algorithm string) (string, error) {
+	prefix := algorithm + "="
+	for _, v := range req.Header.Values("X-Goog-Hash") {
+		for _, hash := range strings.Split(v, ",") {
+			hash = strings.TrimSpace(hash)
+			if strings.HasPrefix(hash, prefix) {
+				return hash, nil
+			}
+		}
+	}
+	if req.GetBody == nil {
+		return "", nil
+	}
+
+	// compute it from a copy of the body, and leave the body to the upload.
+	body, err := req.GetBody()
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	var h hash.Hash
+	if algorithm == "crc32c" {
+		h = crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	} else {
+		h = md5.New()
+	}
+	if _, err := io.Copy(h, body); err != nil {
+		return "", err
+	}
+	// the CRC32C checksum is big-endian, the same as appendHashValues.
+	return prefix + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// newUnchangedResponse returns the 304 Not Modified response to the upload skipped,
+// with the validators of the object.
+func newUnchangedResponse(attrs *storage.ObjectAttrs) *http.Response {
+	resp := newWrittenResponse(attrs)
+	resp.Status = "304 Not Modified"
+	resp.StatusCode = http.StatusNotModified
+	return resp
+}
@@ -0,0 +1,146 @@
+package gsprotocol
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestRoundTrip_PutIfChanged(t *testing.T) {
+	const content = "Hello Google Cloud Storage!"
+	sum := md5.Sum([]byte(content))
+	existing := &storage.ObjectAttrs{
+		Bucket:     "bucket-name",
+		Name:       "object-key",
+		Size:       int64(len(content)),
+		Generation: 1587160158394554,
+		CRC32C:     crc32.Checksum([]byte(content), crc32.MakeTable(crc32.Castagnoli)),
+		MD5:        sum[:],
+	}
+
+	tests := []struct {
+		name     string
+		header   http.Header
+		body     func() io.Reader
+		exists   bool
+		status   int
+		uploaded bool
+	}{
+		{
+			name:   "same content",
+			header: http.Header{"X-Gsprotocol-If-Changed": {"crc32c"}},
+			body:   func() io.Reader { return strings.NewReader(content) },
+			exists: true,
+			status: http.StatusNotModified,
+		},
+		{
+			name:   "same md5",
+			header: http.Header{"X-Gsprotocol-If-Changed": {"md5"}},
+			body:   func() io.Reader { return strings.NewReader(content) },
+			exists: true,
+			status: http.StatusNotModified,
+		},
+		{
+			name: "checksum supplied",
+			header: http.Header{
+				"X-Gsprotocol-If-Changed": {"md5"},
+				"X-Goog-Hash":             {"crc32c=AAAAAA==,md5=" + base64.StdEncoding.EncodeToString(sum[:])},
+			},
+			body:   func() io.Reader { return io.MultiReader(strings.NewReader(content)) },
+			exists: true,
+			status: http.StatusNotModified,
+		},
+		{
+			name:     "changed content",
+			header:   http.Header{"X-Gsprotocol-If-Changed": {"crc32c"}},
+			body:     func() io.Reader { return strings.NewReader("Goodbye") },
+			exists:   true,
+			status:   http.StatusOK,
+			uploaded: true,
+		},
+		{
+			name:     "unrewindable body",
+			header:   http.Header{"X-Gsprotocol-If-Changed": {"crc32c"}},
+			body:     func() io.Reader { return io.MultiReader(strings.NewReader(content)) },
+			exists:   true,
+			status:   http.StatusOK,
+			uploaded: true,
+		},
+		{
+			name:     "not found",
+			header:   http.Header{"X-Gsprotocol-If-Changed": {"crc32c"}},
+			body:     func() io.Reader { return strings.NewReader(content) },
+			status:   http.StatusOK,
+			uploaded: true,
+		},
+		{
+			name:     "other generation",
+			header:   http.Header{"X-Gsprotocol-If-Changed": {"crc32c"}, "X-Goog-If-Generation-Match": {"1"}},
+			body:     func() io.Reader { return strings.NewReader(content) },
+			exists:   true,
+			status:   http.StatusOK,
+			uploaded: true,
+		},
+		{
+			name:   "invalid algorithm",
+			header: http.Header{"X-Gsprotocol-If-Changed": {"sha256"}},
+			body:   func() io.Reader { return strings.NewReader(content) },
+			exists: true,
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var uploaded bool
+			object := &objectHandleMock{
+				attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+					if !tt.exists {
+						return nil, storage.ErrObjectNotExist
+					}
+					return existing, nil
+				},
+				newWriterFunc: func(ctx context.Context, mock *objectHandleMock) *storageWriterMock {
+					return &storageWriterMock{
+						ctx: ctx,
+						closeFunc: func(w *storageWriterMock) (*storage.ObjectAttrs, error) {
+							uploaded = true
+							return &storage.ObjectAttrs{Generation: 1587160158394555}, nil
+						},
+					}
+				},
+			}
+			tr := newTestTransport(newSingleObjectMock(object), WithWriteMethods())
+			req, err := http.NewRequest(http.MethodPut, "gs://bucket-name/object-key", tt.body())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("unexpected status: want %d, got %d", tt.status, resp.StatusCode)
+			}
+			if uploaded != tt.uploaded {
+				t.Errorf("unexpected upload: want %t, got %t", tt.uploaded, uploaded)
+			}
+			if tt.status == http.StatusNotModified {
+				if got := resp.Header.Get("X-Goog-Generation"); got != "1587160158394554" {
+					t.Errorf("unexpected generation: %q", got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package gsprotocol
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"cloud.google.com/go/compute/metadata"
+	"cloud.google.com/go/storage"
+	"golang.org/x/oauth2/google"
+)
+
+// onGCE reports whether the metadata server is available. It is replaced in tests.
+var onGCE = metadata.OnGCE
+
+// CredentialSource is a source of Application Default Credentials that is checked by Diagnose.
+type CredentialSource struct {
+	// Name is the name of the source.
+	Name string
+
+	// Location is the path or the host of the source.
+	Location string
+
+	// Found reports whether the source is available.
+	Found bool
+
+	// Detail describes why the source is unavailable, if any.
+	Detail string
+}
+
+// CredentialsReport is the result of Diagnose.
+type CredentialsReport struct {
+	// Sources are the sources of Application Default Credentials in the order they are attempted.
+	Sources []CredentialSource
+
+	// Scopes are the OAuth2 scopes requested.
+	Scopes []string
+
+	// ProjectID is the project of the credentials found.
+	ProjectID string
+
+	// Err is the error of finding the credentials, or nil if they are found.
+	Err error
+}
+
+func (r *CredentialsReport) String() string {
+	var b strings.Builder
+	if r.Err != nil {
+		fmt.Fprintf(&b, "default credentials: not found: %v\n", r.Err)
+	} else {
+		fmt.Fprintf(&b, "default credentials: found (project: %q)\n", r.ProjectID)
+	}
+	fmt.Fprintf(&b, "scopes: %s\n", strings.Join(r.Scopes, ", "))
+	for _, s := range r.Sources {
+		status := "not found"
+		if s.Found {
+			status = "found"
+		}
+		fmt.Fprintf(&b, "- %s (%s): %s", s.Name, s.Location, status)
+		if s.Detail != "" {
+			fmt.Fprintf(&b, ": %s", s.Detail)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// Diagnose checks the sources of Application Default Credentials that the Transport created by NewTransport uses,
+// unless the credentials are given by the options.
+// It reports which sources are attempted and which credentials are found, for troubleshooting authentication errors.
+func (t *Transport) Diagnose(ctx context.Context) *CredentialsReport {
+	return diagnoseCredentials(ctx)
+}
+
+func diagnoseCredentials(ctx context.Context) *CredentialsReport {
+	scopes := []string{storage.ScopeFullControl}
+	report := &CredentialsReport{
+		Scopes: scopes,
+	}
+
+	// GOOGLE_APPLICATION_CREDENTIALS
+	env := CredentialSource{
+		Name:     "GOOGLE_APPLICATION_CREDENTIALS",
+		Location: os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"),
+	}
+	if env.Location == "" {
+		env.Detail = "the environment variable is not set"
+	} else {
+		env.Found, env.Detail = checkCredentialsFile(env.Location)
+	}
+	report.Sources = append(report.Sources, env)
+
+	// the file created by "gcloud auth application-default login"
+	gcloud := CredentialSource{
+		Name:     "gcloud application default credentials",
+		Location: gcloudCredentialsPath(),
+	}
+	if gcloud.Location == "" {
+		gcloud.Detail = "the config directory of gcloud is unknown"
+	} else {
+		gcloud.Found, gcloud.Detail = checkCredentialsFile(gcloud.Location)
+	}
+	report.Sources = append(report.Sources, gcloud)
+
+	// the metadata server of Google Compute Engine, Cloud Run, etc.
+	gce := CredentialSource{
+		Name:     "metadata server",
+		Location: "metadata.google.internal",
+		Found:    onGCE(),
+	}
+	if !gce.Found {
+		gce.Detail = "not running on Google Cloud"
+	}
+	report.Sources = append(report.Sources, gce)
+
+	creds, err := google.FindDefaultCredentials(ctx, scopes...)
+	if err != nil {
+		report.Err = err
+	} else {
+		report.ProjectID = creds.ProjectID
+	}
+	return report
+}
+
+func checkCredentialsFile(path string) (bool, string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err.Error()
+	}
+	if info.IsDir() {
+		return false, "it is a directory"
+	}
+	return true, ""
+}
+
+// gcloudCredentialsPath returns the path of the application default credentials of gcloud,
+// the same as golang.org/x/oauth2/google.
+func gcloudCredentialsPath() string {
+	const name = "application_default_credentials.json"
+	if runtime.GOOS == "windows" {
+		if dir := os.Getenv("APPDATA"); dir != "" {
+			return filepath.Join(dir, "gcloud", name)
+		}
+		return ""
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "gcloud", name)
+}
@@ -0,0 +1,73 @@
+package gsprotocol
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestTransport_Diagnose(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("gcloud uses APPDATA on Windows")
+	}
+	defer func(f func() bool) { onGCE = f }(onGCE)
+	onGCE = func() bool { return false }
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	dir := filepath.Join(home, ".config", "gcloud")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	adc := `{"type": "authorized_user", "client_id": "id", "client_secret": "secret", "refresh_token": "token", "quota_project_id": "my-project"}`
+	if err := os.WriteFile(filepath.Join(dir, "application_default_credentials.json"), []byte(adc), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tr := &Transport{}
+
+	t.Run("missing GOOGLE_APPLICATION_CREDENTIALS", func(t *testing.T) {
+		missing := filepath.Join(dir, "missing.json")
+		t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", missing)
+
+		report := tr.Diagnose(context.Background())
+		if report.Err == nil {
+			t.Error("want error")
+		}
+		if len(report.Sources) != 3 {
+			t.Fatalf("want 3 sources, got %d", len(report.Sources))
+		}
+		if s := report.Sources[0]; s.Found || s.Location != missing || s.Detail == "" {
+			t.Errorf("unexpected GOOGLE_APPLICATION_CREDENTIALS: %+v", s)
+		}
+		if s := report.Sources[1]; !s.Found {
+			t.Errorf("unexpected gcloud: %+v", s)
+		}
+		if s := report.Sources[2]; s.Found {
+			t.Errorf("unexpected metadata server: %+v", s)
+		}
+		str := report.String()
+		for _, want := range []string{"not found", missing, "devstorage.full_control"} {
+			if !strings.Contains(str, want) {
+				t.Errorf("the report doesn't contain %q:\n%s", want, str)
+			}
+		}
+	})
+
+	t.Run("gcloud", func(t *testing.T) {
+		t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+
+		report := tr.Diagnose(context.Background())
+		if report.Err != nil {
+			t.Errorf("want no error, got %v", report.Err)
+		}
+		if s := report.Sources[0]; s.Found {
+			t.Errorf("unexpected GOOGLE_APPLICATION_CREDENTIALS: %+v", s)
+		}
+		if s := report.Sources[1]; !s.Found || s.Location != filepath.Join(dir, "application_default_credentials.json") {
+			t.Errorf("unexpected gcloud: %+v", s)
+		}
+	})
+}
@@ -18,10 +18,75 @@ The typical use case is to register the "gs" protocol with a http.Transport, as
 	defer resp.Body.Close()
 	// read resp.Body
 
+RegisterTo and NewClient do the same in one call.
+
 Google Cloud Storage supports object versioning.
 To access the noncurrent version of an object, use a uri like gs://[BUCKET_NAME]/[OBJECT_NAME]#[GENERATION_NUMBER].
 For example,
 
 	resp, err := c.Get("gs://shogo82148-gsprotocol/example.txt#1587160158394554")
+
+The generation query parameter, e.g. gs://[BUCKET_NAME]/[OBJECT_NAME]?generation=[GENERATION_NUMBER], does the same
+for the systems that strip the fragments from URLs.
+If both are given, they must be the same generation.
+The query parameters that the Transport doesn't recognize, e.g. a typo like ?genration=[GENERATION_NUMBER],
+get 400 Bad Request instead of being ignored.
+The object names with '#' or '?' must be percent-encoded, e.g. gs://[BUCKET_NAME]/reports/2020%23Q1.csv,
+or use WithoutFragmentGeneration to treat the fragments as a part of the object names.
+
+GET requests honor the Range header, e.g. "Range: bytes=0-499" or "Range: bytes=-500",
+and respond with 206 Partial Content.
+Multiple ranges, e.g. "Range: bytes=0-99,200-299", are served as a multipart/byteranges body, up to 16 ranges.
+Invalid Range headers and the ones with more ranges are ignored and the whole object is returned, in the same way as net/http.
+The ranges out of the object get 416 Requested Range Not Satisfiable.
+
+To download the objects under a prefix as a tar or zip archive, use the archive query parameter.
+For example,
+
+	resp, err := c.Get("gs://shogo82148-gsprotocol/some/prefix/?archive=tar")
+
+The Transport is read-only by default.
+With WithWriteMethods, PUT requests upload their bodies as the objects, DELETE requests delete the objects,
+and PATCH requests update the metadata of the objects.
+OPTIONS requests and 405 Method Not Allowed responses list the methods served in the Allow header.
+The Content-Type, Cache-Control, Content-Encoding, Content-Disposition and x-goog-meta-* headers of the requests
+are the attributes of the objects, and WithMetadataLimits limits the x-goog-meta-* headers.
+The x-goog-if-generation-match and x-goog-if-metageneration-match headers are the preconditions of the writes.
+WithWriteRetry retries only the writes that the preconditions make idempotent.
+DELETE requests of the objects under a hold or a retention policy get 409 Conflict, which is not retried.
+The writes update the caches of the Transport before it responds,
+and WithCacheSubscriber notifies the other caches of them.
+With WithIdempotencyKeys, a PUT request with the Idempotency-Key header of a successful upload
+responds with the object written by it, without uploading again.
+The x-goog-temporary-hold, x-goog-event-based-hold and x-goog-custom-time headers of PUT requests
+set the holds and the custom time of the new objects, and the responses echo them.
+The x-goog-encryption-kms-key-name header of PUT requests encrypts the new objects with the Cloud KMS key,
+and the x-goog-kms-key-name header of the responses is the key used.
+The x-goog-encryption-algorithm, x-goog-encryption-key and x-goog-encryption-key-sha256 headers of PUT requests
+encrypt the new objects with the customer-supplied encryption key.
+For example,
+
+	req, err := http.NewRequest(http.MethodPut, "gs://shogo82148-gsprotocol/example.txt", strings.NewReader("Hello"))
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := c.Do(req)
+
+With WithBulkDelete, DELETE requests with the recursive query parameter, e.g. gs://[BUCKET_NAME]/[PREFIX]?recursive=true,
+delete all the objects under the prefix, and respond with the outcome of each object and the summary of them in JSON.
+The status code is 207 Multi-Status if any object fails.
+
+A PUT request with the x-goog-copy-source header, e.g. "x-goog-copy-source: /[BUCKET_NAME]/[OBJECT_NAME]",
+copies the object in Google Cloud Storage without downloading it.
+The x-goog-copy-source-if-generation-match, x-goog-copy-source-if-match and x-goog-copy-source-if-unmodified-since headers
+are the preconditions of the source object, and the x-gsprotocol-error header of 412 Precondition Failed responses
+is "source-precondition-failed" or "destination-precondition-failed".
+A PATCH request with the x-goog-storage-class header changes the storage class of the object by rewriting it in place.
+A POST request composes up to 32 objects in the same bucket into the object,
+with the body like {"sourceObjects":[{"name":"part-1"},{"name":"part-2","generation":"1587160158394554"}]}.
+
+To get the metadata of several objects in one request, list their names in the objects query parameter.
+The response is a JSON object from the names to their metadata or errors, in the order of the request.
+For example,
+
+	resp, err := c.Get("gs://shogo82148-gsprotocol/?objects=example.txt,other.txt&alt=json")
 */
 package gsprotocol
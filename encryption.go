@@ -0,0 +1,94 @@
+package gsprotocol
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+)
+
+// encryptionKey is a customer-supplied encryption key.
+type encryptionKey struct {
+	key []byte
+
+	// sha256 is the base64-encoded SHA256 hash of key.
+	sha256 string
+}
+
+// WithEncryptionKeys configures the customer-supplied encryption keys (CSEK) for reading objects.
+// Each key must be a 32-byte AES-256 key.
+//
+// The Transport picks the key that the object is encrypted with,
+// comparing its SHA256 hash with the one that Google Cloud Storage reports in the object metadata,
+// so the keys can be rotated by listing the new and the old keys together.
+// The response has the x-goog-encryption-key-sha256 header, which identifies the key used, but never the key itself.
+// If none of the keys matches, the Transport responds 400 Bad Request.
+func WithEncryptionKeys(keys ...[]byte) Option {
+	return func(c *config) {
+		encryptionKeys := make([]encryptionKey, 0, len(c.encryptionKeys)+len(keys))
+		encryptionKeys = append(encryptionKeys, c.encryptionKeys...)
+		for _, key := range keys {
+			sum := sha256.Sum256(key)
+			encryptionKeys = append(encryptionKeys, encryptionKey{
+				key:    append([]byte(nil), key...),
+				sha256: base64.StdEncoding.EncodeToString(sum[:]),
+			})
+		}
+		c.encryptionKeys = encryptionKeys
+	}
+}
+
+// encryptionKeyError is returned if no encryption key is available for the object.
+type encryptionKeyError struct {
+	bucket string
+	object string
+	sha256 string
+}
+
+func (err *encryptionKeyError) Error() string {
+	return fmt.Sprintf("gsprotocol: gs://%s/%s is encrypted with a customer-supplied encryption key (SHA256: %s), but none of the configured keys matches",
+		err.bucket, err.object, err.sha256)
+}
+
+// encryptionKey returns the encryption key that the object is encrypted with.
+func (c *config) encryptionKey(attrs *storage.ObjectAttrs) ([]byte, error) {
+	for _, key := range c.encryptionKeys {
+		if key.sha256 == attrs.CustomerKeySHA256 {
+			return key.key, nil
+		}
+	}
+	return nil, &encryptionKeyError{
+		bucket: attrs.Bucket,
+		object: attrs.Name,
+		sha256: attrs.CustomerKeySHA256,
+	}
+}
+
+// customerKeyFromHeader returns the customer-supplied encryption key of the object to write
+// from the x-goog-encryption-algorithm, x-goog-encryption-key and x-goog-encryption-key-sha256 headers.
+// It returns nil if the request has none of them.
+// The errors never contain the key.
+func customerKeyFromHeader(header http.Header) ([]byte, error) {
+	algorithm := header.Get("X-Goog-Encryption-Algorithm")
+	encoded := header.Get("X-Goog-Encryption-Key")
+	sha := header.Get("X-Goog-Encryption-Key-Sha256")
+	if algorithm == "" && encoded == "" && sha == "" {
+		return nil, nil
+	}
+	if algorithm != "AES256" {
+		return nil, fmt.Errorf("gsprotocol: invalid x-goog-encryption-algorithm %q: want AES256", algorithm)
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("gsprotocol: invalid x-goog-encryption-key: want a base64-encoded 32-byte AES-256 key")
+	}
+	sum := sha256.Sum256(key)
+	if subtle.ConstantTimeCompare([]byte(base64.StdEncoding.EncodeToString(sum[:])), []byte(sha)) != 1 {
+		return nil, errors.New("gsprotocol: x-goog-encryption-key-sha256 doesn't match the SHA256 hash of x-goog-encryption-key")
+	}
+	return key, nil
+}
@@ -0,0 +1,177 @@
+package gsprotocol
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+func TestRoundTrip_EncryptionKeys(t *testing.T) {
+	const content = "Hello Google Cloud Storage!"
+	oldKey := bytes.Repeat([]byte{0x01}, 32)
+	newKey := bytes.Repeat([]byte{0x02}, 32)
+	otherKey := bytes.Repeat([]byte{0x03}, 32)
+	sum := sha256.Sum256(oldKey)
+	oldKeySHA256 := base64.StdEncoding.EncodeToString(sum[:])
+
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/encrypted": {
+			attrs:   &storage.ObjectAttrs{Generation: 1234567890, CustomerKeySHA256: oldKeySHA256},
+			content: content,
+		},
+		"bucket-name/plain": {
+			attrs:   &storage.ObjectAttrs{Generation: 1234567890},
+			content: content,
+		},
+	})
+	bucketFunc := mock.bucketFunc
+	mock.bucketFunc = func(mock *storageClientMock, name string) *bucketHandleMock {
+		bucket := bucketFunc(mock, name)
+		objectFunc := bucket.objectFunc
+		bucket.objectFunc = func(mock *bucketHandleMock, name string) *objectHandleMock {
+			object := objectFunc(mock, name)
+			newReader := object.newReaderFunc
+			object.newReaderFunc = func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+				if name == "encrypted" && !bytes.Equal(mock.encryptionKey, oldKey) {
+					return storage.ReaderObjectAttrs{}, nil, &googleapi.Error{Code: http.StatusBadRequest}
+				}
+				if name == "plain" && mock.encryptionKey != nil {
+					t.Error("the encryption key is used for the plain object")
+				}
+				return newReader(ctx, mock)
+			}
+			return object
+		}
+		return bucket
+	}
+
+	tc := []struct {
+		name   string
+		keys   [][]byte
+		url    string
+		status int
+		sha256 string
+	}{
+		{"new key first", [][]byte{newKey, oldKey}, "gs://bucket-name/encrypted", http.StatusOK, oldKeySHA256},
+		{"old key only", [][]byte{oldKey}, "gs://bucket-name/encrypted", http.StatusOK, oldKeySHA256},
+		{"no matching key", [][]byte{newKey, otherKey}, "gs://bucket-name/encrypted", http.StatusBadRequest, ""},
+		{"no keys", nil, "gs://bucket-name/encrypted", http.StatusBadRequest, ""},
+		{"plain object", [][]byte{newKey}, "gs://bucket-name/plain", http.StatusOK, ""},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(mock, WithEncryptionKeys(tt.keys...))
+
+			resp, err := c.Get(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("unexpected status: want %d, got %d", tt.status, resp.StatusCode)
+			}
+			if got := resp.Header.Get("x-goog-encryption-key-sha256"); got != tt.sha256 {
+				t.Errorf("unexpected x-goog-encryption-key-sha256: want %q, got %q", tt.sha256, got)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.status == http.StatusOK && string(body) != content {
+				t.Errorf("want %q, got %q", content, string(body))
+			}
+			for _, key := range [][]byte{oldKey, newKey, otherKey} {
+				if strings.Contains(string(body), base64.StdEncoding.EncodeToString(key)) {
+					t.Error("the response leaks the encryption key")
+				}
+			}
+		})
+	}
+}
+
+func TestCustomerKeyFromHeader(t *testing.T) {
+	key := bytes.Repeat([]byte{0x01}, 32)
+	encoded := base64.StdEncoding.EncodeToString(key)
+	sum := sha256.Sum256(key)
+	sha := base64.StdEncoding.EncodeToString(sum[:])
+	otherSum := sha256.Sum256(bytes.Repeat([]byte{0x02}, 32))
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   []byte
+		ok     bool
+	}{
+		{"none", http.Header{}, nil, true},
+		{
+			name: "ok",
+			header: http.Header{
+				"X-Goog-Encryption-Algorithm":  {"AES256"},
+				"X-Goog-Encryption-Key":        {encoded},
+				"X-Goog-Encryption-Key-Sha256": {sha},
+			},
+			want: key,
+			ok:   true,
+		},
+		{
+			name: "sha256 mismatch",
+			header: http.Header{
+				"X-Goog-Encryption-Algorithm":  {"AES256"},
+				"X-Goog-Encryption-Key":        {encoded},
+				"X-Goog-Encryption-Key-Sha256": {base64.StdEncoding.EncodeToString(otherSum[:])},
+			},
+		},
+		{
+			name: "no sha256",
+			header: http.Header{
+				"X-Goog-Encryption-Algorithm": {"AES256"},
+				"X-Goog-Encryption-Key":       {encoded},
+			},
+		},
+		{
+			name: "short key",
+			header: http.Header{
+				"X-Goog-Encryption-Algorithm":  {"AES256"},
+				"X-Goog-Encryption-Key":        {base64.StdEncoding.EncodeToString(key[:16])},
+				"X-Goog-Encryption-Key-Sha256": {sha},
+			},
+		},
+		{
+			name: "algorithm",
+			header: http.Header{
+				"X-Goog-Encryption-Algorithm":  {"AES128"},
+				"X-Goog-Encryption-Key":        {encoded},
+				"X-Goog-Encryption-Key-Sha256": {sha},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := customerKeyFromHeader(tt.header)
+			if tt.ok {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, tt.want) {
+					t.Errorf("unexpected key: want %x, got %x", tt.want, got)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("want an error, got nil")
+			}
+			if strings.Contains(err.Error(), encoded) {
+				t.Errorf("the error leaks the key: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package gsprotocol
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// WithoutFragmentGeneration makes the Transport treat the fragment of a URL as a part of the object name,
+// e.g. gs://[BUCKET_NAME]/reports/2020#Q1.csv is the object "reports/2020#Q1.csv", instead of a generation.
+// It is for the buckets whose object names have literal '#' and that never use generations.
+// The generation query parameter still selects a generation.
+// Regardless of the option, '#' encoded as %23 in the path is a part of the object name.
+func WithoutFragmentGeneration() Option {
+	return func(c *config) {
+		c.fragmentInName = true
+	}
+}
+
+// fragmentInName moves the fragment of req to the object name.
+func fragmentInName(req *http.Request) *http.Request {
+	if req.URL.Fragment == "" {
+		return req
+	}
+	u := *req.URL
+	u.Path += "#" + u.Fragment
+	u.RawPath = ""
+	u.Fragment = ""
+	u.RawFragment = ""
+	r := req.WithContext(req.Context())
+	r.URL = &u
+	return r
+}
+
+// generationQuery moves the generation query parameter of req to the fragment,
+// so that gs://[BUCKET_NAME]/[OBJECT_NAME]?generation=[GENERATION_NUMBER] is the same as #[GENERATION_NUMBER].
+// The query parameter is useful because many systems strip the fragments from URLs.
+// It fails if the query parameter conflicts with the fragment or itself.
+func generationQuery(req *http.Request) (*http.Request, error) {
+	u, err := moveGenerationQuery(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u == req.URL {
+		return req, nil
+	}
+	r := req.WithContext(req.Context())
+	r.URL = u
+	return r, nil
+}
+
+// moveGenerationQuery returns the copy of u whose generation query parameter is moved to the fragment.
+// It returns u itself if u has no generation query parameter.
+func moveGenerationQuery(u *url.URL) (*url.URL, error) {
+	if !strings.Contains(u.RawQuery, "generation") {
+		// fast path: avoid parsing the query of every request.
+		return u, nil
+	}
+	query := u.Query()
+	values, ok := query["generation"]
+	if !ok {
+		return u, nil
+	}
+	gen := values[0]
+	if gen == "" {
+		return nil, fmt.Errorf("gsprotocol: the generation in the query is empty")
+	}
+	for _, v := range values[1:] {
+		if v != gen {
+			return nil, fmt.Errorf("gsprotocol: conflicting generations %q and %q in the query", gen, v)
+		}
+	}
+	if fragment := u.Fragment; fragment != "" && fragment != gen {
+		return nil, fmt.Errorf("gsprotocol: the generation %q in the query conflicts with the fragment %q", gen, fragment)
+	}
+
+	moved := *u
+	query.Del("generation")
+	moved.RawQuery = query.Encode()
+	moved.Fragment = gen
+	moved.RawFragment = ""
+	return &moved, nil
+}
+
+// knownQueryParams is the query parameters that the Transport recognizes.
+var knownQueryParams = map[string]bool{
+	"alt":        true,
+	"archive":    true,
+	"decompress": true,
+	"dry-run":    true,
+	"generation": true,
+	"objects":    true,
+	"recursive":  true,
+	"wait":       true,
+}
+
+// checkQuery returns an error if u has a query parameter that the Transport doesn't recognize,
+// so that a typo like ?genration=[GENERATION_NUMBER] doesn't serve the live object silently.
+func checkQuery(u *url.URL) error {
+	query := u.RawQuery
+	for query != "" {
+		var param string
+		if i := strings.IndexByte(query, '&'); i >= 0 {
+			param, query = query[:i], query[i+1:]
+		} else {
+			param, query = query, ""
+		}
+		if param == "" {
+			continue
+		}
+		key := param
+		if i := strings.IndexByte(key, '='); i >= 0 {
+			key = key[:i]
+		}
+		name, err := url.QueryUnescape(key)
+		if err != nil || !knownQueryParams[name] {
+			return fmt.Errorf("gsprotocol: unknown query parameter %q", key)
+		}
+	}
+	return nil
+}
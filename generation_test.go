@@ -0,0 +1,192 @@
+package gsprotocol
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestRoundTrip_GenerationQuery(t *testing.T) {
+	mock := &storageClientMock{
+		bucketFunc: func(mock *storageClientMock, name string) *bucketHandleMock {
+			return &bucketHandleMock{
+				objectFunc: func(mock *bucketHandleMock, name string) *objectHandleMock {
+					return newObjectHandleMock("bucket-name", "object-key", mockObject{
+						attrs:   &storage.ObjectAttrs{Generation: 1587160158394554},
+						content: "noncurrent",
+					})
+				},
+			}
+		},
+	}
+	tr := newTestTransport(mock)
+
+	tests := []struct {
+		name   string
+		url    string
+		status int
+		body   string
+	}{
+		{
+			name:   "query",
+			url:    "gs://bucket-name/object-key?generation=1587160158394554",
+			status: http.StatusOK,
+			body:   "noncurrent",
+		},
+		{
+			name:   "query and fragment",
+			url:    "gs://bucket-name/object-key?generation=1587160158394554#1587160158394554",
+			status: http.StatusOK,
+			body:   "noncurrent",
+		},
+		{
+			name:   "other generation",
+			url:    "gs://bucket-name/object-key?generation=1234567890",
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "conflict with the fragment",
+			url:    "gs://bucket-name/object-key?generation=1587160158394554#1234567890",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "conflict in the query",
+			url:    "gs://bucket-name/object-key?generation=1587160158394554&generation=1234567890",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "empty",
+			url:    "gs://bucket-name/object-key?generation=",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "typo",
+			url:    "gs://bucket-name/object-key?genration=5",
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Errorf("unexpected status: want %d, got %d", tt.status, resp.StatusCode)
+			}
+			if tt.body != "" && string(body) != tt.body {
+				t.Errorf("unexpected body: want %q, got %q", tt.body, string(body))
+			}
+
+			// the URL of the caller is untouched.
+			if got := req.URL.String(); got != tt.url {
+				t.Errorf("the URL is modified: want %q, got %q", tt.url, got)
+			}
+		})
+	}
+
+	// invalid generations fail in the same way as the fragment.
+	for _, u := range []string{"gs://bucket-name/object-key?generation=foo", "gs://bucket-name/object-key#foo"} {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tr.RoundTrip(req)
+		if err == nil {
+			resp.Body.Close()
+			t.Errorf("%s: want an error, got %d", u, resp.StatusCode)
+		}
+	}
+}
+
+func TestRoundTrip_SpecialObjectNames(t *testing.T) {
+	names := []string{
+		"reports/2020#Q1.csv",
+		"what?.txt",
+		"with space.txt",
+		"日本語/ファイル.txt",
+		"100%.txt",
+	}
+	objects := make(map[string]mockObject, len(names))
+	for i, name := range names {
+		objects["bucket-name/"+name] = mockObject{
+			attrs:   &storage.ObjectAttrs{Generation: int64(i + 1)},
+			content: name,
+		}
+	}
+	tr := newTestTransport(newStorageClientMockWithObjects(objects))
+
+	for _, name := range names {
+		u := &url.URL{Scheme: "gs", Host: "bucket-name", Path: "/" + name}
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Errorf("%s: %v", u, err)
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || string(body) != name {
+			t.Errorf("%s: unexpected response: %d %q", u, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestRoundTrip_WithoutFragmentGeneration(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/reports/2020#Q1.csv": {
+			attrs:   &storage.ObjectAttrs{Generation: 1587160158394554},
+			content: "Q1",
+		},
+	})
+	const rawURL = "gs://bucket-name/reports/2020#Q1.csv"
+
+	// the fragment is a generation by default.
+	tr := newTestTransport(mock)
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := tr.RoundTrip(req); err == nil {
+		resp.Body.Close()
+		t.Errorf("want an invalid generation error, got %d", resp.StatusCode)
+	}
+
+	tr = newTestTransport(mock, WithoutFragmentGeneration())
+	for _, u := range []string{rawURL, "gs://bucket-name/reports/2020%23Q1.csv", "gs://bucket-name/reports/2020?generation=1587160158394554#Q1.csv"} {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || string(body) != "Q1" {
+			t.Errorf("%s: unexpected response: %d %q", u, resp.StatusCode, body)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package gsprotocol
+
+import (
+	"container/list"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+// GenerationCache caches the attributes of specific generations of objects.
+// The attributes of a generation never change, except for the metadata updated by metagenerations,
+// so a GenerationCache can be shared by the Transports with different options in a process.
+// It is safe for concurrent use.
+type GenerationCache struct {
+	maxBytes int64
+
+	mu      sync.Mutex
+	bytes   int64
+	entries map[generationCacheKey]*list.Element
+	lru     *list.List
+	stats   GenerationCacheStats
+}
+
+// GenerationCacheStats is the statistics of a GenerationCache.
+type GenerationCacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+
+	// Entries and Bytes are the number and the estimated size of the cached attributes.
+	Entries int
+	Bytes   int64
+}
+
+type generationCacheKey struct {
+	bucket     string
+	object     string
+	generation int64
+}
+
+type generationCacheEntry struct {
+	key   generationCacheKey
+	attrs *storage.ObjectAttrs
+	size  int64
+}
+
+// NewGenerationCache returns a new GenerationCache that keeps at most maxBytes of attributes.
+func NewGenerationCache(maxBytes int64) *GenerationCache {
+	return &GenerationCache{
+		maxBytes: maxBytes,
+		entries:  make(map[generationCacheKey]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// WithGenerationCache makes the Transport share cache with the other Transports.
+// The Transport looks up the attributes of the generations requested,
+// e.g. gs://[BUCKET_NAME]/[OBJECT_NAME]#[GENERATION], in cache before calling Google Cloud Storage,
+// and adds the attributes of the generations it resolves to cache.
+// The requests for the live objects always call Google Cloud Storage.
+func WithGenerationCache(cache *GenerationCache) Option {
+	return func(c *config) {
+		c.generationCache = cache
+	}
+}
+
+// Stats returns the statistics of the cache.
+func (c *GenerationCache) Stats() GenerationCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	stats.Bytes = c.bytes
+	return stats
+}
+
+// get returns a copy of the cached attributes.
+func (c *GenerationCache) get(bucket, object string, generation int64) (*storage.ObjectAttrs, bool) {
+	if c == nil {
+		return nil, false
+	}
+	key := generationCacheKey{bucket: bucket, object: object, generation: generation}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	c.lru.MoveToFront(elem)
+	return copyObjectAttrs(elem.Value.(*generationCacheEntry).attrs), true
+}
+
+// add caches a copy of attrs for the generation requested as bucket/object.
+func (c *GenerationCache) add(bucket, object string, attrs *storage.ObjectAttrs) {
+	if c == nil || attrs.Generation == 0 {
+		return
+	}
+	key := generationCacheKey{bucket: bucket, object: object, generation: attrs.Generation}
+	size := attrsSize(attrs)
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*generationCacheEntry)
+		if attrs.Metageneration <= entry.attrs.Metageneration {
+			c.lru.MoveToFront(elem)
+			return
+		}
+		// the metadata is updated.
+		c.remove(elem)
+	}
+	c.entries[key] = c.lru.PushFront(&generationCacheEntry{
+		key:   key,
+		attrs: copyObjectAttrs(attrs),
+		size:  size,
+	})
+	c.bytes += size
+	for c.bytes > c.maxBytes {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// invalidate drops the cached attributes of all the generations of the object.
+func (c *GenerationCache) invalidate(bucket, object string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, elem := range c.entries {
+		if key.bucket == bucket && key.object == object {
+			c.remove(elem)
+		}
+	}
+}
+
+func (c *GenerationCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*generationCacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.size
+}
+
+// copyObjectAttrs returns a copy of attrs, so that the callers can't modify the cached one.
+func copyObjectAttrs(attrs *storage.ObjectAttrs) *storage.ObjectAttrs {
+	cp := *attrs
+	if attrs.Metadata != nil {
+		cp.Metadata = make(map[string]string, len(attrs.Metadata))
+		for k, v := range attrs.Metadata {
+			cp.Metadata[k] = v
+		}
+	}
+	return &cp
+}
+
+// attrsSize estimates the memory size of attrs.
+func attrsSize(attrs *storage.ObjectAttrs) int64 {
+	const overhead = 512 // the fixed size fields of storage.ObjectAttrs.
+	size := int64(overhead)
+	for _, s := range []string{
+		attrs.Bucket, attrs.Name, attrs.ContentType, attrs.ContentLanguage, attrs.CacheControl,
+		attrs.ContentEncoding, attrs.ContentDisposition, attrs.StorageClass, attrs.Etag,
+		attrs.CustomerKeySHA256, attrs.MediaLink, attrs.Owner,
+	} {
+		size += int64(len(s))
+	}
+	size += int64(len(attrs.MD5))
+	for k, v := range attrs.Metadata {
+		size += int64(len(k) + len(v))
+	}
+	return size
+}
@@ -0,0 +1,145 @@
+package gsprotocol
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestGenerationCache(t *testing.T) {
+	var calls int32
+	mock := &storageClientMock{
+		bucketFunc: func(mock *storageClientMock, bucket string) *bucketHandleMock {
+			return &bucketHandleMock{
+				objectFunc: func(mock *bucketHandleMock, name string) *objectHandleMock {
+					obj := newObjectHandleMock(bucket, name, mockObject{
+						attrs:   &storage.ObjectAttrs{Generation: 1234567890},
+						content: "Hello Google Cloud Storage!",
+					})
+					attrFunc := obj.attrFunc
+					obj.attrFunc = func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+						atomic.AddInt32(&calls, 1)
+						return attrFunc(ctx, mock)
+					}
+					return obj
+				},
+			}
+		},
+	}
+
+	cache := NewGenerationCache(1 << 20)
+	c1 := &http.Client{Transport: newTestTransport(mock, WithGenerationCache(cache))}
+	c2 := &http.Client{Transport: newTestTransport(mock, WithGenerationCache(cache), WithImmutableCacheControl(false))}
+
+	get := func(c *http.Client, url string) {
+		t.Helper()
+		resp, err := c.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "Hello Google Cloud Storage!" {
+			t.Errorf("unexpected body: %q", string(body))
+		}
+	}
+
+	// the live object always calls Attrs, and is cached.
+	get(c1, "gs://bucket-name/object-key")
+	get(c1, "gs://bucket-name/object-key")
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("want 2 calls, got %d", n)
+	}
+
+	// the other Transport shares the generation.
+	get(c2, "gs://bucket-name/object-key#1234567890")
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("want 2 calls, got %d", n)
+	}
+
+	// unknown generations are not cached.
+	get(c2, "gs://bucket-name/other-key#1234567890")
+	get(c1, "gs://bucket-name/other-key#1234567890")
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("want 3 calls, got %d", n)
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Entries != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestGenerationCache_Eviction(t *testing.T) {
+	attrs := func(name string) *storage.ObjectAttrs {
+		return &storage.ObjectAttrs{Name: name, Generation: 1}
+	}
+	size := attrsSize(attrs("a"))
+	cache := NewGenerationCache(2 * size)
+
+	cache.add("bucket", "a", attrs("a"))
+	cache.add("bucket", "b", attrs("b"))
+	if _, ok := cache.get("bucket", "a", 1); !ok {
+		t.Error("a must be cached")
+	}
+	cache.add("bucket", "c", attrs("c"))
+
+	// b is the least recently used.
+	if _, ok := cache.get("bucket", "b", 1); ok {
+		t.Error("b must be evicted")
+	}
+	if _, ok := cache.get("bucket", "a", 1); !ok {
+		t.Error("a must be cached")
+	}
+	if _, ok := cache.get("bucket", "c", 1); !ok {
+		t.Error("c must be cached")
+	}
+	stats := cache.Stats()
+	if stats.Evictions != 1 || stats.Entries != 2 || stats.Bytes != 2*size {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// the cached attributes can't be modified by the callers.
+	got, _ := cache.get("bucket", "a", 1)
+	got.Name = "modified"
+	if got, _ := cache.get("bucket", "a", 1); got.Name != "a" {
+		t.Errorf("the cached attributes are modified: %q", got.Name)
+	}
+}
+
+func TestGenerationCache_Concurrent(t *testing.T) {
+	cache := NewGenerationCache(16 * attrsSize(&storage.ObjectAttrs{Name: "key-00"}))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				name := fmt.Sprintf("key-%02d", (i*j)%32)
+				if _, ok := cache.get("bucket", name, 1); !ok {
+					cache.add("bucket", name, &storage.ObjectAttrs{
+						Name:       name,
+						Generation: 1,
+						Metadata:   map[string]string{"key": strings.Repeat("x", j%4)},
+					})
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stats := cache.Stats()
+	if stats.Bytes > cache.maxBytes {
+		t.Errorf("the cache exceeds the limit: %+v", stats)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"net/http"
+	"net/http/httputil"
 	"os"
 
 	"github.com/shogo82148/gsprotocol"
@@ -31,3 +32,23 @@ func ExampleNewTransport() {
 	// Output:
 	// Hello Google Cloud Storage!
 }
+
+func ExampleTransport_reverseProxy() {
+	gs, err := gsprotocol.NewTransport(context.Background(), option.WithoutAuthentication())
+	if err != nil {
+		panic(err)
+	}
+
+	// serve gs://shogo82148-gsprotocol/ on http://localhost:8080/
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "gs"
+			req.URL.Host = "shogo82148-gsprotocol"
+			req.Host = "" // the Transport takes the bucket name from req.Host if it is set.
+		},
+		Transport: gs,
+	}
+	if err := http.ListenAndServe("localhost:8080", proxy); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,130 @@
+package gsprotocoltest
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+// Op is the kind of the operations recorded by Server.
+type Op string
+
+const (
+	// OpAttrs is ObjectHandle.Attrs.
+	OpAttrs Op = "Attrs"
+
+	// OpNewRangeReader is ObjectHandle.NewRangeReader.
+	// ObjectHandle.NewReader is recorded as OpNewRangeReader with the offset 0 and the length -1.
+	OpNewRangeReader Op = "NewRangeReader"
+
+	// OpWrite is closing the StorageWriter of ObjectHandle.NewWriter.
+	OpWrite Op = "Write"
+
+	// OpDelete is ObjectHandle.Delete.
+	OpDelete Op = "Delete"
+
+	// OpUpdate is ObjectHandle.Update.
+	OpUpdate Op = "Update"
+
+	// OpCopy is running the StorageCopier of ObjectHandle.CopierFrom, recorded with the destination.
+	OpCopy Op = "Copy"
+
+	// OpCompose is running the StorageComposer of ObjectHandle.ComposerFrom, recorded with the destination.
+	OpCompose Op = "Compose"
+
+	// OpObjects is BucketHandle.Objects, recorded with the prefix of the query as the object.
+	OpObjects Op = "Objects"
+)
+
+// Call is an operation on the Server.
+type Call struct {
+	Op         Op
+	Bucket     string
+	Object     string
+	Generation int64
+	Conditions storage.Conditions
+
+	// Offset and Length are the range of OpNewRangeReader, and zero for the other operations.
+	Offset int64
+	Length int64
+}
+
+func (c Call) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s gs://%s/%s", c.Op, c.Bucket, c.Object)
+	if c.Generation != 0 {
+		fmt.Fprintf(&b, "#%d", c.Generation)
+	}
+	if c.Conditions != (storage.Conditions{}) {
+		fmt.Fprintf(&b, " %+v", c.Conditions)
+	}
+	if c.Op == OpNewRangeReader {
+		fmt.Fprintf(&b, " offset=%d length=%d", c.Offset, c.Length)
+	}
+	return b.String()
+}
+
+// record records the call. s.callMu must not be held.
+func (s *Server) record(call Call) {
+	s.callMu.Lock()
+	defer s.callMu.Unlock()
+	s.calls = append(s.calls, call)
+}
+
+// Calls returns the operations on the Server in the order of the calls, including the failed ones.
+// The operations of PutObject and Object are not recorded.
+func (s *Server) Calls() []Call {
+	s.callMu.Lock()
+	defer s.callMu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// CallsFor returns the operations on the object of the URL, e.g. "gs://bucket/key", in the order of the calls.
+// The URL of a bucket, e.g. "gs://bucket", returns the operations on the bucket and all the objects in it.
+func (s *Server) CallsFor(url string) []Call {
+	bucket, name, hasName := strings.Cut(strings.TrimPrefix(url, "gs://"), "/")
+	var calls []Call
+	for _, call := range s.Calls() {
+		if call.Bucket != bucket || (hasName && call.Object != name) {
+			continue
+		}
+		calls = append(calls, call)
+	}
+	return calls
+}
+
+// ResetCalls forgets the recorded operations, e.g. between test cases.
+func (s *Server) ResetCalls() {
+	s.callMu.Lock()
+	defer s.callMu.Unlock()
+	s.calls = nil
+}
+
+// AssertCalls reports an error to t unless the recorded operations are exactly want, in the same order.
+// AssertCalls without want asserts that the Server has no calls.
+func (s *Server) AssertCalls(t testing.TB, want ...Call) bool {
+	t.Helper()
+	got := s.Calls()
+	if len(got) == 0 && len(want) == 0 {
+		return true
+	}
+	if reflect.DeepEqual(got, want) {
+		return true
+	}
+	t.Errorf("unexpected calls:\nwant:\n%s\ngot:\n%s", formatCalls(want), formatCalls(got))
+	return false
+}
+
+func formatCalls(calls []Call) string {
+	if len(calls) == 0 {
+		return "\t(no calls)"
+	}
+	lines := make([]string, 0, len(calls))
+	for _, call := range calls {
+		lines = append(lines, "\t"+call.String())
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,494 @@
+package gsprotocoltest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/shogo82148/gsprotocol"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+var errPreconditionFailed = &googleapi.Error{
+	Code:    http.StatusPreconditionFailed,
+	Message: "At least one of the pre-conditions you specified did not hold.",
+}
+
+var errEncryptionKey = &googleapi.Error{
+	Code:    http.StatusBadRequest,
+	Message: "The target object is encrypted by a customer-supplied encryption key.",
+}
+
+type bucketHandle struct {
+	server *Server
+	bucket string
+}
+
+func (h *bucketHandle) Object(name string) gsprotocol.ObjectHandle {
+	return &objectHandle{
+		server: h.server,
+		bucket: h.bucket,
+		name:   name,
+	}
+}
+
+// UserProject implements gsprotocol.BucketHandle.
+// The fake has no billing, so the project is ignored.
+func (h *bucketHandle) UserProject(project string) gsprotocol.BucketHandle {
+	return h
+}
+
+// Objects supports Prefix, Delimiter, StartOffset, EndOffset and Versions of q.
+func (h *bucketHandle) Objects(ctx context.Context, q *storage.Query) gsprotocol.ObjectIterator {
+	call := Call{Op: OpObjects, Bucket: h.bucket}
+	if q != nil {
+		call.Object = q.Prefix
+	}
+	h.server.record(call)
+	return &objectIterator{
+		ctx:   ctx,
+		attrs: h.server.list(h.bucket, q),
+	}
+}
+
+type objectIterator struct {
+	ctx   context.Context
+	attrs []*storage.ObjectAttrs
+}
+
+func (it *objectIterator) Next() (*storage.ObjectAttrs, error) {
+	if err := it.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(it.attrs) == 0 {
+		return nil, iterator.Done
+	}
+	attrs := it.attrs[0]
+	it.attrs = it.attrs[1:]
+	return attrs, nil
+}
+
+type objectHandle struct {
+	server *Server
+	bucket string
+	name   string
+	gen    int64
+	conds  storage.Conditions
+	key    []byte
+
+	// compressed is recorded but has no effect: the fake serves the stored bytes, without decompressive transcoding.
+	compressed bool
+}
+
+// call returns the call of op on the handle.
+func (h *objectHandle) call(op Op) Call {
+	return Call{
+		Op:         op,
+		Bucket:     h.bucket,
+		Object:     h.name,
+		Generation: h.gen,
+		Conditions: h.conds,
+	}
+}
+
+// lookup returns the object that the handle points to, after checking the conditions.
+// s.mu must be held.
+func (h *objectHandle) lookup() (*object, error) {
+	obj := h.server.find(h.bucket, h.name, h.gen)
+	if obj == nil {
+		if err := h.checkConditions(nil); err != nil {
+			return nil, err
+		}
+		return nil, storage.ErrObjectNotExist
+	}
+	if err := h.checkConditions(obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// checkConditions checks the conditions against obj, which is nil if the object doesn't exist.
+func (h *objectHandle) checkConditions(obj *object) error {
+	conds := h.conds
+	if obj == nil {
+		if conds.GenerationMatch != 0 || conds.MetagenerationMatch != 0 {
+			return errPreconditionFailed
+		}
+		return nil
+	}
+	if conds.DoesNotExist {
+		return errPreconditionFailed
+	}
+	if conds.GenerationMatch != 0 && conds.GenerationMatch != obj.attrs.Generation {
+		return errPreconditionFailed
+	}
+	if conds.GenerationNotMatch != 0 && conds.GenerationNotMatch == obj.attrs.Generation {
+		return errPreconditionFailed
+	}
+	if conds.MetagenerationMatch != 0 && conds.MetagenerationMatch != obj.attrs.Metageneration {
+		return errPreconditionFailed
+	}
+	if conds.MetagenerationNotMatch != 0 && conds.MetagenerationNotMatch == obj.attrs.Metageneration {
+		return errPreconditionFailed
+	}
+	return nil
+}
+
+// checkKey checks that the handle has the encryption key of obj.
+func (h *objectHandle) checkKey(obj *object) error {
+	sha := ""
+	if h.key != nil {
+		sha = keySHA256(h.key)
+	}
+	if sha != obj.attrs.CustomerKeySHA256 {
+		return errEncryptionKey
+	}
+	return nil
+}
+
+func (h *objectHandle) Attrs(ctx context.Context) (*storage.ObjectAttrs, error) {
+	h.server.record(h.call(OpAttrs))
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	h.server.mu.Lock()
+	defer h.server.mu.Unlock()
+	obj, err := h.lookup()
+	if err != nil {
+		return nil, err
+	}
+	return copyAttrs(&obj.attrs), nil
+}
+
+func (h *objectHandle) NewReader(ctx context.Context) (gsprotocol.StorageReader, error) {
+	return h.NewRangeReader(ctx, 0, -1)
+}
+
+// NewRangeReader reads length bytes from offset, or to the end if length is negative.
+// Negative offset reads the last -offset bytes, like storage.ObjectHandle.NewRangeReader.
+func (h *objectHandle) NewRangeReader(ctx context.Context, offset, length int64) (gsprotocol.StorageReader, error) {
+	call := h.call(OpNewRangeReader)
+	call.Offset, call.Length = offset, length
+	h.server.record(call)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	h.server.mu.Lock()
+	defer h.server.mu.Unlock()
+	obj, err := h.lookup()
+	if err != nil {
+		return nil, err
+	}
+	if err := h.checkKey(obj); err != nil {
+		return nil, err
+	}
+
+	size := int64(len(obj.data))
+	if offset < 0 {
+		offset += size
+		if offset < 0 {
+			offset = 0
+		}
+	}
+	if offset > size || (offset == size && size > 0) {
+		return nil, &googleapi.Error{
+			Code:    http.StatusRequestedRangeNotSatisfiable,
+			Message: "The requested range cannot be satisfied.",
+		}
+	}
+	end := size
+	if length >= 0 && offset+length < size {
+		end = offset + length
+	}
+	return &reader{
+		ctx:    ctx,
+		Reader: bytes.NewReader(obj.data[offset:end]),
+		attrs: storage.ReaderObjectAttrs{
+			Size:            size,
+			StartOffset:     offset,
+			ContentType:     obj.attrs.ContentType,
+			ContentEncoding: obj.attrs.ContentEncoding,
+			CacheControl:    obj.attrs.CacheControl,
+			LastModified:    obj.attrs.Updated,
+			Generation:      obj.attrs.Generation,
+			Metageneration:  obj.attrs.Metageneration,
+		},
+	}, nil
+}
+
+// NewWriter writes the new generation of the object when the writer is closed.
+func (h *objectHandle) NewWriter(ctx context.Context) gsprotocol.StorageWriter {
+	return &writer{ctx: ctx, handle: h}
+}
+
+// Delete deletes the generation of the handle permanently,
+// or makes the live generation noncurrent if the handle has no generation.
+func (h *objectHandle) Delete(ctx context.Context) error {
+	h.server.record(h.call(OpDelete))
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	h.server.mu.Lock()
+	defer h.server.mu.Unlock()
+	if _, err := h.lookup(); err != nil {
+		return err
+	}
+	h.server.remove(h.bucket, h.name, h.gen)
+	return nil
+}
+
+// Update updates the metadata of the object.
+// The custom metadata in attrs are merged into the existing ones, and an empty map clears them.
+func (h *objectHandle) Update(ctx context.Context, uattrs storage.ObjectAttrsToUpdate) (*storage.ObjectAttrs, error) {
+	h.server.record(h.call(OpUpdate))
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	h.server.mu.Lock()
+	defer h.server.mu.Unlock()
+	obj, err := h.lookup()
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := &obj.attrs
+	if v, ok := uattrs.ContentType.(string); ok {
+		attrs.ContentType = v
+	}
+	if v, ok := uattrs.ContentLanguage.(string); ok {
+		attrs.ContentLanguage = v
+	}
+	if v, ok := uattrs.ContentEncoding.(string); ok {
+		attrs.ContentEncoding = v
+	}
+	if v, ok := uattrs.ContentDisposition.(string); ok {
+		attrs.ContentDisposition = v
+	}
+	if v, ok := uattrs.CacheControl.(string); ok {
+		attrs.CacheControl = v
+	}
+	if uattrs.Metadata != nil {
+		if len(uattrs.Metadata) == 0 {
+			attrs.Metadata = nil
+		} else {
+			if attrs.Metadata == nil {
+				attrs.Metadata = make(map[string]string, len(uattrs.Metadata))
+			}
+			for k, v := range uattrs.Metadata {
+				if v == "" {
+					// the empty values delete the keys, the same as Google Cloud Storage.
+					delete(attrs.Metadata, k)
+				} else {
+					attrs.Metadata[k] = v
+				}
+			}
+			if len(attrs.Metadata) == 0 {
+				attrs.Metadata = nil
+			}
+		}
+	}
+	attrs.Metageneration++
+	attrs.Etag = etag(attrs.Generation, attrs.Metageneration)
+	attrs.Updated = time.Now()
+	return copyAttrs(attrs), nil
+}
+
+// CopierFrom copies src, which must be an object of the same Server.
+func (h *objectHandle) CopierFrom(src gsprotocol.ObjectHandle) gsprotocol.StorageCopier {
+	return &composer{dst: h, srcs: []gsprotocol.ObjectHandle{src}}
+}
+
+// ComposerFrom concatenates srcs, which must be objects of the same Server.
+func (h *objectHandle) ComposerFrom(srcs ...gsprotocol.ObjectHandle) gsprotocol.StorageComposer {
+	return &composer{dst: h, srcs: srcs, compose: true}
+}
+
+func (h *objectHandle) If(conds storage.Conditions) gsprotocol.ObjectHandle {
+	cp := *h
+	cp.conds = conds
+	return &cp
+}
+
+func (h *objectHandle) Generation(gen int64) gsprotocol.ObjectHandle {
+	cp := *h
+	cp.gen = gen
+	return &cp
+}
+
+func (h *objectHandle) Key(encryptionKey []byte) gsprotocol.ObjectHandle {
+	cp := *h
+	cp.key = append([]byte(nil), encryptionKey...)
+	return &cp
+}
+
+func (h *objectHandle) ReadCompressed(compressed bool) gsprotocol.ObjectHandle {
+	cp := *h
+	cp.compressed = compressed
+	return &cp
+}
+
+// reader fails if the context is canceled, like storage.Reader.
+type reader struct {
+	*bytes.Reader
+	ctx   context.Context
+	attrs storage.ReaderObjectAttrs
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.Reader.Read(p)
+}
+
+func (r *reader) WriteTo(w io.Writer) (int64, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.Reader.WriteTo(w)
+}
+
+func (r *reader) Close() error {
+	return nil
+}
+
+func (r *reader) Attrs() storage.ReaderObjectAttrs {
+	return r.attrs
+}
+
+// writer buffers the content, and writes it on Close.
+type writer struct {
+	ctx     context.Context
+	handle  *objectHandle
+	buf     bytes.Buffer
+	attrs   storage.ObjectAttrs
+	written *storage.ObjectAttrs
+}
+
+func (w *writer) ObjectAttrs() *storage.ObjectAttrs {
+	return &w.attrs
+}
+
+func (w *writer) Attrs() *storage.ObjectAttrs {
+	return w.written
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.buf.Write(p)
+}
+
+// Close writes the object, unless the context is canceled or the conditions don't hold.
+func (w *writer) Close() error {
+	w.handle.server.record(w.handle.call(OpWrite))
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	h := w.handle
+	s := h.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := h.checkConditions(s.find(h.bucket, h.name, 0)); err != nil {
+		return err
+	}
+	attrs := w.attrs
+	attrs.Metadata = copyMetadata(attrs.Metadata)
+	obj := s.put(h.bucket, h.name, append([]byte(nil), w.buf.Bytes()...), attrs, h.key)
+	w.written = copyAttrs(&obj.attrs)
+	return nil
+}
+
+// composer copies or composes the source objects into the destination.
+type composer struct {
+	dst     *objectHandle
+	srcs    []gsprotocol.ObjectHandle
+	compose bool
+	attrs   storage.ObjectAttrs
+}
+
+func (c *composer) ObjectAttrs() *storage.ObjectAttrs {
+	return &c.attrs
+}
+
+func (c *composer) Run(ctx context.Context) (*storage.ObjectAttrs, error) {
+	op := OpCopy
+	if c.compose {
+		op = OpCompose
+	}
+	c.dst.server.record(c.dst.call(op))
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	dst := c.dst
+	s := dst.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var data []byte
+	var base storage.ObjectAttrs
+	for i, src := range c.srcs {
+		h, ok := src.(*objectHandle)
+		if !ok || h.server != s {
+			return nil, errors.New("gsprotocoltest: the source object is not in the same Server")
+		}
+		if c.compose && h.bucket != dst.bucket {
+			return nil, &googleapi.Error{
+				Code:    http.StatusBadRequest,
+				Message: "The source objects must be in the same bucket as the destination.",
+			}
+		}
+		obj, err := h.lookup()
+		if err != nil {
+			return nil, err
+		}
+		if err := h.checkKey(obj); err != nil {
+			return nil, err
+		}
+		if i == 0 && !c.compose {
+			// copying keeps the attributes of the source.
+			base = obj.attrs
+			base.Metadata = copyMetadata(base.Metadata)
+		}
+		data = append(data, obj.data...)
+	}
+	if err := dst.checkConditions(s.find(dst.bucket, dst.name, 0)); err != nil {
+		return nil, err
+	}
+
+	// the non-zero attributes override the ones of the source.
+	if v := c.attrs.ContentType; v != "" {
+		base.ContentType = v
+	}
+	if v := c.attrs.ContentLanguage; v != "" {
+		base.ContentLanguage = v
+	}
+	if v := c.attrs.ContentEncoding; v != "" {
+		base.ContentEncoding = v
+	}
+	if v := c.attrs.ContentDisposition; v != "" {
+		base.ContentDisposition = v
+	}
+	if v := c.attrs.CacheControl; v != "" {
+		base.CacheControl = v
+	}
+	if v := c.attrs.StorageClass; v != "" {
+		base.StorageClass = v
+	}
+	if c.attrs.Metadata != nil {
+		base.Metadata = copyMetadata(c.attrs.Metadata)
+	}
+	obj := s.put(dst.bucket, dst.name, data, base, dst.key)
+	if c.compose {
+		// the composite objects have no MD5 hash.
+		obj.attrs.MD5 = nil
+		obj.attrs.ComponentCount = int64(len(c.srcs))
+	}
+	return copyAttrs(&obj.attrs), nil
+}
@@ -0,0 +1,315 @@
+// Package gsprotocoltest provides an in-memory fake of Google Cloud Storage,
+// to test the code that reads and writes objects through gsprotocol.
+//
+// For example,
+//
+//	fake := gsprotocoltest.NewServer()
+//	fake.PutObject("bucket", "key", []byte("data"), gsprotocoltest.WithContentType("text/plain"))
+//	tr := &http.Transport{}
+//	gsprotocol.NewTransportWithStorage(fake).RegisterProtocols(tr)
+//	c := &http.Client{Transport: tr}
+//	resp, err := c.Get("gs://bucket/key")
+//
+// The Server records the operations on it, to assert the access patterns:
+//
+//	fake.ResetCalls()
+//	resp, err = c.Get("gs://bucket/key")
+//	fake.AssertCalls(t, gsprotocoltest.Call{Op: gsprotocoltest.OpAttrs, Bucket: "bucket", Object: "key"}, ...)
+package gsprotocoltest
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/shogo82148/gsprotocol"
+)
+
+var _ gsprotocol.StorageClient = (*Server)(nil)
+
+// Server is an in-memory fake of Google Cloud Storage, which implements gsprotocol.StorageClient.
+// The buckets have object versioning enabled:
+// writing or deleting an object keeps the previous generation as a noncurrent version.
+// Server is safe for concurrent use.
+type Server struct {
+	mu sync.Mutex
+
+	// objects is the generations of the objects, keyed by "bucket/name", in the order of writes.
+	objects map[string][]*object
+
+	// lastGeneration is the generation of the last write.
+	lastGeneration int64
+
+	// calls is the operations on the Server, in the order of the calls. See Calls.
+	callMu sync.Mutex
+	calls  []Call
+}
+
+// object is a generation of an object.
+type object struct {
+	attrs storage.ObjectAttrs
+	data  []byte
+	live  bool
+}
+
+// NewServer returns a new Server without objects.
+func NewServer() *Server {
+	return &Server{
+		objects: make(map[string][]*object),
+	}
+}
+
+// ObjectOption configures the attributes of an object written by PutObject.
+type ObjectOption func(attrs *storage.ObjectAttrs, key *[]byte)
+
+// WithContentType sets the Content-Type of the object.
+func WithContentType(contentType string) ObjectOption {
+	return func(attrs *storage.ObjectAttrs, key *[]byte) {
+		attrs.ContentType = contentType
+	}
+}
+
+// WithContentEncoding sets the Content-Encoding of the object, e.g. "gzip".
+// The data of PutObject must be encoded already.
+func WithContentEncoding(contentEncoding string) ObjectOption {
+	return func(attrs *storage.ObjectAttrs, key *[]byte) {
+		attrs.ContentEncoding = contentEncoding
+	}
+}
+
+// WithCacheControl sets the Cache-Control of the object.
+func WithCacheControl(cacheControl string) ObjectOption {
+	return func(attrs *storage.ObjectAttrs, key *[]byte) {
+		attrs.CacheControl = cacheControl
+	}
+}
+
+// WithContentDisposition sets the Content-Disposition of the object.
+func WithContentDisposition(contentDisposition string) ObjectOption {
+	return func(attrs *storage.ObjectAttrs, key *[]byte) {
+		attrs.ContentDisposition = contentDisposition
+	}
+}
+
+// WithMetadata sets the custom metadata of the object.
+func WithMetadata(metadata map[string]string) ObjectOption {
+	return func(attrs *storage.ObjectAttrs, key *[]byte) {
+		attrs.Metadata = copyMetadata(metadata)
+	}
+}
+
+// WithEncryptionKey encrypts the object with the customer-supplied encryption key.
+// Reading the object requires the same key.
+func WithEncryptionKey(encryptionKey []byte) ObjectOption {
+	return func(attrs *storage.ObjectAttrs, key *[]byte) {
+		*key = append([]byte(nil), encryptionKey...)
+	}
+}
+
+// PutObject writes data as the new generation of the object, and returns its attributes.
+func (s *Server) PutObject(bucket, name string, data []byte, opts ...ObjectOption) *storage.ObjectAttrs {
+	var attrs storage.ObjectAttrs
+	var key []byte
+	for _, opt := range opts {
+		opt(&attrs, &key)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj := s.put(bucket, name, append([]byte(nil), data...), attrs, key)
+	return copyAttrs(&obj.attrs)
+}
+
+// Object returns the content and the attributes of the live generation of the object.
+// ok is false if the object doesn't exist.
+func (s *Server) Object(bucket, name string) (data []byte, attrs *storage.ObjectAttrs, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj := s.find(bucket, name, 0)
+	if obj == nil {
+		return nil, nil, false
+	}
+	return append([]byte(nil), obj.data...), copyAttrs(&obj.attrs), true
+}
+
+// Bucket implements gsprotocol.StorageClient.
+// The buckets exist implicitly.
+func (s *Server) Bucket(name string) gsprotocol.BucketHandle {
+	return &bucketHandle{server: s, bucket: name}
+}
+
+// Close implements gsprotocol.StorageClient. It does nothing.
+func (s *Server) Close() error {
+	return nil
+}
+
+// Underlying implements gsprotocol.StorageClient. It returns nil.
+func (s *Server) Underlying() *storage.Client {
+	return nil
+}
+
+// put writes a new generation of the object. s.mu must be held.
+func (s *Server) put(bucket, name string, data []byte, attrs storage.ObjectAttrs, key []byte) *object {
+	// the generations are the timestamps in microseconds, like Google Cloud Storage.
+	now := time.Now()
+	gen := now.UnixNano() / int64(time.Microsecond)
+	if gen <= s.lastGeneration {
+		gen = s.lastGeneration + 1
+	}
+	s.lastGeneration = gen
+
+	md5sum := md5.Sum(data)
+	attrs.Bucket = bucket
+	attrs.Name = name
+	attrs.Size = int64(len(data))
+	attrs.MD5 = md5sum[:]
+	attrs.CRC32C = crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))
+	attrs.Generation = gen
+	attrs.Metageneration = 1
+	attrs.Etag = etag(gen, 1)
+	attrs.Created = now
+	attrs.Updated = now
+	attrs.Deleted = time.Time{}
+	attrs.Prefix = ""
+	if attrs.StorageClass == "" {
+		attrs.StorageClass = "STANDARD"
+	}
+	if key != nil {
+		attrs.CustomerKeySHA256 = keySHA256(key)
+	} else {
+		attrs.CustomerKeySHA256 = ""
+	}
+
+	objectKey := bucket + "/" + name
+	for _, obj := range s.objects[objectKey] {
+		if obj.live {
+			obj.live = false
+			obj.attrs.Deleted = now
+		}
+	}
+	obj := &object{attrs: attrs, data: data, live: true}
+	s.objects[objectKey] = append(s.objects[objectKey], obj)
+	return obj
+}
+
+// find returns the generation gen of the object, or the live generation if gen is zero.
+// It returns nil if it doesn't exist. s.mu must be held.
+func (s *Server) find(bucket, name string, gen int64) *object {
+	for _, obj := range s.objects[bucket+"/"+name] {
+		if (gen == 0 && obj.live) || (gen != 0 && obj.attrs.Generation == gen) {
+			return obj
+		}
+	}
+	return nil
+}
+
+// remove deletes the generation gen of the object permanently,
+// or makes the live generation noncurrent if gen is zero. s.mu must be held.
+func (s *Server) remove(bucket, name string, gen int64) bool {
+	objectKey := bucket + "/" + name
+	objects := s.objects[objectKey]
+	for i, obj := range objects {
+		if gen == 0 && obj.live {
+			obj.live = false
+			obj.attrs.Deleted = time.Now()
+			return true
+		}
+		if gen != 0 && obj.attrs.Generation == gen {
+			s.objects[objectKey] = append(objects[:i:i], objects[i+1:]...)
+			if len(s.objects[objectKey]) == 0 {
+				delete(s.objects, objectKey)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// list returns the objects in the bucket that match q, in the order of the names.
+func (s *Server) list(bucket string, q *storage.Query) []*storage.ObjectAttrs {
+	if q == nil {
+		q = &storage.Query{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []*storage.ObjectAttrs
+	prefixes := make(map[string]bool)
+	for objectKey, objects := range s.objects {
+		if !strings.HasPrefix(objectKey, bucket+"/") {
+			continue
+		}
+		name := strings.TrimPrefix(objectKey, bucket+"/")
+		if !strings.HasPrefix(name, q.Prefix) {
+			continue
+		}
+		if q.StartOffset != "" && name < q.StartOffset {
+			continue
+		}
+		if q.EndOffset != "" && name >= q.EndOffset {
+			continue
+		}
+		if q.Delimiter != "" {
+			if i := strings.Index(name[len(q.Prefix):], q.Delimiter); i >= 0 {
+				prefix := name[:len(q.Prefix)+i+len(q.Delimiter)]
+				if !prefixes[prefix] {
+					prefixes[prefix] = true
+					list = append(list, &storage.ObjectAttrs{Prefix: prefix})
+				}
+				continue
+			}
+		}
+		for _, obj := range objects {
+			if obj.live || q.Versions {
+				list = append(list, copyAttrs(&obj.attrs))
+			}
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i].Name+list[i].Prefix, list[j].Name+list[j].Prefix
+		if a != b {
+			return a < b
+		}
+		return list[i].Generation < list[j].Generation
+	})
+	return list
+}
+
+// etag returns the ETag in the format of the JSON API of Google Cloud Storage,
+// which is the base64 encoded protocol buffer of the generation and the metageneration.
+func etag(gen, metageneration int64) string {
+	buf := []byte{0x08}
+	buf = binary.AppendUvarint(buf, uint64(gen))
+	buf = append(buf, 0x10)
+	buf = binary.AppendUvarint(buf, uint64(metageneration))
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+func keySHA256(key []byte) string {
+	sum := sha256.Sum256(key)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func copyAttrs(attrs *storage.ObjectAttrs) *storage.ObjectAttrs {
+	cp := *attrs
+	cp.MD5 = append([]byte(nil), attrs.MD5...)
+	cp.Metadata = copyMetadata(attrs.Metadata)
+	return &cp
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	if metadata == nil {
+		return nil
+	}
+	cp := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		cp[k] = v
+	}
+	return cp
+}
@@ -0,0 +1,340 @@
+package gsprotocoltest_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/shogo82148/gsprotocol"
+	"github.com/shogo82148/gsprotocol/gsprotocoltest"
+	"google.golang.org/api/iterator"
+)
+
+const content = "Hello Google Cloud Storage!"
+
+func newClient(fake *gsprotocoltest.Server, opts ...gsprotocol.Option) *http.Client {
+	tr := &http.Transport{}
+	gsprotocol.NewTransportWithStorage(fake, opts...).RegisterProtocols(tr)
+	return &http.Client{Transport: tr}
+}
+
+func do(t *testing.T, c *http.Client, method, url string, body io.Reader) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(got)
+}
+
+func TestServer_Get(t *testing.T) {
+	fake := gsprotocoltest.NewServer()
+	fake.PutObject("bucket", "key", []byte(content), gsprotocoltest.WithContentType("text/plain"))
+	c := newClient(fake)
+
+	resp, body := do(t, c, http.MethodGet, "gs://bucket/key", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if body != content {
+		t.Errorf("unexpected body: %q", body)
+	}
+	if got, want := resp.Header.Get("Content-Type"), "text/plain"; got != want {
+		t.Errorf("unexpected Content-Type: want %q, got %q", want, got)
+	}
+	sum := md5.Sum([]byte(content))
+	if got, want := resp.Header.Get("ETag"), `"`+hex.EncodeToString(sum[:])+`"`; got != want {
+		t.Errorf("unexpected ETag: want %q, got %q", want, got)
+	}
+	hashes := strings.Join(resp.Header.Values("X-Goog-Hash"), ",")
+	if !strings.Contains(hashes, "crc32c=") || !strings.Contains(hashes, "md5=") {
+		t.Errorf("unexpected x-goog-hash: %q", hashes)
+	}
+
+	resp, body = do(t, c, http.MethodGet, "gs://bucket/key", nil)
+	if resp.StatusCode != http.StatusOK || body != content {
+		t.Errorf("unexpected response: %d %q", resp.StatusCode, body)
+	}
+
+	resp, _ = do(t, c, http.MethodGet, "gs://bucket/not-found", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestServer_Range(t *testing.T) {
+	fake := gsprotocoltest.NewServer()
+	fake.PutObject("bucket", "key", []byte(content))
+	c := newClient(fake)
+
+	req, err := http.NewRequest(http.MethodGet, "gs://bucket/key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", "bytes=-8")
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusPartialContent, resp.StatusCode)
+	}
+	if string(body) != "Storage!" {
+		t.Errorf("unexpected body: %q", body)
+	}
+}
+
+func TestServer_Generations(t *testing.T) {
+	fake := gsprotocoltest.NewServer()
+	v1 := fake.PutObject("bucket", "key", []byte("version 1"))
+	v2 := fake.PutObject("bucket", "key", []byte("version 2"))
+	if v2.Generation <= v1.Generation {
+		t.Fatalf("want a new generation, got %d after %d", v2.Generation, v1.Generation)
+	}
+	c := newClient(fake)
+
+	resp, body := do(t, c, http.MethodGet, "gs://bucket/key", nil)
+	if body != "version 2" {
+		t.Errorf("unexpected body: %q", body)
+	}
+	if got, want := resp.Header.Get("X-Goog-Generation"), strconv.FormatInt(v2.Generation, 10); got != want {
+		t.Errorf("unexpected generation: want %s, got %s", want, got)
+	}
+
+	// the noncurrent version is kept.
+	_, body = do(t, c, http.MethodGet, "gs://bucket/key#"+strconv.FormatInt(v1.Generation, 10), nil)
+	if body != "version 1" {
+		t.Errorf("unexpected body: %q", body)
+	}
+}
+
+func TestServer_Write(t *testing.T) {
+	fake := gsprotocoltest.NewServer()
+	c := newClient(fake, gsprotocol.WithWriteMethods())
+
+	resp, _ := do(t, c, http.MethodPut, "gs://bucket/key", strings.NewReader(content))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	data, attrs, ok := fake.Object("bucket", "key")
+	if !ok {
+		t.Fatal("the object is not written")
+	}
+	if string(data) != content {
+		t.Errorf("unexpected content: %q", data)
+	}
+	if attrs.Size != int64(len(content)) {
+		t.Errorf("unexpected size: %d", attrs.Size)
+	}
+
+	resp, _ = do(t, c, http.MethodDelete, "gs://bucket/key", nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+	if _, _, ok := fake.Object("bucket", "key"); ok {
+		t.Error("the object is not deleted")
+	}
+	resp, _ = do(t, c, http.MethodDelete, "gs://bucket/key", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestServer_WriteEncryptionKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0x01}, 32)
+	sum := sha256.Sum256(key)
+	fake := gsprotocoltest.NewServer()
+	c := newClient(fake, gsprotocol.WithWriteMethods())
+
+	req, err := http.NewRequest(http.MethodPut, "gs://bucket/key", strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Goog-Encryption-Algorithm", "AES256")
+	req.Header.Set("X-Goog-Encryption-Key", base64.StdEncoding.EncodeToString(key))
+	req.Header.Set("X-Goog-Encryption-Key-Sha256", base64.StdEncoding.EncodeToString(sum[:]))
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if got, want := resp.Header.Get("X-Goog-Encryption-Key-Sha256"), base64.StdEncoding.EncodeToString(sum[:]); got != want {
+		t.Errorf("unexpected x-goog-encryption-key-sha256: want %q, got %q", want, got)
+	}
+
+	// the object can't be read without the key.
+	resp, body := do(t, c, http.MethodGet, "gs://bucket/key", nil)
+	if resp.StatusCode != http.StatusBadRequest && resp.StatusCode != http.StatusForbidden {
+		t.Errorf("unexpected status: want %d or %d, got %d", http.StatusBadRequest, http.StatusForbidden, resp.StatusCode)
+	}
+	if strings.Contains(body, content) {
+		t.Errorf("the content is leaked: %q", body)
+	}
+
+	c = newClient(fake, gsprotocol.WithEncryptionKeys(key))
+	resp, body = do(t, c, http.MethodGet, "gs://bucket/key", nil)
+	if resp.StatusCode != http.StatusOK || body != content {
+		t.Errorf("unexpected response: %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestServer_Conditions(t *testing.T) {
+	ctx := context.Background()
+	fake := gsprotocoltest.NewServer()
+	attrs := fake.PutObject("bucket", "key", []byte(content))
+	object := fake.Bucket("bucket").Object("key")
+
+	if _, err := object.If(storage.Conditions{GenerationMatch: attrs.Generation}).Attrs(ctx); err != nil {
+		t.Errorf("want no error, got %v", err)
+	}
+	if _, err := object.If(storage.Conditions{GenerationMatch: attrs.Generation + 1}).Attrs(ctx); err == nil {
+		t.Error("want a precondition error, got nil")
+	}
+
+	w := object.If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+	if _, err := io.WriteString(w, "overwrite"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err == nil {
+		t.Error("want a precondition error, got nil")
+	}
+}
+
+func TestServer_Objects(t *testing.T) {
+	ctx := context.Background()
+	fake := gsprotocoltest.NewServer()
+	for _, name := range []string{"a/1", "a/2", "b/1", "c"} {
+		fake.PutObject("bucket", name, []byte(name))
+	}
+	fake.PutObject("other", "a/3", []byte("other"))
+
+	it := fake.Bucket("bucket").Objects(ctx, &storage.Query{Delimiter: "/"})
+	var got []string
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, attrs.Name+attrs.Prefix)
+	}
+	if want := "a/,b/,c"; strings.Join(got, ",") != want {
+		t.Errorf("want %s, got %s", want, strings.Join(got, ","))
+	}
+}
+
+func TestServer_Calls(t *testing.T) {
+	fake := gsprotocoltest.NewServer()
+	attrs := fake.PutObject("bucket", "key", []byte(content))
+	fake.PutObject("bucket", "other", []byte(content))
+	c := newClient(fake, gsprotocol.WithAttrsCache(10, time.Minute), gsprotocol.WithWriteMethods())
+	fake.AssertCalls(t)
+
+	req, err := http.NewRequest(http.MethodGet, "gs://bucket/key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", "bytes=10-")
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	fake.AssertCalls(t,
+		gsprotocoltest.Call{Op: gsprotocoltest.OpAttrs, Bucket: "bucket", Object: "key"},
+		gsprotocoltest.Call{Op: gsprotocoltest.OpNewRangeReader, Bucket: "bucket", Object: "key", Generation: attrs.Generation, Offset: 10, Length: int64(len(content)) - 10},
+	)
+
+	// the attrs cache serves HEAD requests without calls.
+	fake.ResetCalls()
+	resp, _ = do(t, c, http.MethodHead, "gs://bucket/key", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	fake.AssertCalls(t)
+
+	do(t, c, http.MethodDelete, "gs://bucket/other", nil)
+	do(t, c, http.MethodGet, "gs://bucket/not-found", nil)
+	if got := fake.CallsFor("gs://bucket/key"); len(got) != 0 {
+		t.Errorf("want no calls on gs://bucket/key, got %v", got)
+	}
+	if got := fake.CallsFor("gs://bucket/other"); len(got) != 1 || got[0].Op != gsprotocoltest.OpDelete {
+		t.Errorf("want a Delete call on gs://bucket/other, got %v", got)
+	}
+	if got := fake.CallsFor("gs://bucket"); len(got) != 2 {
+		t.Errorf("want 2 calls on gs://bucket, got %v", got)
+	}
+	if got := fake.CallsFor("gs://other-bucket"); len(got) != 0 {
+		t.Errorf("want no calls on gs://other-bucket, got %v", got)
+	}
+
+	// AssertCalls reports the mismatches.
+	rec := &recordingT{TB: t}
+	if fake.AssertCalls(rec) || !rec.failed {
+		t.Error("want AssertCalls to fail with unexpected calls")
+	}
+}
+
+func TestServer_CallsConcurrent(t *testing.T) {
+	fake := gsprotocoltest.NewServer()
+	fake.PutObject("bucket", "key", []byte(content))
+	c := newClient(fake)
+
+	const n = 10
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			do(t, c, http.MethodHead, "gs://bucket/key", nil)
+		}()
+	}
+	wg.Wait()
+	if got := len(fake.CallsFor("gs://bucket/key")); got != n {
+		t.Errorf("want %d calls, got %d", n, got)
+	}
+	fake.ResetCalls()
+	fake.AssertCalls(t)
+}
+
+// recordingT records the failures instead of reporting them.
+type recordingT struct {
+	testing.TB
+	failed bool
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...any) {
+	t.failed = true
+}
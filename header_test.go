@@ -0,0 +1,157 @@
+package gsprotocol
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// headerTestAttrs is the attributes of a typical object, with all the headers that makeHeader sets.
+var headerTestAttrs = &storage.ObjectAttrs{
+	Bucket:             "bucket-name",
+	Name:               "object-key",
+	ContentType:        "text/plain",
+	ContentLanguage:    "en",
+	CacheControl:       "public, max-age=3600",
+	ContentEncoding:    "identity",
+	ContentDisposition: "inline",
+	Size:               1234567,
+	Updated:            time.Date(2020, time.April, 18, 12, 34, 56, 0, time.UTC),
+	MD5:                []byte{0x0b, 0x46, 0xf3, 0x06, 0xe9, 0x2d, 0x88, 0x51, 0x5e, 0x06, 0xd4, 0x8a, 0x62, 0xdc, 0xc3, 0x19},
+	CRC32C:             0x7f762fe2,
+	Generation:         1587160158394554,
+	Metageneration:     3,
+	Metadata:           map[string]string{"foo": "bar"},
+	StorageClass:       "STANDARD",
+}
+
+func TestMakeHeader(t *testing.T) {
+	want := http.Header{
+		"Content-Type":                   {"text/plain"},
+		"Content-Language":               {"en"},
+		"Cache-Control":                  {"public, max-age=3600"},
+		"Content-Length":                 {"1234567"},
+		"Content-Encoding":               {"identity"},
+		"Content-Disposition":            {"inline"},
+		"Last-Modified":                  {"Sat, 18 Apr 2020 12:34:56 GMT"},
+		"X-Goog-Hash":                    {"crc32c=f3Yv4g==", "md5=C0bzBuktiFFeBtSKYtzDGQ=="},
+		"Etag":                           {`"0b46f306e92d88515e06d48a62dcc319"`},
+		"X-Goog-Generation":              {"1587160158394554"},
+		"X-Goog-Metageneration":          {"3"},
+		"X-Goog-Meta-Foo":                {"bar"},
+		"X-Goog-Stored-Content-Length":   {"1234567"},
+		"X-Goog-Stored-Content-Encoding": {"identity"},
+		"X-Goog-Storage-Class":           {"STANDARD"},
+	}
+	got := makeHeader(headerTestAttrs)
+	if len(got) != len(want) {
+		t.Errorf("want %d headers, got %d: %v", len(want), len(got), got)
+	}
+	for key, values := range want {
+		if len(got[key]) != len(values) {
+			t.Errorf("%s: want %q, got %q", key, values, got[key])
+			continue
+		}
+		for i := range values {
+			if got[key][i] != values[i] {
+				t.Errorf("%s: want %q, got %q", key, values, got[key])
+			}
+		}
+	}
+
+	// the values must not share the backing arrays.
+	got.Add("Content-Type", "text/html")
+	if v := got.Values("Content-Language"); len(v) != 1 || v[0] != "en" {
+		t.Errorf("Add overwrites the other header: %q", v)
+	}
+}
+
+// the upper bounds of the allocations, to catch regressions.
+// makeHeader allocates the map, its buckets, the backing array of the values, and the formatted values.
+const (
+	maxMakeHeaderAllocs = 16
+	maxHEADAllocs       = 32
+)
+
+func TestMakeHeader_Allocs(t *testing.T) {
+	allocs := testing.AllocsPerRun(100, func() {
+		makeHeader(headerTestAttrs)
+	})
+	if allocs > maxMakeHeaderAllocs {
+		t.Errorf("makeHeader allocates %v times, want at most %d", allocs, maxMakeHeaderAllocs)
+	}
+}
+
+func TestRoundTrip_HEADAllocs(t *testing.T) {
+	tr := newHeaderBenchmarkClient().Transport
+	req, err := http.NewRequest(http.MethodHead, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	})
+	if allocs > maxHEADAllocs {
+		t.Errorf("HEAD allocates %v times, want at most %d", allocs, maxHEADAllocs)
+	}
+}
+
+func newHeaderBenchmarkClient() *http.Client {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs:   headerTestAttrs,
+			content: "Hello Google Cloud Storage!",
+		},
+	})
+	return &http.Client{Transport: newTestTransport(mock)}
+}
+
+func BenchmarkMakeHeader(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		makeHeader(headerTestAttrs)
+	}
+}
+
+// BenchmarkRoundTripHeadersOnly measures the GET requests answered without the body.
+func BenchmarkRoundTripHeadersOnly(b *testing.B) {
+	c := newHeaderBenchmarkClient()
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	req.Header.Set("If-None-Match", "*")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, err := c.Do(req)
+		if err != nil {
+			b.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+}
+
+func BenchmarkHEAD(b *testing.B) {
+	c := newHeaderBenchmarkClient()
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodHead, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, err := c.Do(req)
+		if err != nil {
+			b.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+}
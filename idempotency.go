@@ -0,0 +1,125 @@
+package gsprotocol
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// idempotencyKeyHeader is the header of PUT requests that identifies the upload for the retries of it.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// WithIdempotencyKeys makes the Transport remember the Idempotency-Key headers of the successful PUT requests for ttl,
+// for the callers that retry the uploads without the preconditions.
+// A PUT request with a remembered key of the same object is not uploaded again,
+// and the Transport responds with the attributes of the generation written by the first request,
+// with the x-gsprotocol-idempotent-replay header.
+// The keys are scoped by the bucket and the object, and at most maxEntries keys are kept in the memory of the Transport.
+// The least recently used keys are evicted first, and the expired keys are uploaded as usual.
+//
+// The key only identifies the request, and the body of the retry is not compared with the first one.
+// The concurrent requests with the same key are uploaded both.
+// It is disabled by default.
+func WithIdempotencyKeys(maxEntries int, ttl time.Duration) Option {
+	return func(c *config) {
+		c.idempotencyMaxEntries = maxEntries
+		c.idempotencyTTL = ttl
+	}
+}
+
+// idempotencyCache is the cache of WithIdempotencyKeys.
+// The zero value is an empty cache, and it is safe for concurrent use.
+type idempotencyCache struct {
+	mu      sync.Mutex
+	entries map[idempotencyCacheKey]*list.Element
+	lru     list.List
+}
+
+type idempotencyCacheKey struct {
+	bucket string
+	object string
+	key    string
+}
+
+type idempotencyCacheEntry struct {
+	key     idempotencyCacheKey
+	attrs   *storage.ObjectAttrs
+	expires time.Time
+}
+
+// idempotencyEnabled reports whether the configuration enables the idempotency keys.
+func (c *config) idempotencyEnabled() bool {
+	return c.idempotencyTTL > 0 && c.idempotencyMaxEntries > 0
+}
+
+// idempotencyCacheKeyOf returns the key of req in idempotencyCache.
+// ok is false if req has no Idempotency-Key header.
+func idempotencyCacheKeyOf(req *http.Request) (key idempotencyCacheKey, ok bool) {
+	v := req.Header.Get(idempotencyKeyHeader)
+	if v == "" {
+		return idempotencyCacheKey{}, false
+	}
+	return idempotencyCacheKey{bucket: bucketName(req), object: objectName(req.URL), key: v}, true
+}
+
+// get returns a copy of the attributes written by the request with the key.
+func (c *idempotencyCache) get(cfg *config, key idempotencyCacheKey) (*storage.ObjectAttrs, bool) {
+	if !cfg.idempotencyEnabled() {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*idempotencyCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return copyObjectAttrs(entry.attrs), true
+}
+
+// add remembers a copy of attrs written by the request with the key,
+// and evicts the least recently used keys over the limit.
+func (c *idempotencyCache) add(cfg *config, key idempotencyCacheKey, attrs *storage.ObjectAttrs) {
+	if !cfg.idempotencyEnabled() {
+		return
+	}
+	entry := &idempotencyCacheEntry{
+		key:     key,
+		attrs:   copyObjectAttrs(attrs),
+		expires: time.Now().Add(cfg.idempotencyTTL),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[idempotencyCacheKey]*list.Element)
+	}
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > cfg.idempotencyMaxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *idempotencyCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*idempotencyCacheEntry)
+	delete(c.entries, entry.key)
+}
+
+// newReplayedResponse returns the response to a PUT request whose Idempotency-Key is remembered.
+func newReplayedResponse(attrs *storage.ObjectAttrs) *http.Response {
+	resp := newWrittenResponse(attrs)
+	resp.Header.Set("X-Gsprotocol-Idempotent-Replay", "true")
+	return resp
+}
@@ -0,0 +1,105 @@
+package gsprotocol
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestRoundTrip_PutIdempotencyKey(t *testing.T) {
+	var uploads int64
+	newTransport := func(opts ...Option) *Transport {
+		object := &objectHandleMock{
+			newWriterFunc: func(ctx context.Context, mock *objectHandleMock) *storageWriterMock {
+				return &storageWriterMock{
+					ctx: ctx,
+					closeFunc: func(w *storageWriterMock) (*storage.ObjectAttrs, error) {
+						uploads++
+						attrs := w.attrs
+						attrs.Generation = uploads
+						return &attrs, nil
+					},
+				}
+			},
+		}
+		return newTestTransport(newSingleObjectMock(object), append([]Option{WithWriteMethods()}, opts...)...)
+	}
+	put := func(t *testing.T, tr *Transport, url, key string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPut, url, strings.NewReader("Hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		return resp
+	}
+	assertGeneration := func(t *testing.T, resp *http.Response, want int64, replayed bool) {
+		t.Helper()
+		if got := resp.Header.Get("x-goog-generation"); got != strconv.FormatInt(want, 10) {
+			t.Errorf("unexpected generation: want %d, got %s", want, got)
+		}
+		if got := resp.Header.Get("X-Gsprotocol-Idempotent-Replay") == "true"; got != replayed {
+			t.Errorf("unexpected replay: want %t, got %t", replayed, got)
+		}
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		uploads = 0
+		tr := newTransport(WithIdempotencyKeys(10, time.Hour))
+		assertGeneration(t, put(t, tr, "gs://bucket-name/object-key", "key-1"), 1, false)
+		assertGeneration(t, put(t, tr, "gs://bucket-name/object-key", "key-1"), 1, true)
+		if uploads != 1 {
+			t.Errorf("want 1 upload, got %d", uploads)
+		}
+
+		// the other keys and the requests without keys are uploaded.
+		assertGeneration(t, put(t, tr, "gs://bucket-name/object-key", "key-2"), 2, false)
+		assertGeneration(t, put(t, tr, "gs://bucket-name/object-key", ""), 3, false)
+	})
+
+	t.Run("scoped by object", func(t *testing.T) {
+		uploads = 0
+		tr := newTransport(WithIdempotencyKeys(10, time.Hour))
+		assertGeneration(t, put(t, tr, "gs://bucket-name/object-key", "key-1"), 1, false)
+		assertGeneration(t, put(t, tr, "gs://bucket-name/other-key", "key-1"), 2, false)
+		assertGeneration(t, put(t, tr, "gs://other-bucket/object-key", "key-1"), 3, false)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		uploads = 0
+		tr := newTransport(WithIdempotencyKeys(10, time.Millisecond))
+		assertGeneration(t, put(t, tr, "gs://bucket-name/object-key", "key-1"), 1, false)
+		time.Sleep(10 * time.Millisecond)
+		assertGeneration(t, put(t, tr, "gs://bucket-name/object-key", "key-1"), 2, false)
+	})
+
+	t.Run("evicted", func(t *testing.T) {
+		uploads = 0
+		tr := newTransport(WithIdempotencyKeys(1, time.Hour))
+		assertGeneration(t, put(t, tr, "gs://bucket-name/object-key", "key-1"), 1, false)
+		assertGeneration(t, put(t, tr, "gs://bucket-name/object-key", "key-2"), 2, false)
+		assertGeneration(t, put(t, tr, "gs://bucket-name/object-key", "key-1"), 3, false)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		uploads = 0
+		tr := newTransport()
+		assertGeneration(t, put(t, tr, "gs://bucket-name/object-key", "key-1"), 1, false)
+		assertGeneration(t, put(t, tr, "gs://bucket-name/object-key", "key-1"), 2, false)
+	})
+}
@@ -2,6 +2,7 @@ package gsprotocol
 
 import (
 	"context"
+	"io"
 
 	"cloud.google.com/go/storage"
 )
@@ -12,6 +13,10 @@ func newStorageClientImpl(client *storage.Client) storageClient {
 
 type storageClientImpl struct {
 	client *storage.Client
+
+	// idle closes the idle connections of client, if the Transport can reach them.
+	// It is the base transport of WithOwnHTTPClient, or the HTTP client of WithStorageHTTPClient.
+	idle interface{ CloseIdleConnections() }
 }
 
 func (c storageClientImpl) Bucket(name string) bucketHandle {
@@ -20,6 +25,21 @@ func (c storageClientImpl) Bucket(name string) bucketHandle {
 	}
 }
 
+func (c storageClientImpl) Close() error {
+	return c.client.Close()
+}
+
+// CloseIdleConnections closes the idle connections of the storage client, if the Transport can reach them.
+func (c storageClientImpl) CloseIdleConnections() {
+	if c.idle != nil {
+		c.idle.CloseIdleConnections()
+	}
+}
+
+func (c storageClientImpl) Underlying() *storage.Client {
+	return c.client
+}
+
 type bucketHandleImpl struct {
 	bucket *storage.BucketHandle
 }
@@ -30,6 +50,16 @@ func (h bucketHandleImpl) Object(name string) objectHandle {
 	}
 }
 
+func (h bucketHandleImpl) Objects(ctx context.Context, q *storage.Query) objectIterator {
+	return h.bucket.Objects(ctx, q)
+}
+
+func (h bucketHandleImpl) UserProject(project string) bucketHandle {
+	return bucketHandleImpl{
+		bucket: h.bucket.UserProject(project),
+	}
+}
+
 type objectHandleImpl struct {
 	object *storage.ObjectHandle
 }
@@ -48,12 +78,74 @@ func (h objectHandleImpl) NewReader(ctx context.Context) (storageReader, error)
 	}, nil
 }
 
+func (h objectHandleImpl) NewRangeReader(ctx context.Context, offset, length int64) (storageReader, error) {
+	reader, err := h.object.NewRangeReader(ctx, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return storageReaderImpl{
+		reader: reader,
+	}, nil
+}
+
+func (h objectHandleImpl) NewWriter(ctx context.Context) storageWriter {
+	return storageWriterImpl{
+		writer: h.object.NewWriter(ctx),
+	}
+}
+
+func (h objectHandleImpl) Delete(ctx context.Context) error {
+	return h.object.Delete(ctx)
+}
+
+func (h objectHandleImpl) Update(ctx context.Context, attrs storage.ObjectAttrsToUpdate) (*storage.ObjectAttrs, error) {
+	return h.object.Update(ctx, attrs)
+}
+
+// CopierFrom copies src to h.
+// src must be an objectHandleImpl from the same client.
+func (h objectHandleImpl) CopierFrom(src objectHandle) storageCopier {
+	return storageCopierImpl{
+		copier: h.object.CopierFrom(src.(objectHandleImpl).object),
+	}
+}
+
+// ComposerFrom composes srcs into h.
+// srcs must be objectHandleImpls from the same client.
+func (h objectHandleImpl) ComposerFrom(srcs ...objectHandle) storageComposer {
+	objects := make([]*storage.ObjectHandle, 0, len(srcs))
+	for _, src := range srcs {
+		objects = append(objects, src.(objectHandleImpl).object)
+	}
+	return storageComposerImpl{
+		composer: h.object.ComposerFrom(objects...),
+	}
+}
+
+func (h objectHandleImpl) If(conds storage.Conditions) objectHandle {
+	return objectHandleImpl{
+		object: h.object.If(conds),
+	}
+}
+
 func (h objectHandleImpl) Generation(gen int64) objectHandle {
 	return objectHandleImpl{
 		object: h.object.Generation(gen),
 	}
 }
 
+func (h objectHandleImpl) Key(encryptionKey []byte) objectHandle {
+	return objectHandleImpl{
+		object: h.object.Key(encryptionKey),
+	}
+}
+
+func (h objectHandleImpl) ReadCompressed(compressed bool) objectHandle {
+	return objectHandleImpl{
+		object: h.object.ReadCompressed(compressed),
+	}
+}
+
 type storageReaderImpl struct {
 	reader *storage.Reader
 }
@@ -66,6 +158,58 @@ func (r storageReaderImpl) Read(b []byte) (int, error) {
 	return r.reader.Read(b)
 }
 
+func (r storageReaderImpl) WriteTo(w io.Writer) (int64, error) {
+	return r.reader.WriteTo(w)
+}
+
 func (r storageReaderImpl) Close() error {
 	return r.reader.Close()
 }
+
+type storageWriterImpl struct {
+	writer *storage.Writer
+}
+
+func (w storageWriterImpl) ObjectAttrs() *storage.ObjectAttrs {
+	return &w.writer.ObjectAttrs
+}
+
+func (w storageWriterImpl) Attrs() *storage.ObjectAttrs {
+	return w.writer.Attrs()
+}
+
+func (w storageWriterImpl) Write(b []byte) (int, error) {
+	return w.writer.Write(b)
+}
+
+func (w storageWriterImpl) Close() error {
+	return w.writer.Close()
+}
+
+type storageCopierImpl struct {
+	copier *storage.Copier
+}
+
+func (c storageCopierImpl) ObjectAttrs() *storage.ObjectAttrs {
+	return &c.copier.ObjectAttrs
+}
+
+func (c storageCopierImpl) setProgressFunc(progress func(copiedBytes, totalBytes uint64)) {
+	c.copier.ProgressFunc = progress
+}
+
+func (c storageCopierImpl) Run(ctx context.Context) (*storage.ObjectAttrs, error) {
+	return c.copier.Run(ctx)
+}
+
+type storageComposerImpl struct {
+	composer *storage.Composer
+}
+
+func (c storageComposerImpl) ObjectAttrs() *storage.ObjectAttrs {
+	return &c.composer.ObjectAttrs
+}
+
+func (c storageComposerImpl) Run(ctx context.Context) (*storage.ObjectAttrs, error) {
+	return c.composer.Run(ctx)
+}
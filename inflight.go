@@ -0,0 +1,185 @@
+package gsprotocol
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+// trackRequest records the in-flight request and returns the context and the storage client for it.
+// The returned function must be called when the request is done.
+func (t *Transport) trackRequest(req *http.Request) (context.Context, storageClient, func()) {
+	ctx, cancel := context.WithCancel(req.Context())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.inflight == nil {
+		t.inflight = make(map[*http.Request]context.CancelFunc)
+	}
+	t.inflight[req] = cancel
+
+	client := t.client
+	if t.clientRefs == nil {
+		t.clientRefs = make(map[storageClient]int)
+		t.clientIDs = make(map[storageClient]uint64)
+	}
+	if t.clientRefs[client] == 0 {
+		t.lastID++
+		t.clientIDs[client] = t.lastID
+	}
+	t.clientRefs[client]++
+
+	return ctx, client, func() {
+		t.mu.Lock()
+		delete(t.inflight, req)
+		t.mu.Unlock()
+
+		cancel()
+		t.releaseClient(client)
+	}
+}
+
+// Client returns the storage client of the Transport, so that the application can share it,
+// e.g. to write objects or to list buckets.
+// It returns nil if the Transport is not backed by a real *storage.Client, e.g. in tests.
+// The client is closed when it is replaced by SetClient, so don't use it after that.
+func (t *Transport) Client() *storage.Client {
+	t.mu.Lock()
+	client := t.client
+	t.mu.Unlock()
+	if client == nil {
+		return nil
+	}
+	return client.Underlying()
+}
+
+// retainClient counts client as in use, so that SetClient doesn't close it.
+// client must be in use already, e.g. by an in-flight request.
+func (t *Transport) retainClient(client storageClient) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clientRefs[client]++
+}
+
+// releaseClient releases client retained by trackRequest or retainClient,
+// and closes it if it is replaced by SetClient and no longer in use.
+func (t *Transport) releaseClient(client storageClient) {
+	t.mu.Lock()
+	t.clientRefs[client]--
+	retired := t.clientRefs[client] == 0 && client != t.client
+	if t.clientRefs[client] == 0 {
+		delete(t.clientRefs, client)
+		delete(t.clientIDs, client)
+	}
+	t.mu.Unlock()
+
+	if retired {
+		client.Close()
+	}
+}
+
+// wrappingClient is the storage client that wraps another one, e.g. to count the operations.
+type wrappingClient interface {
+	unwrap() storageClient
+}
+
+// clientIdentity returns the ID of the storage client that client wraps and the user project that client bills,
+// for the keys of sharing the calls among the requests,
+// so that the requests through different clients don't share the results, the errors and the billing.
+func (t *Transport) clientIdentity(client storageClient) (id uint64, project string) {
+	for {
+		if c, ok := client.(*userProjectClient); ok {
+			project = c.project
+		}
+		w, ok := client.(wrappingClient)
+		if !ok {
+			break
+		}
+		client = w.unwrap()
+	}
+	t.mu.Lock()
+	id = t.clientIDs[client]
+	t.mu.Unlock()
+	return id, project
+}
+
+// SetClient replaces the storage client of the Transport, e.g. to rotate credentials.
+// New requests use client, while the in-flight requests keep using the previous client.
+// The previous client is closed after all of the requests using it are done,
+// including the transfer of their response bodies.
+func (t *Transport) SetClient(client *storage.Client) {
+	t.setClient(newStorageClientImpl(client))
+}
+
+func (t *Transport) setClient(client storageClient) {
+	t.mu.Lock()
+	old := t.client
+	t.client = client
+	idle := t.clientRefs[old] == 0
+	t.mu.Unlock()
+
+	if idle && old != nil {
+		old.Close()
+	}
+}
+
+// CancelRequest cancels an in-flight request, including the transfer of its response body.
+//
+// Deprecated: Use Request.WithContext to create a request with a cancelable context instead.
+// CancelRequest is provided for the compatibility with the libraries that probe for it.
+func (t *Transport) CancelRequest(req *http.Request) {
+	t.mu.Lock()
+	cancel, ok := t.inflight[req]
+	t.mu.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+// CloseIdleConnections implements the optional interface of http.Client.
+//
+// It closes the idle connections of the storage clients created by NewTransportWithOptions
+// with WithOwnHTTPClient or WithStorageHTTPClient, including the previous clients that the in-flight requests still use.
+// The Transport can't reach the connections of the other clients, e.g. the ones given by NewTransportWithClient, SetClient
+// or option.WithHTTPClient, so close idle connections of them by yourself.
+func (t *Transport) CloseIdleConnections() {
+	t.mu.Lock()
+	clients := make([]storageClient, 0, len(t.clientRefs)+1)
+	if t.client != nil {
+		clients = append(clients, t.client)
+	}
+	for client := range t.clientRefs {
+		if client != t.client {
+			clients = append(clients, client)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, client := range clients {
+		if c, ok := client.(interface{ CloseIdleConnections() }); ok {
+			c.CloseIdleConnections()
+		}
+	}
+}
+
+// trackedBody calls done when it is closed.
+type trackedBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+// WriteTo implements io.WriterTo, so that io.Copy(dst, resp.Body) streams the body with a pooled buffer
+// instead of allocating a buffer for each copy.
+func (b *trackedBody) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, b.ReadCloser)
+}
+
+func (b *trackedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
@@ -0,0 +1,261 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+func TestCancelRequest(t *testing.T) {
+	started := make(chan struct{})
+	object := &objectHandleMock{
+		attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	mock := newSingleObjectMock(object)
+	tr := newTestTransport(mock)
+
+	req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		<-started
+		tr.CancelRequest(req)
+	}()
+	_, err = tr.RoundTrip(req)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+	if len(tr.inflight) != 0 {
+		t.Errorf("want no in-flight requests, got %d", len(tr.inflight))
+	}
+}
+
+func TestCancelRequest_Body(t *testing.T) {
+	var readerCtx context.Context
+	object := newObjectHandleMock("bucket-name", "object-key", mockObject{
+		attrs:   &storage.ObjectAttrs{Generation: 1234567890},
+		content: "Hello Google Cloud Storage!",
+	})
+	newReader := object.newReaderFunc
+	object.newReaderFunc = func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+		readerCtx = ctx
+		return newReader(ctx, mock)
+	}
+	mock := newSingleObjectMock(object)
+	tr := newTestTransport(mock)
+
+	req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tr.inflight) != 1 {
+		t.Errorf("want 1 in-flight request, got %d", len(tr.inflight))
+	}
+
+	tr.CancelRequest(req)
+	if readerCtx.Err() != context.Canceled {
+		t.Errorf("want the reader canceled, got %v", readerCtx.Err())
+	}
+
+	resp.Body.Close()
+	if len(tr.inflight) != 0 {
+		t.Errorf("want no in-flight requests, got %d", len(tr.inflight))
+	}
+}
+
+func TestCloseIdleConnections(t *testing.T) {
+	var tr interface{} = &Transport{}
+	if _, ok := tr.(interface{ CloseIdleConnections() }); !ok {
+		t.Error("Transport doesn't implement CloseIdleConnections")
+	}
+	if _, ok := tr.(interface{ CancelRequest(*http.Request) }); !ok {
+		t.Error("Transport doesn't implement CancelRequest")
+	}
+}
+
+func TestCloseIdleConnections_Clients(t *testing.T) {
+	newClient := func(idle *int32) *storageClientMock {
+		mock := newStorageClientMockWithObjects(map[string]mockObject{
+			"bucket-name/object-key": {
+				attrs:   &storage.ObjectAttrs{Generation: 1234567890},
+				content: "Hello Google Cloud Storage!",
+			},
+		})
+		mock.closeIdleFunc = func(mock *storageClientMock) {
+			atomic.AddInt32(idle, 1)
+		}
+		return mock
+	}
+	var oldIdle, newIdle int32
+	tr := newTestTransport(newClient(&oldIdle))
+	c := &http.Client{Transport: tr}
+
+	// keep a response body of the old client open, so that the old client is retained.
+	resp, err := c.Get("gs://bucket-name/object-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	tr.setClient(newClient(&newIdle))
+
+	c.CloseIdleConnections()
+	if n := atomic.LoadInt32(&oldIdle); n != 1 {
+		t.Errorf("want the retained client to close idle connections once, got %d", n)
+	}
+	if n := atomic.LoadInt32(&newIdle); n != 1 {
+		t.Errorf("want the current client to close idle connections once, got %d", n)
+	}
+}
+
+func TestNewTransport_OwnsHTTPTransport(t *testing.T) {
+	t.Setenv("STORAGE_EMULATOR_HOST", "")
+	newImpl := func(t *testing.T, gcsOpts []option.ClientOption, opts ...Option) storageClientImpl {
+		t.Helper()
+		tr, err := NewTransportWithOptions(context.Background(), gcsOpts, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		impl, ok := tr.client.(storageClientImpl)
+		if !ok {
+			t.Fatalf("unexpected client: %T", tr.client)
+		}
+		tr.CloseIdleConnections()
+		return impl
+	}
+
+	// storage.NewClient creates the HTTP client by default.
+	if impl := newImpl(t, []option.ClientOption{option.WithoutAuthentication()}); impl.idle != nil {
+		t.Errorf("want no idle connections to close by default, got %T", impl.idle)
+	}
+
+	if impl := newImpl(t, []option.ClientOption{option.WithoutAuthentication()}, WithOwnHTTPClient()); impl.idle == nil {
+		t.Error("want the transport of the storage client with WithOwnHTTPClient, got nil")
+	}
+
+	// the HTTP client given by option.WithHTTPClient is used as is.
+	if impl := newImpl(t, []option.ClientOption{option.WithHTTPClient(http.DefaultClient)}, WithOwnHTTPClient()); impl.idle != nil {
+		t.Errorf("want no idle connections to close with option.WithHTTPClient, got %T", impl.idle)
+	}
+
+	hc := &http.Client{Transport: &http.Transport{}}
+	if impl := newImpl(t, nil, WithStorageHTTPClient(hc)); impl.idle != hc {
+		t.Errorf("want the HTTP client of WithStorageHTTPClient, got %T", impl.idle)
+	}
+}
+
+func TestSetClient(t *testing.T) {
+	newClient := func(content string, closed *int32) *storageClientMock {
+		mock := newStorageClientMockWithObjects(map[string]mockObject{
+			"bucket-name/object-key": {
+				attrs:   &storage.ObjectAttrs{Generation: 1234567890},
+				content: content,
+			},
+		})
+		mock.closeFunc = func(mock *storageClientMock) error {
+			if atomic.AddInt32(closed, 1) != 1 {
+				t.Error("the client is closed twice")
+			}
+			return nil
+		}
+		return mock
+	}
+	var oldClosed, newClosed int32
+	tr := newTestTransport(newClient("old", &oldClosed))
+	c := &http.Client{Transport: tr}
+
+	// keep a response body of the old client open.
+	resp, err := c.Get("gs://bucket-name/object-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var failures int32
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i == 50 {
+				tr.setClient(newClient("new", &newClosed))
+			}
+			resp, err := c.Get("gs://bucket-name/object-key")
+			if err != nil {
+				atomic.AddInt32(&failures, 1)
+				return
+			}
+			defer resp.Body.Close()
+			got, err := io.ReadAll(resp.Body)
+			if err != nil || (string(got) != "old" && string(got) != "new") {
+				atomic.AddInt32(&failures, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if failures != 0 {
+		t.Errorf("want no failures, got %d", failures)
+	}
+
+	if atomic.LoadInt32(&oldClosed) != 0 {
+		t.Error("the old client is closed while its response body is open")
+	}
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "old" {
+		t.Errorf("want %q, got %q", "old", string(got))
+	}
+	resp.Body.Close()
+	if atomic.LoadInt32(&oldClosed) != 1 {
+		t.Error("the old client is not closed")
+	}
+	if atomic.LoadInt32(&newClosed) != 0 {
+		t.Error("the new client is closed")
+	}
+
+	resp, err = c.Get("gs://bucket-name/object-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	got, err = io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "new" {
+		t.Errorf("want %q, got %q", "new", string(got))
+	}
+}
+
+func TestClient(t *testing.T) {
+	tr := &Transport{client: &storageClientMock{}}
+	if c := tr.Client(); c != nil {
+		t.Errorf("want nil for the mock, got %v", c)
+	}
+
+	client, err := storage.NewClient(context.Background(), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	tr = NewTransportWithClient(client)
+	if c := tr.Client(); c != client {
+		t.Errorf("want %p, got %p", client, c)
+	}
+}
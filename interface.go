@@ -7,26 +7,107 @@ import (
 	"cloud.google.com/go/storage"
 )
 
-// the interface for Dependency injection
+// The interfaces for dependency injection.
+// They are the subset of cloud.google.com/go/storage that the Transport uses,
+// and make it possible to serve the Transport from fakes in tests.
+// See NewTransportWithStorage, and the gsprotocoltest package for an in-memory fake.
+//
+// The interfaces may grow new methods in the minor versions, when the Transport needs more of the storage client,
+// so the implementations outside of this package should be updated along with the Transport.
 
-// the interface for storage.Client
-type storageClient interface {
-	Bucket(name string) bucketHandle
+// StorageClient is the interface for storage.Client.
+// It is used as a map key, so it must be comparable, e.g. a pointer.
+type StorageClient interface {
+	Bucket(name string) BucketHandle
+	Close() error
+
+	// Underlying returns the *storage.Client, or nil if it is not backed by a real client, e.g. a fake.
+	Underlying() *storage.Client
 }
 
-// the interface for storage.BucketHandle
-type bucketHandle interface {
-	Object(name string) objectHandle
+// BucketHandle is the interface for storage.BucketHandle.
+type BucketHandle interface {
+	Object(name string) ObjectHandle
+	Objects(ctx context.Context, q *storage.Query) ObjectIterator
+
+	// UserProject returns the copy of the handle that bills the project for the requests, for requester pays buckets.
+	UserProject(project string) BucketHandle
 }
 
-// the interface for storage.ObjectHandle
-type objectHandle interface {
+// ObjectIterator is the interface for storage.ObjectIterator.
+// Next returns iterator.Done when the iteration is complete.
+type ObjectIterator interface {
+	Next() (*storage.ObjectAttrs, error)
+}
+
+// ObjectHandle is the interface for storage.ObjectHandle.
+// The errors must be the ones of cloud.google.com/go/storage,
+// e.g. storage.ErrObjectNotExist and *googleapi.Error, for the Transport to respond with the right status.
+type ObjectHandle interface {
 	Attrs(ctx context.Context) (attrs *storage.ObjectAttrs, err error)
-	NewReader(ctx context.Context) (storageReader, error)
-	Generation(gen int64) objectHandle
+	NewReader(ctx context.Context) (StorageReader, error)
+	NewRangeReader(ctx context.Context, offset, length int64) (StorageReader, error)
+	NewWriter(ctx context.Context) StorageWriter
+	Delete(ctx context.Context) error
+	Update(ctx context.Context, attrs storage.ObjectAttrsToUpdate) (*storage.ObjectAttrs, error)
+	CopierFrom(src ObjectHandle) StorageCopier
+	ComposerFrom(srcs ...ObjectHandle) StorageComposer
+	If(conds storage.Conditions) ObjectHandle
+	Generation(gen int64) ObjectHandle
+	Key(encryptionKey []byte) ObjectHandle
+
+	// ReadCompressed returns the copy of the handle whose readers read the stored bytes of the objects
+	// with the gzip Content-Encoding, instead of decompressing them.
+	ReadCompressed(compressed bool) ObjectHandle
 }
 
-type storageReader interface {
+// StorageReader is the interface for storage.Reader.
+type StorageReader interface {
 	io.ReadCloser
+	io.WriterTo
 	Attrs() storage.ReaderObjectAttrs
 }
+
+// StorageWriter is the interface for storage.Writer.
+type StorageWriter interface {
+	io.WriteCloser
+
+	// ObjectAttrs returns the attributes of the object to write.
+	// They must be set before the first Write.
+	ObjectAttrs() *storage.ObjectAttrs
+
+	// Attrs returns the attributes of the object written, after Close succeeds.
+	Attrs() *storage.ObjectAttrs
+}
+
+// StorageCopier is the interface for storage.Copier.
+type StorageCopier interface {
+	// ObjectAttrs returns the attributes to set on the destination object.
+	// They must be set before Run, and the zero values are ignored.
+	ObjectAttrs() *storage.ObjectAttrs
+
+	// Run copies the source object to the destination, and returns the attributes of the destination.
+	Run(ctx context.Context) (*storage.ObjectAttrs, error)
+}
+
+// StorageComposer is the interface for storage.Composer.
+type StorageComposer interface {
+	// ObjectAttrs returns the attributes to set on the destination object.
+	// They must be set before Run, and the zero values are ignored.
+	ObjectAttrs() *storage.ObjectAttrs
+
+	// Run composes the source objects into the destination, and returns the attributes of the destination.
+	Run(ctx context.Context) (*storage.ObjectAttrs, error)
+}
+
+// the internal names of the interfaces.
+type (
+	storageClient   = StorageClient
+	bucketHandle    = BucketHandle
+	objectIterator  = ObjectIterator
+	objectHandle    = ObjectHandle
+	storageReader   = StorageReader
+	storageWriter   = StorageWriter
+	storageCopier   = StorageCopier
+	storageComposer = StorageComposer
+)
@@ -0,0 +1,48 @@
+package gsprotocol
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+// kmsKeyNameHeader is the header of PUT requests that encrypts the object with the Cloud KMS key in it,
+// e.g. projects/[PROJECT]/locations/[LOCATION]/keyRings/[KEY_RING]/cryptoKeys/[KEY].
+const kmsKeyNameHeader = "X-Goog-Encryption-Kms-Key-Name"
+
+// parseKMSKeyName returns an error if name is not the resource name of a Cloud KMS key.
+func parseKMSKeyName(name string) error {
+	parts := strings.Split(name, "/")
+	if len(parts) == 8 && parts[0] == "projects" && parts[2] == "locations" && parts[4] == "keyRings" && parts[6] == "cryptoKeys" {
+		ok := true
+		for i := 1; i < len(parts); i += 2 {
+			ok = ok && parts[i] != ""
+		}
+		if ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("gsprotocol: invalid x-goog-encryption-kms-key-name %q: want projects/*/locations/*/keyRings/*/cryptoKeys/*", name)
+}
+
+// kmsKeyError is returned if Google Cloud Storage can't use the Cloud KMS key to encrypt the object.
+type kmsKeyError struct {
+	keyName string
+}
+
+func (err *kmsKeyError) Error() string {
+	return fmt.Sprintf("gsprotocol: permission denied to encrypt with the Cloud KMS key %q", err.keyName)
+}
+
+// wrapKMSKeyError returns *kmsKeyError if err is the permission error of writing with the Cloud KMS key keyName.
+// The other errors are returned as they are.
+func wrapKMSKeyError(err error, keyName string) error {
+	var apiErr *googleapi.Error
+	if keyName != "" && errors.As(err, &apiErr) && apiErr.Code == http.StatusForbidden {
+		return &kmsKeyError{keyName: keyName}
+	}
+	return err
+}
@@ -0,0 +1,135 @@
+package gsprotocol
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+func TestParseKMSKeyName(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"projects/my-project/locations/us/keyRings/my-ring/cryptoKeys/my-key", true},
+		{"projects/my-project/locations/us/keyRings/my-ring/cryptoKeys/", false},
+		{"projects/my-project/locations/us/keyRings/my-ring", false},
+		{"projects/my-project/locations/us/keyRings/my-ring/cryptoKeys/my-key/cryptoKeyVersions/1", false},
+		{"project/my-project/locations/us/keyRings/my-ring/cryptoKeys/my-key", false},
+	}
+	for _, tt := range tests {
+		err := parseKMSKeyName(tt.name)
+		if tt.ok && err != nil {
+			t.Errorf("%s: want ok, got %v", tt.name, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: want an error, got nil", tt.name)
+		}
+	}
+}
+
+func TestRoundTrip_PutKMSKey(t *testing.T) {
+	const keyName = "projects/my-project/locations/us/keyRings/my-ring/cryptoKeys/my-key"
+
+	t.Run("ok", func(t *testing.T) {
+		var w *storageWriterMock
+		object := &objectHandleMock{
+			newWriterFunc: func(ctx context.Context, mock *objectHandleMock) *storageWriterMock {
+				w = &storageWriterMock{
+					ctx: ctx,
+					closeFunc: func(w *storageWriterMock) (*storage.ObjectAttrs, error) {
+						attrs := w.attrs
+						attrs.Generation = 1587160158394554
+						attrs.KMSKeyName = w.attrs.KMSKeyName + "/cryptoKeyVersions/1"
+						return &attrs, nil
+					},
+				}
+				return w
+			},
+		}
+		tr := newTestTransport(newSingleObjectMock(object), WithWriteMethods())
+		req, err := http.NewRequest(http.MethodPut, "gs://bucket-name/object-key", strings.NewReader("Hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Goog-Encryption-Kms-Key-Name", keyName)
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		if w.attrs.KMSKeyName != keyName {
+			t.Errorf("unexpected KMS key name: %q", w.attrs.KMSKeyName)
+		}
+		if got, want := resp.Header.Get("X-Goog-Kms-Key-Name"), keyName+"/cryptoKeyVersions/1"; got != want {
+			t.Errorf("unexpected x-goog-kms-key-name: want %q, got %q", want, got)
+		}
+	})
+
+	t.Run("permission denied", func(t *testing.T) {
+		object := &objectHandleMock{
+			newWriterFunc: func(ctx context.Context, mock *objectHandleMock) *storageWriterMock {
+				return &storageWriterMock{
+					ctx: ctx,
+					closeFunc: func(w *storageWriterMock) (*storage.ObjectAttrs, error) {
+						return nil, &googleapi.Error{Code: http.StatusForbidden, Message: "permission denied on the key"}
+					},
+				}
+			},
+		}
+		tr := newTestTransport(newSingleObjectMock(object), WithWriteMethods())
+		req, err := http.NewRequest(http.MethodPut, "gs://bucket-name/object-key", strings.NewReader("Hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Goog-Encryption-Kms-Key-Name", keyName)
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("unexpected status: want %d, got %d", http.StatusForbidden, resp.StatusCode)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(body), keyName) {
+			t.Errorf("want the body naming the key, got %q", body)
+		}
+	})
+
+	for _, header := range []http.Header{
+		{"X-Goog-Encryption-Kms-Key-Name": {"my-key"}},
+		{"X-Goog-Encryption-Kms-Key-Name": {keyName}, "X-Goog-Copy-Source": {"/bucket-name/source"}},
+	} {
+		// the writer must not be created.
+		tr := newTestTransport(newSingleObjectMock(&objectHandleMock{}), WithWriteMethods())
+		req, err := http.NewRequest(http.MethodPut, "gs://bucket-name/object-key", http.NoBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%v: unexpected status: want %d, got %d", header, http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+}
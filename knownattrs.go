@@ -0,0 +1,40 @@
+package gsprotocol
+
+import (
+	"context"
+
+	"cloud.google.com/go/storage"
+)
+
+type knownAttrsKey struct{}
+
+// WithKnownAttrs returns a copy of ctx that makes the Transport use attrs
+// instead of fetching the attributes of the object, e.g. the attributes from listing the objects.
+// Use it with http.Request.WithContext.
+//
+// The Transport builds the headers from attrs and reads the generation of attrs.
+// attrs is used only for the request of the object that attrs.Bucket and attrs.Name name,
+// without the generation in the URL.
+// If the generation of attrs no longer exists, the GET request fails with 409 Conflict,
+// or retries with the fresh attributes if WithGenerationRaceRetry is given.
+// HEAD requests trust attrs as is.
+func WithKnownAttrs(ctx context.Context, attrs *storage.ObjectAttrs) context.Context {
+	return context.WithValue(ctx, knownAttrsKey{}, attrs)
+}
+
+// withoutKnownAttrs returns a copy of ctx that ignores the attributes given by WithKnownAttrs.
+func withoutKnownAttrs(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(knownAttrsKey{}).(*storage.ObjectAttrs); !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, knownAttrsKey{}, (*storage.ObjectAttrs)(nil))
+}
+
+// knownAttrs returns the attributes given by WithKnownAttrs if they are of the object.
+func knownAttrs(ctx context.Context, bucket, object string) (*storage.ObjectAttrs, bool) {
+	attrs, _ := ctx.Value(knownAttrsKey{}).(*storage.ObjectAttrs)
+	if attrs == nil || attrs.Bucket != bucket || attrs.Name != object {
+		return nil, false
+	}
+	return attrs, true
+}
@@ -0,0 +1,129 @@
+package gsprotocol
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+var knownAttrsTestObjects = map[string]mockObject{
+	"bucket-name/object-key": {
+		attrs: &storage.ObjectAttrs{
+			ContentType: "text/plain",
+			Generation:  2,
+		},
+		content: "Hello Google Cloud Storage!",
+	},
+}
+
+func TestRoundTrip_KnownAttrs(t *testing.T) {
+	t.Run("fresh", func(t *testing.T) {
+		c := newTestClient(newStorageClientMockWithObjects(knownAttrsTestObjects))
+		ctx := WithKnownAttrs(context.Background(), &storage.ObjectAttrs{
+			Bucket:      "bucket-name",
+			Name:        "object-key",
+			ContentType: "application/x-known",
+			Size:        27,
+			Generation:  2,
+		})
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "gs://bucket-name/object-key", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		if got := resp.Header.Get("Content-Type"); got != "application/x-known" {
+			t.Errorf("the header is not built from the known attrs: Content-Type %q", got)
+		}
+		if string(body) != "Hello Google Cloud Storage!" {
+			t.Errorf("unexpected body: %q", body)
+		}
+	})
+
+	t.Run("other object", func(t *testing.T) {
+		c := newTestClient(newStorageClientMockWithObjects(knownAttrsTestObjects))
+		ctx := WithKnownAttrs(context.Background(), &storage.ObjectAttrs{
+			Bucket:      "bucket-name",
+			Name:        "other-key",
+			ContentType: "application/x-known",
+			Generation:  2,
+		})
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, "gs://bucket-name/object-key", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if got := resp.Header.Get("Content-Type"); got != "text/plain" {
+			t.Errorf("want the fetched attrs, got Content-Type %q", got)
+		}
+	})
+
+	stale := &storage.ObjectAttrs{
+		Bucket:      "bucket-name",
+		Name:        "object-key",
+		ContentType: "application/x-known",
+		Generation:  1,
+	}
+
+	t.Run("stale", func(t *testing.T) {
+		c := newTestClient(newStorageClientMockWithObjects(knownAttrsTestObjects))
+		req, err := http.NewRequestWithContext(WithKnownAttrs(context.Background(), stale), http.MethodGet, "gs://bucket-name/object-key", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusConflict {
+			t.Errorf("unexpected status: want %d, got %d", http.StatusConflict, resp.StatusCode)
+		}
+	})
+
+	t.Run("stale with retry", func(t *testing.T) {
+		c := newTestClient(newStorageClientMockWithObjects(knownAttrsTestObjects), WithGenerationRaceRetry())
+		req, err := http.NewRequestWithContext(WithKnownAttrs(context.Background(), stale), http.MethodGet, "gs://bucket-name/object-key", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if _, err := io.ReadAll(resp.Body); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		if got := resp.Header.Get("x-goog-generation"); got != "2" {
+			t.Errorf("want the fresh generation 2, got %q", got)
+		}
+		if got := resp.Header.Get("Content-Type"); got != "text/plain" {
+			t.Errorf("want the fresh attrs, got Content-Type %q", got)
+		}
+	})
+}
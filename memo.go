@@ -0,0 +1,86 @@
+package gsprotocol
+
+import (
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// headMemoEntry is the attributes fetched by a HEAD request.
+type headMemoEntry struct {
+	attrs   *storage.ObjectAttrs
+	expires time.Time
+}
+
+// WithHeadMemo makes the Transport reuse the attributes fetched by a HEAD request
+// for the GET request of the same object that follows it within ttl,
+// for the callers that check the size or the ETag by HEAD before GET.
+// The GET request reads the generation that the HEAD request found,
+// so the content is consistent with the HEAD response, but it may be stale up to ttl.
+// Each attributes are reused only once, and at most maxEntries attributes are kept.
+// The attributes are forgotten when a write request modifies the object through the Transport.
+// The reuses are reported by RequestStats.MemoHit.
+// It is disabled by default.
+func WithHeadMemo(ttl time.Duration, maxEntries int) Option {
+	return func(c *config) {
+		c.headMemoTTL = ttl
+		c.headMemoMaxEntries = maxEntries
+	}
+}
+
+// memoizeHead keeps attrs fetched by a HEAD request for the object.
+func (t *Transport) memoizeHead(cfg *config, bucket, object string, attrs *storage.ObjectAttrs) {
+	if cfg.headMemoTTL <= 0 || cfg.headMemoMaxEntries <= 0 {
+		return
+	}
+	now := time.Now()
+	key := bucket + "/" + object
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.headMemo == nil {
+		t.headMemo = make(map[string]headMemoEntry)
+	}
+	if _, ok := t.headMemo[key]; !ok && len(t.headMemo) >= cfg.headMemoMaxEntries {
+		for k, e := range t.headMemo {
+			if now.After(e.expires) {
+				delete(t.headMemo, k)
+			}
+		}
+		if len(t.headMemo) >= cfg.headMemoMaxEntries {
+			return
+		}
+	}
+	t.headMemo[key] = headMemoEntry{
+		attrs:   attrs,
+		expires: now.Add(cfg.headMemoTTL),
+	}
+}
+
+// recallHead returns the attributes kept by memoizeHead for the GET request, and forgets them.
+func (t *Transport) recallHead(req *http.Request, cfg *config, bucket, object string) (*storage.ObjectAttrs, bool) {
+	if cfg.headMemoTTL <= 0 || req.Method != http.MethodGet {
+		return nil, false
+	}
+	key := bucket + "/" + object
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.headMemo[key]
+	if !ok {
+		return nil, false
+	}
+	delete(t.headMemo, key)
+	if time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.attrs, true
+}
+
+// forgetHead forgets the attributes kept by memoizeHead for the object.
+func (t *Transport) forgetHead(bucket, object string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.headMemo, bucket+"/"+object)
+}
@@ -0,0 +1,100 @@
+package gsprotocol
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestRoundTrip_HeadMemo(t *testing.T) {
+	var calls int32
+	obj := newObjectHandleMock("bucket-name", "object-key", mockObject{
+		attrs:   &storage.ObjectAttrs{Generation: 1234567890},
+		content: "Hello Google Cloud Storage!",
+	})
+	attrFunc := obj.attrFunc
+	obj.attrFunc = func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+		atomic.AddInt32(&calls, 1)
+		return attrFunc(ctx, mock)
+	}
+	mock := newSingleObjectMock(obj)
+	c := &http.Client{Transport: newTestTransport(mock, WithHeadMemo(50*time.Millisecond, 10))}
+
+	do := func(method string) *RequestStats {
+		t.Helper()
+		var stats RequestStats
+		req, err := http.NewRequestWithContext(WithStatsRecorder(context.Background(), &stats), method, "gs://bucket-name/object-key", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		if got := resp.Header.Get("x-goog-generation"); got != "1234567890" {
+			t.Errorf("unexpected generation: %q", got)
+		}
+		return &stats
+	}
+
+	// the GET following HEAD reuses the attributes.
+	do(http.MethodHead)
+	if stats := do(http.MethodGet); !stats.MemoHit {
+		t.Error("want memo hit")
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("want 1 call, got %d", n)
+	}
+
+	// the attributes are reused only once.
+	if stats := do(http.MethodGet); stats.MemoHit {
+		t.Error("want no memo hit")
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("want 2 calls, got %d", n)
+	}
+
+	// the attributes expire.
+	do(http.MethodHead)
+	time.Sleep(60 * time.Millisecond)
+	if stats := do(http.MethodGet); stats.MemoHit {
+		t.Error("want no memo hit")
+	}
+	if n := atomic.LoadInt32(&calls); n != 4 {
+		t.Errorf("want 4 calls, got %d", n)
+	}
+}
+
+func TestTransport_HeadMemoMaxEntries(t *testing.T) {
+	tr := &Transport{}
+	cfg := newConfig([]Option{WithHeadMemo(time.Minute, 2)})
+	req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr.memoizeHead(&cfg, "bucket-name", "a", &storage.ObjectAttrs{Generation: 1})
+	tr.memoizeHead(&cfg, "bucket-name", "b", &storage.ObjectAttrs{Generation: 1})
+	tr.memoizeHead(&cfg, "bucket-name", "c", &storage.ObjectAttrs{Generation: 1})
+	if len(tr.headMemo) != 2 {
+		t.Errorf("want 2 entries, got %d", len(tr.headMemo))
+	}
+	if _, ok := tr.recallHead(req, &cfg, "bucket-name", "c"); ok {
+		t.Error("c must not be kept")
+	}
+	if _, ok := tr.recallHead(req, &cfg, "bucket-name", "a"); !ok {
+		t.Error("a must be kept")
+	}
+}
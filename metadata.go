@@ -0,0 +1,136 @@
+package gsprotocol
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// defaultMetadataMaxBytes is the default limit of the total size of the custom metadata,
+// the same as the limit of Google Cloud Storage.
+const defaultMetadataMaxBytes = 8 << 10
+
+// WithMetadataLimits limits the size of the custom metadata in the x-goog-meta-* headers of PUT and PATCH requests.
+// maxValueBytes is the limit of each value, and maxTotalBytes is the limit of the total size of the keys and the values.
+// Zero or negative maxTotalBytes means the default, 8 KiB, and zero or negative maxValueBytes means maxTotalBytes.
+// The limits of PATCH requests apply to the metadata in the request, not to the merged metadata of the object.
+//
+// The keys must consist of the ASCII letters, the digits, '-', '_' and '.', and the values must be valid UTF-8 without control characters.
+// The keys that differ only in case are rejected, because the names of the metadata are lower case.
+// The Transport responds 400 Bad Request that lists the invalid keys, without sending any bytes to Google Cloud Storage.
+func WithMetadataLimits(maxValueBytes, maxTotalBytes int) Option {
+	return func(c *config) {
+		c.metadataMaxValueBytes = maxValueBytes
+		c.metadataMaxTotalBytes = maxTotalBytes
+	}
+}
+
+func (c *config) metadataLimits() (maxValueBytes, maxTotalBytes int) {
+	maxTotalBytes = c.metadataMaxTotalBytes
+	if maxTotalBytes <= 0 {
+		maxTotalBytes = defaultMetadataMaxBytes
+	}
+	maxValueBytes = c.metadataMaxValueBytes
+	if maxValueBytes <= 0 {
+		maxValueBytes = maxTotalBytes
+	}
+	return maxValueBytes, maxTotalBytes
+}
+
+// metadataError is the error of the invalid x-goog-meta-* headers.
+type metadataError struct {
+	// problems are the problems of the keys, sorted by the keys.
+	problems []string
+	total    int
+	limit    int
+}
+
+func (e *metadataError) Error() string {
+	var msgs []string
+	if len(e.problems) > 0 {
+		msgs = append(msgs, "invalid keys: "+strings.Join(e.problems, ", "))
+	}
+	if e.total > e.limit {
+		msgs = append(msgs, fmt.Sprintf("the metadata is %d bytes, the limit is %d bytes", e.total, e.limit))
+	}
+	return "gsprotocol: invalid metadata: " + strings.Join(msgs, "; ")
+}
+
+// checkMetadataHeader validates the x-goog-meta-* headers with the limits of cfg.
+func checkMetadataHeader(header http.Header, cfg *config) error {
+	maxValueBytes, maxTotalBytes := cfg.metadataLimits()
+	keys := make(map[string][]string)
+	for key := range header {
+		if name, ok := metadataName(key); ok {
+			keys[name] = append(keys[name], key)
+		}
+	}
+
+	problems := make(map[string]string)
+	total := 0
+	for name, dups := range keys {
+		if len(dups) > 1 {
+			sort.Strings(dups)
+			problems[name] = "duplicated keys " + strings.Join(dups, ", ")
+			continue
+		}
+		key := dups[0]
+		values := header[key]
+		if len(values) > 1 {
+			problems[name] = "multiple values"
+			continue
+		}
+		if !validMetadataKey(key[len("x-goog-meta-"):]) {
+			problems[name] = "invalid characters"
+			continue
+		}
+		value := firstValue(values)
+		if !validMetadataValue(value) {
+			problems[name] = "invalid value"
+			continue
+		}
+		if len(value) > maxValueBytes {
+			problems[name] = fmt.Sprintf("the value is %d bytes, the limit is %d bytes", len(value), maxValueBytes)
+			continue
+		}
+		total += len(name) + len(value)
+	}
+	if len(problems) == 0 && total <= maxTotalBytes {
+		return nil
+	}
+
+	names := make([]string, 0, len(problems))
+	for name := range problems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	err := &metadataError{total: total, limit: maxTotalBytes}
+	for _, name := range names {
+		err.problems = append(err.problems, fmt.Sprintf("%q (%s)", name, problems[name]))
+	}
+	return err
+}
+
+func validMetadataKey(key string) bool {
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func validMetadataValue(value string) bool {
+	if !utf8.ValidString(value) {
+		return false
+	}
+	for _, r := range value {
+		if r < 0x20 && r != '\t' || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package gsprotocol
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCheckMetadataHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Option
+		header http.Header
+		want   string // the substring of the error, or empty if valid.
+	}{
+		{
+			name:   "valid",
+			header: http.Header{"X-Goog-Meta-Build-Id": {"1234"}, "X-Goog-Meta-Owner.Team": {"storage"}, "Content-Type": {"text/plain"}},
+		},
+		{
+			name:   "empty value",
+			header: http.Header{"X-Goog-Meta-Build-Id": {""}},
+		},
+		{
+			name:   "invalid key",
+			header: http.Header{"X-Goog-Meta-Build Id": {"1234"}},
+			want:   `"build id" (invalid characters)`,
+		},
+		{
+			name:   "invalid value",
+			header: http.Header{"X-Goog-Meta-Build-Id": {"12\n34"}},
+			want:   `"build-id" (invalid value)`,
+		},
+		{
+			name:   "case duplicate",
+			header: http.Header{"X-Goog-Meta-Build-Id": {"1234"}, "x-goog-meta-build-id": {"5678"}},
+			want:   `"build-id" (duplicated keys X-Goog-Meta-Build-Id, x-goog-meta-build-id)`,
+		},
+		{
+			name:   "multiple values",
+			header: http.Header{"X-Goog-Meta-Build-Id": {"1234", "5678"}},
+			want:   `"build-id" (multiple values)`,
+		},
+		{
+			name:   "value too large",
+			opts:   []Option{WithMetadataLimits(4, 0)},
+			header: http.Header{"X-Goog-Meta-Build-Id": {"12345"}, "X-Goog-Meta-Owner": {"1234"}},
+			want:   `invalid keys: "build-id" (the value is 5 bytes, the limit is 4 bytes)`,
+		},
+		{
+			name:   "total too large",
+			opts:   []Option{WithMetadataLimits(0, 16)},
+			header: http.Header{"X-Goog-Meta-Build-Id": {"1234"}, "X-Goog-Meta-Owner": {"storage"}},
+			want:   "the metadata is 24 bytes, the limit is 16 bytes",
+		},
+		{
+			name:   "default total",
+			header: http.Header{"X-Goog-Meta-Large": {strings.Repeat("x", 8<<10)}},
+			want:   "the limit is 8192 bytes",
+		},
+		{
+			name: "sorted",
+			header: http.Header{
+				"X-Goog-Meta-B!": {"1"},
+				"X-Goog-Meta-A!": {"1"},
+			},
+			want: `invalid keys: "a!" (invalid characters), "b!" (invalid characters)`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newConfig(tt.opts)
+			err := checkMetadataHeader(tt.header, &cfg)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("want nil, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("want the error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestRoundTrip_MetadataLimits(t *testing.T) {
+	// the writer is never created.
+	tr := newTestTransport(newSingleObjectMock(&objectHandleMock{}), WithWriteMethods(), WithBucketConfig("small-bucket", BucketConfig{WithMetadataLimits(0, 16)}))
+	for _, method := range []string{http.MethodPut, http.MethodPatch} {
+		for _, url := range []string{"gs://bucket-name/object-key", "gs://small-bucket/object-key"} {
+			req, err := http.NewRequest(method, url, strings.NewReader("Hello"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-Goog-Meta-Build Id", "1234")
+			if strings.Contains(url, "small-bucket") {
+				req.Header = http.Header{"X-Goog-Meta-Description": {"the build of the release"}}
+			}
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("%s %s: unexpected status: want %d, got %d", method, url, http.StatusBadRequest, resp.StatusCode)
+			}
+			if !strings.Contains(string(body), "invalid metadata") {
+				t.Errorf("%s %s: unexpected body: %q", method, url, body)
+			}
+		}
+	}
+}
@@ -1,10 +1,15 @@
 package gsprotocol
 
 import (
+	"bytes"
 	"context"
 	"io"
+	"net/http"
+	"sort"
+	"strings"
 
 	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
 )
 
 var bucketMockNotFount = &bucketHandleMock{
@@ -25,8 +30,45 @@ var objectMockNotFound = &objectHandleMock{
 	},
 }
 
+// newTestTransport returns a Transport that accesses Google Cloud Storage through the mock client.
+func newTestTransport(client storageClient, opts ...Option) *Transport {
+	return &Transport{client: client, config: newConfig(opts)}
+}
+
+// newTestClient returns an http.Client that handles the gs scheme by newTestTransport,
+// in the same way as the users register the Transport.
+func newTestClient(client storageClient, opts ...Option) *http.Client {
+	tr := &http.Transport{}
+	tr.RegisterProtocol("gs", newTestTransport(client, opts...))
+	return &http.Client{Transport: tr}
+}
+
+// newSingleObjectMock returns a mock client whose objects are all object, regardless of the buckets and the names.
+func newSingleObjectMock(object *objectHandleMock) *storageClientMock {
+	bucket := &bucketHandleMock{
+		objectFunc: func(mock *bucketHandleMock, name string) *objectHandleMock {
+			return object
+		},
+	}
+	return &storageClientMock{
+		bucketFunc: func(mock *storageClientMock, name string) *bucketHandleMock {
+			return bucket
+		},
+	}
+}
+
+// withGeneration is the generationFunc of objectHandleMock that copies the mock with the generation.
+func withGeneration(mock *objectHandleMock, gen int64) *objectHandleMock {
+	cp := *mock
+	cp.generation = gen
+	return &cp
+}
+
 type storageClientMock struct {
 	bucketFunc func(mock *storageClientMock, name string) *bucketHandleMock
+	closeFunc  func(mock *storageClientMock) error
+
+	closeIdleFunc func(mock *storageClientMock)
 }
 
 func (c *storageClientMock) Bucket(name string) bucketHandle {
@@ -36,8 +78,27 @@ func (c *storageClientMock) Bucket(name string) bucketHandle {
 	return c.bucketFunc(c, name)
 }
 
+func (c *storageClientMock) Underlying() *storage.Client {
+	return nil
+}
+
+func (c *storageClientMock) CloseIdleConnections() {
+	if c.closeIdleFunc != nil {
+		c.closeIdleFunc(c)
+	}
+}
+
+func (c *storageClientMock) Close() error {
+	if c.closeFunc == nil {
+		return nil
+	}
+	return c.closeFunc(c)
+}
+
 type bucketHandleMock struct {
-	objectFunc func(mock *bucketHandleMock, name string) *objectHandleMock
+	userProject string
+	objectFunc  func(mock *bucketHandleMock, name string) *objectHandleMock
+	objectsFunc func(ctx context.Context, mock *bucketHandleMock, q *storage.Query) objectIterator
 }
 
 func (h *bucketHandleMock) Object(name string) objectHandle {
@@ -47,10 +108,48 @@ func (h *bucketHandleMock) Object(name string) objectHandle {
 	return h.objectFunc(h, name)
 }
 
+func (h *bucketHandleMock) UserProject(project string) bucketHandle {
+	cp := *h
+	cp.userProject = project
+	return &cp
+}
+
+func (h *bucketHandleMock) Objects(ctx context.Context, q *storage.Query) objectIterator {
+	if h.objectsFunc == nil {
+		panic("unexpected call of Objects")
+	}
+	return h.objectsFunc(ctx, h, q)
+}
+
+type objectIteratorMock struct {
+	attrs []*storage.ObjectAttrs
+	err   error
+}
+
+func (it *objectIteratorMock) Next() (*storage.ObjectAttrs, error) {
+	if len(it.attrs) == 0 {
+		if it.err != nil {
+			return nil, it.err
+		}
+		return nil, iterator.Done
+	}
+	attrs := it.attrs[0]
+	it.attrs = it.attrs[1:]
+	return attrs, nil
+}
+
 type objectHandleMock struct {
 	generation     int64
+	encryptionKey  []byte
+	readCompressed bool
+	conds          storage.Conditions
 	attrFunc       func(ctx context.Context, mock *objectHandleMock) (attrs *storage.ObjectAttrs, err error)
 	newReaderFunc  func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error)
+	newWriterFunc  func(ctx context.Context, mock *objectHandleMock) *storageWriterMock
+	deleteFunc     func(ctx context.Context, mock *objectHandleMock) error
+	updateFunc     func(ctx context.Context, mock *objectHandleMock, attrs storage.ObjectAttrsToUpdate) (*storage.ObjectAttrs, error)
+	copierFunc     func(mock *objectHandleMock, src *objectHandleMock) *storageCopierMock
+	composerFunc   func(mock *objectHandleMock, srcs []*objectHandleMock) *storageCopierMock
 	generationFunc func(mock *objectHandleMock, gen int64) *objectHandleMock
 }
 
@@ -72,6 +171,75 @@ func (h *objectHandleMock) NewReader(ctx context.Context) (storageReader, error)
 	}, nil
 }
 
+// NewRangeReader reads the range of the content that newReaderFunc returns.
+func (h *objectHandleMock) NewRangeReader(ctx context.Context, offset, length int64) (storageReader, error) {
+	attrs, reader, err := h.newReaderFunc(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, reader, offset); err != nil {
+		reader.Close()
+		return nil, err
+	}
+	var r io.Reader = reader
+	if length >= 0 {
+		r = io.LimitReader(reader, length)
+	}
+	attrs.StartOffset = offset
+	return &storageReaderMock{
+		ReadCloser: struct {
+			io.Reader
+			io.Closer
+		}{r, reader},
+		attrs: attrs,
+	}, nil
+}
+
+func (h *objectHandleMock) NewWriter(ctx context.Context) storageWriter {
+	if h.newWriterFunc == nil {
+		panic("unexpected call of NewWriter")
+	}
+	return h.newWriterFunc(ctx, h)
+}
+
+func (h *objectHandleMock) Delete(ctx context.Context) error {
+	if h.deleteFunc == nil {
+		panic("unexpected call of Delete")
+	}
+	return h.deleteFunc(ctx, h)
+}
+
+func (h *objectHandleMock) Update(ctx context.Context, attrs storage.ObjectAttrsToUpdate) (*storage.ObjectAttrs, error) {
+	if h.updateFunc == nil {
+		panic("unexpected call of Update")
+	}
+	return h.updateFunc(ctx, h, attrs)
+}
+
+func (h *objectHandleMock) CopierFrom(src objectHandle) storageCopier {
+	if h.copierFunc == nil {
+		panic("unexpected call of CopierFrom")
+	}
+	return h.copierFunc(h, src.(*objectHandleMock))
+}
+
+func (h *objectHandleMock) ComposerFrom(srcs ...objectHandle) storageComposer {
+	if h.composerFunc == nil {
+		panic("unexpected call of ComposerFrom")
+	}
+	mocks := make([]*objectHandleMock, 0, len(srcs))
+	for _, src := range srcs {
+		mocks = append(mocks, src.(*objectHandleMock))
+	}
+	return h.composerFunc(h, mocks)
+}
+
+func (h *objectHandleMock) If(conds storage.Conditions) objectHandle {
+	cp := *h
+	cp.conds = conds
+	return &cp
+}
+
 func (h *objectHandleMock) Generation(gen int64) objectHandle {
 	if h.generationFunc == nil {
 		panic("unexpected call of Generation")
@@ -79,6 +247,18 @@ func (h *objectHandleMock) Generation(gen int64) objectHandle {
 	return h.generationFunc(h, gen)
 }
 
+func (h *objectHandleMock) Key(encryptionKey []byte) objectHandle {
+	cp := *h
+	cp.encryptionKey = encryptionKey
+	return &cp
+}
+
+func (h *objectHandleMock) ReadCompressed(compressed bool) objectHandle {
+	cp := *h
+	cp.readCompressed = compressed
+	return &cp
+}
+
 type storageReaderMock struct {
 	io.ReadCloser
 	attrs storage.ReaderObjectAttrs
@@ -87,3 +267,136 @@ type storageReaderMock struct {
 func (r *storageReaderMock) Attrs() storage.ReaderObjectAttrs {
 	return r.attrs
 }
+
+func (r *storageReaderMock) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, r.ReadCloser)
+}
+
+// storageWriterMock writes the content to buf, and calls closeFunc on Close.
+type storageWriterMock struct {
+	ctx       context.Context
+	buf       bytes.Buffer
+	attrs     storage.ObjectAttrs
+	written   *storage.ObjectAttrs
+	closed    bool
+	closeFunc func(w *storageWriterMock) (*storage.ObjectAttrs, error)
+}
+
+func (w *storageWriterMock) ObjectAttrs() *storage.ObjectAttrs {
+	return &w.attrs
+}
+
+func (w *storageWriterMock) Attrs() *storage.ObjectAttrs {
+	return w.written
+}
+
+func (w *storageWriterMock) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+// Close fails if the context is canceled, like storage.Writer.
+func (w *storageWriterMock) Close() error {
+	w.closed = true
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	attrs, err := w.closeFunc(w)
+	if err != nil {
+		return err
+	}
+	w.written = attrs
+	return nil
+}
+
+// storageCopierMock calls runFunc on Run.
+// It is also the mock of storageComposer.
+type storageCopierMock struct {
+	attrs    storage.ObjectAttrs
+	progress func(copiedBytes, totalBytes uint64)
+	runFunc  func(ctx context.Context, c *storageCopierMock) (*storage.ObjectAttrs, error)
+}
+
+func (c *storageCopierMock) setProgressFunc(progress func(copiedBytes, totalBytes uint64)) {
+	c.progress = progress
+}
+
+func (c *storageCopierMock) ObjectAttrs() *storage.ObjectAttrs {
+	return &c.attrs
+}
+
+func (c *storageCopierMock) Run(ctx context.Context) (*storage.ObjectAttrs, error) {
+	return c.runFunc(ctx, c)
+}
+
+// mockObject is an object served by newStorageClientMockWithObjects.
+type mockObject struct {
+	attrs   *storage.ObjectAttrs
+	content string
+}
+
+// newStorageClientMockWithObjects returns a storageClientMock that serves objects.
+// The keys of objects are "bucket-name/object-key".
+func newStorageClientMockWithObjects(objects map[string]mockObject) *storageClientMock {
+	return &storageClientMock{
+		bucketFunc: func(mock *storageClientMock, bucketName string) *bucketHandleMock {
+			return &bucketHandleMock{
+				objectFunc: func(mock *bucketHandleMock, objectName string) *objectHandleMock {
+					obj, ok := objects[bucketName+"/"+objectName]
+					if !ok {
+						return objectMockNotFound
+					}
+					return newObjectHandleMock(bucketName, objectName, obj)
+				},
+				objectsFunc: func(ctx context.Context, mock *bucketHandleMock, q *storage.Query) objectIterator {
+					var names []string
+					for key := range objects {
+						if strings.HasPrefix(key, bucketName+"/"+q.Prefix) {
+							names = append(names, strings.TrimPrefix(key, bucketName+"/"))
+						}
+					}
+					sort.Strings(names)
+					it := &objectIteratorMock{}
+					for _, name := range names {
+						obj := objects[bucketName+"/"+name]
+						attrs := *obj.attrs
+						attrs.Bucket = bucketName
+						attrs.Name = name
+						attrs.Size = int64(len(obj.content))
+						it.attrs = append(it.attrs, &attrs)
+					}
+					return it
+				},
+			}
+		},
+	}
+}
+
+func newObjectHandleMock(bucketName, objectName string, obj mockObject) *objectHandleMock {
+	return &objectHandleMock{
+		attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+			if mock.generation != 0 && mock.generation != obj.attrs.Generation {
+				return nil, storage.ErrObjectNotExist
+			}
+			attrs := *obj.attrs
+			attrs.Bucket = bucketName
+			attrs.Name = objectName
+			attrs.Size = int64(len(obj.content))
+			return &attrs, nil
+		},
+		newReaderFunc: func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+			if mock.generation != 0 && mock.generation != obj.attrs.Generation {
+				return storage.ReaderObjectAttrs{}, nil, storage.ErrObjectNotExist
+			}
+			return storage.ReaderObjectAttrs{
+				Size:            int64(len(obj.content)),
+				ContentType:     obj.attrs.ContentType,
+				ContentEncoding: obj.attrs.ContentEncoding,
+				CacheControl:    obj.attrs.CacheControl,
+				LastModified:    obj.attrs.Updated,
+				Generation:      obj.attrs.Generation,
+				Metageneration:  obj.attrs.Metageneration,
+			}, io.NopCloser(strings.NewReader(obj.content)), nil
+		},
+		generationFunc: withGeneration,
+	}
+}
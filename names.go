@@ -0,0 +1,83 @@
+package gsprotocol
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// checkNames validates the bucket name and the object name of req, so that the obvious mistakes fail fast without calling
+// Google Cloud Storage.
+// The object name may be empty for the requests for the whole bucket, i.e. archives and batch metadata.
+func checkNames(req *http.Request) error {
+	bucket := bucketName(req)
+	if bucket == "" {
+		return errors.New("gsprotocol: the bucket name is empty, the URL must be gs://[BUCKET_NAME]/[OBJECT_NAME]")
+	}
+	if err := validateBucketName(bucket); err != nil {
+		return err
+	}
+	if objectName(req.URL) == "" && !isBucketRequest(req) {
+		return fmt.Errorf("gsprotocol: the object name is empty, the URL must be gs://%s/[OBJECT_NAME]", bucket)
+	}
+	return nil
+}
+
+// isBucketRequest reports whether req is for the whole bucket rather than an object.
+func isBucketRequest(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	return isBatchMetadataRequest(req) || req.URL.Query().Get("archive") != ""
+}
+
+// validateBucketName validates name against the naming rules of buckets.
+// See https://cloud.google.com/storage/docs/buckets#naming
+func validateBucketName(name string) error {
+	if len(name) < 3 || len(name) > 222 {
+		return invalidBucketName(name, "it must contain 3-63 characters, or up to 222 characters with dots")
+	}
+	for _, c := range []byte(name) {
+		if !isBucketNameChar(c) {
+			return invalidBucketName(name, fmt.Sprintf("it must contain only lowercase letters, numbers, dashes, underscores and dots, but %q", c))
+		}
+	}
+	if !isAlphaNum(name[0]) || !isAlphaNum(name[len(name)-1]) {
+		return invalidBucketName(name, "it must start and end with a number or letter")
+	}
+	for rest := name; rest != ""; {
+		component := rest
+		if i := strings.IndexByte(rest, '.'); i >= 0 {
+			component, rest = rest[:i], rest[i+1:]
+		} else {
+			rest = ""
+		}
+		if component == "" || len(component) > 63 {
+			return invalidBucketName(name, "each dot-separated component must contain 1-63 characters")
+		}
+	}
+	if strings.Trim(name, "0123456789.") == "" && net.ParseIP(name) != nil {
+		return invalidBucketName(name, "it must not be an IP address")
+	}
+	if strings.HasPrefix(name, "goog") {
+		return invalidBucketName(name, `it must not begin with "goog"`)
+	}
+	if strings.Contains(name, "google") || strings.Contains(name, "g00gle") {
+		return invalidBucketName(name, `it must not contain "google" or its misspellings`)
+	}
+	return nil
+}
+
+func invalidBucketName(name, reason string) error {
+	return fmt.Errorf("gsprotocol: invalid bucket name %q: %s", name, reason)
+}
+
+func isBucketNameChar(c byte) bool {
+	return isAlphaNum(c) || c == '-' || c == '_' || c == '.'
+}
+
+func isAlphaNum(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('0' <= c && c <= '9')
+}
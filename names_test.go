@@ -0,0 +1,98 @@
+package gsprotocol
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestValidateBucketName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"bucket-name", true},
+		{"my_bucket.example.com", true},
+		{"123", true},
+		{"ab", false},
+		{strings.Repeat("a", 63), true},
+		{strings.Repeat("a", 64), false},
+		{strings.Repeat("a", 63) + "." + strings.Repeat("b", 63), true},
+		{"Bucket-Name", false},
+		{"bucket name", false},
+		{"-bucket", false},
+		{"bucket.", false},
+		{"bucket..name", false},
+		{"192.168.5.4", false},
+		{"goog-bucket", false},
+		{"my-google-bucket", false},
+		{"my-g00gle-bucket", false},
+	}
+	for _, tt := range tests {
+		err := validateBucketName(tt.name)
+		if tt.valid && err != nil {
+			t.Errorf("%q: want valid, got %v", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%q: want invalid, got nil", tt.name)
+		}
+	}
+}
+
+func TestRoundTrip_InvalidNames(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs:   &storage.ObjectAttrs{Generation: 1},
+			content: "Hello Google Cloud Storage!",
+		},
+	})
+	tr := newTestTransport(mock)
+
+	tests := []struct {
+		method string
+		url    string
+		want   string
+	}{
+		{http.MethodGet, "gs://bucket-name", "the object name is empty"},
+		{http.MethodGet, "gs://bucket-name/", "the object name is empty"},
+		{http.MethodHead, "gs://bucket-name/", "the object name is empty"},
+		{http.MethodGet, "gs:///object-key", "the bucket name is empty"},
+		{http.MethodGet, "gs://Bucket-Name/object-key", "invalid bucket name"},
+		{http.MethodGet, "gs://bucket_name!/object-key", "invalid bucket name"},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Errorf("%s %s: want a response, got %v", tt.method, tt.url, err)
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s %s: unexpected status: want %d, got %d", tt.method, tt.url, http.StatusBadRequest, resp.StatusCode)
+		}
+		if tt.method != http.MethodHead && !strings.Contains(string(body), tt.want) {
+			t.Errorf("%s %s: want the body containing %q, got %q", tt.method, tt.url, tt.want, body)
+		}
+	}
+
+	// the requests for the whole bucket have no object name.
+	resp, err := (&http.Client{Transport: tr}).Get("gs://bucket-name/?objects=object-key&alt=json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+}
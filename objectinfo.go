@@ -0,0 +1,72 @@
+package gsprotocol
+
+import (
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// ObjectInfo is the information of an object that the package reports.
+// Unlike the headers of the responses, it carries the generations as integers.
+type ObjectInfo struct {
+	// Bucket and Name are the names of the object.
+	Bucket string
+	Name   string
+
+	// Size is the size of the object in bytes.
+	Size int64
+
+	// Generation is the generation of the content of the object.
+	Generation int64
+
+	// Metageneration is the version of the metadata of the object at Generation.
+	Metageneration int64
+
+	// Updated is the time that the object was last modified.
+	Updated time.Time
+
+	// ContentType is the MIME type of the object.
+	ContentType string
+
+	// MD5 is the MD5 hash of the object, or nil for composite objects.
+	MD5 []byte
+
+	// CRC32C is the CRC32C checksum of the object.
+	CRC32C uint32
+
+	// Metadata is the user-provided metadata of the object.
+	Metadata map[string]string
+
+	// StorageClass is the storage class of the object, e.g. "STANDARD".
+	StorageClass string
+}
+
+// NewObjectInfo converts attrs into an ObjectInfo.
+// It copies MD5 and Metadata, so the result doesn't share memory with attrs.
+// It returns the zero ObjectInfo if attrs is nil.
+func NewObjectInfo(attrs *storage.ObjectAttrs) ObjectInfo {
+	if attrs == nil {
+		return ObjectInfo{}
+	}
+	info := ObjectInfo{
+		Bucket:         attrs.Bucket,
+		Name:           attrs.Name,
+		Size:           attrs.Size,
+		Generation:     attrs.Generation,
+		Metageneration: attrs.Metageneration,
+		Updated:        attrs.Updated,
+		ContentType:    attrs.ContentType,
+		CRC32C:         attrs.CRC32C,
+		StorageClass:   attrs.StorageClass,
+	}
+	if attrs.MD5 != nil {
+		info.MD5 = append([]byte(nil), attrs.MD5...)
+	}
+	if attrs.Metadata != nil {
+		info.Metadata = make(map[string]string, len(attrs.Metadata))
+		for k, v := range attrs.Metadata {
+			info.Metadata[k] = v
+		}
+	}
+	return info
+}
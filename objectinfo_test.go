@@ -0,0 +1,57 @@
+package gsprotocol
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestNewObjectInfo(t *testing.T) {
+	updated := time.Date(2020, time.April, 18, 12, 34, 56, 0, time.UTC)
+	attrs := &storage.ObjectAttrs{
+		Bucket:         "bucket-name",
+		Name:           "object-key",
+		Size:           27,
+		Generation:     1587160158394554,
+		Metageneration: 3,
+		Updated:        updated,
+		ContentType:    "text/plain",
+		MD5:            []byte{0x0b, 0x46, 0xf3, 0x06, 0xe9, 0x2d, 0x88, 0x51, 0x5e, 0x06, 0xd4, 0x8a, 0x62, 0xdc, 0xc3, 0x19},
+		CRC32C:         0x7f762fe2,
+		Metadata:       map[string]string{"foo": "bar"},
+		StorageClass:   "NEARLINE",
+	}
+	want := ObjectInfo{
+		Bucket:         "bucket-name",
+		Name:           "object-key",
+		Size:           27,
+		Generation:     1587160158394554,
+		Metageneration: 3,
+		Updated:        updated,
+		ContentType:    "text/plain",
+		MD5:            []byte{0x0b, 0x46, 0xf3, 0x06, 0xe9, 0x2d, 0x88, 0x51, 0x5e, 0x06, 0xd4, 0x8a, 0x62, 0xdc, 0xc3, 0x19},
+		CRC32C:         0x7f762fe2,
+		Metadata:       map[string]string{"foo": "bar"},
+		StorageClass:   "NEARLINE",
+	}
+	got := NewObjectInfo(attrs)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %#v, got %#v", want, got)
+	}
+
+	// the result must not share memory with attrs.
+	attrs.MD5[0] = 0
+	attrs.Metadata["foo"] = "baz"
+	if got.MD5[0] != 0x0b {
+		t.Error("MD5 is shared with attrs")
+	}
+	if got.Metadata["foo"] != "bar" {
+		t.Error("Metadata is shared with attrs")
+	}
+
+	if got := NewObjectInfo(nil); !reflect.DeepEqual(got, ObjectInfo{}) {
+		t.Errorf("want the zero value, got %#v", got)
+	}
+}
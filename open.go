@@ -0,0 +1,111 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// ErrNotModified is returned by Open if the object matches the validators.
+var ErrNotModified = errors.New("gsprotocol: not modified")
+
+// Validators are the validators of the object that the caller already has.
+// The zero value matches nothing.
+type Validators struct {
+	// ETag is the ETag of the object, e.g. `"0b46f306e92d88515e06d48a62dcc319"`.
+	// It is compared in the same way as the If-None-Match header.
+	ETag string
+
+	// Generation is the generation of the object.
+	// It is compared in the same way as the x-goog-if-generation-not-match header.
+	Generation int64
+
+	// ModifiedSince is the time that the object was last modified.
+	// It is compared in the same way as the If-Modified-Since header.
+	ModifiedSince time.Time
+}
+
+func (v Validators) setHeader(header http.Header) {
+	if v.ETag != "" {
+		header.Set("If-None-Match", v.ETag)
+	}
+	if v.Generation != 0 {
+		header.Set("x-goog-if-generation-not-match", strconv.FormatInt(v.Generation, 10))
+	}
+	if !v.ModifiedSince.IsZero() {
+		header.Set("If-Modified-Since", v.ModifiedSince.UTC().Format(http.TimeFormat))
+	}
+}
+
+// Open opens the object of rawurl, e.g. gs://[BUCKET_NAME]/[OBJECT_NAME], unless it matches v.
+// The validators are evaluated in the same way as the conditional headers of RoundTrip.
+// If the object matches v, Open returns its attributes and ErrNotModified.
+// Otherwise, it returns the attributes and the content of the generation that the attributes describe.
+// The caller must close the content.
+// The bucket name is translated by WithBucketAlias and checked by WithAllowedBuckets and WithDeniedBuckets, the same as RoundTrip.
+func (t *Transport) Open(ctx context.Context, rawurl string, v Validators) (*storage.ObjectAttrs, io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawurl, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := t.checkScheme(req.URL); err != nil {
+		return nil, nil, err
+	}
+	alias := bucketName(req)
+	req, resp := t.config.resolveAlias(req)
+	if resp != nil {
+		resp.Body.Close()
+		return nil, nil, fmt.Errorf("gsprotocol: the bucket %q is not in the aliases", alias)
+	}
+	if err := t.config.checkBucketAccess(bucketName(req)); err != nil {
+		return nil, nil, err
+	}
+	v.setHeader(req.Header)
+
+	ctx, client, done := t.trackRequest(req)
+	bucket := bucketName(req)
+	cfg := t.config.forBucket(bucket)
+	if cfg.strictURLs {
+		if err := t.validateURL(req.URL); err != nil {
+			done()
+			return nil, nil, err
+		}
+	}
+	client = t.budgetedClient(client, bucket, cfg)
+	client = userProjectedClient(client, req, cfg)
+	object, attrs, err := t.objectAttrs(ctx, client, req, cfg)
+	if err != nil {
+		done()
+		return nil, nil, err
+	}
+	if resp := checkPreconditions(req, cfg.responseHeader(req, attrs), attrs); resp != nil {
+		done()
+		if resp.StatusCode == http.StatusNotModified {
+			return attrs, nil, ErrNotModified
+		}
+		return nil, nil, fmt.Errorf("gsprotocol: unexpected status %s", resp.Status)
+	}
+
+	body, err := object.NewReader(ctx)
+	if err != nil {
+		done()
+		return nil, nil, err
+	}
+	return attrs, &trackedBody{
+		ReadCloser: newTransferBody(body, attrs.Bucket, attrs.Name, attrs.Generation, attrs.Size),
+		done:       done,
+	}, nil
+}
+
+// OpenIfChanged opens the object of rawurl if its generation is not lastGen.
+// It returns ErrNotModified if the object is not changed.
+// See Open for details.
+func (t *Transport) OpenIfChanged(ctx context.Context, rawurl string, lastGen int64) (*storage.ObjectAttrs, io.ReadCloser, error) {
+	return t.Open(ctx, rawurl, Validators{Generation: lastGen})
+}
@@ -0,0 +1,101 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestTransport_Open(t *testing.T) {
+	updated := time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs: &storage.ObjectAttrs{
+				Generation: 1234567890,
+				MD5:        []byte{0x0b, 0x46, 0xf3, 0x06, 0xe9, 0x2d, 0x88, 0x51, 0x5e, 0x06, 0xd4, 0x8a, 0x62, 0xdc, 0xc3, 0x19},
+				Updated:    updated,
+			},
+			content: "Hello Google Cloud Storage!",
+		},
+	})
+	tr := newTestTransport(mock)
+
+	tc := []struct {
+		name        string
+		validators  Validators
+		notModified bool
+	}{
+		{"no validators", Validators{}, false},
+		{"ETag match", Validators{ETag: `"0b46f306e92d88515e06d48a62dcc319"`}, true},
+		{"ETag mismatch", Validators{ETag: `"ffffffffffffffffffffffffffffffff"`}, false},
+		{"generation match", Validators{Generation: 1234567890}, true},
+		{"generation mismatch", Validators{Generation: 1}, false},
+		{"not modified since", Validators{ModifiedSince: updated}, true},
+		{"modified since", Validators{ModifiedSince: updated.Add(-time.Hour)}, false},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			attrs, body, err := tr.Open(context.Background(), "gs://bucket-name/object-key", tt.validators)
+			if tt.notModified {
+				if !errors.Is(err, ErrNotModified) {
+					t.Fatalf("want ErrNotModified, got %v", err)
+				}
+				if body != nil {
+					t.Error("want nil body")
+				}
+				if attrs.Generation != 1234567890 {
+					t.Errorf("unexpected generation: %d", attrs.Generation)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer body.Close()
+			if attrs.Generation != 1234567890 {
+				t.Errorf("unexpected generation: %d", attrs.Generation)
+			}
+			got, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != "Hello Google Cloud Storage!" {
+				t.Errorf("unexpected content: %q", string(got))
+			}
+		})
+	}
+}
+
+func TestTransport_OpenIfChanged(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs:   &storage.ObjectAttrs{Generation: 2},
+			content: "Hello Google Cloud Storage!",
+		},
+	})
+	tr := newTestTransport(mock)
+
+	if _, _, err := tr.OpenIfChanged(context.Background(), "gs://bucket-name/object-key", 2); !errors.Is(err, ErrNotModified) {
+		t.Errorf("want ErrNotModified, got %v", err)
+	}
+
+	attrs, body, err := tr.OpenIfChanged(context.Background(), "gs://bucket-name/object-key", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body.Close()
+	if attrs.Generation != 2 {
+		t.Errorf("unexpected generation: %d", attrs.Generation)
+	}
+
+	if _, _, err := tr.OpenIfChanged(context.Background(), "gs://bucket-name/not-found", 1); !errors.Is(err, storage.ErrObjectNotExist) {
+		t.Errorf("want storage.ErrObjectNotExist, got %v", err)
+	}
+	if len(tr.inflight) != 0 {
+		t.Errorf("want no in-flight requests, got %d", len(tr.inflight))
+	}
+}
@@ -0,0 +1,420 @@
+package gsprotocol
+
+import (
+	"net/http"
+	"time"
+)
+
+// Option configures the behavior of the Transport.
+type Option func(*config)
+
+// config is the behavior of the Transport configured by Options.
+// The zero value is the default behavior.
+type config struct {
+	symlinkMode SymlinkMode
+
+	immutableCacheControl      bool
+	forceImmutableCacheControl bool
+
+	// maxMetadataBytes is the limit of the x-goog-meta-* headers.
+	// zero means defaultMaxMetadataBytes, and negative means no limit.
+	maxMetadataBytes int
+
+	gzipDecompression bool
+
+	// the configuration of WithResponseCompression.
+	responseCompression bool
+	compressionMinSize  int
+
+	// the patterns of WithAllowedBuckets and WithDeniedBuckets.
+	// nil allowedBuckets means all buckets are allowed.
+	allowedBuckets []string
+	deniedBuckets  []string
+
+	// userProject is the project billed for the requests.
+	userProject string
+
+	// the configuration of WithBucketAlias.
+	bucketAliases map[string]string
+	strictAliases bool
+
+	// fragmentInName treats the fragments of URLs as a part of the object names, instead of generations.
+	fragmentInName bool
+
+	// anyScheme serves the URLs of any scheme.
+	anyScheme bool
+
+	// schemes is the schemes of the URLs served by the Transport.
+	// nil means defaultSchemes.
+	schemes []string
+
+	// the HTTP client of the storage client created by NewTransportWithOptions.
+	// See WithOwnHTTPClient and WithStorageHTTPClient.
+	ownHTTPClient     bool
+	storageHTTPClient *http.Client
+
+	// strictURLs validates the whole URL of a request.
+	strictURLs bool
+
+	// strictConditionals rejects self-contradictory conditional headers.
+	strictConditionals bool
+
+	// combinedHashHeader joins the x-goog-hash values with commas.
+	combinedHashHeader bool
+
+	// the limits of archives. zero means the default.
+	archiveMaxEntries int
+	archiveMaxBytes   int64
+
+	// batchMaxKeys is the limit of the keys of batch metadata requests. zero means the default.
+	batchMaxKeys int
+
+	// the configuration of long-polling requests.
+	// zero watchInterval means long-polling is disabled.
+	watchInterval    time.Duration
+	watchMaxWait     time.Duration
+	watchMaxWatchers int
+
+	// singleRequestGet serves plain GET requests without looking up the attributes.
+	singleRequestGet bool
+
+	// requiredHeaders is the canonical response headers that WithSingleRequestGet must serve.
+	requiredHeaders []string
+
+	// the configuration of WithRangeCoalescing.
+	// zero coalesceMaxDelay means the range coalescing is disabled.
+	coalesceMaxGap   int64
+	coalesceMaxSize  int64
+	coalesceMaxDelay time.Duration
+
+	// the configuration of WithParallelDownload.
+	parallelChunkSize int64
+	parallelWorkers   int
+
+	// the configuration of WithRetry.
+	// retryMaxAttempts less than 2 means disabled.
+	retryMaxAttempts int
+	retryBackoff     time.Duration
+
+	// writeClient is the storage client of the write requests, given by WithWriteClient.
+	// splitClients is true if WithWriteClient is given to the Transport or any bucket.
+	writeClient  storageClient
+	splitClients bool
+
+	// rewriteProgress is called with the progress of changing storage classes.
+	rewriteProgress func(bucket, object string, copiedBytes, totalBytes uint64)
+
+	// the limits of the custom metadata of WithMetadataLimits. zero means the default.
+	metadataMaxValueBytes int
+	metadataMaxTotalBytes int
+
+	// bulkDelete accepts the DELETE requests of prefixes.
+	bulkDelete bool
+
+	// maxUploadSize is the limit of the size of uploads. zero means unlimited.
+	maxUploadSize int64
+
+	// the configuration of WithWriteRetry.
+	// writeRetryMaxAttempts less than 2 means disabled.
+	writeRetryMaxAttempts int
+	writeRetryBackoff     time.Duration
+
+	// resumeRetries is the number of the resumptions of a response body.
+	// zero means defaultResumeRetries, and negative means disabled.
+	resumeRetries int
+
+	// retryGenerationRace retries reading the live generation
+	// if the generation pinned by Attrs is deleted before NewReader.
+	retryGenerationRace bool
+
+	// the configuration of shadow reads.
+	shadowBuckets       map[string]string
+	shadowSampleRate    float64
+	shadowComparator    ShadowComparator
+	shadowMaxConcurrent int
+	shadowTimeout       time.Duration
+
+	// budget is the budget of operations configured by WithOperationBudget.
+	budget *OperationBudget
+
+	// cacheSubscribers are notified of the writes.
+	cacheSubscribers []CacheSubscriber
+
+	// requestRecorder is called with the record of each request.
+	requestRecorder func(record *RequestRecord)
+
+	// requestTimeout limits the time to get the response headers.
+	requestTimeout time.Duration
+
+	// idleReadTimeout aborts the response body if a Read waits for Google Cloud Storage for the duration.
+	idleReadTimeout time.Duration
+
+	// readProgressTimeout aborts the response body if it is not read for the duration.
+	readProgressTimeout time.Duration
+
+	// generationCache is shared by the Transports.
+	generationCache *GenerationCache
+
+	// the configuration of WithHeadMemo.
+	headMemoTTL        time.Duration
+	headMemoMaxEntries int
+
+	// the configuration of WithAttrsCache.
+	attrsCacheMaxEntries int
+	attrsCacheTTL        time.Duration
+
+	// the configuration of WithIdempotencyKeys.
+	idempotencyMaxEntries int
+	idempotencyTTL        time.Duration
+
+	// prefetchBytes is the size of the buffer of WithPrefetchBuffer.
+	prefetchBytes int
+
+	// writeMethods accepts the methods that modify objects.
+	writeMethods bool
+
+	// encryptionKeys is the customer-supplied encryption keys for reading objects.
+	encryptionKeys []encryptionKey
+
+	// buckets is the per-bucket overrides configured by WithBucketConfig.
+	buckets map[string]BucketConfig
+
+	// resolved is the configurations for each bucket resolved from buckets.
+	resolved map[string]*config
+}
+
+func newConfig(opts []Option) config {
+	var c config
+	for _, opt := range opts {
+		opt(&c)
+	}
+	c.resolveBuckets()
+	return c
+}
+
+// resolveBuckets layers the per-bucket overrides on top of the Transport defaults.
+func (c *config) resolveBuckets() {
+	if len(c.buckets) == 0 {
+		return
+	}
+	c.resolved = make(map[string]*config, len(c.buckets))
+	for bucket, opts := range c.buckets {
+		bc := *c
+		bc.buckets = nil
+		bc.resolved = nil
+		for _, opt := range opts {
+			opt(&bc)
+		}
+
+		// BucketConfig can't be nested.
+		bc.buckets = nil
+		c.resolved[bucket] = &bc
+		if bc.splitClients {
+			c.splitClients = true
+		}
+	}
+}
+
+// forBucket returns the configuration for the bucket.
+func (c *config) forBucket(bucket string) *config {
+	if bc, ok := c.resolved[bucket]; ok {
+		return bc
+	}
+	return c
+}
+
+// BucketConfig is a list of Options that override the Transport defaults for a specific bucket.
+type BucketConfig []Option
+
+// WithBucketConfig overrides the Transport defaults for the bucket.
+// The Options in cfg are applied on top of all the other Options of the Transport,
+// regardless of their order.
+// If WithBucketConfig is given more than once for the same bucket, the Options are concatenated in order.
+// WithBucketConfig in a BucketConfig is ignored.
+func WithBucketConfig(bucket string, cfg BucketConfig) Option {
+	return func(c *config) {
+		if c.buckets == nil {
+			c.buckets = make(map[string]BucketConfig)
+		}
+		c.buckets[bucket] = append(c.buckets[bucket], cfg...)
+	}
+}
+
+// WithSymlinks configures how the Transport handles symlink objects created by gcsfuse.
+// The default is SymlinkNone.
+func WithSymlinks(mode SymlinkMode) Option {
+	return func(c *config) {
+		c.symlinkMode = mode
+	}
+}
+
+// WithImmutableCacheControl makes the Transport respond with
+// "Cache-Control: public, max-age=31536000, immutable" to the requests that pin a specific generation,
+// because the content of a generation never changes.
+// The Cache-Control of the object is kept if it has the private or no-store directive, unless force is true.
+// The requests for the live object are not affected,
+// nor are the pinned symlinks that SymlinkFollow serves the live targets of.
+func WithImmutableCacheControl(force bool) Option {
+	return func(c *config) {
+		c.immutableCacheControl = true
+		c.forceImmutableCacheControl = force
+	}
+}
+
+// WithoutImmutableCacheControl cancels WithImmutableCacheControl.
+// It is useful to disable the override for a specific bucket by WithBucketConfig.
+func WithoutImmutableCacheControl() Option {
+	return func(c *config) {
+		c.immutableCacheControl = false
+		c.forceImmutableCacheControl = false
+	}
+}
+
+// defaultMaxMetadataBytes is the default limit of the total size of x-goog-meta-* headers.
+const defaultMaxMetadataBytes = 8 << 10
+
+// WithMaxMetadataHeaderBytes limits the total size of x-goog-meta-* headers in a response.
+// If the metadata of an object exceeds the limit, the largest entries are dropped
+// and "x-goog-meta-truncated: true" is added.
+// The size of an entry is the length of its header name and its value.
+// The default is 8 KiB. Zero or negative n disables the limit.
+func WithMaxMetadataHeaderBytes(n int) Option {
+	return func(c *config) {
+		if n <= 0 {
+			n = -1
+		}
+		c.maxMetadataBytes = n
+	}
+}
+
+// WithGzipDecompression enables the "decompress=gzip" query parameter,
+// e.g. gs://[BUCKET_NAME]/[OBJECT_NAME]?decompress=gzip.
+// The Transport decompresses gzip-compressed objects that have no Content-Encoding,
+// such as "data.json.gz", and responds with the decompressed content.
+// The Content-Type is derived from the object name without the ".gz" extension.
+func WithGzipDecompression() Option {
+	return func(c *config) {
+		c.gzipDecompression = true
+	}
+}
+
+// WithoutGzipDecompression cancels WithGzipDecompression.
+// It is useful to disable the decompression for a specific bucket by WithBucketConfig.
+func WithoutGzipDecompression() Option {
+	return func(c *config) {
+		c.gzipDecompression = false
+	}
+}
+
+// WithArchiveLimits limits the archives of prefixes, e.g. gs://[BUCKET_NAME]/[PREFIX]?archive=tar.
+// The Transport responds 413 Request Entity Too Large if the archive has more than maxEntries objects
+// or the total size of the objects is more than maxBytes.
+// The defaults are 1000 entries and 1 GiB. Zero or negative values mean the defaults.
+func WithArchiveLimits(maxEntries int, maxBytes int64) Option {
+	return func(c *config) {
+		c.archiveMaxEntries = maxEntries
+		c.archiveMaxBytes = maxBytes
+	}
+}
+
+// WithLongPoll enables long-polling requests, e.g. gs://[BUCKET_NAME]/[OBJECT_NAME]?wait=30s.
+//
+// A long-polling GET request with the If-None-Match or x-goog-if-generation-not-match header
+// is held until the object changes or the wait elapses.
+// The Transport checks the object every interval, and responds 200 OK as soon as it changes,
+// or 304 Not Modified if it doesn't change.
+// The wait is capped at maxWait.
+// If there are maxWatchers long-polling requests already, the Transport responds 429 Too Many Requests.
+func WithLongPoll(interval, maxWait time.Duration, maxWatchers int) Option {
+	return func(c *config) {
+		c.watchInterval = interval
+		c.watchMaxWait = maxWait
+		c.watchMaxWatchers = maxWatchers
+	}
+}
+
+// WithGenerationRaceRetry makes the Transport retry once against the live generation,
+// if the generation of the object is deleted between getting its attributes and reading its content.
+// Without this option, the Transport responds 409 Conflict naming both generations.
+func WithGenerationRaceRetry() Option {
+	return func(c *config) {
+		c.retryGenerationRace = true
+	}
+}
+
+// WithCombinedHashHeader makes the Transport respond with a single x-goog-hash header
+// whose value is the comma-separated list of the hashes, e.g. "x-goog-hash: crc32c=n03x6A==,md5=Ojk9c3dhfxgoKVVHYwFbHQ==",
+// for the parsers that take only the first header.
+// By default, each hash has its own x-goog-hash header. The order is crc32c and then md5 in both cases.
+func WithCombinedHashHeader() Option {
+	return func(c *config) {
+		c.combinedHashHeader = true
+	}
+}
+
+// WithStrictConditionals makes the Transport respond 400 Bad Request to the requests
+// with self-contradictory conditional headers, such as If-Match and If-None-Match with the same ETag,
+// or If-Unmodified-Since earlier than If-Modified-Since.
+// The body of the response names the conflicting headers.
+// By default, the headers are evaluated in the order of RFC 9110, regardless of the conflicts.
+func WithStrictConditionals() Option {
+	return func(c *config) {
+		c.strictConditionals = true
+	}
+}
+
+// defaultSchemes is the schemes served by default.
+var defaultSchemes = []string{"gs", "gcs"}
+
+// WithSchemes sets the schemes of the URLs that the Transport serves, e.g. WithSchemes("gs", "artifact"),
+// and Transport.RegisterProtocols registers the Transport for them.
+// The Transport responds 400 Bad Request to the URLs of the other schemes.
+// The default is gs and gcs.
+// WithSchemes in a BucketConfig is ignored.
+func WithSchemes(schemes ...string) Option {
+	return func(c *config) {
+		c.schemes = append([]string(nil), schemes...)
+	}
+}
+
+// servedSchemes returns the schemes that the Transport serves.
+func (c *config) servedSchemes() []string {
+	if c.schemes == nil {
+		return defaultSchemes
+	}
+	return c.schemes
+}
+
+// WithAnyScheme makes the Transport serve the URLs of any scheme, e.g. https://[BUCKET_NAME]/[OBJECT_NAME],
+// as if they were gs:// URLs.
+// It is useful to intercept the requests to other schemes.
+// By default, the Transport responds 400 Bad Request to the URLs other than gs:// and gcs://, or the schemes of WithSchemes,
+// in case it is used as the Transport of http.Client by mistake, instead of registered by http.Transport.RegisterProtocol.
+// WithAnyScheme in a BucketConfig is ignored.
+func WithAnyScheme() Option {
+	return func(c *config) {
+		c.anyScheme = true
+	}
+}
+
+// WithOwnHTTPClient makes NewTransportWithOptions create the HTTP client of the storage client by itself,
+// from the option.ClientOption given, so that Transport.CloseIdleConnections closes its idle connections.
+// By default, storage.NewClient creates the HTTP client, and the Transport can't reach its connections.
+// It has no effect if the storage client dials by itself,
+// e.g. with the emulator, the client certificates or option.WithHTTPClient.
+// WithOwnHTTPClient in a BucketConfig is ignored.
+func WithOwnHTTPClient() Option {
+	return func(c *config) {
+		c.ownHTTPClient = true
+	}
+}
+
+// WithStorageHTTPClient makes NewTransportWithOptions create the storage client with hc, like option.WithHTTPClient,
+// and Transport.CloseIdleConnections close the idle connections of hc.
+// hc must be authenticated for Google Cloud Storage.
+// WithStorageHTTPClient in a BucketConfig is ignored.
+func WithStorageHTTPClient(hc *http.Client) Option {
+	return func(c *config) {
+		c.storageHTTPClient = hc
+	}
+}
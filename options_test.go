@@ -0,0 +1,191 @@
+package gsprotocol
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+func TestNewTransportWithOptions(t *testing.T) {
+	ctx := context.Background()
+
+	// the zero config is the default behavior.
+	tr, err := NewTransport(ctx, option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.client.Close()
+	if !reflect.DeepEqual(tr.config, config{}) {
+		t.Errorf("want the zero config, got %#v", tr.config)
+	}
+
+	tr, err = NewTransportWithOptions(ctx, []option.ClientOption{option.WithoutAuthentication()}, WithGzipDecompression())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.client.Close()
+	if !tr.config.gzipDecompression {
+		t.Error("want gzipDecompression, got disabled")
+	}
+}
+
+func TestNewTransportWithStorage(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs:   &storage.ObjectAttrs{Generation: 1234567890},
+			content: "Hello Google Cloud Storage!",
+		},
+	})
+	gs := NewTransportWithStorage(mock, WithSchemes("gs"))
+	if gs.Client() != nil {
+		t.Error("want no underlying client")
+	}
+	c := &http.Client{Transport: gs}
+
+	resp, err := c.Get("gs://bucket-name/object-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "Hello Google Cloud Storage!" {
+		t.Errorf("unexpected body: %q", body)
+	}
+}
+
+func TestBucketConfig(t *testing.T) {
+	tc := []struct {
+		name   string
+		opts   []Option
+		bucket string
+		want   config
+	}{
+		{
+			name:   "defaults",
+			opts:   nil,
+			bucket: "assets",
+			want:   config{},
+		},
+		{
+			name:   "transport option",
+			opts:   []Option{WithGzipDecompression()},
+			bucket: "assets",
+			want:   config{gzipDecompression: true},
+		},
+		{
+			name: "bucket option",
+			opts: []Option{
+				WithBucketConfig("assets", BucketConfig{WithGzipDecompression()}),
+			},
+			bucket: "assets",
+			want:   config{gzipDecompression: true},
+		},
+		{
+			name: "other bucket",
+			opts: []Option{
+				WithBucketConfig("assets", BucketConfig{WithGzipDecompression()}),
+			},
+			bucket: "data",
+			want:   config{},
+		},
+		{
+			name: "bucket option disables transport option",
+			opts: []Option{
+				WithGzipDecompression(),
+				WithImmutableCacheControl(true),
+				WithBucketConfig("data", BucketConfig{WithoutGzipDecompression(), WithoutImmutableCacheControl()}),
+			},
+			bucket: "data",
+			want:   config{},
+		},
+		{
+			name: "bucket option wins regardless of order",
+			opts: []Option{
+				WithBucketConfig("assets", BucketConfig{WithSymlinks(SymlinkRedirect), WithMaxMetadataHeaderBytes(1024)}),
+				WithSymlinks(SymlinkFollow),
+				WithMaxMetadataHeaderBytes(0),
+			},
+			bucket: "assets",
+			want:   config{symlinkMode: SymlinkRedirect, maxMetadataBytes: 1024},
+		},
+		{
+			name: "inherits transport option",
+			opts: []Option{
+				WithSymlinks(SymlinkFollow),
+				WithBucketConfig("assets", BucketConfig{WithImmutableCacheControl(false)}),
+			},
+			bucket: "assets",
+			want:   config{symlinkMode: SymlinkFollow, immutableCacheControl: true},
+		},
+		{
+			name: "concatenated",
+			opts: []Option{
+				WithBucketConfig("assets", BucketConfig{WithImmutableCacheControl(false), WithMaxMetadataHeaderBytes(1024)}),
+				WithBucketConfig("assets", BucketConfig{WithImmutableCacheControl(true)}),
+			},
+			bucket: "assets",
+			want:   config{immutableCacheControl: true, forceImmutableCacheControl: true, maxMetadataBytes: 1024},
+		},
+		{
+			name: "nested",
+			opts: []Option{
+				WithBucketConfig("assets", BucketConfig{
+					WithBucketConfig("data", BucketConfig{WithGzipDecompression()}),
+				}),
+			},
+			bucket: "data",
+			want:   config{},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConfig(tt.opts)
+			got := *c.forBucket(tt.bucket)
+			got.buckets = nil
+			got.resolved = nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("want %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRoundTrip_BucketConfig(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"assets/object-key": {
+			attrs:   &storage.ObjectAttrs{CacheControl: "public, max-age=60", Generation: 1234567890},
+			content: "Hello Google Cloud Storage!",
+		},
+		"data/object-key": {
+			attrs:   &storage.ObjectAttrs{CacheControl: "public, max-age=60", Generation: 1234567890},
+			content: "Hello Google Cloud Storage!",
+		},
+	})
+	c := newTestClient(mock, WithBucketConfig("assets", BucketConfig{WithImmutableCacheControl(false)}))
+
+	tc := []struct {
+		url  string
+		want string
+	}{
+		{"gs://assets/object-key#1234567890", immutableCacheControl},
+		{"gs://data/object-key#1234567890", "public, max-age=60"},
+	}
+	for _, tt := range tc {
+		resp, err := c.Get(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("Cache-Control"); got != tt.want {
+			t.Errorf("%s: unexpected Cache-Control: want %q, got %q", tt.url, tt.want, got)
+		}
+	}
+}
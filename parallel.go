@@ -0,0 +1,149 @@
+package gsprotocol
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+)
+
+// WithParallelDownload makes the Transport download the large objects by workers range readers in parallel,
+// each of which reads a chunk of chunkSize bytes.
+// The chunks are written to the response body in order, and at most workers chunks are buffered,
+// so the memory use of a request is bounded to workers * chunkSize bytes.
+// If any chunk fails, reading the response body returns the error.
+//
+// The objects of chunkSize bytes or smaller, the requests with the Range header,
+// and the objects that are not served by ranges, e.g. with gzip decompression, are downloaded by one reader.
+// It is disabled by default, and zero or negative chunkSize, or workers less than 2 disables it.
+func WithParallelDownload(chunkSize int64, workers int) Option {
+	return func(c *config) {
+		c.parallelChunkSize = chunkSize
+		c.parallelWorkers = workers
+	}
+}
+
+// parallelDownload reports whether the Transport downloads the object in parallel for the request.
+func (c *config) parallelDownload(req *http.Request, attrs *storage.ObjectAttrs, decompress string) bool {
+	if c.parallelChunkSize <= 0 || c.parallelWorkers < 2 {
+		return false
+	}
+	if _, ok := req.Header["Range"]; ok {
+		return false
+	}
+	return attrs.Size > c.parallelChunkSize && rangeSupported(attrs, decompress)
+}
+
+// parallelBody is the response body of a parallel download.
+type parallelBody struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+// Close aborts the download.
+func (b *parallelBody) Close() error {
+	b.cancel()
+	return b.PipeReader.Close()
+}
+
+// chunkResult is a chunk downloaded.
+type chunkResult struct {
+	buf []byte
+	err error
+}
+
+// newParallelBody returns the body that downloads the object of size bytes in chunks of chunkSize bytes by workers readers.
+// first is the reader of the first chunk.
+// The object must be pinned to a generation, so that the chunks are of the same generation.
+func newParallelBody(ctx context.Context, object objectHandle, first storageReader, size, chunkSize int64, workers int) io.ReadCloser {
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+
+	// a buffer is taken before downloading a chunk, and returned after writing it to the body.
+	bufs := make(chan []byte, workers)
+	for i := 0; i < workers; i++ {
+		bufs <- nil
+	}
+	// the results in the order of the chunks.
+	queue := make(chan chan chunkResult, workers)
+
+	go func() {
+		defer close(queue)
+		var r io.ReadCloser = first
+		defer func() {
+			if r != nil {
+				r.Close()
+			}
+		}()
+		for offset := int64(0); offset < size; offset += chunkSize {
+			var buf []byte
+			select {
+			case buf = <-bufs:
+			case <-ctx.Done():
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			length := chunkSize
+			if size-offset < length {
+				length = size - offset
+			}
+			if buf == nil {
+				buf = make([]byte, chunkSize)
+			}
+			ch := make(chan chunkResult, 1)
+			queue <- ch
+			go func(r io.ReadCloser, buf []byte, offset, length int64) {
+				ch <- downloadChunk(ctx, object, r, buf[:length], offset)
+			}(r, buf, offset, length)
+			r = nil
+		}
+	}()
+
+	go func() {
+		defer cancel()
+		var err error
+		for ch := range queue {
+			res := <-ch
+			if err == nil {
+				err = res.err
+			}
+			if err == nil {
+				_, err = pw.Write(res.buf)
+			}
+			if err != nil {
+				// abort the others, and drain the queue.
+				cancel()
+			}
+			bufs <- res.buf[:cap(res.buf)]
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return &parallelBody{
+		PipeReader: pr,
+		cancel:     cancel,
+	}
+}
+
+// downloadChunk reads the chunk at offset into buf by r, or a new range reader if r is nil.
+func downloadChunk(ctx context.Context, object objectHandle, r io.ReadCloser, buf []byte, offset int64) chunkResult {
+	if r == nil {
+		var err error
+		r, err = object.NewRangeReader(ctx, offset, int64(len(buf)))
+		if err != nil {
+			return chunkResult{buf: buf, err: err}
+		}
+	}
+	defer r.Close()
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return chunkResult{buf: buf, err: fmt.Errorf("gsprotocol: failed to read the chunk at %d: %w", offset, err)}
+	}
+	return chunkResult{buf: buf}
+}
@@ -0,0 +1,225 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// parallelTestContent is the content of the object in the tests of the parallel downloads.
+var parallelTestContent = strings.Repeat("0123456789abcdef", 6) + "0123"
+
+// readerTracker counts the readers opened and the ones open at the same time.
+type readerTracker struct {
+	mu      sync.Mutex
+	opened  int
+	open    int
+	maxOpen int
+
+	// failAt makes the failAt-th reader fail. zero means never.
+	failAt int
+}
+
+func (tr *readerTracker) newReaderFunc(content string) func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+	return func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+		tr.mu.Lock()
+		defer tr.mu.Unlock()
+		tr.opened++
+		if tr.failAt > 0 && tr.opened == tr.failAt {
+			return storage.ReaderObjectAttrs{}, nil, errors.New("connection reset")
+		}
+		tr.open++
+		if tr.open > tr.maxOpen {
+			tr.maxOpen = tr.open
+		}
+		return storage.ReaderObjectAttrs{
+			Size:       int64(len(content)),
+			Generation: mock.generation,
+		}, &trackedReader{Reader: strings.NewReader(content), tracker: tr}, nil
+	}
+}
+
+type trackedReader struct {
+	io.Reader
+	tracker *readerTracker
+	once    sync.Once
+}
+
+func (r *trackedReader) Close() error {
+	r.once.Do(func() {
+		// keep the reader open for a while, so that the readers overlap.
+		time.Sleep(time.Millisecond)
+		r.tracker.mu.Lock()
+		defer r.tracker.mu.Unlock()
+		r.tracker.open--
+	})
+	return nil
+}
+
+// newParallelTestObject returns an object of parallelTestContent whose readers are tracked by tracker.
+func newParallelTestObject(tracker *readerTracker) *objectHandleMock {
+	attrs := &storage.ObjectAttrs{
+		Bucket:         "bucket-name",
+		Name:           "object-key",
+		Size:           int64(len(parallelTestContent)),
+		Generation:     1587160158394554,
+		Metageneration: 1,
+	}
+	return &objectHandleMock{
+		attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+			return attrs, nil
+		},
+		newReaderFunc:  tracker.newReaderFunc(parallelTestContent),
+		generationFunc: withGeneration,
+	}
+}
+
+func TestRoundTrip_ParallelDownload(t *testing.T) {
+	var tracker readerTracker
+	tr := newTestTransport(newSingleObjectMock(newParallelTestObject(&tracker)), WithParallelDownload(16, 3))
+	req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if string(body) != parallelTestContent {
+		t.Errorf("unexpected body: want %q, got %q", parallelTestContent, body)
+	}
+	if resp.ContentLength != int64(len(parallelTestContent)) {
+		t.Errorf("unexpected content length: want %d, got %d", len(parallelTestContent), resp.ContentLength)
+	}
+	if tracker.opened != 7 {
+		t.Errorf("want 7 chunks, got %d", tracker.opened)
+	}
+	if tracker.maxOpen > 3 {
+		t.Errorf("want at most 3 readers at the same time, got %d", tracker.maxOpen)
+	}
+}
+
+func TestRoundTrip_ParallelDownloadError(t *testing.T) {
+	tracker := readerTracker{failAt: 3}
+	tr := newTestTransport(newSingleObjectMock(newParallelTestObject(&tracker)), WithParallelDownload(16, 3))
+	req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err == nil || !strings.Contains(err.Error(), "connection reset") {
+		t.Errorf("want the error of the chunk, got %v", err)
+	}
+	// the chunks are opened concurrently, so the chunk that fails is any of the first three.
+	if len(body)%16 != 0 || len(body) > 32 {
+		t.Errorf("want the chunks before the error, got %d bytes", len(body))
+	}
+}
+
+func TestRoundTrip_ParallelDownloadDisabled(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Option
+		header http.Header
+	}{
+		{
+			name: "small object",
+			opts: []Option{WithParallelDownload(int64(len(parallelTestContent)), 3)},
+		},
+		{
+			name:   "range",
+			opts:   []Option{WithParallelDownload(16, 3)},
+			header: http.Header{"Range": {"bytes=0-"}},
+		},
+		{
+			name: "one worker",
+			opts: []Option{WithParallelDownload(16, 1)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tracker readerTracker
+			tr := newTestTransport(newSingleObjectMock(newParallelTestObject(&tracker)), tt.opts...)
+			req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/object-key", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, values := range tt.header {
+				req.Header[key] = values
+			}
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != parallelTestContent {
+				t.Errorf("unexpected body: want %q, got %q", parallelTestContent, body)
+			}
+			if tracker.opened != 1 {
+				t.Errorf("want one reader, got %d", tracker.opened)
+			}
+		})
+	}
+}
+
+func TestParallelBody_Close(t *testing.T) {
+	var tracker readerTracker
+	object := &objectHandleMock{
+		newReaderFunc: tracker.newReaderFunc(parallelTestContent),
+	}
+	first, err := object.NewRangeReader(context.Background(), 0, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := newParallelBody(context.Background(), object, first, int64(len(parallelTestContent)), 16, 2)
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(body, buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := body.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := body.Read(buf); err != io.ErrClosedPipe {
+		t.Errorf("want io.ErrClosedPipe, got %v", err)
+	}
+
+	// all the readers are closed eventually.
+	deadline := time.Now().Add(time.Second)
+	for {
+		tracker.mu.Lock()
+		open := tracker.open
+		tracker.mu.Unlock()
+		if open == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d readers are left open", open)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,61 @@
+package gsprotocol
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"cloud.google.com/go/storage"
+)
+
+type unpinnedKey struct{}
+
+// WithoutGenerationPin returns a copy of ctx that makes the Transport read the live object
+// without pinning the generation that its attributes are fetched from.
+// Use it with http.Request.WithContext.
+//
+// By default, the Transport reads the generation it got the attributes of,
+// so that the headers and the content of a response always describe the same generation.
+// Without the pin, the object may be overwritten between the two calls.
+// In that case, the x-goog-generation, x-goog-metageneration, Content-Length, Content-Type and Last-Modified headers
+// are taken from the generation actually read, and the ETag and x-goog-hash headers are dropped
+// because they belong to the other generation.
+// The requests for a specific generation, e.g. gs://[BUCKET_NAME]/[OBJECT_NAME]#[GENERATION], are not affected.
+func WithoutGenerationPin(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unpinnedKey{}, true)
+}
+
+func isUnpinned(ctx context.Context) bool {
+	unpinned, _ := ctx.Value(unpinnedKey{}).(bool)
+	return unpinned
+}
+
+// unpinnedHeader updates header with the attributes of the generation actually read,
+// if it differs from attrs.
+// It returns the size of the generation read.
+func unpinnedHeader(header http.Header, attrs *storage.ObjectAttrs, r storage.ReaderObjectAttrs) int64 {
+	if r.Generation == 0 || r.Generation == attrs.Generation {
+		return attrs.Size
+	}
+
+	header.Set("x-goog-generation", strconv.FormatInt(r.Generation, 10))
+	header.Del("x-goog-metageneration")
+	if r.Metageneration != 0 {
+		header.Set("x-goog-metageneration", strconv.FormatInt(r.Metageneration, 10))
+	}
+	if header.Get("Content-Length") != "" {
+		header.Set("Content-Length", strconv.FormatInt(r.Size, 10))
+	}
+	if header.Get("x-goog-stored-content-length") != "" {
+		header.Set("x-goog-stored-content-length", strconv.FormatInt(r.Size, 10))
+	}
+	if r.ContentType != "" && header.Get("Content-Type") != "" {
+		header.Set("Content-Type", r.ContentType)
+	}
+	if !r.LastModified.IsZero() {
+		header.Set("Last-Modified", r.LastModified.Format(http.TimeFormat))
+	}
+	header.Del("ETag")
+	header.Del("x-goog-hash")
+	return r.Size
+}
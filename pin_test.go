@@ -0,0 +1,77 @@
+package gsprotocol
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestRoundTrip_WithoutGenerationPin(t *testing.T) {
+	// the object is overwritten between Attrs and NewReader.
+	object := &objectHandleMock{
+		attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+			return &storage.ObjectAttrs{
+				Bucket:         "bucket-name",
+				Name:           "object-key",
+				ContentType:    "text/plain",
+				Size:           3,
+				MD5:            []byte{0x01, 0x02},
+				Generation:     1,
+				Metageneration: 1,
+			}, nil
+		},
+		newReaderFunc: func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+			if mock.generation != 0 {
+				t.Errorf("the generation is pinned: %d", mock.generation)
+			}
+			content := "new content"
+			return storage.ReaderObjectAttrs{
+				ContentType:    "text/plain",
+				Size:           int64(len(content)),
+				Generation:     2,
+				Metageneration: 1,
+			}, io.NopCloser(strings.NewReader(content)), nil
+		},
+	}
+	c := &http.Client{Transport: newTestTransport(newSingleObjectMock(object))}
+
+	req, err := http.NewRequestWithContext(WithoutGenerationPin(context.Background()), http.MethodGet, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if got := resp.Header.Get("x-goog-generation"); got != "2" {
+		t.Errorf("unexpected x-goog-generation: want %q, got %q", "2", got)
+	}
+	if got := resp.Header.Get("Content-Length"); got != "11" {
+		t.Errorf("unexpected Content-Length: want %q, got %q", "11", got)
+	}
+	if resp.ContentLength != 11 {
+		t.Errorf("unexpected ContentLength: want %d, got %d", 11, resp.ContentLength)
+	}
+	if got := resp.Header.Get("ETag"); got != "" {
+		t.Errorf("want no ETag, got %q", got)
+	}
+	if got := resp.Header.Values("x-goog-hash"); len(got) != 0 {
+		t.Errorf("want no x-goog-hash, got %q", got)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "new content" {
+		t.Errorf("want %q, got %q", "new content", string(body))
+	}
+}
@@ -0,0 +1,127 @@
+package gsprotocol
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// prefetchChunkSize is the maximum size of a read from Google Cloud Storage by prefetchBody.
+const prefetchChunkSize = 32 << 10
+
+// errPrefetchBodyClosed is returned from a closed prefetchBody.
+var errPrefetchBodyClosed = errors.New("gsprotocol: read on closed response body")
+
+// WithPrefetchBuffer makes the Transport read the response body ahead up to n bytes in the background,
+// while the caller reads it at its own pace.
+// It improves the throughput for the callers that process the data slower than Google Cloud Storage delivers it.
+// The buffer never holds more than n bytes that the caller hasn't read yet.
+// An error of Google Cloud Storage is returned after the caller reads all the data before it.
+// Zero or negative n disables the prefetch. It is disabled by default.
+func WithPrefetchBuffer(n int) Option {
+	return func(c *config) {
+		c.prefetchBytes = n
+	}
+}
+
+// prefetchBody reads body ahead into a ring buffer.
+type prefetchBody struct {
+	body io.ReadCloser
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    []byte
+	start  int // the position of the first unread byte
+	size   int // the number of the unread bytes
+	err    error
+	closed bool
+}
+
+func newPrefetchBody(body io.ReadCloser, n int) *prefetchBody {
+	b := &prefetchBody{
+		body: body,
+		buf:  make([]byte, n),
+	}
+	b.cond = sync.NewCond(&b.mu)
+	go b.prefetch()
+	return b
+}
+
+// prefetch reads body until an error occurs or b is closed, and then closes body.
+func (b *prefetchBody) prefetch() {
+	defer b.body.Close()
+
+	chunk := make([]byte, min(len(b.buf), prefetchChunkSize))
+	for {
+		b.mu.Lock()
+		for b.size == len(b.buf) && !b.closed {
+			b.cond.Wait()
+		}
+		if b.closed {
+			b.mu.Unlock()
+			return
+		}
+		free := len(b.buf) - b.size
+		b.mu.Unlock()
+
+		n, err := b.body.Read(chunk[:min(free, len(chunk))])
+
+		b.mu.Lock()
+		end := (b.start + b.size) % len(b.buf)
+		m := copy(b.buf[end:], chunk[:n])
+		copy(b.buf, chunk[m:n])
+		b.size += n
+		if err != nil {
+			b.err = err
+		}
+		b.cond.Broadcast()
+		b.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (b *prefetchBody) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.size == 0 && b.err == nil && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed {
+		return 0, errPrefetchBodyClosed
+	}
+	if b.size == 0 {
+		return 0, b.err
+	}
+
+	n := copy(p, b.buf[b.start:min(b.start+b.size, len(b.buf))])
+	if n < len(p) && n < b.size {
+		n += copy(p[n:], b.buf[:b.size-n])
+	}
+	b.start = (b.start + n) % len(b.buf)
+	b.size -= n
+	b.cond.Broadcast()
+	return n, nil
+}
+
+// Close stops prefetching without waiting for the in-flight read of body.
+// body is closed by the prefetching goroutine.
+func (b *prefetchBody) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+	return nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
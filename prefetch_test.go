@@ -0,0 +1,187 @@
+package gsprotocol
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// countingReader counts the bytes read, and sleeps latency on each read.
+type countingReader struct {
+	r       io.Reader
+	latency time.Duration
+
+	mu     sync.Mutex
+	n      int
+	closed bool
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	time.Sleep(r.latency)
+	n, err := r.r.Read(p)
+	r.mu.Lock()
+	r.n += n
+	r.mu.Unlock()
+	return n, err
+}
+
+func (r *countingReader) Close() error {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *countingReader) count() (int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.n, r.closed
+}
+
+func TestPrefetchBody(t *testing.T) {
+	data := make([]byte, 100_000)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	for _, size := range []int{1, 7, 1000, 1 << 20} {
+		r := &countingReader{r: bytes.NewReader(data)}
+		b := newPrefetchBody(r, size)
+
+		// read with odd sizes, so that the reads wrap around the ring buffer.
+		var got []byte
+		buf := make([]byte, 13)
+		for {
+			n, err := b.Read(buf)
+			got = append(got, buf[:n]...)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("size %d: the data is corrupted", size)
+		}
+		b.Close()
+	}
+}
+
+func TestPrefetchBody_Bounded(t *testing.T) {
+	r := &countingReader{r: bytes.NewReader(make([]byte, 100_000))}
+	b := newPrefetchBody(r, 1000)
+	defer b.Close()
+
+	if _, err := io.ReadFull(b, make([]byte, 500)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n, _ := r.count(); n > 1500 {
+		t.Errorf("read ahead too much: %d bytes", n)
+	}
+}
+
+func TestPrefetchBody_Error(t *testing.T) {
+	errUpstream := errors.New("upstream error")
+	r := &failingReader{r: bytes.NewReader([]byte("0123456789")), err: errUpstream}
+	b := newPrefetchBody(io.NopCloser(r), 4)
+	defer b.Close()
+
+	got, err := io.ReadAll(b)
+	if string(got) != "0123456789" {
+		t.Errorf("want %q, got %q", "0123456789", string(got))
+	}
+	if !errors.Is(err, errUpstream) {
+		t.Errorf("want errUpstream, got %v", err)
+	}
+}
+
+func TestPrefetchBody_Close(t *testing.T) {
+	r := &countingReader{r: bytes.NewReader(make([]byte, 100_000))}
+	b := newPrefetchBody(r, 1000)
+	b.Close()
+
+	if _, err := b.Read(make([]byte, 10)); !errors.Is(err, errPrefetchBodyClosed) {
+		t.Errorf("want errPrefetchBodyClosed, got %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, closed := r.count(); closed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the body is not closed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// newPrefetchTestObject returns an object of size bytes whose reader waits for latency on every read.
+func newPrefetchTestObject(size int, latency time.Duration) *objectHandleMock {
+	return &objectHandleMock{
+		attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+			return &storage.ObjectAttrs{Generation: 1, Size: int64(size)}, nil
+		},
+		newReaderFunc: func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+			r := &countingReader{r: bytes.NewReader(make([]byte, size)), latency: latency}
+			return storage.ReaderObjectAttrs{Generation: 1, Size: int64(size)}, r, nil
+		},
+		generationFunc: func(mock *objectHandleMock, gen int64) *objectHandleMock {
+			return mock
+		},
+	}
+}
+
+func TestRoundTrip_PrefetchBuffer(t *testing.T) {
+	c := newTestClient(newSingleObjectMock(newPrefetchTestObject(100_000, 0)), WithPrefetchBuffer(4096))
+	resp, err := c.Get("gs://bucket-name/object-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 100_000 {
+		t.Errorf("want %d bytes, got %d bytes", 100_000, len(got))
+	}
+}
+
+func benchmarkSlowConsumer(b *testing.B, opts ...Option) {
+	const size = 64 << 10
+	c := newTestClient(newSingleObjectMock(newPrefetchTestObject(size, time.Millisecond)), opts...)
+	buf := make([]byte, 4096)
+	for i := 0; i < b.N; i++ {
+		resp, err := c.Get("gs://bucket-name/object-key")
+		if err != nil {
+			b.Fatal(err)
+		}
+		for {
+			_, err := resp.Body.Read(buf)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+			// process the data slowly.
+			time.Sleep(time.Millisecond)
+		}
+		resp.Body.Close()
+	}
+}
+
+func BenchmarkSlowConsumer(b *testing.B) {
+	benchmarkSlowConsumer(b)
+}
+
+func BenchmarkSlowConsumer_PrefetchBuffer(b *testing.B) {
+	benchmarkSlowConsumer(b, WithPrefetchBuffer(64<<10))
+}
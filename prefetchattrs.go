@@ -0,0 +1,102 @@
+package gsprotocol
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// defaultPrefetchConcurrency is the number of the concurrent lookups of Prefetch by default.
+const defaultPrefetchConcurrency = 8
+
+// PrefetchOption configures Transport.Prefetch.
+type PrefetchOption func(*prefetchConfig)
+
+type prefetchConfig struct {
+	concurrency int
+}
+
+// WithPrefetchConcurrency limits the number of the concurrent lookups of Transport.Prefetch to n.
+// The default is 8.
+func WithPrefetchConcurrency(n int) PrefetchOption {
+	return func(c *prefetchConfig) {
+		c.concurrency = n
+	}
+}
+
+// PrefetchResult is the result of Transport.Prefetch for a URL.
+type PrefetchResult struct {
+	// URL is the URL given to Prefetch.
+	URL string
+
+	// Cached reports whether the attributes of the object are in the attrs cache of WithAttrsCache.
+	// It is false if the attrs cache is disabled for the bucket.
+	Cached bool
+
+	// Err is the error of the lookup, e.g. the object is not found or the context is canceled.
+	Err error
+}
+
+// Prefetch looks up the attributes of the objects of urls, to warm the attrs cache of WithAttrsCache
+// before the requests for them, e.g. before a traffic spike.
+// The lookups are HEAD requests through the Transport, so they are limited by WithOperationBudget,
+// retried by WithRetry, and coalesced with the concurrent requests for the same objects.
+// The Transport has no cache of the bodies, so Prefetch doesn't read them.
+//
+// Prefetch returns the results in the order of urls.
+// If ctx is canceled, the URLs not looked up yet fail with the error of ctx.
+func (t *Transport) Prefetch(ctx context.Context, urls []string, opts ...PrefetchOption) []PrefetchResult {
+	cfg := prefetchConfig{concurrency: defaultPrefetchConcurrency}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.concurrency <= 0 {
+		cfg.concurrency = 1
+	}
+
+	results := make([]PrefetchResult, len(urls))
+	sem := make(chan struct{}, cfg.concurrency)
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		results[i].URL = u
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func(result *PrefetchResult) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			result.Cached, result.Err = t.prefetch(ctx, result.URL)
+		}(&results[i])
+	}
+	wg.Wait()
+	return results
+}
+
+// prefetch looks up the attributes of the object of u by a HEAD request.
+func (t *Transport) prefetch(ctx context.Context, u string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := t.RoundTrip(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("gsprotocol: failed to prefetch %s: %s", u, resp.Status)
+	}
+	bucket, _ := t.config.resolveBucket(bucketName(req))
+	cfg := t.config.forBucket(bucket)
+	return cfg.attrsCacheEnabled(), nil
+}
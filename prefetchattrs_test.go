@@ -0,0 +1,116 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// newAttrsCountingClient returns a storageClientMock that serves objects, and counts the calls of Attrs.
+func newAttrsCountingClient(objects map[string]mockObject, count *int32) *storageClientMock {
+	mock := newStorageClientMockWithObjects(objects)
+	bucketFunc := mock.bucketFunc
+	mock.bucketFunc = func(mock *storageClientMock, name string) *bucketHandleMock {
+		bucket := bucketFunc(mock, name)
+		objectFunc := bucket.objectFunc
+		bucket.objectFunc = func(mock *bucketHandleMock, name string) *objectHandleMock {
+			object := *objectFunc(mock, name)
+			attrFunc := object.attrFunc
+			object.attrFunc = func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+				atomic.AddInt32(count, 1)
+				return attrFunc(ctx, mock)
+			}
+			return &object
+		}
+		return bucket
+	}
+	return mock
+}
+
+var prefetchTestObjects = map[string]mockObject{
+	"bucket-name/foo": {
+		attrs:   &storage.ObjectAttrs{Generation: 1, Metageneration: 1},
+		content: "foo",
+	},
+	"bucket-name/bar": {
+		attrs:   &storage.ObjectAttrs{Generation: 2, Metageneration: 1},
+		content: "bar",
+	},
+}
+
+func TestPrefetch(t *testing.T) {
+	var count int32
+	tr := newTestTransport(newAttrsCountingClient(prefetchTestObjects, &count), WithAttrsCache(10, time.Minute))
+
+	urls := []string{"gs://bucket-name/foo", "gs://bucket-name/bar", "gs://bucket-name/not-found", "gs://bucket-name/foo#2"}
+	results := tr.Prefetch(context.Background(), urls, WithPrefetchConcurrency(2))
+	if len(results) != len(urls) {
+		t.Fatalf("want %d results, got %d", len(urls), len(results))
+	}
+	for i, result := range results {
+		if result.URL != urls[i] {
+			t.Errorf("%d: unexpected URL: want %q, got %q", i, urls[i], result.URL)
+		}
+	}
+	for _, result := range results[:2] {
+		if !result.Cached || result.Err != nil {
+			t.Errorf("%s: want cached, got %+v", result.URL, result)
+		}
+	}
+	for _, result := range results[2:] {
+		if result.Cached || result.Err == nil {
+			t.Errorf("%s: want an error, got %+v", result.URL, result)
+		}
+	}
+
+	// the requests use the cached attributes.
+	atomic.StoreInt32(&count, 0)
+	for _, u := range urls[:2] {
+		req, err := http.NewRequest(http.MethodHead, u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: unexpected status: %d", u, resp.StatusCode)
+		}
+	}
+	if n := atomic.LoadInt32(&count); n != 0 {
+		t.Errorf("want no Attrs after Prefetch, got %d", n)
+	}
+}
+
+func TestPrefetch_WithoutAttrsCache(t *testing.T) {
+	var count int32
+	tr := newTestTransport(newAttrsCountingClient(prefetchTestObjects, &count))
+	results := tr.Prefetch(context.Background(), []string{"gs://bucket-name/foo"})
+	if len(results) != 1 || results[0].Cached || results[0].Err != nil {
+		t.Errorf("want not cached without error, got %+v", results)
+	}
+}
+
+func TestPrefetch_Canceled(t *testing.T) {
+	var count int32
+	tr := newTestTransport(newAttrsCountingClient(prefetchTestObjects, &count), WithAttrsCache(10, time.Minute))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results := tr.Prefetch(ctx, []string{"gs://bucket-name/foo", "gs://bucket-name/bar"})
+	for _, result := range results {
+		if result.Cached || !errors.Is(result.Err, context.Canceled) {
+			t.Errorf("%s: want context.Canceled, got %+v", result.URL, result)
+		}
+	}
+	if n := atomic.LoadInt32(&count); n != 0 {
+		t.Errorf("want no Attrs, got %d", n)
+	}
+}
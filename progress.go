@@ -0,0 +1,75 @@
+package gsprotocol
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// errReadStalled is returned from the response body if the caller stops reading it.
+var errReadStalled = errors.New("gsprotocol: the response body is aborted because it was not read for too long")
+
+// WithReadProgressTimeout aborts the transfer of a response body,
+// if the caller doesn't read it for d, e.g. because the consumer of a proxy is stalled.
+// The timer starts when RoundTrip returns, and is reset on every Read.
+// The time spent in Read, waiting for Google Cloud Storage, doesn't count.
+// Once aborted, the reader of Google Cloud Storage is closed, and Read returns an error.
+// Zero d disables the timeout. It is disabled by default.
+func WithReadProgressTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.readProgressTimeout = d
+	}
+}
+
+// progressBody calls abort if it is not read for timeout.
+type progressBody struct {
+	io.ReadCloser
+	timeout time.Duration
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	aborted bool
+}
+
+func newProgressBody(body io.ReadCloser, timeout time.Duration, abort func()) *progressBody {
+	b := &progressBody{
+		ReadCloser: body,
+		timeout:    timeout,
+	}
+	b.timer = time.AfterFunc(timeout, func() {
+		b.mu.Lock()
+		b.aborted = true
+		b.mu.Unlock()
+		abort()
+	})
+	return b
+}
+
+func (b *progressBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	if b.aborted {
+		b.mu.Unlock()
+		return 0, errReadStalled
+	}
+	if !b.timer.Stop() {
+		// the timer has fired, and is aborting the transfer.
+		b.mu.Unlock()
+		return 0, errReadStalled
+	}
+	b.mu.Unlock()
+
+	n, err := b.ReadCloser.Read(p)
+
+	b.mu.Lock()
+	if !b.aborted {
+		b.timer.Reset(b.timeout)
+	}
+	b.mu.Unlock()
+	return n, err
+}
+
+func (b *progressBody) Close() error {
+	b.timer.Stop()
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,72 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// ctxReader fails if the context is canceled, like the reader of Google Cloud Storage.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+func TestRoundTrip_ReadProgressTimeout(t *testing.T) {
+	canceled := make(chan struct{})
+	object := &objectHandleMock{
+		attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+			return &storage.ObjectAttrs{Generation: 1, Size: 10}, nil
+		},
+		newReaderFunc: func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+			go func() {
+				<-ctx.Done()
+				close(canceled)
+			}()
+			return storage.ReaderObjectAttrs{Generation: 1, Size: 10}, io.NopCloser(&ctxReader{ctx: ctx, r: strings.NewReader("0123456789")}), nil
+		},
+		generationFunc: func(mock *objectHandleMock, gen int64) *objectHandleMock {
+			return mock
+		},
+	}
+	tr := newTestTransport(newSingleObjectMock(object), WithReadProgressTimeout(50*time.Millisecond))
+	c := &http.Client{Transport: tr}
+
+	resp, err := c.Get("gs://bucket-name/object-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// the reads in time reset the deadline.
+	buf := make([]byte, 2)
+	for i := 0; i < 3; i++ {
+		if _, err := io.ReadFull(resp.Body, buf); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(30 * time.Millisecond)
+	}
+
+	// stop draining.
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("the transfer is not aborted")
+	}
+	if _, err := resp.Body.Read(buf); !errors.Is(err, errReadStalled) {
+		t.Errorf("want errReadStalled, got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+)
+
+// generationRaceError is returned if the generation pinned by Attrs is deleted before NewReader,
+// while the live object exists with another generation.
+type generationRaceError struct {
+	bucket string
+	object string
+	pinned int64
+	live   int64
+}
+
+func (err *generationRaceError) Error() string {
+	return fmt.Sprintf("gsprotocol: generation %d of gs://%s/%s no longer exists, the live generation is %d",
+		err.pinned, err.bucket, err.object, err.live)
+}
+
+// checkGenerationRace converts the error of NewReader into generationRaceError
+// if the pinned generation no longer exists but the live object does.
+// Otherwise, it returns err as is.
+func checkGenerationRace(ctx context.Context, client storageClient, req *http.Request, attrs *storage.ObjectAttrs, err error) error {
+	if !errors.Is(err, storage.ErrObjectNotExist) {
+		return err
+	}
+	if req.URL.Fragment != "" {
+		// the generation is specified by the client, so it is not a race.
+		return err
+	}
+
+	live, liveErr := client.Bucket(attrs.Bucket).Object(attrs.Name).Attrs(ctx)
+	if liveErr != nil || live.Generation == attrs.Generation {
+		return err
+	}
+	return &generationRaceError{
+		bucket: attrs.Bucket,
+		object: attrs.Name,
+		pinned: attrs.Generation,
+		live:   live.Generation,
+	}
+}
@@ -0,0 +1,85 @@
+package gsprotocol
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+// newGenerationRaceObject returns an object whose generation 1 is deleted right after the first Attrs call.
+func newGenerationRaceObject() *objectHandleMock {
+	live := int64(1)
+	return &objectHandleMock{
+		attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+			gen := live
+			live = 2
+			if mock.generation != 0 && mock.generation != live {
+				return nil, storage.ErrObjectNotExist
+			}
+			return &storage.ObjectAttrs{
+				Bucket:     "bucket-name",
+				Name:       "object-key",
+				Generation: gen,
+			}, nil
+		},
+		newReaderFunc: func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+			if mock.generation != live {
+				return storage.ReaderObjectAttrs{}, nil, storage.ErrObjectNotExist
+			}
+			content := "generation " + strconv.FormatInt(live, 10)
+			return storage.ReaderObjectAttrs{Generation: live}, io.NopCloser(strings.NewReader(content)), nil
+		},
+		generationFunc: withGeneration,
+	}
+}
+
+func TestRoundTrip_GenerationRace(t *testing.T) {
+	c := &http.Client{Transport: newTestTransport(newSingleObjectMock(newGenerationRaceObject()))}
+	resp, err := c.Get("gs://bucket-name/object-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusConflict, resp.StatusCode)
+	}
+	if got := resp.Header.Get("x-gsprotocol-error"); got != "generation-race" {
+		t.Errorf("unexpected x-gsprotocol-error: want %q, got %q", "generation-race", got)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "generation 1 ") || !strings.Contains(string(body), "generation is 2") {
+		t.Errorf("the body doesn't name both generations: %q", string(body))
+	}
+}
+
+func TestRoundTrip_GenerationRaceRetry(t *testing.T) {
+	c := &http.Client{Transport: newTestTransport(newSingleObjectMock(newGenerationRaceObject()), WithGenerationRaceRetry())}
+	resp, err := c.Get("gs://bucket-name/object-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if got := resp.Header.Get("x-goog-generation"); got != "2" {
+		t.Errorf("unexpected x-goog-generation: want %q, got %q", "2", got)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "generation 2" {
+		t.Errorf("want %q, got %q", "generation 2", string(body))
+	}
+}
@@ -0,0 +1,239 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// httpRange is a byte range of the object, the same as the one of net/http.
+type httpRange struct {
+	start, length int64
+}
+
+// contentRange returns the value of the Content-Range header for the object of size bytes.
+func (r httpRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+var (
+	// errInvalidRange is returned if the Range header is syntactically invalid.
+	errInvalidRange = errors.New("gsprotocol: invalid range")
+
+	// errNoOverlap is returned if the range doesn't overlap the object.
+	errNoOverlap = errors.New("gsprotocol: invalid range: failed to overlap")
+)
+
+// maxRanges is the maximum number of the ranges in a request.
+// The Transport ignores the Range header with more ranges, and responds with the whole object.
+const maxRanges = 16
+
+// parseRanges parses the Range header, e.g. "bytes=0-499", "bytes=500-", "bytes=-500" or "bytes=0-99,200-299",
+// for the object of size bytes.
+// The end of each range is clamped to the object, and the ranges out of the object are skipped,
+// in the same way as net/http.
+// It returns errNoOverlap if all the ranges are out of the object.
+func parseRanges(s string, size int64) ([]httpRange, error) {
+	const b = "bytes="
+	if !strings.HasPrefix(s, b) {
+		return nil, errInvalidRange
+	}
+	var ranges []httpRange
+	noOverlap := false
+	for _, spec := range strings.Split(s[len(b):], ",") {
+		spec = textproto.TrimString(spec)
+		if spec == "" {
+			continue
+		}
+		r, err := parseRangeSpec(spec, size)
+		if err == errNoOverlap {
+			noOverlap = true
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	if noOverlap && len(ranges) == 0 {
+		return nil, errNoOverlap
+	}
+	return ranges, nil
+}
+
+// parseRangeSpec parses a range of the Range header, e.g. "0-499".
+func parseRangeSpec(spec string, size int64) (httpRange, error) {
+	i := strings.Index(spec, "-")
+	if i < 0 {
+		return httpRange{}, errInvalidRange
+	}
+	start, end := textproto.TrimString(spec[:i]), textproto.TrimString(spec[i+1:])
+
+	if start == "" {
+		// suffix range, e.g. bytes=-500, is the last 500 bytes.
+		n, err := strconv.ParseInt(end, 10, 64)
+		if err != nil || n < 0 {
+			return httpRange{}, errInvalidRange
+		}
+		if n == 0 || size == 0 {
+			return httpRange{}, errNoOverlap
+		}
+		if n > size {
+			n = size
+		}
+		return httpRange{start: size - n, length: n}, nil
+	}
+
+	i64, err := strconv.ParseInt(start, 10, 64)
+	if err != nil || i64 < 0 {
+		return httpRange{}, errInvalidRange
+	}
+	var r httpRange
+	r.start = i64
+	if end == "" {
+		// open-ended range, e.g. bytes=500-, is to the end of the object.
+		if r.start >= size {
+			return httpRange{}, errNoOverlap
+		}
+		r.length = size - r.start
+		return r, nil
+	}
+	i64, err = strconv.ParseInt(end, 10, 64)
+	if err != nil || r.start > i64 {
+		return httpRange{}, errInvalidRange
+	}
+	if r.start >= size {
+		return httpRange{}, errNoOverlap
+	}
+	if i64 >= size {
+		i64 = size - 1
+	}
+	r.length = i64 - r.start + 1
+	return r, nil
+}
+
+// requestRanges returns the ranges that the GET request asks for.
+// It returns no ranges if the request has no Range header, the header is invalid,
+// it has more than maxRanges ranges, or If-Range doesn't match,
+// and then the Transport responds with the whole object, in the same way as net/http.
+// It returns errNoOverlap if the ranges are out of the object, checked against attrs.Size without reading the object.
+// See rangeSupported for the objects whose ranges are not served.
+func requestRanges(req *http.Request, header http.Header, attrs *storage.ObjectAttrs, decompress string) ([]httpRange, error) {
+	s := req.Header.Get("Range")
+	if s == "" || req.Method != http.MethodGet || !rangeSupported(attrs, decompress) {
+		return nil, nil
+	}
+	if checkIfRange(req, header, attrs) == condFalse {
+		return nil, nil
+	}
+	ranges, err := parseRanges(s, attrs.Size)
+	if err == errNoOverlap {
+		return nil, err
+	}
+	if err != nil || len(ranges) > maxRanges {
+		return nil, nil
+	}
+	return ranges, nil
+}
+
+// rangeSupported reports whether the Transport serves the ranges of the object.
+// The offsets of the decompressed content are unknown until the whole object is decompressed,
+// and Google Cloud Storage ignores the ranges of the objects that it decompresses.
+func rangeSupported(attrs *storage.ObjectAttrs, decompress string) bool {
+	return decompress == "" && attrs.ContentEncoding != "gzip"
+}
+
+// acceptRanges returns the value of the Accept-Ranges header of the successful responses.
+func acceptRanges(attrs *storage.ObjectAttrs, decompress string) string {
+	if rangeSupported(attrs, decompress) {
+		return "bytes"
+	}
+	return "none"
+}
+
+// newRangeNotSatisfiableResponse returns the 416 Requested Range Not Satisfiable response for the object of size bytes.
+func newRangeNotSatisfiableResponse(size int64) *http.Response {
+	resp := newErrorResponse(http.StatusRequestedRangeNotSatisfiable, errNoOverlap.Error())
+	resp.Header.Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+	return resp
+}
+
+// mimeHeader returns the header of the part of the multipart/byteranges response.
+func (r httpRange) mimeHeader(contentType string, size int64) textproto.MIMEHeader {
+	header := textproto.MIMEHeader{
+		"Content-Range": {r.contentRange(size)},
+	}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	return header
+}
+
+// countingWriter counts the bytes written.
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+// multipartSize returns the size of the multipart/byteranges body of ranges.
+func multipartSize(ranges []httpRange, boundary, contentType string, size int64) int64 {
+	var w countingWriter
+	mw := multipart.NewWriter(&w)
+	mw.SetBoundary(boundary)
+	var partsSize int64
+	for _, r := range ranges {
+		mw.CreatePart(r.mimeHeader(contentType, size))
+		partsSize += r.length
+	}
+	mw.Close()
+	return int64(w) + partsSize
+}
+
+// newMultipartBody returns the multipart/byteranges body of ranges of the object, its Content-Type and its length.
+// first is the reader of the first range, and the others are opened one by one while the body is read.
+// The body is closed by the caller, and then the reader in use is closed.
+func newMultipartBody(ctx context.Context, object objectHandle, first storageReader, ranges []httpRange, contentType string, size int64) (io.ReadCloser, string, int64) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	length := multipartSize(ranges, mw.Boundary(), contentType, size)
+	go func() {
+		var r io.ReadCloser = first
+		for i, rng := range ranges {
+			if i > 0 {
+				var err error
+				r, err = object.NewRangeReader(ctx, rng.start, rng.length)
+				if err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+			}
+			part, err := mw.CreatePart(rng.mimeHeader(contentType, size))
+			if err != nil {
+				r.Close()
+				pw.CloseWithError(err)
+				return
+			}
+			n, err := io.Copy(part, r)
+			r.Close()
+			if err == nil && n != rng.length {
+				err = io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(mw.Close())
+	}()
+	return pr, "multipart/byteranges; boundary=" + mw.Boundary(), length
+}
@@ -0,0 +1,383 @@
+package gsprotocol
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestParseRanges(t *testing.T) {
+	tests := []struct {
+		in   string
+		size int64
+		want []httpRange
+		err  error
+	}{
+		{"bytes=0-4", 10, []httpRange{{0, 5}}, nil},
+		{"bytes=2-", 10, []httpRange{{2, 8}}, nil},
+		{"bytes=5-100", 10, []httpRange{{5, 5}}, nil},
+		{"bytes=-3", 10, []httpRange{{7, 3}}, nil},
+		{"bytes=-100", 10, []httpRange{{0, 10}}, nil},
+		{"bytes= 1 - 2 ", 10, []httpRange{{1, 2}}, nil},
+		{"bytes=9-9", 10, []httpRange{{9, 1}}, nil},
+		{"bytes=0-1,3-4", 10, []httpRange{{0, 2}, {3, 2}}, nil},
+		{"bytes=0-1, ,-2", 10, []httpRange{{0, 2}, {8, 2}}, nil},
+		{"bytes=0-1,20-30", 10, []httpRange{{0, 2}}, nil},
+		{"bytes=", 10, nil, nil},
+
+		// invalid syntax
+		{"", 10, nil, errInvalidRange},
+		{"bits=0-4", 10, nil, errInvalidRange},
+		{"bytes=4-0", 10, nil, errInvalidRange},
+		{"bytes=abc", 10, nil, errInvalidRange},
+		{"bytes=-", 10, nil, errInvalidRange},
+		{"bytes=--5", 10, nil, errInvalidRange},
+		{"bytes=0-1,abc", 10, nil, errInvalidRange},
+
+		// out of bounds
+		{"bytes=10-", 10, nil, errNoOverlap},
+		{"bytes=10-20", 10, nil, errNoOverlap},
+		{"bytes=-0", 10, nil, errNoOverlap},
+		{"bytes=10-20,30-", 10, nil, errNoOverlap},
+		{"bytes=0-", 0, nil, errNoOverlap},
+		{"bytes=-5", 0, nil, errNoOverlap},
+	}
+	for _, tt := range tests {
+		got, err := parseRanges(tt.in, tt.size)
+		if err != tt.err {
+			t.Errorf("parseRanges(%q, %d): want error %v, got %v", tt.in, tt.size, tt.err, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRanges(%q, %d): want %v, got %v", tt.in, tt.size, tt.want, got)
+		}
+	}
+}
+
+var rangeTestObjects = map[string]mockObject{
+	"bucket-name/object-key": {
+		attrs: &storage.ObjectAttrs{
+			ContentType: "text/plain",
+			Generation:  1,
+		},
+		content: "Hello Google Cloud Storage!",
+	},
+}
+
+func TestRoundTrip_Range(t *testing.T) {
+	const content = "Hello Google Cloud Storage!"
+	tests := []struct {
+		name         string
+		rangeHeader  string
+		status       int
+		contentRange string
+		body         string
+	}{
+		{"first bytes", "bytes=0-4", http.StatusPartialContent, "bytes 0-4/27", "Hello"},
+		{"middle", "bytes=6-11", http.StatusPartialContent, "bytes 6-11/27", "Google"},
+		{"open-ended", "bytes=19-", http.StatusPartialContent, "bytes 19-26/27", "Storage!"},
+		{"suffix", "bytes=-8", http.StatusPartialContent, "bytes 19-26/27", "Storage!"},
+		{"clamped", "bytes=19-1000", http.StatusPartialContent, "bytes 19-26/27", "Storage!"},
+		{"invalid", "bytes=5-1", http.StatusOK, "", content},
+		{"too many ranges", "bytes=0-0,1-1,2-2,3-3,4-4,5-5,6-6,7-7,8-8,9-9,10-10,11-11,12-12,13-13,14-14,15-15,16-16", http.StatusOK, "", content},
+	}
+	c := newTestClient(newStorageClientMockWithObjects(rangeTestObjects))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/object-key", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Range", tt.rangeHeader)
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("unexpected status: want %d, got %d", tt.status, resp.StatusCode)
+			}
+			if got := resp.Header.Get("Content-Range"); got != tt.contentRange {
+				t.Errorf("unexpected Content-Range: want %q, got %q", tt.contentRange, got)
+			}
+			if resp.ContentLength != int64(len(tt.body)) {
+				t.Errorf("unexpected ContentLength: want %d, got %d", len(tt.body), resp.ContentLength)
+			}
+			if string(body) != tt.body {
+				t.Errorf("unexpected body: want %q, got %q", tt.body, string(body))
+			}
+		})
+	}
+}
+
+func TestRoundTrip_RangeNotSatisfiable(t *testing.T) {
+	newObject := func(size int64) *objectHandleMock {
+		return &objectHandleMock{
+			attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+				return &storage.ObjectAttrs{
+					Bucket:     "bucket-name",
+					Name:       "object-key",
+					Size:       size,
+					Generation: 1,
+				}, nil
+			},
+			newReaderFunc: func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+				t.Error("the object must not be read")
+				return storage.ReaderObjectAttrs{}, nil, storage.ErrObjectNotExist
+			},
+			generationFunc: func(mock *objectHandleMock, gen int64) *objectHandleMock {
+				return mock
+			},
+		}
+	}
+	newClient := func(object *objectHandleMock) *http.Client {
+		mock := newSingleObjectMock(object)
+		return &http.Client{Transport: newTestTransport(mock)}
+	}
+
+	tests := []struct {
+		name         string
+		size         int64
+		rangeHeader  string
+		contentRange string
+	}{
+		{"after the end", 27, "bytes=99999999-", "bytes */27"},
+		{"at the end", 27, "bytes=27-30", "bytes */27"},
+		{"empty suffix", 27, "bytes=-0", "bytes */27"},
+		{"zero-byte object", 0, "bytes=0-", "bytes */0"},
+		{"zero-byte object with suffix", 0, "bytes=-10", "bytes */0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClient(newObject(tt.size))
+			req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/object-key", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Range", tt.rangeHeader)
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+				t.Errorf("unexpected status: want %d, got %d", http.StatusRequestedRangeNotSatisfiable, resp.StatusCode)
+			}
+			if got := resp.Header.Get("Content-Range"); got != tt.contentRange {
+				t.Errorf("unexpected Content-Range: want %q, got %q", tt.contentRange, got)
+			}
+		})
+	}
+}
+
+func TestRoundTrip_IfRange(t *testing.T) {
+	const content = "Hello Google Cloud Storage!"
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs: &storage.ObjectAttrs{
+				MD5:        []byte{0x0b, 0x46, 0xf3, 0x06, 0xe9, 0x2d, 0x88, 0x51, 0x5e, 0x06, 0xd4, 0x8a, 0x62, 0xdc, 0xc3, 0x19},
+				Updated:    time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC),
+				Generation: 1,
+			},
+			content: content,
+		},
+	})
+	c := &http.Client{Transport: newTestTransport(mock)}
+
+	tests := []struct {
+		name    string
+		ifRange string
+		status  int
+		body    string
+	}{
+		{"matching ETag", `"0b46f306e92d88515e06d48a62dcc319"`, http.StatusPartialContent, "Hello"},
+		{"other ETag", `"other"`, http.StatusOK, content},
+		{"weak ETag", `W/"0b46f306e92d88515e06d48a62dcc319"`, http.StatusOK, content},
+		{"matching date", "Fri, 01 Jan 2021 00:00:00 GMT", http.StatusPartialContent, "Hello"},
+		{"other date", "Sat, 02 Jan 2021 00:00:00 GMT", http.StatusOK, content},
+		{"invalid", "invalid", http.StatusOK, content},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/object-key", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Range", "bytes=0-4")
+			req.Header.Set("If-Range", tt.ifRange)
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("unexpected status: want %d, got %d", tt.status, resp.StatusCode)
+			}
+			if string(body) != tt.body {
+				t.Errorf("unexpected body: want %q, got %q", tt.body, string(body))
+			}
+		})
+	}
+}
+
+func TestRoundTrip_AcceptRanges(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs: &storage.ObjectAttrs{
+				MD5:        []byte{0x0b, 0x46, 0xf3, 0x06, 0xe9, 0x2d, 0x88, 0x51, 0x5e, 0x06, 0xd4, 0x8a, 0x62, 0xdc, 0xc3, 0x19},
+				Generation: 1,
+			},
+			content: "Hello Google Cloud Storage!",
+		},
+		"bucket-name/object-key.gz": {
+			attrs: &storage.ObjectAttrs{
+				ContentEncoding: "gzip",
+				Generation:      1,
+			},
+			content: "compressed",
+		},
+	})
+	c := &http.Client{Transport: newTestTransport(mock)}
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		header map[string]string
+		status int
+		want   string
+	}{
+		{"GET", http.MethodGet, "gs://bucket-name/object-key", nil, http.StatusOK, "bytes"},
+		{"HEAD", http.MethodHead, "gs://bucket-name/object-key", nil, http.StatusOK, "bytes"},
+		{"partial", http.MethodGet, "gs://bucket-name/object-key", map[string]string{"Range": "bytes=0-4"}, http.StatusPartialContent, "bytes"},
+		{"GET gzip encoding", http.MethodGet, "gs://bucket-name/object-key.gz", nil, http.StatusOK, "none"},
+		{"HEAD gzip encoding", http.MethodHead, "gs://bucket-name/object-key.gz", nil, http.StatusOK, "none"},
+		{"not modified", http.MethodGet, "gs://bucket-name/object-key", map[string]string{"If-None-Match": "*"}, http.StatusNotModified, ""},
+		{"precondition failed", http.MethodHead, "gs://bucket-name/object-key", map[string]string{"If-Match": `"other"`}, http.StatusPreconditionFailed, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, value := range tt.header {
+				req.Header.Set(key, value)
+			}
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("unexpected status: want %d, got %d", tt.status, resp.StatusCode)
+			}
+			if got := resp.Header.Get("Accept-Ranges"); got != tt.want {
+				t.Errorf("unexpected Accept-Ranges: want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRoundTrip_MultipleRanges(t *testing.T) {
+	c := newTestClient(newStorageClientMockWithObjects(rangeTestObjects))
+	req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", "bytes=0-4,-8")
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusPartialContent, resp.StatusCode)
+	}
+	if resp.ContentLength != int64(len(body)) {
+		t.Errorf("unexpected ContentLength: want %d, got %d", len(body), resp.ContentLength)
+	}
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "multipart/byteranges" {
+		t.Errorf("unexpected media type: %s", mediaType)
+	}
+
+	want := []struct {
+		contentRange string
+		body         string
+	}{
+		{"bytes 0-4/27", "Hello"},
+		{"bytes 19-26/27", "Storage!"},
+	}
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for i, w := range want {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		if got := part.Header.Get("Content-Range"); got != w.contentRange {
+			t.Errorf("part %d: unexpected Content-Range: want %q, got %q", i, w.contentRange, got)
+		}
+		if got := part.Header.Get("Content-Type"); got != "text/plain" {
+			t.Errorf("part %d: unexpected Content-Type: want %q, got %q", i, "text/plain", got)
+		}
+		got, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != w.body {
+			t.Errorf("part %d: want %q, got %q", i, w.body, string(got))
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("want io.EOF, got %v", err)
+	}
+}
+
+func TestRoundTrip_MultipleRangesClose(t *testing.T) {
+	c := newTestClient(newStorageClientMockWithObjects(rangeTestObjects))
+	req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", "bytes=0-4,6-11,19-")
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// closing the body before reading it must not block the goroutine writing the parts.
+	if err := resp.Body.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,246 @@
+package gsprotocol
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// WithRangeCoalescing makes the Transport merge the GET requests with a single range of the same generation of an object,
+// which arrive within maxDelay, into one range read from Google Cloud Storage.
+// It saves the requests of the readers that issue many small, nearly adjacent range reads, e.g. the readers of columnar formats.
+//
+// A range joins a pending read if the gap between them is at most maxGap bytes,
+// and the merged range is at most maxSize bytes. The larger ranges are read as usual.
+// The pending reads are not merged with each other, even if a later range fills the gap between them.
+// The first range of a read waits up to maxDelay for the others, and the merged range is read into the memory,
+// so the responses arrive after the whole merged range is read.
+// The read is canceled when all the requests merged into it are canceled.
+// The counts of the saved requests are available by Transport.RangeCoalescingStats.
+// It is disabled by default.
+func WithRangeCoalescing(maxGap, maxSize int64, maxDelay time.Duration) Option {
+	return func(c *config) {
+		c.coalesceMaxGap = maxGap
+		c.coalesceMaxSize = maxSize
+		c.coalesceMaxDelay = maxDelay
+	}
+}
+
+// canCoalesceRange reports whether the range r is read by the range coalescer.
+func (c *config) canCoalesceRange(r httpRange) bool {
+	return c.coalesceMaxDelay > 0 && c.coalesceMaxSize > 0 && r.length <= c.coalesceMaxSize
+}
+
+// RangeCoalescingStats is the counts of the range coalescer of WithRangeCoalescing.
+type RangeCoalescingStats struct {
+	// Requests is the number of the range requests served by the range coalescer.
+	Requests int64
+
+	// Reads is the number of the range reads from Google Cloud Storage for them.
+	// Requests - Reads is the number of the requests saved.
+	Reads int64
+}
+
+// RangeCoalescingStats returns the counts of the range coalescer of WithRangeCoalescing.
+func (t *Transport) RangeCoalescingStats() RangeCoalescingStats {
+	t.rangeCoalescer.mu.Lock()
+	defer t.rangeCoalescer.mu.Unlock()
+	return t.rangeCoalescer.stats
+}
+
+// rangeCoalescer merges the concurrent range reads of the same generation of an object.
+// The zero value is ready to use.
+type rangeCoalescer struct {
+	mu      sync.Mutex
+	pending map[rangeBatchKey][]*rangeBatch
+	stats   RangeCoalescingStats
+}
+
+// rangeBatchKey is the generation of an object read through a storage client and a user project.
+type rangeBatchKey struct {
+	client     uint64
+	project    string
+	bucket     string
+	object     string
+	generation int64
+}
+
+// rangeBatch is a merged range read.
+// The fields are guarded by rangeCoalescer.mu until done is closed, and read-only after that.
+type rangeBatch struct {
+	object     objectHandle
+	ctx        context.Context
+	cancel     context.CancelFunc
+	start, end int64
+
+	// waiters is the number of the requests waiting for the read.
+	waiters int
+
+	done  chan struct{}
+	data  []byte
+	attrs storage.ReaderObjectAttrs
+	err   error
+}
+
+// join merges r into the pending read, if it fits in the limits of cfg.
+func (b *rangeBatch) join(cfg *config, r httpRange) bool {
+	end := r.start + r.length
+	if r.start > b.end+cfg.coalesceMaxGap || end < b.start-cfg.coalesceMaxGap {
+		return false
+	}
+	start, mergedEnd := b.start, b.end
+	if r.start < start {
+		start = r.start
+	}
+	if end > mergedEnd {
+		mergedEnd = end
+	}
+	if mergedEnd-start > cfg.coalesceMaxSize {
+		return false
+	}
+	b.start, b.end = start, mergedEnd
+	b.waiters++
+	return true
+}
+
+// coalescedRange reads the range r of the generation of object that attrs describes, merged with the other ranges.
+func (t *Transport) coalescedRange(ctx context.Context, client storageClient, cfg *config, object objectHandle, attrs *storage.ObjectAttrs, r httpRange) (storageReader, error) {
+	id, project := t.clientIdentity(client)
+	key := rangeBatchKey{
+		client:     id,
+		project:    project,
+		bucket:     attrs.Bucket,
+		object:     attrs.Name,
+		generation: attrs.Generation,
+	}
+	c := &t.rangeCoalescer
+
+	c.mu.Lock()
+	c.stats.Requests++
+	var batch *rangeBatch
+	for _, b := range c.pending[key] {
+		if b.join(cfg, r) {
+			batch = b
+			break
+		}
+	}
+	if batch == nil {
+		// the read outlives the request that starts it, while the others wait for it.
+		readCtx, cancel := context.WithCancel(valueOnlyContext{ctx})
+		batch = &rangeBatch{
+			object:  object,
+			ctx:     readCtx,
+			cancel:  cancel,
+			start:   r.start,
+			end:     r.start + r.length,
+			waiters: 1,
+			done:    make(chan struct{}),
+		}
+		if c.pending == nil {
+			c.pending = make(map[rangeBatchKey][]*rangeBatch)
+		}
+		c.pending[key] = append(c.pending[key], batch)
+		time.AfterFunc(cfg.coalesceMaxDelay, func() {
+			c.read(key, batch)
+		})
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-batch.done:
+	case <-ctx.Done():
+		c.leave(batch)
+		return nil, ctx.Err()
+	}
+	if batch.err != nil {
+		return nil, batch.err
+	}
+	offset := r.start - batch.start
+	readerAttrs := batch.attrs
+	readerAttrs.StartOffset = r.start
+	return &coalescedReader{
+		Reader: bytes.NewReader(batch.data[offset : offset+r.length]),
+		attrs:  readerAttrs,
+	}, nil
+}
+
+// leave cancels the read of b if no requests wait for it.
+func (c *rangeCoalescer) leave(b *rangeBatch) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b.waiters--
+	if b.waiters == 0 {
+		b.cancel()
+	}
+}
+
+// read reads the merged range of b, after it stops accepting ranges.
+func (c *rangeCoalescer) read(key rangeBatchKey, b *rangeBatch) {
+	c.mu.Lock()
+	batches := c.pending[key]
+	for i, pending := range batches {
+		if pending == b {
+			batches = append(batches[:i:i], batches[i+1:]...)
+			break
+		}
+	}
+	if len(batches) == 0 {
+		delete(c.pending, key)
+	} else {
+		c.pending[key] = batches
+	}
+	c.stats.Reads++
+	start, end := b.start, b.end
+	c.mu.Unlock()
+
+	defer close(b.done)
+	defer b.cancel()
+	r, err := b.object.NewRangeReader(b.ctx, start, end-start)
+	if err != nil {
+		b.err = err
+		return
+	}
+	defer r.Close()
+	data := make([]byte, end-start)
+	if _, err := io.ReadFull(r, data); err != nil {
+		b.err = err
+		return
+	}
+	b.data = data
+	b.attrs = r.Attrs()
+}
+
+// coalescedReader is the range of a merged read.
+type coalescedReader struct {
+	*bytes.Reader
+	attrs storage.ReaderObjectAttrs
+}
+
+func (r *coalescedReader) Close() error {
+	return nil
+}
+
+func (r *coalescedReader) Attrs() storage.ReaderObjectAttrs {
+	return r.attrs
+}
+
+// valueOnlyContext has the values of the parent context, but it is never canceled.
+type valueOnlyContext struct {
+	context.Context
+}
+
+func (valueOnlyContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (valueOnlyContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (valueOnlyContext) Err() error {
+	return nil
+}
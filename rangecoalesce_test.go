@@ -0,0 +1,211 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+const rangeCoalesceTestContent = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// rangeReads counts the reads of the object.
+type rangeReads struct {
+	reads    int32
+	canceled int32
+}
+
+// newRangeCountingTransport returns a Transport that serves rangeCoalesceTestContent, and counts the reads.
+func newRangeCountingTransport(counts *rangeReads, opts ...Option) *Transport {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs: &storage.ObjectAttrs{
+				Generation: 1587160158394554,
+				Size:       int64(len(rangeCoalesceTestContent)),
+			},
+			content: rangeCoalesceTestContent,
+		},
+	})
+	bucketFunc := mock.bucketFunc
+	mock.bucketFunc = func(mock *storageClientMock, name string) *bucketHandleMock {
+		bucket := bucketFunc(mock, name)
+		objectFunc := bucket.objectFunc
+		bucket.objectFunc = func(mock *bucketHandleMock, name string) *objectHandleMock {
+			object := *objectFunc(mock, name)
+			newReaderFunc := object.newReaderFunc
+			object.newReaderFunc = func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+				atomic.AddInt32(&counts.reads, 1)
+				if err := ctx.Err(); err != nil {
+					atomic.AddInt32(&counts.canceled, 1)
+					return storage.ReaderObjectAttrs{}, nil, err
+				}
+				return newReaderFunc(ctx, mock)
+			}
+			return &object
+		}
+		return bucket
+	}
+	return newTestTransport(mock, opts...)
+}
+
+// getRange requests the range of gs://bucket-name/object-key.
+func getRange(ctx context.Context, tr *Transport, rng string) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Range", rng)
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body), err
+}
+
+func TestRoundTrip_RangeCoalescing(t *testing.T) {
+	var counts rangeReads
+	tr := newRangeCountingTransport(&counts, WithRangeCoalescing(16, 1<<10, 100*time.Millisecond))
+
+	// overlapping and out-of-order ranges, which are in the gap from each other.
+	tests := []struct {
+		rng  string
+		want string
+	}{
+		{"bytes=20-24", "klmno"},
+		{"bytes=0-4", "01234"},
+		{"bytes=2-11", "23456789ab"},
+		{"bytes=14-17", "efgh"},
+	}
+	var wg sync.WaitGroup
+	for _, tt := range tests {
+		tt := tt
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, body, err := getRange(context.Background(), tr, tt.rng)
+			if err != nil {
+				t.Errorf("%s: %v", tt.rng, err)
+				return
+			}
+			if status != http.StatusPartialContent || body != tt.want {
+				t.Errorf("%s: want %d %q, got %d %q", tt.rng, http.StatusPartialContent, tt.want, status, body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&counts.reads); n != 1 {
+		t.Errorf("want one read, got %d", n)
+	}
+	if got, want := tr.RangeCoalescingStats(), (RangeCoalescingStats{Requests: 4, Reads: 1}); got != want {
+		t.Errorf("unexpected stats: want %+v, got %+v", want, got)
+	}
+}
+
+func TestRoundTrip_RangeCoalescingLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		ranges []string
+		reads  int32
+	}{
+		{
+			name:   "gap",
+			ranges: []string{"bytes=0-4", "bytes=10-14"},
+			reads:  2,
+		},
+		{
+			name:   "max size",
+			ranges: []string{"bytes=0-7", "bytes=8-15"},
+			reads:  2,
+		},
+		{
+			name:   "too large",
+			ranges: []string{"bytes=0-19"},
+			reads:  1,
+		},
+		{
+			name:   "multiple ranges",
+			ranges: []string{"bytes=0-1,3-4"},
+			reads:  2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var counts rangeReads
+			tr := newRangeCountingTransport(&counts, WithRangeCoalescing(4, 10, 50*time.Millisecond))
+			var wg sync.WaitGroup
+			for _, rng := range tt.ranges {
+				rng := rng
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					status, _, err := getRange(context.Background(), tr, rng)
+					if err != nil {
+						t.Errorf("%s: %v", rng, err)
+						return
+					}
+					if status != http.StatusPartialContent {
+						t.Errorf("%s: unexpected status: %d", rng, status)
+					}
+				}()
+			}
+			wg.Wait()
+			if n := atomic.LoadInt32(&counts.reads); n != tt.reads {
+				t.Errorf("want %d reads, got %d", tt.reads, n)
+			}
+		})
+	}
+}
+
+func TestRoundTrip_RangeCoalescingCanceled(t *testing.T) {
+	var counts rangeReads
+	tr := newRangeCountingTransport(&counts, WithRangeCoalescing(4, 1<<10, 100*time.Millisecond))
+
+	// a canceled request doesn't cancel the read of the others.
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		_, _, err := getRange(ctx, tr, "bytes=0-4")
+		errCh <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	status, body, err := getRange(context.Background(), tr, "bytes=5-9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusPartialContent || body != "56789" {
+		t.Errorf("unexpected response: %d %q", status, body)
+	}
+	if err := <-errCh; !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+	if n := atomic.LoadInt32(&counts.reads); n != 1 {
+		t.Errorf("want one read, got %d", n)
+	}
+
+	// the read is canceled if all the requests are canceled.
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, _, err := getRange(ctx, tr, "bytes=0-4"); !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := atomic.LoadInt32(&counts.canceled); n != 1 {
+		t.Errorf("want the read to be canceled, got %d canceled reads", n)
+	}
+}
@@ -0,0 +1,223 @@
+package gsprotocol
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RequestRecordVersion is the version of the schema of RequestRecord.
+// It is incremented when the meaning of an existing field changes.
+const RequestRecordVersion = 1
+
+// conditionalHeaders is the request headers recorded in RequestRecord.
+var conditionalHeaders = []string{
+	"If-Match",
+	"If-None-Match",
+	"If-Modified-Since",
+	"If-Unmodified-Since",
+	"If-Range",
+	"X-Goog-If-Generation-Not-Match",
+}
+
+// RequestRecord is the canonical representation of a request and its outcome, for replaying it by ReplayRequest.
+type RequestRecord struct {
+	// Version is the version of the schema, RequestRecordVersion.
+	Version int `json:"version"`
+
+	// Time is when the request started.
+	Time time.Time `json:"time"`
+
+	// Method, Bucket, Object, and Query are the request.
+	Method string `json:"method"`
+	Bucket string `json:"bucket"`
+	Object string `json:"object"`
+	Query  string `json:"query,omitempty"`
+
+	// Generation is the generation requested, e.g. gs://[BUCKET_NAME]/[OBJECT_NAME]#[GENERATION].
+	// Zero means the live object.
+	Generation int64 `json:"generation,omitempty"`
+
+	// Conditions is the conditional headers of the request.
+	Conditions map[string]string `json:"conditions,omitempty"`
+
+	// Range is the Range header of the request.
+	Range string `json:"range,omitempty"`
+
+	// StatusCode is the status code of the response, or zero if the request failed with Error.
+	StatusCode int    `json:"status_code"`
+	Error      string `json:"error,omitempty"`
+
+	// ResolvedGeneration, ETag, LastModified, and Size are the validators of the response.
+	// Size is the size of the object stored, or -1 if it is unknown.
+	ResolvedGeneration int64  `json:"resolved_generation,omitempty"`
+	ETag               string `json:"etag,omitempty"`
+	LastModified       string `json:"last_modified,omitempty"`
+	Size               int64  `json:"size"`
+
+	// WriteRetry is the policy of retrying the write request chosen by WithWriteRetry,
+	// "none", "precondition" or "idempotent". It is empty for the other requests.
+	WriteRetry string `json:"write_retry,omitempty"`
+
+	// Client is the storage client that served the request, "read" or "write", with WithWriteClient.
+	// It is empty without WithWriteClient.
+	Client string `json:"client,omitempty"`
+}
+
+// WithRequestRecorder makes the Transport call recorder with the record of each request,
+// after RoundTrip returns. recorder must not block.
+// Use NewJSONRequestRecorder to write them as JSON lines.
+func WithRequestRecorder(recorder func(record *RequestRecord)) Option {
+	return func(c *config) {
+		c.requestRecorder = recorder
+	}
+}
+
+// NewJSONRequestRecorder returns a recorder for WithRequestRecorder that writes the records to w as JSON lines.
+// It is safe for concurrent use.
+func NewJSONRequestRecorder(w io.Writer) func(record *RequestRecord) {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	return func(record *RequestRecord) {
+		mu.Lock()
+		defer mu.Unlock()
+		enc.Encode(record)
+	}
+}
+
+// newRequestRecord returns the record of the request without the outcome.
+func newRequestRecord(req *http.Request, start time.Time) *RequestRecord {
+	record := &RequestRecord{
+		Version: RequestRecordVersion,
+		Time:    start,
+		Method:  req.Method,
+		Bucket:  bucketName(req),
+		Object:  objectName(req.URL),
+		Query:   req.URL.RawQuery,
+		Range:   req.Header.Get("Range"),
+		Size:    -1,
+	}
+	if gen, err := urlGeneration(req.URL); err == nil {
+		record.Generation = gen
+	}
+	for _, key := range conditionalHeaders {
+		if v := req.Header.Get(key); v != "" {
+			if record.Conditions == nil {
+				record.Conditions = make(map[string]string)
+			}
+			record.Conditions[key] = v
+		}
+	}
+	return record
+}
+
+// setOutcome records the response.
+func (record *RequestRecord) setOutcome(resp *http.Response, err error) {
+	if err != nil {
+		record.Error = err.Error()
+		return
+	}
+	record.StatusCode = resp.StatusCode
+	if gen, err := strconv.ParseInt(resp.Header.Get("x-goog-generation"), 10, 64); err == nil {
+		record.ResolvedGeneration = gen
+	}
+	record.ETag = resp.Header.Get("ETag")
+	record.LastModified = resp.Header.Get("Last-Modified")
+	if size, err := strconv.ParseInt(resp.Header.Get("x-goog-stored-content-length"), 10, 64); err == nil {
+		record.Size = size
+	}
+}
+
+// recordRequest calls the recorder configured by WithRequestRecorder.
+func (t *Transport) recordRequest(req *http.Request, start time.Time, resp *http.Response, err error) {
+	cfg := t.config.forBucket(bucketName(req))
+	if cfg.requestRecorder == nil {
+		return
+	}
+	record := newRequestRecord(req, start)
+	record.setOutcome(resp, err)
+	if isWriteMethod(req.Method) {
+		record.WriteRetry = writeRetryPolicy(req, cfg)
+	}
+	record.Client = t.clientLabel(req)
+	cfg.requestRecorder(record)
+}
+
+// ReplayDifference is a difference between the recorded outcome and the replayed one.
+type ReplayDifference struct {
+	Field    string
+	Recorded string
+	Replayed string
+}
+
+func (d ReplayDifference) String() string {
+	return fmt.Sprintf("%s: %q -> %q", d.Field, d.Recorded, d.Replayed)
+}
+
+// ReplayRequest re-issues the request of record with rt, and reports the differences of the outcome,
+// the status code, the error, the validators, and the size, from the recorded one.
+// The response body is discarded.
+func ReplayRequest(ctx context.Context, rt http.RoundTripper, record *RequestRecord) ([]ReplayDifference, error) {
+	if record.Version != RequestRecordVersion {
+		return nil, fmt.Errorf("gsprotocol: unsupported version of request record: %d", record.Version)
+	}
+
+	u := &url.URL{
+		Scheme:   "gs",
+		Host:     record.Bucket,
+		Path:     "/" + record.Object,
+		RawQuery: record.Query,
+	}
+	if record.Generation != 0 {
+		u.Fragment = strconv.FormatInt(record.Generation, 10)
+	}
+	req, err := http.NewRequestWithContext(ctx, record.Method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range record.Conditions {
+		req.Header.Set(key, value)
+	}
+	if record.Range != "" {
+		req.Header.Set("Range", record.Range)
+	}
+
+	replayed := newRequestRecord(req, time.Now())
+	resp, err := rt.RoundTrip(req)
+	replayed.setOutcome(resp, err)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return diffRequestRecords(record, replayed), nil
+}
+
+func diffRequestRecords(recorded, replayed *RequestRecord) []ReplayDifference {
+	fields := []struct {
+		name               string
+		recorded, replayed string
+	}{
+		{"status_code", strconv.Itoa(recorded.StatusCode), strconv.Itoa(replayed.StatusCode)},
+		{"error", recorded.Error, replayed.Error},
+		{"resolved_generation", strconv.FormatInt(recorded.ResolvedGeneration, 10), strconv.FormatInt(replayed.ResolvedGeneration, 10)},
+		{"etag", recorded.ETag, replayed.ETag},
+		{"last_modified", recorded.LastModified, replayed.LastModified},
+		{"size", strconv.FormatInt(recorded.Size, 10), strconv.FormatInt(replayed.Size, 10)},
+	}
+	var diffs []ReplayDifference
+	for _, f := range fields {
+		if f.recorded != f.replayed {
+			diffs = append(diffs, ReplayDifference{
+				Field:    f.name,
+				Recorded: f.recorded,
+				Replayed: f.replayed,
+			})
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,117 @@
+package gsprotocol
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestRoundTrip_RequestRecorder(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs: &storage.ObjectAttrs{
+				Generation: 1234567890,
+				MD5:        []byte{0x0b, 0x46, 0xf3, 0x06, 0xe9, 0x2d, 0x88, 0x51, 0x5e, 0x06, 0xd4, 0x8a, 0x62, 0xdc, 0xc3, 0x19},
+			},
+			content: "Hello Google Cloud Storage!",
+		},
+	})
+	var buf bytes.Buffer
+	c := &http.Client{Transport: newTestTransport(mock, WithRequestRecorder(NewJSONRequestRecorder(&buf)))}
+
+	req, err := http.NewRequest(http.MethodHead, "gs://bucket-name/object-key?decompress=gzip#1234567890", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-None-Match", `"foo"`)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	var record RequestRecord
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Time.IsZero() {
+		t.Error("want non-zero time")
+	}
+	record.Time = record.Time.UTC()
+	want := RequestRecord{
+		Version:            RequestRecordVersion,
+		Time:               record.Time,
+		Method:             http.MethodHead,
+		Bucket:             "bucket-name",
+		Object:             "object-key",
+		Query:              "decompress=gzip",
+		Generation:         1234567890,
+		Conditions:         map[string]string{"If-None-Match": `"foo"`},
+		StatusCode:         http.StatusOK,
+		ResolvedGeneration: 1234567890,
+		ETag:               `"0b46f306e92d88515e06d48a62dcc319"`,
+		Size:               27,
+	}
+	if !reflect.DeepEqual(record, want) {
+		t.Errorf("unexpected record: want %+v, got %+v", want, record)
+	}
+}
+
+func TestReplayRequest(t *testing.T) {
+	objects := map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs: &storage.ObjectAttrs{
+				Generation: 1,
+				MD5:        []byte{0x0b, 0x46, 0xf3, 0x06, 0xe9, 0x2d, 0x88, 0x51, 0x5e, 0x06, 0xd4, 0x8a, 0x62, 0xdc, 0xc3, 0x19},
+			},
+			content: "Hello Google Cloud Storage!",
+		},
+	}
+	var records []*RequestRecord
+	tr := newTestTransport(newStorageClientMockWithObjects(objects), WithRequestRecorder(func(record *RequestRecord) {
+		records = append(records, record)
+	}))
+	resp, err := (&http.Client{Transport: tr}).Get("gs://bucket-name/object-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(records) != 1 {
+		t.Fatalf("want 1 record, got %d", len(records))
+	}
+	record := records[0]
+
+	diffs, err := ReplayRequest(context.Background(), tr, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("want no differences, got %v", diffs)
+	}
+
+	// the object is overwritten.
+	objects["bucket-name/object-key"] = mockObject{
+		attrs: &storage.ObjectAttrs{
+			Generation: 2,
+			MD5:        []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10},
+		},
+		content: "Hello!",
+	}
+	diffs, err = ReplayRequest(context.Background(), tr, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ReplayDifference{
+		{"resolved_generation", "1", "2"},
+		{"etag", `"0b46f306e92d88515e06d48a62dcc319"`, `"0102030405060708090a0b0c0d0e0f10"`},
+		{"size", "27", "6"},
+	}
+	if !reflect.DeepEqual(diffs, want) {
+		t.Errorf("unexpected differences: want %v, got %v", want, diffs)
+	}
+}
@@ -0,0 +1,45 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"google.golang.org/api/option"
+)
+
+// RegisterTo creates a new Transport with opts, and registers it to tr for the gs and gcs schemes.
+// Use Transport.RegisterProtocols to register a Transport configured by WithSchemes.
+// It panics if tr already has the protocols for them, in the same way as http.Transport.RegisterProtocol.
+func RegisterTo(tr *http.Transport, ctx context.Context, opts ...option.ClientOption) error {
+	gs, err := NewTransport(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	gs.RegisterProtocols(tr)
+	return nil
+}
+
+// NewClient returns a new http.Client that serves the gs and gcs schemes by a new Transport with opts,
+// and the other schemes by a clone of http.DefaultTransport.
+// http.DefaultTransport itself is not modified.
+func NewClient(ctx context.Context, opts ...option.ClientOption) (*http.Client, error) {
+	def, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return nil, errors.New("gsprotocol: http.DefaultTransport is not *http.Transport")
+	}
+	tr := def.Clone()
+	if err := RegisterTo(tr, ctx, opts...); err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: tr}, nil
+}
+
+// RegisterProtocols registers t to tr for the schemes that t serves, gs and gcs by default.
+// See WithSchemes.
+// It panics if tr already has the protocols for them, in the same way as http.Transport.RegisterProtocol.
+func (t *Transport) RegisterProtocols(tr *http.Transport) {
+	for _, scheme := range t.config.servedSchemes() {
+		tr.RegisterProtocol(scheme, t)
+	}
+}
@@ -0,0 +1,87 @@
+package gsprotocol
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+func TestRegisterTo(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs:   &storage.ObjectAttrs{Generation: 1234567890},
+			content: "Hello Google Cloud Storage!",
+		},
+	})
+	gs := newTestTransport(mock)
+	tr := &http.Transport{}
+	gs.RegisterProtocols(tr)
+	c := &http.Client{Transport: tr}
+
+	for _, u := range []string{"gs://bucket-name/object-key", "gcs://bucket-name/object-key"} {
+		resp, err := c.Get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "Hello Google Cloud Storage!" {
+			t.Errorf("%s: unexpected body: %q", u, body)
+		}
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	c, err := NewClient(context.Background(), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Transport == http.DefaultTransport {
+		t.Error("want a clone of http.DefaultTransport")
+	}
+
+	// http.DefaultTransport is not modified.
+	req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := http.DefaultTransport.RoundTrip(req); err == nil {
+		resp.Body.Close()
+		t.Error("want an unsupported protocol error, got no error")
+	}
+}
+
+func TestRegisterProtocols_Schemes(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs:   &storage.ObjectAttrs{Generation: 1234567890},
+			content: "Hello Google Cloud Storage!",
+		},
+	})
+	gs := newTestTransport(mock, WithSchemes("gs", "artifact"))
+	tr := &http.Transport{}
+	gs.RegisterProtocols(tr)
+	c := &http.Client{Transport: tr}
+
+	resp, err := c.Get("artifact://bucket-name/object-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	// gcs is not registered.
+	if resp, err := c.Get("gcs://bucket-name/object-key"); err == nil {
+		resp.Body.Close()
+		t.Error("want an unsupported protocol error, got no error")
+	}
+}
@@ -0,0 +1,37 @@
+package gsprotocol
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/googleapis/gax-go/v2/apierror"
+)
+
+// requestIDHeader is the header for the request ID that Google support asks for.
+const requestIDHeader = "X-Goog-Request-Id"
+
+// requestID returns the request ID in the error details of err.
+// It returns an empty string if err has no request ID.
+func requestID(err error) string {
+	var apiErr *apierror.APIError
+	if !errors.As(err, &apiErr) {
+		return ""
+	}
+	return apiErr.Details().RequestInfo.GetRequestId()
+}
+
+// errorHeader returns the header for the response synthesized from the upstream error.
+// The upstream header, including X-GUploader-UploadID, is kept as is,
+// and the request ID in the error details is added as X-Goog-Request-Id.
+func errorHeader(upstream http.Header, err error) http.Header {
+	header := upstream.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	if header.Get(requestIDHeader) == "" {
+		if id := requestID(err); id != "" {
+			header.Set(requestIDHeader, id)
+		}
+	}
+	return header
+}
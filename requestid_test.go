@@ -0,0 +1,95 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newAPIErrorWithRequestID(t *testing.T, id string) *apierror.APIError {
+	t.Helper()
+	st, err := status.New(codes.Unavailable, "unavailable").WithDetails(&errdetails.RequestInfo{RequestId: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiErr, ok := apierror.FromError(st.Err())
+	if !ok {
+		t.Fatal("failed to convert the status to APIError")
+	}
+	return apiErr
+}
+
+// newErrorTestObject returns an object whose attributes fail with err.
+func newErrorTestObject(err error) *objectHandleMock {
+	return &objectHandleMock{
+		attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+			return nil, err
+		},
+	}
+}
+
+func TestRoundTrip_ErrorUploadID(t *testing.T) {
+	c := newTestClient(newSingleObjectMock(newErrorTestObject(&googleapi.Error{
+		Code: http.StatusServiceUnavailable,
+		Header: http.Header{
+			"X-Guploader-Uploadid": []string{"upload-id"},
+		},
+	})))
+	resp, err := c.Get("gs://bucket-name/object-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+	if got := resp.Header.Get("X-GUploader-UploadID"); got != "upload-id" {
+		t.Errorf("unexpected X-GUploader-UploadID: want %q, got %q", "upload-id", got)
+	}
+}
+
+func TestRoundTrip_ErrorRequestID(t *testing.T) {
+	gerr := &googleapi.Error{
+		Code: http.StatusServiceUnavailable,
+	}
+	gerr.Wrap(newAPIErrorWithRequestID(t, "request-id"))
+	c := newTestClient(newSingleObjectMock(newErrorTestObject(gerr)))
+	resp, err := c.Get("gs://bucket-name/object-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+	if got := resp.Header.Get("X-Goog-Request-Id"); got != "request-id" {
+		t.Errorf("unexpected X-Goog-Request-Id: want %q, got %q", "request-id", got)
+	}
+}
+
+func TestRoundTrip_ErrorRequestID_gRPC(t *testing.T) {
+	apiErr := newAPIErrorWithRequestID(t, "request-id")
+	c := newTestClient(newSingleObjectMock(newErrorTestObject(apiErr)))
+	_, err := c.Get("gs://bucket-name/object-key")
+	if err == nil {
+		t.Fatal("want error, got nil")
+	}
+	if !strings.Contains(err.Error(), "request id: request-id") {
+		t.Errorf("the error doesn't contain the request id: %v", err)
+	}
+	var target *apierror.APIError
+	if !errors.As(err, &target) {
+		t.Errorf("the error doesn't wrap the original error: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package gsprotocol
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+)
+
+// defaultResumeRetries is the default number of the resumptions of a response body.
+const defaultResumeRetries = 3
+
+// WithResumeRetries limits the number of the times that the Transport resumes reading an object
+// after transient errors, e.g. connection reset, in the middle of a response body.
+// The Transport reopens the reader at the offset delivered for the same generation,
+// so the body never mixes the contents of two generations.
+// After the retries run out, reading the body returns the error.
+// The default is 3 retries. Zero means the default, and negative n disables resuming.
+//
+// The responses to the Range header, parallel downloads, and the objects stored with gzip encoding
+// are not resumed.
+func WithResumeRetries(n int) Option {
+	return func(c *config) {
+		c.resumeRetries = n
+	}
+}
+
+// resumeRetriesOrDefault returns the number of the resumptions of a response body.
+func (c *config) resumeRetriesOrDefault() int {
+	if c.resumeRetries == 0 {
+		return defaultResumeRetries
+	}
+	if c.resumeRetries < 0 {
+		return 0
+	}
+	return c.resumeRetries
+}
+
+// resumableBody reads a generation of the object from the beginning to the end,
+// and reopens the reader at the offset on transient errors.
+type resumableBody struct {
+	ctx     context.Context
+	object  objectHandle
+	r       io.ReadCloser
+	attrs   storage.ReaderObjectAttrs
+	offset  int64
+	retries int
+
+	// unpinned is true if object is the live object, and then the body resumes the generation of attrs.
+	unpinned bool
+
+	// err is the error that the body gave up with.
+	err error
+}
+
+// newResumableBody returns the body that resumes reading r, the reader of the whole object.
+// object is pinned to the generation that r reads, or the live object if unpinned is true.
+func newResumableBody(ctx context.Context, object objectHandle, r storageReader, unpinned bool, retries int) *resumableBody {
+	return &resumableBody{
+		ctx:      ctx,
+		object:   object,
+		r:        r,
+		attrs:    r.Attrs(),
+		retries:  retries,
+		unpinned: unpinned,
+	}
+}
+
+// Attrs returns the attributes of the first reader.
+func (b *resumableBody) Attrs() storage.ReaderObjectAttrs {
+	return b.attrs
+}
+
+func (b *resumableBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	for {
+		n, err := b.r.Read(p)
+		b.offset += int64(n)
+		if err == nil || err == io.EOF || !b.resumable(err) {
+			return n, err
+		}
+		if !b.resume() {
+			// give up with the original error.
+			b.err = err
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// resumable reports whether the body resumes reading after err.
+func (b *resumableBody) resumable(err error) bool {
+	if b.retries <= 0 || b.offset >= b.attrs.Size || b.ctx.Err() != nil {
+		return false
+	}
+	if b.unpinned && b.attrs.Generation == 0 {
+		// the generation read is unknown.
+		return false
+	}
+	return storage.ShouldRetry(err)
+}
+
+// resume reopens the reader at the offset.
+func (b *resumableBody) resume() bool {
+	b.retries--
+	b.r.Close()
+	object := b.object
+	if b.unpinned {
+		object = object.Generation(b.attrs.Generation)
+	}
+	r, err := object.NewRangeReader(b.ctx, b.offset, -1)
+	if err != nil {
+		b.r = http.NoBody
+		return false
+	}
+	b.r = r
+	return true
+}
+
+// WriteTo implements io.WriterTo, and resumes in the same way as Read.
+func (b *resumableBody) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, b)
+}
+
+func (b *resumableBody) Close() error {
+	return b.r.Close()
+}
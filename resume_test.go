@@ -0,0 +1,146 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+// newResumeTestObject returns an object whose first failures readers fail with err, the i-th one after 5*i bytes.
+// opens records the generations that the readers are opened for.
+func newResumeTestObject(failures int, err error, opens *[]int64) *objectHandleMock {
+	const content = "Hello Google Cloud Storage!"
+	attrs := &storage.ObjectAttrs{
+		Bucket:         "bucket-name",
+		Name:           "object-key",
+		Size:           int64(len(content)),
+		Generation:     1587160158394554,
+		Metageneration: 1,
+	}
+	return &objectHandleMock{
+		attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+			return attrs, nil
+		},
+		newReaderFunc: func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+			*opens = append(*opens, mock.generation)
+			var r io.Reader = strings.NewReader(content)
+			if len(*opens) <= failures {
+				// the mock reader discards the bytes before the offset, so fail after them.
+				r = &failingReader{r: io.LimitReader(r, int64(5*len(*opens))), err: err}
+			}
+			return storage.ReaderObjectAttrs{
+				Size:           attrs.Size,
+				Generation:     attrs.Generation,
+				Metageneration: attrs.Metageneration,
+			}, io.NopCloser(r), nil
+		},
+		generationFunc: withGeneration,
+	}
+}
+
+func TestRoundTrip_Resume(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		err      error
+		opts     []Option
+		ctx      func(ctx context.Context) context.Context
+		wantErr  bool
+		opens    int
+	}{
+		{
+			name:     "resume",
+			failures: 1,
+			err:      io.ErrUnexpectedEOF,
+			opens:    2,
+		},
+		{
+			name:     "resume the retries",
+			failures: 3,
+			err:      io.ErrUnexpectedEOF,
+			opens:    4,
+		},
+		{
+			name:     "unpinned",
+			failures: 2,
+			err:      io.ErrUnexpectedEOF,
+			ctx:      WithoutGenerationPin,
+			opens:    3,
+		},
+		{
+			name:     "retries run out",
+			failures: 4,
+			err:      io.ErrUnexpectedEOF,
+			wantErr:  true,
+			opens:    4,
+		},
+		{
+			name:     "more retries",
+			failures: 4,
+			err:      io.ErrUnexpectedEOF,
+			opts:     []Option{WithResumeRetries(5)},
+			opens:    5,
+		},
+		{
+			name:     "disabled",
+			failures: 1,
+			err:      io.ErrUnexpectedEOF,
+			opts:     []Option{WithResumeRetries(-1)},
+			wantErr:  true,
+			opens:    1,
+		},
+		{
+			name:     "permanent error",
+			failures: 1,
+			err:      errors.New("permission denied"),
+			wantErr:  true,
+			opens:    1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opens []int64
+			tr := newTestTransport(newSingleObjectMock(newResumeTestObject(tt.failures, tt.err, &opens)), tt.opts...)
+			ctx := context.Background()
+			if tt.ctx != nil {
+				ctx = tt.ctx(ctx)
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "gs://bucket-name/object-key", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+
+			if tt.wantErr {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("want %v, got %v", tt.err, err)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(body) != "Hello Google Cloud Storage!" {
+					t.Errorf("unexpected body: %q", body)
+				}
+			}
+			if len(opens) != tt.opens {
+				t.Errorf("want %d readers, got %d", tt.opens, len(opens))
+			}
+			for i, gen := range opens[1:] {
+				if gen != 1587160158394554 {
+					t.Errorf("the reader %d is not pinned: %d", i+1, gen)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// the kinds of retentionError.
+const (
+	retentionTemporaryHold  = "temporary-hold"
+	retentionEventBasedHold = "event-based-hold"
+	retentionPolicy         = "retention-policy"
+)
+
+// retentionError is returned if the object can't be deleted because of a hold or a retention policy.
+type retentionError struct {
+	// kind is retentionTemporaryHold, retentionEventBasedHold or retentionPolicy.
+	kind string
+
+	// expiration is the time when the retention policy expires, or zero if it is unknown.
+	expiration time.Time
+}
+
+func (err *retentionError) Error() string {
+	switch err.kind {
+	case retentionTemporaryHold:
+		return "gsprotocol: the object is under the temporary hold"
+	case retentionEventBasedHold:
+		return "gsprotocol: the object is under the event-based hold"
+	}
+	if err.expiration.IsZero() {
+		return "gsprotocol: the object is retained by the retention policy"
+	}
+	return fmt.Sprintf("gsprotocol: the object is retained by the retention policy until %s", err.expiration.Format(time.RFC3339))
+}
+
+// newResponse returns the 409 Conflict response to the request that the hold or the retention policy rejected.
+// It is not retryable, because only removing the hold or waiting for the expiration helps.
+func (err *retentionError) newResponse() *http.Response {
+	resp := newErrorResponse(http.StatusConflict, err.Error())
+	resp.Header.Set("x-gsprotocol-error", err.kind)
+	if !err.expiration.IsZero() {
+		resp.Header.Set("X-Goog-Retention-Expiration", err.expiration.Format(http.TimeFormat))
+	}
+	return resp
+}
+
+// isRetentionError reports whether err is the error of Google Cloud Storage
+// that a hold or a retention policy rejects the deletion.
+func isRetentionError(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusForbidden {
+		return false
+	}
+	for _, item := range apiErr.Errors {
+		if item.Reason == "retentionPolicyNotMet" {
+			return true
+		}
+	}
+	// the XML API has no reasons in the errors.
+	msg := apiErr.Message + " " + apiErr.Body
+	return strings.Contains(msg, "RetentionPolicyNotMet") || strings.Contains(msg, "retentionPolicyNotMet")
+}
+
+// wrapRetentionError returns *retentionError if err is the error of deleting the object because of a hold or a retention policy.
+// It looks up the attributes of the object to tell the kind of the retention.
+// The other errors are returned as they are.
+func wrapRetentionError(ctx context.Context, object objectHandle, err error) error {
+	if !isRetentionError(err) {
+		return err
+	}
+	attrs, attrsErr := object.Attrs(ctx)
+	if attrsErr != nil {
+		return &retentionError{kind: retentionKindFromMessage(err)}
+	}
+	switch {
+	case attrs.TemporaryHold:
+		return &retentionError{kind: retentionTemporaryHold}
+	case attrs.EventBasedHold:
+		return &retentionError{kind: retentionEventBasedHold}
+	}
+	return &retentionError{kind: retentionPolicy, expiration: attrs.RetentionExpirationTime}
+}
+
+// retentionKindFromMessage guesses the kind of the retention from the error message of Google Cloud Storage,
+// e.g. "Object 'bucket/object' is under active Temporary hold and cannot be deleted, overwritten or archived until hold is removed."
+func retentionKindFromMessage(err error) string {
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "temporary hold"):
+		return retentionTemporaryHold
+	case strings.Contains(msg, "event-based hold"):
+		return retentionEventBasedHold
+	}
+	return retentionPolicy
+}
@@ -0,0 +1,146 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+func TestRoundTrip_DeleteRetention(t *testing.T) {
+	expiration := time.Date(2030, time.January, 2, 3, 4, 5, 0, time.UTC)
+	retentionErr := func(msg string) error {
+		return &googleapi.Error{
+			Code:    http.StatusForbidden,
+			Message: msg,
+			Errors:  []googleapi.ErrorItem{{Reason: "retentionPolicyNotMet", Message: msg}},
+		}
+	}
+	tests := []struct {
+		name       string
+		deleteErr  error
+		attrs      *storage.ObjectAttrs
+		attrsErr   error
+		status     int
+		errorType  string
+		body       string
+		expiration string
+	}{
+		{
+			name:      "temporary hold",
+			deleteErr: retentionErr("Object 'bucket-name/object-key' is under active Temporary hold and cannot be deleted, overwritten or archived until hold is removed."),
+			attrs:     &storage.ObjectAttrs{TemporaryHold: true},
+			status:    http.StatusConflict,
+			errorType: "temporary-hold",
+			body:      "temporary hold",
+		},
+		{
+			name:      "event-based hold",
+			deleteErr: retentionErr("Object 'bucket-name/object-key' is under active Event-Based hold and cannot be deleted, overwritten or archived until hold is removed."),
+			attrs:     &storage.ObjectAttrs{EventBasedHold: true},
+			status:    http.StatusConflict,
+			errorType: "event-based-hold",
+			body:      "event-based hold",
+		},
+		{
+			name:       "retention policy",
+			deleteErr:  retentionErr("Object 'bucket-name/object-key' is subject to bucket's retention policy and cannot be deleted, overwritten or archived until 2030-01-02T03:04:05Z"),
+			attrs:      &storage.ObjectAttrs{RetentionExpirationTime: expiration},
+			status:     http.StatusConflict,
+			errorType:  "retention-policy",
+			body:       "until 2030-01-02T03:04:05Z",
+			expiration: "Wed, 02 Jan 2030 03:04:05 GMT",
+		},
+		{
+			name:      "hold without attrs",
+			deleteErr: retentionErr("Object 'bucket-name/object-key' is under active Event-Based hold and cannot be deleted, overwritten or archived until hold is removed."),
+			attrsErr:  &googleapi.Error{Code: http.StatusForbidden},
+			status:    http.StatusConflict,
+			errorType: "event-based-hold",
+			body:      "event-based hold",
+		},
+		{
+			name:      "permission denied",
+			deleteErr: &googleapi.Error{Code: http.StatusForbidden, Body: "permission denied"},
+			status:    http.StatusForbidden,
+			body:      "permission denied",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deletes int
+			object := &objectHandleMock{
+				attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+					if tt.attrs == nil {
+						return nil, tt.attrsErr
+					}
+					return tt.attrs, nil
+				},
+				deleteFunc: func(ctx context.Context, mock *objectHandleMock) error {
+					deletes++
+					return tt.deleteErr
+				},
+				generationFunc: withGeneration,
+			}
+			tr := newTestTransport(newSingleObjectMock(object), WithWriteMethods(), WithWriteRetry(3, time.Millisecond))
+
+			// the generation makes the deletion idempotent, but the retention errors are not retried.
+			req, err := http.NewRequest(http.MethodDelete, "gs://bucket-name/object-key#1587160158394554", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("unexpected status: want %d, got %d", tt.status, resp.StatusCode)
+			}
+			if got := resp.Header.Get("x-gsprotocol-error"); got != tt.errorType {
+				t.Errorf("unexpected x-gsprotocol-error: want %q, got %q", tt.errorType, got)
+			}
+			if got := resp.Header.Get("X-Goog-Retention-Expiration"); got != tt.expiration {
+				t.Errorf("unexpected X-Goog-Retention-Expiration: want %q, got %q", tt.expiration, got)
+			}
+			if !strings.Contains(string(body), tt.body) {
+				t.Errorf("want the body containing %q, got %q", tt.body, body)
+			}
+			if deletes != 1 {
+				t.Errorf("want 1 deletion, got %d", deletes)
+			}
+		})
+	}
+}
+
+func TestIsRetentionError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"reason", &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "retentionPolicyNotMet"}}}, true},
+		{"xml", &googleapi.Error{Code: http.StatusForbidden, Body: "<Error><Code>RetentionPolicyNotMet</Code></Error>"}, true},
+		{"forbidden", &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "forbidden"}}}, false},
+		{"not found", storage.ErrObjectNotExist, false},
+		{"other", errors.New("retentionPolicyNotMet"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetentionError(tt.err); got != tt.want {
+				t.Errorf("want %t, got %t", tt.want, got)
+			}
+		})
+	}
+}
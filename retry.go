@@ -0,0 +1,407 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// the defaults of WithRetry.
+const (
+	defaultRetryBackoff = 100 * time.Millisecond
+	maxRetryBackoff     = 30 * time.Second
+)
+
+// WithRetry makes the Transport retry looking up the attributes and opening the readers of objects
+// on 429, 500, 502, 503 and 504 responses and on transient network errors, up to maxAttempts attempts in total.
+// The Transport waits for the Retry-After header of the responses, or jittered exponential backoff from initialBackoff
+// if there is none, between the attempts,
+// and gives up if the deadline of the request context comes before the next attempt.
+// Only GET and HEAD requests are retried, and the failed responses after retries have the x-gsprotocol-attempts header.
+// The retries are reported by RequestStats.Retries.
+// It is disabled by default. Zero or negative initialBackoff means 100ms.
+func WithRetry(maxAttempts int, initialBackoff time.Duration) Option {
+	return func(c *config) {
+		c.retryMaxAttempts = maxAttempts
+		c.retryBackoff = initialBackoff
+	}
+}
+
+// WithWriteRetry makes the Transport retry the write requests on the same failures as WithRetry,
+// up to maxAttempts attempts in total, with the same backoff.
+// Retrying a write that is not idempotent may create duplicate generations or overwrite a concurrent writer,
+// so only the idempotent requests are retried, following the rules of storage.RetryIdempotent:
+// PUT and POST requests with the x-goog-if-generation-match header,
+// PATCH requests with the x-goog-if-metageneration-match header, and
+// DELETE requests with the x-goog-if-generation-match header or a generation, e.g. gs://[BUCKET_NAME]/[OBJECT_NAME]#[GENERATION].
+// The requests whose contexts are marked by WithIdempotentWrite are always retried, like storage.RetryAlways.
+// PUT requests are retried only if Request.GetBody can rewind their bodies.
+// The policy chosen for each request is recorded in RequestRecord.WriteRetry.
+// It is independent of WithRetry, and disabled by default. Zero or negative initialBackoff means 100ms.
+func WithWriteRetry(maxAttempts int, initialBackoff time.Duration) Option {
+	return func(c *config) {
+		c.writeRetryMaxAttempts = maxAttempts
+		c.writeRetryBackoff = initialBackoff
+	}
+}
+
+type idempotentWriteKey struct{}
+
+// WithIdempotentWrite returns a copy of ctx that marks the write request as idempotent,
+// so that WithWriteRetry retries it without any preconditions.
+// Use it with http.Request.WithContext.
+func WithIdempotentWrite(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentWriteKey{}, true)
+}
+
+func isIdempotentWrite(ctx context.Context) bool {
+	idempotent, _ := ctx.Value(idempotentWriteKey{}).(bool)
+	return idempotent
+}
+
+// the policies of retrying write requests, recorded in RequestRecord.WriteRetry.
+const (
+	// writeRetryNone means that the request is not retried.
+	writeRetryNone = "none"
+
+	// writeRetryPrecondition means that the request is retried because its preconditions make it idempotent.
+	writeRetryPrecondition = "precondition"
+
+	// writeRetryIdempotent means that the request is retried because WithIdempotentWrite marks it.
+	writeRetryIdempotent = "idempotent"
+)
+
+// writeRetryPolicy returns the policy of retrying the write request req.
+func writeRetryPolicy(req *http.Request, cfg *config) string {
+	if cfg.writeRetryMaxAttempts <= 1 {
+		return writeRetryNone
+	}
+	if req.Method == http.MethodPut && req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		// the body can't be sent again.
+		return writeRetryNone
+	}
+	if isIdempotentWrite(req.Context()) {
+		return writeRetryIdempotent
+	}
+	genMatch := req.Header.Get("X-Goog-If-Generation-Match") != ""
+	switch req.Method {
+	case http.MethodPut, http.MethodPost:
+		if genMatch {
+			return writeRetryPrecondition
+		}
+	case http.MethodPatch:
+		if req.Header.Get("X-Goog-If-Metageneration-Match") != "" {
+			return writeRetryPrecondition
+		}
+	case http.MethodDelete:
+		if genMatch || req.URL.Fragment != "" {
+			return writeRetryPrecondition
+		}
+	}
+	return writeRetryNone
+}
+
+// withWriteRetry serves the write request by serve, and retries it on the transient failures if it is idempotent.
+// The failures are the responses, because serve converts the errors of Google Cloud Storage into them.
+func (t *Transport) withWriteRetry(req *http.Request, client storageClient, cfg *config, serve func(req *http.Request, client storageClient) (*http.Response, error)) (*http.Response, error) {
+	if writeRetryPolicy(req, cfg) == writeRetryNone {
+		return serve(req, client)
+	}
+	r := &retrier{
+		maxAttempts: cfg.writeRetryMaxAttempts,
+		backoff:     cfg.writeRetryBackoff,
+	}
+	if r.backoff <= 0 {
+		r.backoff = defaultRetryBackoff
+	}
+
+	ctx := req.Context()
+	for attempt := 1; ; attempt++ {
+		resp, err := serve(req, client)
+		if attempt >= r.maxAttempts || !isRetryableResponse(resp, err) {
+			statsFromContext(ctx).recordRetries(attempt - 1)
+			if resp != nil && resp.StatusCode >= 400 && attempt > 1 {
+				resp.Header.Set("X-Gsprotocol-Attempts", strconv.Itoa(attempt))
+			}
+			return resp, err
+		}
+
+		var wait time.Duration
+		if err != nil {
+			wait = retryAfter(err)
+		} else {
+			wait = parseRetryAfter(resp.Header.Get("Retry-After"))
+		}
+		if wait <= 0 {
+			wait = r.wait(attempt)
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return resp, err
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return resp, err
+		case <-timer.C:
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			// roundTrip closes the body of the first attempt.
+			defer body.Close()
+			req = req.WithContext(ctx)
+			req.Body = body
+		}
+	}
+}
+
+// isRetryableResponse reports whether the response or the error of a write request is transient.
+func isRetryableResponse(resp *http.Response, err error) bool {
+	if err != nil {
+		return isRetryable(err)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retrier retries the calls of a request.
+// It is shared by the goroutines that read the response body, e.g. parallel downloads.
+type retrier struct {
+	maxAttempts int
+	backoff     time.Duration
+
+	mu sync.Mutex
+	// attempts is the number of the attempts of the last call.
+	attempts int
+	// retries is the number of the retries of all the calls.
+	retries int
+}
+
+// withRetry serves the GET or HEAD request by serve with the client that retries the transient errors.
+func (t *Transport) withRetry(req *http.Request, client storageClient, cfg *config, serve func(req *http.Request, client storageClient) (*http.Response, error)) (*http.Response, error) {
+	if cfg.retryMaxAttempts <= 1 {
+		return serve(req, client)
+	}
+	r := &retrier{
+		maxAttempts: cfg.retryMaxAttempts,
+		backoff:     cfg.retryBackoff,
+	}
+	if r.backoff <= 0 {
+		r.backoff = defaultRetryBackoff
+	}
+	resp, err := serve(req, &retryClient{storageClient: client, retrier: r})
+
+	r.mu.Lock()
+	attempts, retries := r.attempts, r.retries
+	r.mu.Unlock()
+	statsFromContext(req.Context()).recordRetries(retries)
+	if resp != nil && resp.StatusCode >= 400 && attempts > 1 {
+		resp.Header.Set("X-Gsprotocol-Attempts", strconv.Itoa(attempts))
+	}
+	return resp, err
+}
+
+// do calls f until it succeeds, fails with a permanent error, or the attempts run out.
+func (r *retrier) do(ctx context.Context, f func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := f()
+		r.mu.Lock()
+		r.attempts = attempt
+		r.mu.Unlock()
+		if err == nil || attempt >= r.maxAttempts || !isRetryable(err) {
+			return err
+		}
+
+		wait := retryAfter(err)
+		if wait <= 0 {
+			wait = r.wait(attempt)
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return err
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		r.mu.Lock()
+		r.retries++
+		r.mu.Unlock()
+	}
+}
+
+// wait returns the jittered exponential backoff after the attempt.
+func (r *retrier) wait(attempt int) time.Duration {
+	d := r.backoff
+	for i := 1; i < attempt && d < maxRetryBackoff; i++ {
+		d *= 2
+	}
+	if d > maxRetryBackoff {
+		d = maxRetryBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryAfter returns the wait that the Retry-After header of err asks for.
+// It returns zero if err has no valid Retry-After header.
+func retryAfter(err error) time.Duration {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return 0
+	}
+	return parseRetryAfter(apiErr.Header.Get("Retry-After"))
+}
+
+// parseRetryAfter returns the wait of the Retry-After header value v.
+// It returns zero if v is not valid.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	var wait time.Duration
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		if secs > int64(maxRetryBackoff/time.Second) {
+			return maxRetryBackoff
+		}
+		wait = time.Duration(secs) * time.Second
+	} else if date, err := http.ParseTime(v); err == nil {
+		wait = time.Until(date)
+	}
+	if wait > maxRetryBackoff {
+		wait = maxRetryBackoff
+	}
+	return wait
+}
+
+// isRetryable reports whether err is transient.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	return storage.ShouldRetry(err)
+}
+
+// retryClient retries the calls to read objects.
+type retryClient struct {
+	storageClient
+	retrier *retrier
+}
+
+func (c *retryClient) unwrap() storageClient {
+	return c.storageClient
+}
+
+func (c *retryClient) Bucket(name string) bucketHandle {
+	return &retryBucketHandle{
+		bucketHandle: c.storageClient.Bucket(name),
+		retrier:      c.retrier,
+	}
+}
+
+type retryBucketHandle struct {
+	bucketHandle
+	retrier *retrier
+}
+
+func (h *retryBucketHandle) Object(name string) objectHandle {
+	return &retryObjectHandle{
+		objectHandle: h.bucketHandle.Object(name),
+		retrier:      h.retrier,
+	}
+}
+
+func (h *retryBucketHandle) UserProject(project string) bucketHandle {
+	return &retryBucketHandle{
+		bucketHandle: h.bucketHandle.UserProject(project),
+		retrier:      h.retrier,
+	}
+}
+
+type retryObjectHandle struct {
+	objectHandle
+	retrier *retrier
+}
+
+func (h *retryObjectHandle) Attrs(ctx context.Context) (attrs *storage.ObjectAttrs, err error) {
+	err = h.retrier.do(ctx, func() error {
+		attrs, err = h.objectHandle.Attrs(ctx)
+		return err
+	})
+	return attrs, err
+}
+
+func (h *retryObjectHandle) NewReader(ctx context.Context) (r storageReader, err error) {
+	err = h.retrier.do(ctx, func() error {
+		r, err = h.objectHandle.NewReader(ctx)
+		return err
+	})
+	return r, err
+}
+
+func (h *retryObjectHandle) NewRangeReader(ctx context.Context, offset, length int64) (r storageReader, err error) {
+	err = h.retrier.do(ctx, func() error {
+		r, err = h.objectHandle.NewRangeReader(ctx, offset, length)
+		return err
+	})
+	return r, err
+}
+
+func (h *retryObjectHandle) If(conds storage.Conditions) objectHandle {
+	return &retryObjectHandle{
+		objectHandle: h.objectHandle.If(conds),
+		retrier:      h.retrier,
+	}
+}
+
+func (h *retryObjectHandle) Generation(gen int64) objectHandle {
+	return &retryObjectHandle{
+		objectHandle: h.objectHandle.Generation(gen),
+		retrier:      h.retrier,
+	}
+}
+
+func (h *retryObjectHandle) Key(encryptionKey []byte) objectHandle {
+	return &retryObjectHandle{
+		objectHandle: h.objectHandle.Key(encryptionKey),
+		retrier:      h.retrier,
+	}
+}
+
+func (h *retryObjectHandle) ReadCompressed(compressed bool) objectHandle {
+	return &retryObjectHandle{
+		objectHandle: h.objectHandle.ReadCompressed(compressed),
+		retrier:      h.retrier,
+	}
+}
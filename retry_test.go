@@ -0,0 +1,484 @@
+package gsprotocol
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// newRetryTestObject returns an object whose Attrs and NewReader fail with attrsErrs and readerErrs in order,
+// and then succeed. calls counts the calls of Attrs.
+func newRetryTestObject(attrsErrs, readerErrs []error, calls *int) *objectHandleMock {
+	const content = "Hello Google Cloud Storage!"
+	return &objectHandleMock{
+		attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+			*calls++
+			if len(attrsErrs) > 0 {
+				err := attrsErrs[0]
+				attrsErrs = attrsErrs[1:]
+				return nil, err
+			}
+			return &storage.ObjectAttrs{
+				Bucket:     "bucket-name",
+				Name:       "object-key",
+				Size:       int64(len(content)),
+				Generation: 1587160158394554,
+			}, nil
+		},
+		newReaderFunc: func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+			if len(readerErrs) > 0 {
+				err := readerErrs[0]
+				readerErrs = readerErrs[1:]
+				return storage.ReaderObjectAttrs{}, nil, err
+			}
+			return storage.ReaderObjectAttrs{
+				Size:       int64(len(content)),
+				Generation: 1587160158394554,
+			}, io.NopCloser(strings.NewReader(content)), nil
+		},
+		generationFunc: func(mock *objectHandleMock, gen int64) *objectHandleMock {
+			return mock
+		},
+	}
+}
+
+func TestRoundTrip_Retry(t *testing.T) {
+	errUnavailable := &googleapi.Error{Code: http.StatusServiceUnavailable, Message: "unavailable"}
+	errTooMany := &googleapi.Error{Code: http.StatusTooManyRequests, Message: "too many requests"}
+	tests := []struct {
+		name       string
+		method     string
+		attrsErrs  []error
+		readerErrs []error
+		opts       []Option
+		status     int
+		calls      int
+		retries    int
+		attempts   string
+	}{
+		{
+			name:      "attrs fails twice",
+			method:    http.MethodGet,
+			attrsErrs: []error{errUnavailable, errTooMany},
+			opts:      []Option{WithRetry(3, time.Millisecond)},
+			status:    http.StatusOK,
+			calls:     3,
+			retries:   2,
+		},
+		{
+			name:       "reader fails once",
+			method:     http.MethodGet,
+			readerErrs: []error{io.ErrUnexpectedEOF},
+			opts:       []Option{WithRetry(3, time.Millisecond)},
+			status:     http.StatusOK,
+			calls:      1,
+			retries:    1,
+		},
+		{
+			name:      "HEAD",
+			method:    http.MethodHead,
+			attrsErrs: []error{errUnavailable},
+			opts:      []Option{WithRetry(3, time.Millisecond)},
+			status:    http.StatusOK,
+			calls:     2,
+			retries:   1,
+		},
+		{
+			name:      "attempts run out",
+			method:    http.MethodGet,
+			attrsErrs: []error{errUnavailable, errUnavailable, errUnavailable},
+			opts:      []Option{WithRetry(3, time.Millisecond)},
+			status:    http.StatusServiceUnavailable,
+			calls:     3,
+			retries:   2,
+			attempts:  "3",
+		},
+		{
+			name:      "permanent error",
+			method:    http.MethodGet,
+			attrsErrs: []error{&googleapi.Error{Code: http.StatusForbidden, Message: "forbidden"}},
+			opts:      []Option{WithRetry(3, time.Millisecond)},
+			status:    http.StatusForbidden,
+			calls:     1,
+		},
+		{
+			name:      "not found",
+			method:    http.MethodGet,
+			attrsErrs: []error{storage.ErrObjectNotExist},
+			opts:      []Option{WithRetry(3, time.Millisecond)},
+			status:    http.StatusNotFound,
+			calls:     1,
+		},
+		{
+			name:      "disabled",
+			method:    http.MethodGet,
+			attrsErrs: []error{errUnavailable},
+			status:    http.StatusServiceUnavailable,
+			calls:     1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			tr := newTestTransport(newSingleObjectMock(newRetryTestObject(tt.attrsErrs, tt.readerErrs, &calls)), tt.opts...)
+			var stats RequestStats
+			req, err := http.NewRequestWithContext(WithStatsRecorder(context.Background(), &stats), tt.method, "gs://bucket-name/object-key", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("unexpected status: want %d, got %d", tt.status, resp.StatusCode)
+			}
+			if calls != tt.calls {
+				t.Errorf("unexpected calls of Attrs: want %d, got %d", tt.calls, calls)
+			}
+			if stats.Retries != tt.retries {
+				t.Errorf("unexpected retries: want %d, got %d", tt.retries, stats.Retries)
+			}
+			if got := resp.Header.Get("X-Gsprotocol-Attempts"); got != tt.attempts {
+				t.Errorf("unexpected X-Gsprotocol-Attempts: want %q, got %q", tt.attempts, got)
+			}
+		})
+	}
+}
+
+func TestRoundTrip_RetryDeadline(t *testing.T) {
+	var calls int
+	errUnavailable := &googleapi.Error{Code: http.StatusServiceUnavailable, Message: "unavailable"}
+	tr := newTestTransport(newSingleObjectMock(newRetryTestObject([]error{errUnavailable}, nil, &calls)), WithRetry(3, time.Hour))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// the backoff exceeds the deadline, so it gives up without waiting.
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+	if calls != 1 {
+		t.Errorf("want 1 call, got %d", calls)
+	}
+}
+
+func TestRetrier_Wait(t *testing.T) {
+	r := &retrier{backoff: 100 * time.Millisecond}
+	for attempt, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond} {
+		got := r.wait(attempt + 1)
+		if got < want/2 || got > want {
+			t.Errorf("attempt %d: want between %s and %s, got %s", attempt+1, want/2, want, got)
+		}
+	}
+	if got := r.wait(100); got > maxRetryBackoff {
+		t.Errorf("want at most %s, got %s", maxRetryBackoff, got)
+	}
+}
+
+func TestHandleError_RetryAfter(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "upstream",
+			err:  &googleapi.Error{Code: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"7"}}},
+			want: "7",
+		},
+		{
+			name: "synthesized 429",
+			err:  &googleapi.Error{Code: http.StatusTooManyRequests},
+			want: "1",
+		},
+		{
+			name: "synthesized 503",
+			err:  &googleapi.Error{Code: http.StatusServiceUnavailable},
+			want: "1",
+		},
+		{
+			name: "other errors",
+			err:  &googleapi.Error{Code: http.StatusInternalServerError},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := handleError(tt.err)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := resp.Header.Get("Retry-After"); got != tt.want {
+				t.Errorf("want Retry-After %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	newErr := func(v string) error {
+		return &googleapi.Error{Code: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {v}}}
+	}
+	if got := retryAfter(newErr("2")); got != 2*time.Second {
+		t.Errorf("want 2s, got %s", got)
+	}
+	if got := retryAfter(newErr("86400")); got != maxRetryBackoff {
+		t.Errorf("want %s, got %s", maxRetryBackoff, got)
+	}
+	date := time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat)
+	if got := retryAfter(newErr(date)); got <= 8*time.Second || got > 10*time.Second {
+		t.Errorf("want about 10s, got %s", got)
+	}
+	if got := retryAfter(newErr("invalid")); got != 0 {
+		t.Errorf("want 0, got %s", got)
+	}
+	if got := retryAfter(io.ErrUnexpectedEOF); got != 0 {
+		t.Errorf("want 0, got %s", got)
+	}
+}
+
+func TestRoundTrip_RetryAfter(t *testing.T) {
+	var calls int
+	errTooMany := &googleapi.Error{
+		Code:   http.StatusTooManyRequests,
+		Header: http.Header{"Retry-After": {"1"}},
+	}
+	// the backoff is much longer than Retry-After, so the retry must follow Retry-After within the deadline.
+	tr := newTestTransport(newSingleObjectMock(newRetryTestObject([]error{errTooMany}, nil, &calls)), WithRetry(3, time.Hour))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if calls != 2 {
+		t.Errorf("want 2 calls, got %d", calls)
+	}
+	if d := time.Since(start); d < time.Second {
+		t.Errorf("want waiting for Retry-After, got %s", d)
+	}
+}
+
+func TestRoundTrip_WriteRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		header     http.Header
+		idempotent bool
+		body       func() io.Reader
+		policy     string
+		calls      int
+		status     int
+	}{
+		{
+			name:   "put without preconditions",
+			method: http.MethodPut,
+			url:    "gs://bucket-name/object-key",
+			body:   func() io.Reader { return strings.NewReader("Hello") },
+			policy: writeRetryNone,
+			calls:  1,
+			status: http.StatusInternalServerError,
+		},
+		{
+			name:   "put with preconditions",
+			method: http.MethodPut,
+			url:    "gs://bucket-name/object-key",
+			header: http.Header{"X-Goog-If-Generation-Match": {"0"}},
+			body:   func() io.Reader { return strings.NewReader("Hello") },
+			policy: writeRetryPrecondition,
+			calls:  3,
+			status: http.StatusOK,
+		},
+		{
+			name:   "put with an unrewindable body",
+			method: http.MethodPut,
+			url:    "gs://bucket-name/object-key",
+			header: http.Header{"X-Goog-If-Generation-Match": {"0"}},
+			body:   func() io.Reader { return io.MultiReader(strings.NewReader("Hello")) },
+			policy: writeRetryNone,
+			calls:  1,
+			status: http.StatusInternalServerError,
+		},
+		{
+			name:       "idempotent put",
+			method:     http.MethodPut,
+			url:        "gs://bucket-name/object-key",
+			idempotent: true,
+			body:       func() io.Reader { return strings.NewReader("Hello") },
+			policy:     writeRetryIdempotent,
+			calls:      3,
+			status:     http.StatusOK,
+		},
+		{
+			name:   "delete without preconditions",
+			method: http.MethodDelete,
+			url:    "gs://bucket-name/object-key",
+			policy: writeRetryNone,
+			calls:  1,
+			status: http.StatusInternalServerError,
+		},
+		{
+			name:   "delete a generation",
+			method: http.MethodDelete,
+			url:    "gs://bucket-name/object-key#1587160158394554",
+			policy: writeRetryPrecondition,
+			calls:  3,
+			status: http.StatusNoContent,
+		},
+		{
+			name:   "patch with the generation precondition",
+			method: http.MethodPatch,
+			url:    "gs://bucket-name/object-key",
+			header: http.Header{"X-Goog-If-Generation-Match": {"1587160158394554"}},
+			policy: writeRetryNone,
+			calls:  1,
+			status: http.StatusInternalServerError,
+		},
+		{
+			name:   "patch with the metageneration precondition",
+			method: http.MethodPatch,
+			url:    "gs://bucket-name/object-key",
+			header: http.Header{"X-Goog-If-Metageneration-Match": {"1"}},
+			policy: writeRetryPrecondition,
+			calls:  3,
+			status: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			// fail the first two calls.
+			fail := func() error {
+				calls++
+				if calls <= 2 {
+					return &googleapi.Error{Code: http.StatusInternalServerError, Message: "backend error"}
+				}
+				return nil
+			}
+			object := &objectHandleMock{
+				newWriterFunc: func(ctx context.Context, mock *objectHandleMock) *storageWriterMock {
+					return &storageWriterMock{
+						ctx: ctx,
+						closeFunc: func(w *storageWriterMock) (*storage.ObjectAttrs, error) {
+							if err := fail(); err != nil {
+								return nil, err
+							}
+							if got := w.buf.String(); got != "Hello" {
+								t.Errorf("unexpected content: %q", got)
+							}
+							return &storage.ObjectAttrs{Generation: 1587160158394554}, nil
+						},
+					}
+				},
+				deleteFunc: func(ctx context.Context, mock *objectHandleMock) error {
+					return fail()
+				},
+				updateFunc: func(ctx context.Context, mock *objectHandleMock, attrs storage.ObjectAttrsToUpdate) (*storage.ObjectAttrs, error) {
+					if err := fail(); err != nil {
+						return nil, err
+					}
+					return &storage.ObjectAttrs{Generation: 1587160158394554, Metageneration: 2}, nil
+				},
+				generationFunc: func(mock *objectHandleMock, gen int64) *objectHandleMock {
+					return mock
+				},
+			}
+			var record *RequestRecord
+			tr := newTestTransport(newSingleObjectMock(object),
+				WithWriteMethods(),
+				WithWriteRetry(3, time.Millisecond),
+				WithRequestRecorder(func(r *RequestRecord) {
+					record = r
+				}),
+			)
+
+			var body io.Reader
+			if tt.body != nil {
+				body = tt.body()
+			}
+			req, err := http.NewRequest(tt.method, tt.url, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			if tt.idempotent {
+				req = req.WithContext(WithIdempotentWrite(req.Context()))
+			}
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("unexpected status: want %d, got %d", tt.status, resp.StatusCode)
+			}
+			if calls != tt.calls {
+				t.Errorf("unexpected calls: want %d, got %d", tt.calls, calls)
+			}
+			if record == nil || record.WriteRetry != tt.policy {
+				t.Errorf("unexpected policy: want %q, got %+v", tt.policy, record)
+			}
+		})
+	}
+}
+
+func TestRoundTrip_WriteRetryDisabled(t *testing.T) {
+	// WithRetry doesn't retry the write requests.
+	var calls int
+	object := &objectHandleMock{
+		newWriterFunc: func(ctx context.Context, mock *objectHandleMock) *storageWriterMock {
+			return &storageWriterMock{
+				ctx: ctx,
+				closeFunc: func(w *storageWriterMock) (*storage.ObjectAttrs, error) {
+					calls++
+					return nil, &googleapi.Error{Code: http.StatusInternalServerError, Message: "backend error"}
+				},
+			}
+		},
+	}
+	tr := newTestTransport(newSingleObjectMock(object), WithWriteMethods(), WithRetry(3, time.Millisecond))
+	req, err := http.NewRequest(http.MethodPut, "gs://bucket-name/object-key", strings.NewReader("Hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Goog-If-Generation-Match", "0")
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if calls != 1 {
+		t.Errorf("unexpected calls: want 1, got %d", calls)
+	}
+}
@@ -0,0 +1,141 @@
+package gsprotocol
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestRoundTrip_ReverseProxy(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs: &storage.ObjectAttrs{
+				ContentType: "text/plain",
+				Generation:  1234567890,
+				MD5:         []byte{0x0b, 0x46, 0xf3, 0x06, 0xe9, 0x2d, 0x88, 0x51, 0x5e, 0x06, 0xd4, 0x8a, 0x62, 0xdc, 0xc3, 0x19},
+			},
+			content: "Hello Google Cloud Storage!",
+		},
+	})
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "gs"
+			req.URL.Host = "bucket-name"
+			req.Host = ""
+		},
+		Transport: newTestTransport(mock),
+	}
+	ts := httptest.NewServer(proxy)
+	defer ts.Close()
+
+	tc := []struct {
+		name          string
+		method        string
+		path          string
+		header        map[string]string
+		status        int
+		contentLength string
+		body          string
+	}{
+		{
+			name:          "GET",
+			method:        http.MethodGet,
+			path:          "/object-key",
+			header:        map[string]string{"Connection": "x-goog-if-generation-not-match", "x-goog-if-generation-not-match": "1234567890"},
+			status:        http.StatusOK,
+			contentLength: "27",
+			body:          "Hello Google Cloud Storage!",
+		},
+		{
+			name:          "HEAD",
+			method:        http.MethodHead,
+			path:          "/object-key",
+			status:        http.StatusOK,
+			contentLength: "27",
+		},
+		{
+			name:   "Not Modified",
+			method: http.MethodGet,
+			path:   "/object-key",
+			header: map[string]string{"If-None-Match": `"0b46f306e92d88515e06d48a62dcc319"`},
+			status: http.StatusNotModified,
+		},
+		{
+			name:   "Not Found",
+			method: http.MethodGet,
+			path:   "/not-found",
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "Method Not Allowed",
+			method: http.MethodPost,
+			path:   "/object-key",
+			status: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			resp, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("unexpected status: want %d, got %d", tt.status, resp.StatusCode)
+			}
+			if tt.contentLength != "" {
+				if got := resp.Header.Get("Content-Length"); got != tt.contentLength {
+					t.Errorf("unexpected Content-Length: want %q, got %q", tt.contentLength, got)
+				}
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.body {
+				t.Errorf("unexpected body: want %q, got %q", tt.body, string(body))
+			}
+		})
+	}
+}
+
+func TestRoundTrip_RequestURI(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs:   &storage.ObjectAttrs{Generation: 1234567890},
+			content: "Hello Google Cloud Storage!",
+		},
+	})
+	tr := newTestTransport(mock)
+
+	req := httptest.NewRequest(http.MethodHead, "gs://bucket-name/object-key", nil)
+	req.RequestURI = "/object-key"
+	req.Header.Set("Connection", "close")
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if resp.Body == nil {
+		t.Error("want non-nil Body")
+	}
+	if resp.ContentLength != 27 {
+		t.Errorf("unexpected ContentLength: want %d, got %d", 27, resp.ContentLength)
+	}
+}
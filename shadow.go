@@ -0,0 +1,193 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// the defaults of WithShadowReadLimits.
+const (
+	defaultShadowMaxConcurrent = 16
+	defaultShadowTimeout       = 10 * time.Second
+)
+
+// ShadowResult is the result of a request compared by the shadow reads.
+type ShadowResult struct {
+	// Bucket and Object are the names of the object requested.
+	Bucket string
+	Object string
+
+	// StatusCode is the status code of the response.
+	StatusCode int
+
+	// Size is the size of the object stored, or -1 if it is unknown.
+	Size int64
+
+	// CRC32C is the base64-encoded CRC32C checksum of the object, or empty if it is unknown.
+	CRC32C string
+
+	// Err is the error of the request, if the Transport returned no response.
+	Err error
+}
+
+// ShadowComparator receives the results of a primary request and its shadow request.
+// It is called in its own goroutine after the primary request has returned.
+type ShadowComparator func(primary, shadow ShadowResult)
+
+// WithShadowReads duplicates the GET and HEAD requests to the buckets in buckets,
+// a mapping from the primary bucket names to the shadow bucket names, e.g. for testing a migration.
+// The fraction sampleRate of the requests is duplicated,
+// and comparator receives the results of the primary request and its shadow request.
+//
+// A shadow request never affects the primary response.
+// It looks up the attributes of the object with the same name in the shadow bucket,
+// and evaluates the conditional headers of the primary request against them,
+// asynchronously after the primary request has returned.
+// If the shadow requests reach the limit configured by WithShadowReadLimits, the new ones are dropped.
+// The requests for a specific generation and archives are not duplicated.
+func WithShadowReads(buckets map[string]string, sampleRate float64, comparator ShadowComparator) Option {
+	return func(c *config) {
+		c.shadowBuckets = make(map[string]string, len(buckets))
+		for primary, shadow := range buckets {
+			c.shadowBuckets[primary] = shadow
+		}
+		c.shadowSampleRate = sampleRate
+		c.shadowComparator = comparator
+	}
+}
+
+// WithShadowReadLimits limits the shadow reads configured by WithShadowReads.
+// At most maxConcurrent shadow requests run at the same time, and each of them times out after timeout.
+// The defaults are 16 requests and 10 seconds. Zero or negative values mean the defaults.
+func WithShadowReadLimits(maxConcurrent int, timeout time.Duration) Option {
+	return func(c *config) {
+		c.shadowMaxConcurrent = maxConcurrent
+		c.shadowTimeout = timeout
+	}
+}
+
+// shadowRead starts the shadow request of req if it is sampled.
+// client must be in use by req.
+func (t *Transport) shadowRead(req *http.Request, client storageClient, resp *http.Response, err error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return
+	}
+	if req.URL.Fragment != "" || req.URL.Query().Get("archive") != "" {
+		return
+	}
+	bucket := bucketName(req)
+	cfg := t.config.forBucket(bucket)
+	shadowBucket, ok := cfg.shadowBuckets[bucket]
+	if !ok || cfg.shadowComparator == nil || rand.Float64() >= cfg.shadowSampleRate {
+		return
+	}
+	maxConcurrent := cfg.shadowMaxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = defaultShadowMaxConcurrent
+	}
+	if !t.acquireShadow(maxConcurrent) {
+		return
+	}
+	timeout := cfg.shadowTimeout
+	if timeout <= 0 {
+		timeout = defaultShadowTimeout
+	}
+
+	object := objectName(req.URL)
+	primary := shadowResultFromResponse(bucket, object, resp, err)
+
+	// copy the request, the caller may reuse it after RoundTrip returns.
+	shadowReq := req.Clone(context.Background())
+	t.retainClient(client)
+	go func() {
+		defer t.releaseShadow()
+		defer t.releaseClient(client)
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		shadow := t.shadowAttrs(ctx, client, shadowReq, cfg, shadowBucket, object)
+		cfg.shadowComparator(primary, shadow)
+	}()
+}
+
+// shadowAttrs evaluates req against the object in the shadow bucket.
+func (t *Transport) shadowAttrs(ctx context.Context, client storageClient, req *http.Request, cfg *config, bucket, object string) ShadowResult {
+	attrs, err := client.Bucket(bucket).Object(object).Attrs(ctx)
+	if err != nil {
+		result := ShadowResult{
+			Bucket: bucket,
+			Object: object,
+			Size:   -1,
+		}
+		var apiErr *googleapi.Error
+		switch {
+		case errors.Is(err, storage.ErrObjectNotExist) || errors.Is(err, storage.ErrBucketNotExist):
+			result.StatusCode = http.StatusNotFound
+		case errors.As(err, &apiErr):
+			result.StatusCode = apiErr.Code
+		default:
+			result.Err = err
+		}
+		return result
+	}
+
+	header := cfg.responseHeader(req, attrs)
+	resp := checkPreconditions(req, header, attrs)
+	if resp == nil {
+		resp = &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     header,
+		}
+	}
+	return shadowResultFromResponse(bucket, object, resp, nil)
+}
+
+func shadowResultFromResponse(bucket, object string, resp *http.Response, err error) ShadowResult {
+	result := ShadowResult{
+		Bucket: bucket,
+		Object: object,
+		Size:   -1,
+		Err:    err,
+	}
+	if resp == nil {
+		return result
+	}
+	result.StatusCode = resp.StatusCode
+	if v := resp.Header.Get("x-goog-stored-content-length"); v != "" {
+		if size, err := strconv.ParseInt(v, 10, 64); err == nil {
+			result.Size = size
+		}
+	}
+	for _, v := range resp.Header.Values("x-goog-hash") {
+		for _, hash := range strings.Split(v, ",") {
+			if strings.HasPrefix(hash, "crc32c=") {
+				result.CRC32C = strings.TrimPrefix(hash, "crc32c=")
+			}
+		}
+	}
+	return result
+}
+
+func (t *Transport) acquireShadow(max int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.shadows >= max {
+		return false
+	}
+	t.shadows++
+	return true
+}
+
+func (t *Transport) releaseShadow() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.shadows--
+}
@@ -0,0 +1,148 @@
+package gsprotocol
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestRoundTrip_ShadowReads(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"primary/same": {
+			attrs:   &storage.ObjectAttrs{Generation: 1, CRC32C: 0x7f762fe2},
+			content: "Hello Google Cloud Storage!",
+		},
+		"shadow/same": {
+			attrs:   &storage.ObjectAttrs{Generation: 2, CRC32C: 0x7f762fe2},
+			content: "Hello Google Cloud Storage!",
+		},
+		"primary/different": {
+			attrs:   &storage.ObjectAttrs{Generation: 1, CRC32C: 0x7f762fe2},
+			content: "Hello Google Cloud Storage!",
+		},
+		"shadow/different": {
+			attrs:   &storage.ObjectAttrs{Generation: 2, CRC32C: 0x12345678},
+			content: "Hello!",
+		},
+		"primary/missing": {
+			attrs:   &storage.ObjectAttrs{Generation: 1, CRC32C: 0x7f762fe2},
+			content: "Hello Google Cloud Storage!",
+		},
+	})
+
+	type result struct {
+		primary, shadow ShadowResult
+	}
+	results := make(chan result, 1)
+	c := &http.Client{
+		Transport: newTestTransport(mock,
+			WithShadowReads(map[string]string{"primary": "shadow"}, 1, func(primary, shadow ShadowResult) {
+				results <- result{primary, shadow}
+			}),
+		),
+	}
+
+	tc := []struct {
+		object string
+		status int
+		size   int64
+		crc32c string
+	}{
+		{"same", http.StatusOK, 27, "f3Yv4g=="},
+		{"different", http.StatusOK, 6, "EjRWeA=="},
+		{"missing", http.StatusNotFound, -1, ""},
+	}
+	for _, tt := range tc {
+		t.Run(tt.object, func(t *testing.T) {
+			resp, err := c.Get("gs://primary/" + tt.object)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			var got result
+			select {
+			case got = <-results:
+			case <-time.After(time.Second):
+				t.Fatal("timeout")
+			}
+			want := ShadowResult{Bucket: "primary", Object: tt.object, StatusCode: http.StatusOK, Size: 27, CRC32C: "f3Yv4g=="}
+			if got.primary != want {
+				t.Errorf("unexpected primary result: want %+v, got %+v", want, got.primary)
+			}
+			want = ShadowResult{Bucket: "shadow", Object: tt.object, StatusCode: tt.status, Size: tt.size, CRC32C: tt.crc32c}
+			if got.shadow != want {
+				t.Errorf("unexpected shadow result: want %+v, got %+v", want, got.shadow)
+			}
+		})
+	}
+}
+
+func TestRoundTrip_ShadowReadsLimit(t *testing.T) {
+	primary := newObjectHandleMock("primary", "object-key", mockObject{
+		attrs:   &storage.ObjectAttrs{Generation: 1},
+		content: "Hello Google Cloud Storage!",
+	})
+	unblock := make(chan struct{})
+	shadow := &objectHandleMock{
+		attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+			select {
+			case <-unblock:
+			case <-ctx.Done():
+			}
+			return nil, storage.ErrObjectNotExist
+		},
+	}
+	mock := &storageClientMock{
+		bucketFunc: func(mock *storageClientMock, bucket string) *bucketHandleMock {
+			return &bucketHandleMock{
+				objectFunc: func(mock *bucketHandleMock, name string) *objectHandleMock {
+					if bucket == "shadow" {
+						return shadow
+					}
+					return primary
+				},
+			}
+		},
+	}
+
+	compared := make(chan ShadowResult, 10)
+	tr := newTestTransport(mock,
+		WithShadowReads(map[string]string{"primary": "shadow"}, 1, func(primary, shadow ShadowResult) {
+			compared <- shadow
+		}),
+		WithShadowReadLimits(1, time.Minute),
+	)
+	c := &http.Client{Transport: tr}
+
+	// the primary requests don't wait for the blocked shadow request.
+	for i := 0; i < 3; i++ {
+		resp, err := c.Head("gs://primary/object-key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+	}
+
+	close(unblock)
+	select {
+	case <-compared:
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	select {
+	case <-compared:
+		t.Error("the shadow requests over the limit must be dropped")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
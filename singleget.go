@@ -0,0 +1,132 @@
+package gsprotocol
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// WithSingleRequestGet makes the Transport serve plain GET requests by one RPC,
+// opening the reader without looking up the attributes of the object first.
+// The headers of the response are built from the attributes that the reader returns,
+// so they have no ETag, x-goog-hash, x-goog-meta-*, Content-Language, Content-Disposition
+// and x-goog-storage-class headers.
+//
+// The requests with conditional headers, a Range header, a generation, or the ones that need the whole attributes,
+// e.g. with symlinks, encryption keys or gzip decompression, are served as usual.
+// See WithRequiredHeaders to serve the requests as usual if the response needs the missing headers.
+// RequestStats.SingleRequest reports whether a request is served by one RPC.
+func WithSingleRequestGet() Option {
+	return func(c *config) {
+		c.singleRequestGet = true
+	}
+}
+
+// WithRequiredHeaders makes WithSingleRequestGet serve the requests as usual, looking up the attributes of the object,
+// if any of the response headers, e.g. "Content-Disposition", can't be built from the attributes that the reader returns.
+// "x-goog-meta-*" requires all the x-goog-meta-* headers.
+// It trades the latency for the complete headers.
+// The headers are added to the ones of the previous WithRequiredHeaders.
+func WithRequiredHeaders(headers ...string) Option {
+	return func(c *config) {
+		required := make([]string, 0, len(c.requiredHeaders)+len(headers))
+		required = append(required, c.requiredHeaders...)
+		for _, key := range headers {
+			required = append(required, http.CanonicalHeaderKey(key))
+		}
+		c.requiredHeaders = required
+	}
+}
+
+// singleRequestHeaders are the request headers that need the attributes of the object.
+var singleRequestHeaders = []string{
+	"If-Match",
+	"If-None-Match",
+	"If-Modified-Since",
+	"If-Unmodified-Since",
+	"If-Range",
+	"Range",
+	"X-Goog-If-Generation-Not-Match",
+}
+
+// readerHeaders are the response headers that getObjectSingleRequest builds from the attributes of the reader.
+var readerHeaders = map[string]bool{
+	"Content-Type":                   true,
+	"Content-Encoding":               true,
+	"Content-Length":                 true,
+	"Cache-Control":                  true,
+	"Last-Modified":                  true,
+	"Accept-Ranges":                  true,
+	"X-Goog-Generation":              true,
+	"X-Goog-Metageneration":          true,
+	"X-Goog-Stored-Content-Length":   true,
+	"X-Goog-Stored-Content-Encoding": true,
+}
+
+// canServeSingleRequest reports whether the GET request can be served without the attributes of the object.
+func canServeSingleRequest(ctx context.Context, req *http.Request, cfg *config, decompress string) bool {
+	if !cfg.singleRequestGet || req.URL.Fragment != "" || decompress != "" {
+		return false
+	}
+	if cfg.symlinkMode != SymlinkNone || len(cfg.encryptionKeys) > 0 {
+		return false
+	}
+	for _, key := range singleRequestHeaders {
+		if _, ok := req.Header[key]; ok {
+			return false
+		}
+	}
+	for _, key := range cfg.requiredHeaders {
+		if !readerHeaders[key] {
+			return false
+		}
+	}
+	_, ok := knownAttrs(ctx, bucketName(req), objectName(req.URL))
+	return !ok
+}
+
+// getObjectSingleRequest serves the GET request by NewReader only.
+// See WithSingleRequestGet.
+func (t *Transport) getObjectSingleRequest(ctx context.Context, req *http.Request, client storageClient, cfg *config) (*http.Response, error) {
+	bucket := bucketName(req)
+	path := objectName(req.URL)
+	stats := statsFromContext(ctx)
+	stats.recordSingleRequest()
+	start := time.Now()
+	body, err := client.Bucket(bucket).Object(path).NewReader(ctx)
+	stats.recordReader(time.Since(start))
+	if err != nil {
+		return handleError(err)
+	}
+
+	r := body.Attrs()
+	attrs := &storage.ObjectAttrs{
+		Bucket:          bucket,
+		Name:            path,
+		ContentType:     r.ContentType,
+		ContentEncoding: r.ContentEncoding,
+		CacheControl:    r.CacheControl,
+		Size:            r.Size,
+		Updated:         r.LastModified,
+		Generation:      r.Generation,
+		Metageneration:  r.Metageneration,
+	}
+	stats.recordAttrs(0, attrs)
+	header := cfg.responseHeader(req, attrs)
+	// the reader doesn't tell the hashes.
+	header.Del("X-Goog-Hash")
+	header.Set("Accept-Ranges", acceptRanges(attrs, ""))
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.0",
+		ProtoMajor:    1,
+		ProtoMinor:    0,
+		Header:        header,
+		Body:          body,
+		ContentLength: attrs.Size,
+		Close:         true,
+	}, nil
+}
@@ -0,0 +1,276 @@
+package gsprotocol
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// rpcCounts counts the calls of Attrs and NewReader.
+type rpcCounts struct {
+	attrs  int
+	reader int
+}
+
+func (c *rpcCounts) total() int {
+	return c.attrs + c.reader
+}
+
+// newRPCCountingClient returns a storageClientMock that serves objects, and counts the RPCs in counts.
+// Deleting the objects always succeeds.
+func newRPCCountingClient(objects map[string]mockObject, counts *rpcCounts) *storageClientMock {
+	mock := newStorageClientMockWithObjects(objects)
+	bucketFunc := mock.bucketFunc
+	mock.bucketFunc = func(mock *storageClientMock, name string) *bucketHandleMock {
+		bucket := bucketFunc(mock, name)
+		objectFunc := bucket.objectFunc
+		bucket.objectFunc = func(mock *bucketHandleMock, name string) *objectHandleMock {
+			object := *objectFunc(mock, name)
+			attrFunc, newReaderFunc := object.attrFunc, object.newReaderFunc
+			object.attrFunc = func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+				counts.attrs++
+				return attrFunc(ctx, mock)
+			}
+			object.newReaderFunc = func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+				counts.reader++
+				return newReaderFunc(ctx, mock)
+			}
+			if object.deleteFunc == nil {
+				object.deleteFunc = func(ctx context.Context, mock *objectHandleMock) error {
+					return nil
+				}
+			}
+			return &object
+		}
+		return bucket
+	}
+	return mock
+}
+
+var singleRequestTestObjects = map[string]mockObject{
+	"bucket-name/object-key": {
+		attrs: &storage.ObjectAttrs{
+			ContentType:    "text/plain",
+			CacheControl:   "no-cache",
+			Updated:        time.Date(2020, time.April, 18, 12, 34, 56, 0, time.UTC),
+			Generation:     1587160158394554,
+			Metageneration: 1,
+			MD5:            []byte{0x0b, 0x46, 0xf3, 0x06, 0xe9, 0x2d, 0x88, 0x51, 0x5e, 0x06, 0xd4, 0x8a, 0x62, 0xdc, 0xc3, 0x19},
+			Metadata:       map[string]string{"foo": "bar"},
+		},
+		content: "Hello Google Cloud Storage!",
+	},
+}
+
+func TestRoundTrip_SingleRequestGet(t *testing.T) {
+	var counts rpcCounts
+	tr := newTestTransport(newRPCCountingClient(singleRequestTestObjects, &counts), WithSingleRequestGet())
+
+	req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats RequestStats
+	req = req.WithContext(WithStatsRecorder(req.Context(), &stats))
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if string(body) != "Hello Google Cloud Storage!" {
+		t.Errorf("unexpected body: %q", body)
+	}
+	if counts != (rpcCounts{reader: 1}) {
+		t.Errorf("want one NewReader, got %+v", counts)
+	}
+	if !stats.SingleRequest {
+		t.Error("want SingleRequest in the stats")
+	}
+	if resp.ContentLength != int64(len(body)) {
+		t.Errorf("unexpected content length: want %d, got %d", len(body), resp.ContentLength)
+	}
+	wantHeader := map[string]string{
+		"Content-Type":          "text/plain",
+		"Cache-Control":         "no-cache",
+		"Content-Length":        "27",
+		"Last-Modified":         "Sat, 18 Apr 2020 12:34:56 GMT",
+		"X-Goog-Generation":     "1587160158394554",
+		"X-Goog-Metageneration": "1",
+		"Accept-Ranges":         "bytes",
+		"Etag":                  "",
+		"X-Goog-Hash":           "",
+		"X-Goog-Meta-Foo":       "",
+	}
+	for key, want := range wantHeader {
+		if got := resp.Header.Get(key); got != want {
+			t.Errorf("unexpected %s: want %q, got %q", key, want, got)
+		}
+	}
+}
+
+func TestRoundTrip_SingleRequestGetFallback(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		header http.Header
+		opts   []Option
+	}{
+		{
+			name: "disabled",
+			url:  "gs://bucket-name/object-key",
+		},
+		{
+			name:   "If-None-Match",
+			url:    "gs://bucket-name/object-key",
+			header: http.Header{"If-None-Match": {`"foobar"`}},
+			opts:   []Option{WithSingleRequestGet()},
+		},
+		{
+			name:   "If-Modified-Since",
+			url:    "gs://bucket-name/object-key",
+			header: http.Header{"If-Modified-Since": {"Sat, 18 Apr 2020 00:00:00 GMT"}},
+			opts:   []Option{WithSingleRequestGet()},
+		},
+		{
+			name:   "Range",
+			url:    "gs://bucket-name/object-key",
+			header: http.Header{"Range": {"bytes=0-4"}},
+			opts:   []Option{WithSingleRequestGet()},
+		},
+		{
+			name: "generation",
+			url:  "gs://bucket-name/object-key#1587160158394554",
+			opts: []Option{WithSingleRequestGet()},
+		},
+		{
+			name: "symlinks",
+			url:  "gs://bucket-name/object-key",
+			opts: []Option{WithSingleRequestGet(), WithSymlinks(SymlinkFollow)},
+		},
+		{
+			name: "required headers",
+			url:  "gs://bucket-name/object-key",
+			opts: []Option{WithSingleRequestGet(), WithRequiredHeaders("content-type", "Content-Disposition")},
+		},
+		{
+			name: "required metadata",
+			url:  "gs://bucket-name/object-key",
+			opts: []Option{WithSingleRequestGet(), WithRequiredHeaders("x-goog-meta-*")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var counts rpcCounts
+			tr := newTestTransport(newRPCCountingClient(singleRequestTestObjects, &counts), tt.opts...)
+			req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, values := range tt.header {
+				req.Header[key] = values
+			}
+			var stats RequestStats
+			req = req.WithContext(WithStatsRecorder(req.Context(), &stats))
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+				t.Errorf("unexpected status: %d", resp.StatusCode)
+			}
+			if counts.attrs != 1 {
+				t.Errorf("want one Attrs, got %+v", counts)
+			}
+			if stats.SingleRequest {
+				t.Error("want no SingleRequest in the stats")
+			}
+			if resp.Header.Get("Etag") == "" {
+				t.Error("want Etag, got none")
+			}
+		})
+	}
+}
+
+func TestRoundTrip_SingleRequestGetRequiredHeaders(t *testing.T) {
+	// the headers built from the attributes of the reader don't need the fallback.
+	var counts rpcCounts
+	tr := newTestTransport(
+		newRPCCountingClient(singleRequestTestObjects, &counts),
+		WithSingleRequestGet(),
+		WithRequiredHeaders("Content-Type", "cache-control", "Last-Modified"),
+	)
+	req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if counts != (rpcCounts{reader: 1}) {
+		t.Errorf("want one NewReader, got %+v", counts)
+	}
+}
+
+func TestRoundTrip_SingleRequestGetNotFound(t *testing.T) {
+	var counts rpcCounts
+	tr := newTestTransport(newRPCCountingClient(singleRequestTestObjects, &counts), WithSingleRequestGet())
+	req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/missing-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func benchmarkGET(b *testing.B, opts ...Option) {
+	var counts rpcCounts
+	c := &http.Client{
+		Transport: newTestTransport(newRPCCountingClient(singleRequestTestObjects, &counts), opts...),
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, err := c.Do(req)
+		if err != nil {
+			b.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	b.ReportMetric(float64(counts.total())/float64(b.N), "rpcs/op")
+}
+
+func BenchmarkGET(b *testing.B) {
+	benchmarkGET(b)
+}
+
+func BenchmarkGETSingleRequest(b *testing.B) {
+	benchmarkGET(b, WithSingleRequestGet())
+}
@@ -0,0 +1,204 @@
+package gsprotocol
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// RequestStats is the statistics of a request, filled in by the Transport as the request progresses.
+//
+// The Transport updates RequestStats under a lock, from the goroutines that call RoundTrip and
+// read or close the response body.
+// The stats are complete after the response body is closed, and the fields can be read directly after that.
+// To read them while the request is in progress, e.g. from another goroutine, use Snapshot.
+// A RequestStats must not be shared between requests.
+type RequestStats struct {
+	// Bucket and Object are the names of the object served.
+	// If the request follows symlinks, they are the names of the target.
+	Bucket string
+	Object string
+
+	// Generation is the generation of the object served.
+	Generation int64
+
+	// StatusCode is the status code of the response.
+	StatusCode int
+
+	// AttrsDuration is the time spent on looking up the metadata of the object.
+	AttrsDuration time.Duration
+
+	// ReaderDuration is the time spent on opening the reader of the object.
+	ReaderDuration time.Duration
+
+	// TimeToFirstByte is the time from the start of RoundTrip to the first byte read from the response body.
+	TimeToFirstByte time.Duration
+
+	// TotalDuration is the time from the start of RoundTrip to closing the response body.
+	TotalDuration time.Duration
+
+	// BytesRead is the number of bytes read from the response body.
+	BytesRead int64
+
+	// MemoHit reports whether the request reused the attributes fetched by a HEAD request.
+	// See WithHeadMemo.
+	MemoHit bool
+
+	// Retries is the number of the retries of the calls to Google Cloud Storage by WithRetry,
+	// until the response is returned.
+	Retries int
+
+	// AttrsCacheHit reports whether the request used the attributes cached by WithAttrsCache.
+	AttrsCacheHit bool
+
+	// SingleRequest reports whether the request was served by one RPC, without looking up the attributes.
+	// See WithSingleRequestGet and WithRequiredHeaders.
+	SingleRequest bool
+
+	// mu guards the fields. It is a pointer so that Snapshot can return a copy of RequestStats.
+	mu    *sync.Mutex
+	start time.Time
+}
+
+type statsRecorderKey struct{}
+
+// WithStatsRecorder returns a copy of ctx that makes the Transport fill in stats.
+// Use it with http.Request.WithContext.
+func WithStatsRecorder(ctx context.Context, stats *RequestStats) context.Context {
+	if stats.mu == nil {
+		stats.mu = new(sync.Mutex)
+	}
+	return context.WithValue(ctx, statsRecorderKey{}, stats)
+}
+
+// Snapshot returns a copy of the stats, which is consistent even while the request is in progress.
+func (s *RequestStats) Snapshot() RequestStats {
+	if s.mu == nil {
+		// not recorded by any request.
+		return *s
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := *s
+	cp.mu = nil
+	return cp
+}
+
+func statsFromContext(ctx context.Context) *RequestStats {
+	stats, _ := ctx.Value(statsRecorderKey{}).(*RequestStats)
+	return stats
+}
+
+func (s *RequestStats) recordStart() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.start = time.Now()
+}
+
+func (s *RequestStats) recordAttrs(d time.Duration, attrs *storage.ObjectAttrs) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.AttrsDuration += d
+	if attrs != nil {
+		s.Bucket = attrs.Bucket
+		s.Object = attrs.Name
+		s.Generation = attrs.Generation
+	}
+}
+
+func (s *RequestStats) recordMemoHit() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.MemoHit = true
+}
+
+func (s *RequestStats) recordAttrsCacheHit() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.AttrsCacheHit = true
+}
+
+func (s *RequestStats) recordSingleRequest() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.SingleRequest = true
+}
+
+func (s *RequestStats) recordRetries(n int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Retries += n
+}
+
+func (s *RequestStats) recordReader(d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ReaderDuration += d
+}
+
+func (s *RequestStats) recordResponse(resp *http.Response) {
+	if s == nil || resp == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.StatusCode = resp.StatusCode
+}
+
+func (s *RequestStats) recordRead(n int) {
+	if s == nil || n == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.BytesRead == 0 {
+		s.TimeToFirstByte = time.Since(s.start)
+	}
+	s.BytesRead += int64(n)
+}
+
+func (s *RequestStats) recordDone() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.TotalDuration = time.Since(s.start)
+}
+
+// statsBody records the statistics of reading the response body.
+type statsBody struct {
+	io.ReadCloser
+	stats *RequestStats
+}
+
+func (b *statsBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.stats.recordRead(n)
+	return n, err
+}
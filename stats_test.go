@@ -0,0 +1,141 @@
+package gsprotocol
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestWithStatsRecorder(t *testing.T) {
+	const content = "Hello Google Cloud Storage!"
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs:   &storage.ObjectAttrs{Generation: 1234567890},
+			content: content,
+		},
+	})
+	c := &http.Client{Transport: newTestTransport(mock)}
+
+	var stats RequestStats
+	req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(WithStatsRecorder(req.Context(), &stats))
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if stats.Bucket != "bucket-name" || stats.Object != "object-key" {
+		t.Errorf("unexpected object: gs://%s/%s", stats.Bucket, stats.Object)
+	}
+	if stats.Generation != 1234567890 {
+		t.Errorf("unexpected generation: want %d, got %d", 1234567890, stats.Generation)
+	}
+	if stats.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, stats.StatusCode)
+	}
+	if stats.BytesRead != int64(len(content)) {
+		t.Errorf("unexpected BytesRead: want %d, got %d", len(content), stats.BytesRead)
+	}
+	if stats.AttrsDuration <= 0 || stats.TimeToFirstByte <= 0 || stats.TotalDuration < stats.TimeToFirstByte {
+		t.Errorf("unexpected durations: %+v", stats)
+	}
+}
+
+func TestWithStatsRecorder_HEAD(t *testing.T) {
+	c := &http.Client{Transport: newTestTransport(newStorageClientMockWithObjects(nil))}
+
+	var stats RequestStats
+	req, err := http.NewRequest(http.MethodHead, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(WithStatsRecorder(req.Context(), &stats))
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if stats.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusNotFound, stats.StatusCode)
+	}
+	if stats.BytesRead != 0 {
+		t.Errorf("unexpected BytesRead: want %d, got %d", 0, stats.BytesRead)
+	}
+	if stats.TotalDuration <= 0 {
+		t.Errorf("unexpected TotalDuration: %v", stats.TotalDuration)
+	}
+}
+
+func TestWithStatsRecorder_Parallel(t *testing.T) {
+	const content = "Hello Google Cloud Storage!"
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs:   &storage.ObjectAttrs{Generation: 1234567890},
+			content: content,
+		},
+	})
+	c := &http.Client{Transport: newTestTransport(mock)}
+
+	const n = 16
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var stats RequestStats
+			req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/object-key", nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			req = req.WithContext(WithStatsRecorder(req.Context(), &stats))
+
+			// watch the progress while the request is in progress.
+			done := make(chan struct{})
+			watched := make(chan struct{})
+			go func() {
+				defer close(watched)
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					if s := stats.Snapshot(); s.BytesRead > int64(len(content)) {
+						t.Errorf("unexpected BytesRead: %d", s.BytesRead)
+					}
+				}
+			}()
+
+			resp, err := c.Do(req)
+			if err != nil {
+				close(done)
+				t.Error(err)
+				return
+			}
+			_, err = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			close(done)
+			<-watched
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if got := stats.Snapshot(); got.BytesRead != int64(len(content)) || got.StatusCode != http.StatusOK {
+				t.Errorf("unexpected stats: %+v", got)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,127 @@
+package gsprotocol
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// storageClasses are the storage classes that PATCH requests can change objects to.
+var storageClasses = map[string]bool{
+	"STANDARD":                     true,
+	"NEARLINE":                     true,
+	"COLDLINE":                     true,
+	"ARCHIVE":                      true,
+	"MULTI_REGIONAL":               true,
+	"REGIONAL":                     true,
+	"DURABLE_REDUCED_AVAILABILITY": true,
+}
+
+// parseStorageClass returns the storage class of the x-goog-storage-class header in upper case.
+func parseStorageClass(v string) (string, error) {
+	class := strings.ToUpper(v)
+	if !storageClasses[class] {
+		return "", fmt.Errorf("gsprotocol: invalid x-goog-storage-class %q", v)
+	}
+	return class, nil
+}
+
+// WithRewriteProgress makes the Transport call progress with the bytes rewritten
+// while PATCH requests change the storage classes of objects.
+// Google Cloud Storage rewrites a large object in several calls, and progress is called after each of them.
+// progress must not block.
+func WithRewriteProgress(progress func(bucket, object string, copiedBytes, totalBytes uint64)) Option {
+	return func(c *config) {
+		c.rewriteProgress = progress
+	}
+}
+
+// progressCopier is the storage copier that reports the progress of the rewrite.
+type progressCopier interface {
+	setProgressFunc(progress func(copiedBytes, totalBytes uint64))
+}
+
+// changeStorageClass rewrites the object in place with the storage class,
+// preserving its metadata and ACLs, and applying the updates of the request headers.
+// The rewrite has the precondition of the generation rewritten,
+// so that it doesn't overwrite the object written concurrently.
+func (t *Transport) changeStorageClass(req *http.Request, object objectHandle, class string) (*http.Response, error) {
+	ctx := req.Context()
+	attrs, err := object.Attrs(ctx)
+	if err != nil {
+		return handleError(err)
+	}
+
+	dst := object
+	if attrs.Generation != 0 {
+		object = object.Generation(attrs.Generation)
+		dst = dst.If(storage.Conditions{GenerationMatch: attrs.Generation})
+	}
+	c := dst.CopierFrom(object)
+	rewritten := c.ObjectAttrs()
+	rewritten.ContentType = attrs.ContentType
+	rewritten.ContentLanguage = attrs.ContentLanguage
+	rewritten.CacheControl = attrs.CacheControl
+	rewritten.ContentEncoding = attrs.ContentEncoding
+	rewritten.ContentDisposition = attrs.ContentDisposition
+	rewritten.ACL = attrs.ACL
+	rewritten.TemporaryHold = attrs.TemporaryHold
+	rewritten.EventBasedHold = attrs.EventBasedHold
+	rewritten.CustomTime = attrs.CustomTime
+	for key, value := range attrs.Metadata {
+		if rewritten.Metadata == nil {
+			rewritten.Metadata = make(map[string]string, len(attrs.Metadata))
+		}
+		rewritten.Metadata[key] = value
+	}
+	applyAttrsToUpdate(rewritten, objectAttrsToUpdateFromHeader(req.Header))
+	rewritten.StorageClass = class
+
+	cfg := t.config.forBucket(bucketName(req))
+	if progress := cfg.rewriteProgress; progress != nil {
+		if pc, ok := c.(progressCopier); ok {
+			bucket, name := bucketName(req), objectName(req.URL)
+			pc.setProgressFunc(func(copiedBytes, totalBytes uint64) {
+				progress(bucket, name, copiedBytes, totalBytes)
+			})
+		}
+	}
+
+	written, err := c.Run(ctx)
+	if err != nil {
+		return handleError(err)
+	}
+	t.publishWrite(req, bucketName(req), objectName(req.URL), written)
+	return newPatchedResponse(written), nil
+}
+
+// applyAttrsToUpdate applies the updates of objectAttrsToUpdateFromHeader to attrs.
+func applyAttrsToUpdate(attrs *storage.ObjectAttrs, uattrs storage.ObjectAttrsToUpdate) {
+	if v, ok := uattrs.ContentType.(string); ok {
+		attrs.ContentType = v
+	}
+	if v, ok := uattrs.ContentLanguage.(string); ok {
+		attrs.ContentLanguage = v
+	}
+	if v, ok := uattrs.CacheControl.(string); ok {
+		attrs.CacheControl = v
+	}
+	if v, ok := uattrs.ContentEncoding.(string); ok {
+		attrs.ContentEncoding = v
+	}
+	if v, ok := uattrs.ContentDisposition.(string); ok {
+		attrs.ContentDisposition = v
+	}
+	for key, value := range uattrs.Metadata {
+		if value == "" {
+			delete(attrs.Metadata, key)
+			continue
+		}
+		if attrs.Metadata == nil {
+			attrs.Metadata = make(map[string]string)
+		}
+		attrs.Metadata[key] = value
+	}
+}
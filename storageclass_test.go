@@ -0,0 +1,107 @@
+package gsprotocol
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestRoundTrip_PatchStorageClass(t *testing.T) {
+	var copier *storageCopierMock
+	var src, dst *objectHandleMock
+	object := &objectHandleMock{
+		attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+			return &storage.ObjectAttrs{
+				Bucket:       "bucket-name",
+				Name:         "object-key",
+				ContentType:  "text/plain",
+				Generation:   1587160158394554,
+				StorageClass: "STANDARD",
+				Metadata:     map[string]string{"foo": "bar", "hoge": "fuga"},
+				ACL:          []storage.ACLRule{{Entity: storage.AllUsers, Role: storage.RoleReader}},
+			}, nil
+		},
+		generationFunc: withGeneration,
+		copierFunc: func(d *objectHandleMock, s *objectHandleMock) *storageCopierMock {
+			dst, src = d, s
+			copier = &storageCopierMock{
+				runFunc: func(ctx context.Context, c *storageCopierMock) (*storage.ObjectAttrs, error) {
+					c.progress(50, 100)
+					c.progress(100, 100)
+					attrs := c.attrs
+					attrs.Bucket = "bucket-name"
+					attrs.Name = "object-key"
+					attrs.Generation = 1587160158394555
+					return &attrs, nil
+				},
+			}
+			return copier
+		},
+	}
+	var progress []uint64
+	tr := newTestTransport(newSingleObjectMock(object),
+		WithWriteMethods(),
+		WithRewriteProgress(func(bucket, object string, copiedBytes, totalBytes uint64) {
+			if bucket != "bucket-name" || object != "object-key" {
+				t.Errorf("unexpected object: %s/%s", bucket, object)
+			}
+			progress = append(progress, copiedBytes)
+		}),
+	)
+
+	req, err := http.NewRequest(http.MethodPatch, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Goog-Storage-Class", "nearline")
+	req.Header.Set("X-Goog-Meta-Hoge", "")
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if got := resp.Header.Get("X-Goog-Storage-Class"); got != "NEARLINE" {
+		t.Errorf("unexpected storage class: %q", got)
+	}
+	if got := resp.Header.Get("X-Goog-Generation"); got != "1587160158394555" {
+		t.Errorf("unexpected generation: %q", got)
+	}
+	if src.generation != 1587160158394554 {
+		t.Errorf("the source is not pinned: %d", src.generation)
+	}
+	if dst.conds.GenerationMatch != 1587160158394554 {
+		t.Errorf("the destination has no precondition: %+v", dst.conds)
+	}
+	if copier.attrs.ContentType != "text/plain" || len(copier.attrs.ACL) != 1 {
+		t.Errorf("the attributes are not preserved: %#v", copier.attrs)
+	}
+	if want := map[string]string{"foo": "bar"}; !reflect.DeepEqual(copier.attrs.Metadata, want) {
+		t.Errorf("unexpected metadata: want %v, got %v", want, copier.attrs.Metadata)
+	}
+	if want := []uint64{50, 100}; !reflect.DeepEqual(progress, want) {
+		t.Errorf("unexpected progress: want %v, got %v", want, progress)
+	}
+
+	// the invalid storage class is rejected before any call.
+	tr.client = &storageClientMock{}
+	req, err = http.NewRequest(http.MethodPatch, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Goog-Storage-Class", "FROZEN")
+	resp, err = tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
@@ -0,0 +1,101 @@
+package gsprotocol
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// WithStrictURLs makes the Transport validate the whole URL of a request,
+// and respond 400 Bad Request whose body pinpoints the offending component and its offset in the URL.
+// The URL must have a scheme that the Transport serves, a bucket name, no userinfo,
+// an object name without encoded slashes and dot segments,
+// no query parameters that the Transport doesn't recognize, and a fragment that is a generation number if any.
+// By default, the Transport rejects the unknown query parameters without the offset,
+// and ignores the other components it doesn't use.
+func WithStrictURLs() Option {
+	return func(c *config) {
+		c.strictURLs = true
+	}
+}
+
+// urlError is returned if a URL is rejected by WithStrictURLs.
+type urlError struct {
+	url       string
+	component string
+	offset    int
+	reason    string
+}
+
+func (err *urlError) Error() string {
+	return fmt.Sprintf("gsprotocol: invalid URL %q: %s at offset %d: %s", err.url, err.component, err.offset, err.reason)
+}
+
+// validateURL validates u strictly.
+func (t *Transport) validateURL(u *url.URL) error {
+	s := u.String()
+	newError := func(component string, offset int, reason string) error {
+		return &urlError{
+			url:       s,
+			component: component,
+			offset:    offset,
+			reason:    reason,
+		}
+	}
+
+	if err := t.checkScheme(u); err != nil {
+		schemes := strings.Join(t.config.servedSchemes(), " or ")
+		return newError("scheme", 0, fmt.Sprintf("the scheme must be %s, but %q", schemes, u.Scheme))
+	}
+	if u.Opaque != "" {
+		return newError("path", len(u.Scheme)+len(":"), "the URL must be "+u.Scheme+"://[BUCKET_NAME]/[OBJECT_NAME]")
+	}
+	hostOffset := len(u.Scheme) + len("://")
+	if u.User != nil {
+		return newError("userinfo", hostOffset, "userinfo is not allowed")
+	}
+	if u.Host == "" {
+		return newError("host", hostOffset, "the bucket name is empty")
+	}
+
+	pathOffset := hostOffset + len(u.Host)
+	rawPath := u.EscapedPath()
+	if i := strings.Index(strings.ToLower(rawPath), "%2f"); i >= 0 {
+		return newError("path", pathOffset+i, "encoded slash is ambiguous")
+	}
+	offset := pathOffset
+	for _, segment := range strings.Split(rawPath, "/") {
+		if segment == "." || segment == ".." {
+			return newError("path", offset, fmt.Sprintf("dot segment %q may be normalized", segment))
+		}
+		offset += len(segment) + 1
+	}
+
+	queryOffset := pathOffset + len(rawPath) + 1
+	offset = queryOffset
+	for _, param := range strings.Split(u.RawQuery, "&") {
+		if param == "" {
+			offset++
+			continue
+		}
+		key := param
+		if i := strings.IndexByte(key, '='); i >= 0 {
+			key = key[:i]
+		}
+		name, err := url.QueryUnescape(key)
+		if err != nil || !knownQueryParams[name] {
+			return newError("query", offset, fmt.Sprintf("unknown query parameter %q", key))
+		}
+		offset += len(param) + 1
+	}
+
+	if u.Fragment != "" {
+		fragmentOffset := len(s) - len(u.EscapedFragment())
+		for i, c := range u.Fragment {
+			if c < '0' || c > '9' {
+				return newError("fragment", fragmentOffset+i, "the fragment must be a generation number")
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package gsprotocol
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestValidateURL(t *testing.T) {
+	tc := []struct {
+		url       string
+		component string
+		offset    int
+	}{
+		// valid URLs
+		{"gs://bucket-name/object-key", "", 0},
+		{"gs://bucket-name/dir/object%20key", "", 0},
+		{"gs://bucket-name/object-key?decompress=gzip", "", 0},
+		{"gs://bucket-name/dir/?archive=tar", "", 0},
+		{"gs://bucket-name/object-key?wait=30s&decompress=gzip", "", 0},
+		{"gs://bucket-name/object-key#1234567890", "", 0},
+		{"gs://bucket-name/...", "", 0},
+		{"gcs://bucket-name/object-key", "", 0},
+		{"gcs://user@bucket-name/object-key", "userinfo", 6},
+		{"gcs://bucket-name/dir%2Fobject-key", "path", 21},
+		{"gcs://bucket-name/object-key?foo=bar", "query", 29},
+
+		// malformed URLs
+		{"https://bucket-name/object-key", "scheme", 0},
+		{"s3://bucket-name/object-key", "scheme", 0},
+		{"gs:bucket-name/object-key", "path", 3},
+		{"gs://user@bucket-name/object-key", "userinfo", 5},
+		{"gs://user:pass@bucket-name/object-key", "userinfo", 5},
+		{"gs:///object-key", "host", 5},
+		{"gs://bucket-name/dir%2Fobject-key", "path", 20},
+		{"gs://bucket-name/dir%2fobject-key", "path", 20},
+		{"gs://bucket-name/dir/../object-key", "path", 21},
+		{"gs://bucket-name/./object-key", "path", 17},
+		{"gs://bucket-name/object-key?foo=bar", "query", 28},
+		{"gs://bucket-name/object-key?decompress=gzip&foo", "query", 44},
+		{"gs://bucket-name/object-key?%zz=1", "query", 28},
+		{"gs://bucket-name/object-key#latest", "fragment", 28},
+		{"gs://bucket-name/object-key#123x", "fragment", 31},
+	}
+	for _, tt := range tc {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = (&Transport{}).validateURL(u)
+			if tt.component == "" {
+				if err != nil {
+					t.Errorf("want no error, got %v", err)
+				}
+				return
+			}
+			urlErr, ok := err.(*urlError)
+			if !ok {
+				t.Fatalf("want *urlError, got %v", err)
+			}
+			if urlErr.component != tt.component || urlErr.offset != tt.offset {
+				t.Errorf("want %s at offset %d, got %s at offset %d: %v", tt.component, tt.offset, urlErr.component, urlErr.offset, err)
+			}
+		})
+	}
+}
+
+func TestRoundTrip_StrictURLs(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs:   &storage.ObjectAttrs{Generation: 1},
+			content: "Hello Google Cloud Storage!",
+		},
+	})
+
+	// the unknown query parameters are rejected by default, and the other components are ignored.
+	c := &http.Client{Transport: newTestTransport(mock)}
+	resp, err := c.Get("gs://bucket-name/object-key?foo=bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	resp, err = c.Get("gs://user@bucket-name/object-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	c = &http.Client{Transport: newTestTransport(mock, WithStrictURLs())}
+	resp, err = c.Get("gs://bucket-name/object-key?foo=bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "query at offset 28") {
+		t.Errorf("unexpected body: %q", string(body))
+	}
+}
+
+func TestRoundTrip_StrictURLsSchemes(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs:   &storage.ObjectAttrs{Generation: 1},
+			content: "Hello Google Cloud Storage!",
+		},
+	})
+
+	tests := []struct {
+		opts   []Option
+		url    string
+		status int
+	}{
+		{[]Option{WithStrictURLs()}, "gcs://bucket-name/object-key", http.StatusOK},
+		{[]Option{WithStrictURLs(), WithSchemes("gs", "storage")}, "storage://bucket-name/object-key", http.StatusOK},
+		{[]Option{WithStrictURLs(), WithAnyScheme()}, "https://bucket-name/object-key", http.StatusOK},
+		{[]Option{WithStrictURLs(), WithSchemes("gs", "storage")}, "gcs://bucket-name/object-key", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			tr := newTestTransport(mock, tt.opts...)
+			req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				body, _ := io.ReadAll(resp.Body)
+				t.Errorf("unexpected status: want %d, got %d: %s", tt.status, resp.StatusCode, body)
+			}
+		})
+	}
+}
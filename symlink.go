@@ -0,0 +1,114 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// SymlinkMode controls how the Transport handles symlink objects created by gcsfuse.
+//
+// gcsfuse represents a symlink as a zero-byte object with the "gcsfuse_symlink_target" metadata.
+// A relative target is resolved against the directory of the symlink object,
+// a target beginning with "/" is resolved against the root of the bucket,
+// and a target like gs://[BUCKET_NAME]/[OBJECT_NAME] points at another bucket.
+type SymlinkMode int
+
+const (
+	// SymlinkNone serves symlink objects as they are, i.e. as empty placeholder objects.
+	SymlinkNone SymlinkMode = iota
+
+	// SymlinkFollow serves the target object of symlinks transparently.
+	// The Transport responds 508 Loop Detected if the chain of symlinks loops
+	// or is longer than 8 hops.
+	SymlinkFollow
+
+	// SymlinkRedirect responds 302 Found with the gs URL of the symlink target.
+	SymlinkRedirect
+)
+
+// symlinkMetadataKey is the metadata key that gcsfuse uses for the target of symlinks.
+const symlinkMetadataKey = "gcsfuse_symlink_target"
+
+// maxSymlinkHops is the maximum length of symlink chains that the Transport follows.
+const maxSymlinkHops = 8
+
+var errSymlinkLoop = errors.New("gsprotocol: too many levels of symbolic links")
+
+// symlinkRedirectError is returned by objectAttrs in the SymlinkRedirect mode.
+type symlinkRedirectError struct {
+	location string
+}
+
+func (err *symlinkRedirectError) Error() string {
+	return "gsprotocol: symbolic link to " + err.location
+}
+
+// resolveSymlink follows the chain of symlinks beginning at the object.
+// The target of each hop is subject to WithAllowedBuckets and WithDeniedBuckets,
+// as well as the bucket of the request.
+func (t *Transport) resolveSymlink(ctx context.Context, client storageClient, mode SymlinkMode, bucket, name string, object objectHandle, attrs *storage.ObjectAttrs) (objectHandle, *storage.ObjectAttrs, error) {
+	visited := map[string]bool{bucket + "/" + name: true}
+	for hops := 0; ; hops++ {
+		target, ok := attrs.Metadata[symlinkMetadataKey]
+		if !ok {
+			return object, attrs, nil
+		}
+		bucket, name, ok = resolveSymlinkTarget(bucket, name, target)
+		if !ok {
+			return nil, nil, storage.ErrObjectNotExist
+		}
+		if err := t.config.checkBucketAccess(bucket); err != nil {
+			return nil, nil, err
+		}
+		if mode == SymlinkRedirect {
+			u := &url.URL{Scheme: "gs", Host: bucket, Path: "/" + name}
+			return nil, nil, &symlinkRedirectError{location: u.String()}
+		}
+
+		key := bucket + "/" + name
+		if visited[key] || hops >= maxSymlinkHops {
+			return nil, nil, errSymlinkLoop
+		}
+		visited[key] = true
+
+		var err error
+		object = client.Bucket(bucket).Object(name)
+		attrs, err = object.Attrs(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		object = object.Generation(attrs.Generation)
+	}
+}
+
+// resolveSymlinkTarget returns the bucket and the object name that the symlink points at.
+// It reports false if the target doesn't name any object.
+func resolveSymlinkTarget(bucket, name, target string) (string, string, bool) {
+	if strings.HasPrefix(target, "gs://") {
+		u, err := url.Parse(target)
+		if err != nil || u.Host == "" {
+			return "", "", false
+		}
+		object := strings.TrimPrefix(u.Path, "/")
+		if object == "" {
+			return "", "", false
+		}
+		return u.Host, object, true
+	}
+
+	var p string
+	if strings.HasPrefix(target, "/") {
+		p = path.Clean(target)
+	} else {
+		p = path.Join("/", path.Dir(name), target)
+	}
+	if p == "/" {
+		return "", "", false
+	}
+	return bucket, p[1:], true
+}
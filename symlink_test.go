@@ -0,0 +1,182 @@
+package gsprotocol
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestResolveSymlinkTarget(t *testing.T) {
+	tc := []struct {
+		name, target string
+		bucket       string
+		object       string
+		ok           bool
+	}{
+		{"link", "target", "bucket-name", "target", true},
+		{"dir/link", "target", "bucket-name", "dir/target", true},
+		{"dir/link", "../target", "bucket-name", "target", true},
+		{"dir/link", "/other/target", "bucket-name", "other/target", true},
+		{"dir/link", "../../../target", "bucket-name", "target", true},
+		{"dir/link", "gs://other-bucket/target", "other-bucket", "target", true},
+		{"dir/link", "/", "", "", false},
+		{"dir/link", "gs:///target", "", "", false},
+		{"dir/link", "gs://other-bucket", "", "", false},
+		{"dir/link", "gs://other-bucket/", "", "", false},
+	}
+	for _, tt := range tc {
+		bucket, object, ok := resolveSymlinkTarget("bucket-name", tt.name, tt.target)
+		if bucket != tt.bucket || object != tt.object || ok != tt.ok {
+			t.Errorf("resolveSymlinkTarget(%q, %q): want (%q, %q, %t), got (%q, %q, %t)",
+				tt.name, tt.target, tt.bucket, tt.object, tt.ok, bucket, object, ok)
+		}
+	}
+}
+
+var symlinkTestObjects = map[string]mockObject{
+	"bucket-name/target.txt": {
+		attrs:   &storage.ObjectAttrs{ContentType: "text/plain", Generation: 1},
+		content: "Hello Google Cloud Storage!",
+	},
+	"bucket-name/link": {
+		attrs: &storage.ObjectAttrs{Generation: 2, Metadata: map[string]string{symlinkMetadataKey: "target.txt"}},
+	},
+	"bucket-name/dir/link-to-link": {
+		attrs: &storage.ObjectAttrs{Generation: 3, Metadata: map[string]string{symlinkMetadataKey: "../link"}},
+	},
+	"bucket-name/loop-a": {
+		attrs: &storage.ObjectAttrs{Generation: 4, Metadata: map[string]string{symlinkMetadataKey: "loop-b"}},
+	},
+	"bucket-name/loop-b": {
+		attrs: &storage.ObjectAttrs{Generation: 5, Metadata: map[string]string{symlinkMetadataKey: "loop-a"}},
+	},
+	"bucket-name/dangling": {
+		attrs: &storage.ObjectAttrs{Generation: 6, Metadata: map[string]string{symlinkMetadataKey: "missing"}},
+	},
+	"bucket-name/cross-bucket": {
+		attrs: &storage.ObjectAttrs{Generation: 7, Metadata: map[string]string{symlinkMetadataKey: "gs://other-bucket/target.txt"}},
+	},
+	"other-bucket/target.txt": {
+		attrs:   &storage.ObjectAttrs{ContentType: "text/plain", Generation: 8},
+		content: "Hello from the other bucket!",
+	},
+}
+
+func TestRoundTrip_SymlinkNone(t *testing.T) {
+	c := newTestClient(newStorageClientMockWithObjects(symlinkTestObjects), WithSymlinks(SymlinkNone))
+	resp, err := c.Get("gs://bucket-name/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if got := resp.Header.Get("x-goog-meta-" + symlinkMetadataKey); got != "target.txt" {
+		t.Errorf("unexpected symlink target: want %q, got %q", "target.txt", got)
+	}
+	if resp.ContentLength != 0 {
+		t.Errorf("unexpected Content-Length: want %d, got %d", 0, resp.ContentLength)
+	}
+}
+
+func TestRoundTrip_SymlinkFollow(t *testing.T) {
+	c := newTestClient(newStorageClientMockWithObjects(symlinkTestObjects), WithSymlinks(SymlinkFollow))
+	tc := []struct {
+		url     string
+		status  int
+		content string
+	}{
+		{"gs://bucket-name/link", http.StatusOK, "Hello Google Cloud Storage!"},
+		{"gs://bucket-name/dir/link-to-link", http.StatusOK, "Hello Google Cloud Storage!"},
+		{"gs://bucket-name/cross-bucket", http.StatusOK, "Hello from the other bucket!"},
+		{"gs://bucket-name/loop-a", http.StatusLoopDetected, ""},
+		{"gs://bucket-name/dangling", http.StatusNotFound, ""},
+	}
+	for _, tt := range tc {
+		t.Run(tt.url, func(t *testing.T) {
+			resp, err := c.Get(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("unexpected status: want %d, got %d", tt.status, resp.StatusCode)
+			}
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.content {
+				t.Errorf("want %q, got %q", tt.content, string(got))
+			}
+		})
+	}
+}
+
+func TestRoundTrip_SymlinkRedirect(t *testing.T) {
+	c := newTestClient(newStorageClientMockWithObjects(symlinkTestObjects), WithSymlinks(SymlinkRedirect))
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	tc := []struct {
+		url      string
+		location string
+	}{
+		{"gs://bucket-name/link", "gs://bucket-name/target.txt"},
+		{"gs://bucket-name/dir/link-to-link", "gs://bucket-name/link"},
+		{"gs://bucket-name/cross-bucket", "gs://other-bucket/target.txt"},
+		{"gs://bucket-name/loop-a", "gs://bucket-name/loop-b"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.url, func(t *testing.T) {
+			resp, err := c.Get(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusFound {
+				t.Errorf("unexpected status: want %d, got %d", http.StatusFound, resp.StatusCode)
+			}
+			if got := resp.Header.Get("Location"); got != tt.location {
+				t.Errorf("unexpected Location: want %q, got %q", tt.location, got)
+			}
+		})
+	}
+}
+
+func TestRoundTrip_SymlinkPinnedCacheControl(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/target.txt": {
+			attrs:   &storage.ObjectAttrs{CacheControl: "public, max-age=60", Generation: 1},
+			content: "Hello Google Cloud Storage!",
+		},
+		"bucket-name/link": {
+			attrs: &storage.ObjectAttrs{Generation: 2, Metadata: map[string]string{symlinkMetadataKey: "target.txt"}},
+		},
+	})
+	c := newTestClient(mock, WithSymlinks(SymlinkFollow), WithImmutableCacheControl(false))
+	tc := []struct {
+		url  string
+		want string
+	}{
+		// the target may change even if the symlink is pinned.
+		{"gs://bucket-name/link#2", "public, max-age=60"},
+		{"gs://bucket-name/target.txt#1", immutableCacheControl},
+	}
+	for _, tt := range tc {
+		resp, err := c.Get(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("Cache-Control"); got != tt.want {
+			t.Errorf("%s: unexpected Cache-Control: want %q, got %q", tt.url, tt.want, got)
+		}
+	}
+}
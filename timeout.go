@@ -0,0 +1,90 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// errIdleRead is returned from the response body if Google Cloud Storage doesn't send it for too long.
+var errIdleRead = errors.New("gsprotocol: the response body is aborted because Google Cloud Storage didn't send it for too long")
+
+// WithRequestTimeout limits the time to get the response headers of a request,
+// including looking up the attributes and opening the reader of the object.
+// Once timed out, the calls to Google Cloud Storage are canceled, and RoundTrip returns an error
+// that satisfies errors.Is(err, context.DeadlineExceeded).
+// The transfer of the response body is not limited; use WithIdleReadTimeout for it.
+// Zero d disables the timeout. It is disabled by default.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.requestTimeout = d
+	}
+}
+
+// WithIdleReadTimeout aborts the transfer of a response body,
+// if a Read waits for Google Cloud Storage for d, e.g. because the connection hangs.
+// Once aborted, the reader of Google Cloud Storage is closed, and Read returns an error.
+// Unlike WithReadProgressTimeout, the time that the caller doesn't read the body doesn't count.
+// Zero d disables the timeout. It is disabled by default.
+func WithIdleReadTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.idleReadTimeout = d
+	}
+}
+
+// timeoutError is returned from RoundTrip when the request times out.
+// It is compatible with the timeout errors of net/http.
+type timeoutError struct{}
+
+func (timeoutError) Error() string {
+	return "gsprotocol: timeout awaiting response headers"
+}
+
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func (timeoutError) Is(err error) bool {
+	return err == context.DeadlineExceeded
+}
+
+// idleBody calls abort if a Read doesn't return for timeout.
+type idleBody struct {
+	io.ReadCloser
+	timeout time.Duration
+	abort   func()
+
+	mu      sync.Mutex
+	aborted bool
+}
+
+func newIdleBody(body io.ReadCloser, timeout time.Duration, abort func()) *idleBody {
+	return &idleBody{
+		ReadCloser: body,
+		timeout:    timeout,
+		abort:      abort,
+	}
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	aborted := b.aborted
+	b.mu.Unlock()
+	if aborted {
+		return 0, errIdleRead
+	}
+
+	timer := time.AfterFunc(b.timeout, func() {
+		b.mu.Lock()
+		b.aborted = true
+		b.mu.Unlock()
+		b.abort()
+	})
+	n, err := b.ReadCloser.Read(p)
+	if !timer.Stop() {
+		// the timer has fired, and the error is caused by the abort.
+		return n, errIdleRead
+	}
+	return n, err
+}
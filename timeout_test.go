@@ -0,0 +1,158 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// blockingReader blocks until the context is canceled, like the reader of a hung connection.
+type blockingReader struct {
+	ctx context.Context
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	<-r.ctx.Done()
+	return 0, r.ctx.Err()
+}
+
+func TestRoundTrip_RequestTimeout(t *testing.T) {
+	object := &objectHandleMock{
+		attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+			// hang until canceled.
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	tr := newTestTransport(newSingleObjectMock(object), WithRequestTimeout(50*time.Millisecond))
+
+	req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	var resp *http.Response
+	go func() {
+		defer close(done)
+		resp, err = tr.RoundTrip(req)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request doesn't time out")
+	}
+
+	if resp != nil {
+		t.Errorf("want no response, got %d", resp.StatusCode)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want context.DeadlineExceeded, got %v", err)
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("want a timeout error, got %v", err)
+	}
+}
+
+func TestRoundTrip_RequestTimeoutBody(t *testing.T) {
+	const content = "0123456789"
+	object := &objectHandleMock{
+		attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+			return &storage.ObjectAttrs{Generation: 1, Size: int64(len(content))}, nil
+		},
+		newReaderFunc: func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+			r := &ctxReader{ctx: ctx, r: strings.NewReader(content)}
+			return storage.ReaderObjectAttrs{Generation: 1, Size: int64(len(content))}, io.NopCloser(r), nil
+		},
+		generationFunc: func(mock *objectHandleMock, gen int64) *objectHandleMock {
+			return mock
+		},
+	}
+	tr := newTestTransport(newSingleObjectMock(object), WithRequestTimeout(20*time.Millisecond))
+
+	req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// the timeout doesn't cover the transfer of the body.
+	time.Sleep(50 * time.Millisecond)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != content {
+		t.Errorf("want %q, got %q", content, body)
+	}
+}
+
+func TestRoundTrip_IdleReadTimeout(t *testing.T) {
+	canceled := make(chan struct{})
+	object := &objectHandleMock{
+		attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+			return &storage.ObjectAttrs{Generation: 1, Size: 10}, nil
+		},
+		newReaderFunc: func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+			go func() {
+				<-ctx.Done()
+				close(canceled)
+			}()
+			r := io.MultiReader(strings.NewReader("01234"), &blockingReader{ctx: ctx})
+			return storage.ReaderObjectAttrs{Generation: 1, Size: 10}, io.NopCloser(r), nil
+		},
+		generationFunc: func(mock *objectHandleMock, gen int64) *objectHandleMock {
+			return mock
+		},
+	}
+	// disable resumption, which would retry the aborted read.
+	tr := newTestTransport(newSingleObjectMock(object), WithIdleReadTimeout(50*time.Millisecond), WithResumeRetries(-1))
+
+	req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// the time that the caller doesn't read the body doesn't count.
+	time.Sleep(100 * time.Millisecond)
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// the read in flight is aborted.
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := resp.Body.Read(buf)
+		errCh <- err
+	}()
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, errIdleRead) {
+			t.Errorf("want errIdleRead, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the read is not aborted")
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("the reader of Google Cloud Storage is not canceled")
+	}
+}
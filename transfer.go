@@ -0,0 +1,89 @@
+package gsprotocol
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// TransferError is returned from the response body if the transfer is aborted partway,
+// e.g. by an upstream error or a canceled context.
+// The retry logic can resume the transfer from BytesDelivered.
+type TransferError struct {
+	// Bucket, Object, and Generation are the object transferred.
+	// Generation is zero if it is unknown.
+	Bucket     string
+	Object     string
+	Generation int64
+
+	// BytesDelivered is the number of bytes read from the body before the transfer is aborted.
+	BytesDelivered int64
+
+	// ExpectedBytes is the length of the body, or -1 if it is unknown.
+	ExpectedBytes int64
+
+	// Err is the cause.
+	Err error
+}
+
+func (err *TransferError) Error() string {
+	expected := "unknown"
+	if err.ExpectedBytes >= 0 {
+		expected = strconv.FormatInt(err.ExpectedBytes, 10)
+	}
+	return fmt.Sprintf("gsprotocol: the transfer of gs://%s/%s#%d is aborted after %d of %s bytes: %v",
+		err.Bucket, err.Object, err.Generation, err.BytesDelivered, expected, err.Err)
+}
+
+func (err *TransferError) Unwrap() error {
+	return err.Err
+}
+
+// transferBody wraps the errors of body with TransferError.
+type transferBody struct {
+	io.ReadCloser
+	err *TransferError
+}
+
+func newTransferBody(body io.ReadCloser, bucket, object string, generation, expected int64) *transferBody {
+	return &transferBody{
+		ReadCloser: body,
+		err: &TransferError{
+			Bucket:        bucket,
+			Object:        object,
+			Generation:    generation,
+			ExpectedBytes: expected,
+		},
+	}
+}
+
+// newTransferBodyFromResponse returns transferBody for body of the response of req.
+func newTransferBodyFromResponse(req *http.Request, resp *http.Response, body io.ReadCloser) *transferBody {
+	gen, _ := strconv.ParseInt(resp.Header.Get("x-goog-generation"), 10, 64)
+	object := objectName(req.URL)
+	return newTransferBody(body, bucketName(req), object, gen, resp.ContentLength)
+}
+
+func (b *transferBody) Read(p []byte) (int, error) {
+	if b.err.Err != nil {
+		return 0, b.err
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.err.BytesDelivered += int64(n)
+	if err != nil && err != io.EOF {
+		b.err.Err = err
+		return n, b.err
+	}
+	return n, err
+}
+
+func (b *transferBody) Close() error {
+	if err := b.ReadCloser.Close(); err != nil {
+		if b.err.Err == nil {
+			b.err.Err = err
+		}
+		return b.err
+	}
+	return nil
+}
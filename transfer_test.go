@@ -0,0 +1,88 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+// failingReader returns err after reading r.
+type failingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		return n, r.err
+	}
+	return n, err
+}
+
+func TestRoundTrip_TransferError(t *testing.T) {
+	errUpstream := errors.New("upstream error")
+	object := &objectHandleMock{
+		attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+			return &storage.ObjectAttrs{Bucket: "bucket-name", Name: "object-key", Generation: 1234567890, Size: 10}, nil
+		},
+		newReaderFunc: func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+			r := &failingReader{r: strings.NewReader("01234"), err: errUpstream}
+			return storage.ReaderObjectAttrs{Generation: 1234567890, Size: 10}, io.NopCloser(r), nil
+		},
+		generationFunc: func(mock *objectHandleMock, gen int64) *objectHandleMock {
+			return mock
+		},
+	}
+	tr := newTestTransport(newSingleObjectMock(object))
+	want := TransferError{
+		Bucket:         "bucket-name",
+		Object:         "object-key",
+		Generation:     1234567890,
+		BytesDelivered: 5,
+		ExpectedBytes:  10,
+		Err:            errUpstream,
+	}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		resp, err := (&http.Client{Transport: tr}).Get("gs://bucket-name/object-key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		_, err = io.ReadAll(resp.Body)
+		var transferErr *TransferError
+		if !errors.As(err, &transferErr) {
+			t.Fatalf("want *TransferError, got %v", err)
+		}
+		if *transferErr != want {
+			t.Errorf("unexpected error: want %+v, got %+v", want, *transferErr)
+		}
+		if !errors.Is(err, errUpstream) {
+			t.Errorf("want errUpstream, got %v", err)
+		}
+	})
+
+	t.Run("Open", func(t *testing.T) {
+		_, body, err := tr.Open(context.Background(), "gs://bucket-name/object-key", Validators{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer body.Close()
+
+		_, err = io.ReadAll(body)
+		var transferErr *TransferError
+		if !errors.As(err, &transferErr) {
+			t.Fatalf("want *TransferError, got %v", err)
+		}
+		if *transferErr != want {
+			t.Errorf("unexpected error: want %+v, got %+v", want, *transferErr)
+		}
+	})
+}
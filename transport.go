@@ -5,76 +5,502 @@ import (
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/textproto"
+	"net/url"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/storage"
+	"golang.org/x/sync/singleflight"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
 )
 
 // Transport serving the Google Cloud Storage objects.
 type Transport struct {
 	client storageClient
+	config config
+
+	mu         sync.Mutex
+	inflight   map[*http.Request]context.CancelFunc
+	clientRefs map[storageClient]int
+	clientIDs  map[storageClient]uint64
+	lastID     uint64
+	watchers   int
+	shadows    int
+	budgets    map[string]*budgetCounter
+	headMemo   map[string]headMemoEntry
+	attrsCache attrsCache
+
+	// idempotencyKeys remembers the Idempotency-Key headers of the successful uploads.
+	idempotencyKeys idempotencyCache
+
+	// watchGroup coalesces the checks of long-polling requests.
+	watchGroup singleflight.Group
+
+	// attrsGroup coalesces the concurrent lookups of the attributes of the same object.
+	attrsGroup singleflight.Group
+
+	// rangeCoalescer merges the concurrent range reads. See WithRangeCoalescing.
+	rangeCoalescer rangeCoalescer
 }
 
 // NewTransport returns a new Transport.
+// If it fails to find the credentials, the error describes the sources of credentials attempted.
+// See Transport.Diagnose.
 func NewTransport(ctx context.Context, opts ...option.ClientOption) (*Transport, error) {
-	client, err := storage.NewClient(ctx, opts...)
+	return NewTransportWithOptions(ctx, opts)
+}
+
+// NewTransportWithOptions returns a new Transport with the storage client created from gcsOpts,
+// and the behavior configured by opts.
+// If it fails to find the credentials, the error describes the sources of credentials attempted.
+// See WithOwnHTTPClient and WithStorageHTTPClient for closing the idle connections of the storage client.
+func NewTransportWithOptions(ctx context.Context, gcsOpts []option.ClientOption, opts ...Option) (*Transport, error) {
+	cfg := newConfig(opts)
+	var impl storageClientImpl
+	if hc := cfg.storageHTTPClient; hc != nil {
+		gcsOpts = append(gcsOpts[:len(gcsOpts):len(gcsOpts)], option.WithHTTPClient(hc))
+		impl.idle = hc
+	} else if cfg.ownHTTPClient {
+		if hc, base, err := newHTTPClient(ctx, gcsOpts); err == nil {
+			gcsOpts = append(gcsOpts[:len(gcsOpts):len(gcsOpts)], option.WithHTTPClient(hc))
+			impl.idle = base
+		}
+	}
+	client, err := storage.NewClient(ctx, gcsOpts...)
 	if err != nil {
+		if strings.Contains(err.Error(), "credentials") {
+			return nil, fmt.Errorf("%w\n%s", err, diagnoseCredentials(ctx))
+		}
 		return nil, err
 	}
+	impl.client = client
 	return &Transport{
-		client: newStorageClientImpl(client),
+		client: impl,
+		config: cfg,
 	}, nil
 }
 
+// newHTTPClient returns the authenticated HTTP client for the storage client and its base transport,
+// so that CloseIdleConnections can close the idle connections of the storage client.
+// See WithOwnHTTPClient.
+// It fails if the storage client should dial by itself,
+// e.g. with the emulator, the client certificates or the HTTP client given by option.WithHTTPClient.
+func newHTTPClient(ctx context.Context, gcsOpts []option.ClientOption) (*http.Client, *http.Transport, error) {
+	if os.Getenv("STORAGE_EMULATOR_HOST") != "" || os.Getenv("GOOGLE_API_USE_CLIENT_CERTIFICATE") == "true" {
+		return nil, nil, errors.New("gsprotocol: the storage client dials by itself")
+	}
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.MaxIdleConnsPerHost = 100 // the same as the storage client's default
+	opts := append([]option.ClientOption{
+		option.WithScopes(storage.ScopeFullControl, "https://www.googleapis.com/auth/cloud-platform"),
+	}, gcsOpts...)
+	rt, err := htransport.NewTransport(ctx, base, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &http.Client{Transport: rt}, base, nil
+}
+
 // NewTransportWithClient returns a new Transport.
-func NewTransportWithClient(client *storage.Client) *Transport {
+// The Transport closes client when it is replaced by SetClient.
+func NewTransportWithClient(client *storage.Client, opts ...Option) *Transport {
 	return &Transport{
 		client: newStorageClientImpl(client),
+		config: newConfig(opts),
+	}
+}
+
+// NewTransportWithStorage returns a new Transport serving the objects of client,
+// e.g. a fake of Google Cloud Storage in tests.
+func NewTransportWithStorage(client StorageClient, opts ...Option) *Transport {
+	return &Transport{
+		client: client,
+		config: newConfig(opts),
 	}
 }
 
 // RoundTrip implements http.RoundTripper.
+//
+// RoundTrip can be used as the Transport of httputil.ReverseProxy.
+// The response always has a non-nil Body, and the request headers other than the conditional ones
+// and the x-goog-user-project header, including the hop-by-hop headers and RequestURI, are ignored.
+// The bucket name is taken from req.Host, or req.URL.Host if req.Host is empty,
+// so the Director that rewrites req.URL must also clear or rewrite req.Host.
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	stats := statsFromContext(req.Context())
+	stats.recordStart()
+
+	bucket, _ := t.config.resolveBucket(bucketName(req))
+	cfg := t.config.forBucket(bucket)
+	ctx, client, done := t.trackRequest(req)
+	var timer *time.Timer
+	if d := cfg.requestTimeout; d > 0 {
+		timer = time.AfterFunc(d, func() {
+			t.CancelRequest(req)
+		})
+	}
+	resp, err := t.roundTrip(req.WithContext(ctx), client)
+	if resp != nil && resp.Body == nil {
+		resp.Body = http.NoBody
+	}
+	if resp != nil && t.config.bucketAliases != nil {
+		if resp.Header == nil {
+			resp.Header = make(http.Header)
+		}
+		resp.Header.Set("X-Gsprotocol-Bucket", bucket)
+	}
+	if timer != nil && !timer.Stop() {
+		// the request is canceled by the timeout, and the response is broken if any.
+		if resp != nil {
+			resp.Body.Close()
+		}
+		resp, err = nil, timeoutError{}
+	}
+	t.shadowRead(req, client, resp, err)
+	t.recordRequest(req, start, resp, err)
+	stats.recordResponse(resp)
+	if err != nil || resp.Body == nil || resp.Body == http.NoBody {
+		done()
+		stats.recordDone()
+		return resp, err
+	}
+	body := resp.Body
+	if d := cfg.idleReadTimeout; d > 0 {
+		body = newIdleBody(body, d, func() {
+			t.CancelRequest(req)
+		})
+	}
+	if cfg.prefetchBytes > 0 {
+		body = newPrefetchBody(body, cfg.prefetchBytes)
+	}
+	if stats != nil {
+		body = &statsBody{
+			ReadCloser: body,
+			stats:      stats,
+		}
+	}
+	if d := cfg.readProgressTimeout; d > 0 {
+		body = newProgressBody(body, d, func() {
+			t.CancelRequest(req)
+		})
+	}
+	resp.Body = &trackedBody{
+		ReadCloser: newTransferBodyFromResponse(req, resp, body),
+		done: func() {
+			done()
+			stats.recordDone()
+		},
+	}
+	return resp, nil
+}
+
+func (t *Transport) roundTrip(req *http.Request, client storageClient) (*http.Response, error) {
+	// RoundTrip must always close the body, including on errors.
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+	if err := t.checkScheme(req.URL); err != nil {
+		return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	req, resp := t.config.resolveAlias(req)
+	if resp != nil {
+		return resp, nil
+	}
+	bucket := bucketName(req)
+	if err := t.config.checkBucketAccess(bucket); err != nil {
+		return newForbiddenResponse(err), nil
+	}
+	cfg := t.config.forBucket(bucket)
+	if !cfg.allowsMethod(req.Method) {
+		return newMethodNotAllowedResponse(cfg), nil
+	}
+	if req.Method == http.MethodOptions {
+		header := make(http.Header)
+		header.Set("Allow", cfg.allowHeader())
+		return &http.Response{
+			Status:     "204 No Content",
+			StatusCode: http.StatusNoContent,
+			Proto:      "HTTP/1.0",
+			ProtoMajor: 1,
+			ProtoMinor: 0,
+			Header:     header,
+			Body:       http.NoBody,
+			Close:      true,
+		}, nil
+	}
+
+	if cfg.fragmentInName {
+		req = fragmentInName(req)
+	}
+	req, err := generationQuery(req)
+	if err != nil {
+		return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	if cfg.strictURLs {
+		if err := t.validateURL(req.URL); err != nil {
+			return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+		}
+	}
+	if err := checkQuery(req.URL); err != nil {
+		return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	if err := checkNames(req); err != nil {
+		return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	if resp := t.checkBudget(bucket, cfg); resp != nil {
+		return resp, nil
+	}
+	if isWriteMethod(req.Method) {
+		var resp *http.Response
+		client, resp = t.writeClientFor(client, bucket, cfg)
+		if resp != nil {
+			return resp, nil
+		}
+	}
+	client = t.budgetedClient(client, bucket, cfg)
+	client = userProjectedClient(client, req, cfg)
+
 	switch req.Method {
 	case http.MethodGet:
-		return t.getObject(req)
+		return t.withRetry(req, client, cfg, t.getObject)
 	case http.MethodHead:
-		return t.headObject(req)
+		return t.withRetry(req, client, cfg, t.headObject)
+	case http.MethodPut:
+		resp, err = t.withWriteRetry(req, client, cfg, t.putObject)
+	case http.MethodDelete:
+		resp, err = t.withWriteRetry(req, client, cfg, t.deleteObject)
+	case http.MethodPatch:
+		resp, err = t.withWriteRetry(req, client, cfg, t.patchObject)
+	case http.MethodPost:
+		resp, err = t.withWriteRetry(req, client, cfg, t.composeObject)
+	default:
+		return newMethodNotAllowedResponse(cfg), nil
 	}
+	// the successful writes update the caches by publishWrite,
+	// and the failed ones may have modified the object too.
+	if err != nil || resp.StatusCode >= http.StatusBadRequest {
+		t.invalidateCaches(cfg, bucket, objectName(req.URL))
+	}
+	return resp, err
+}
+
+// newMethodNotAllowedResponse returns the 405 Method Not Allowed response with the Allow header.
+func newMethodNotAllowedResponse(cfg *config) *http.Response {
+	header := make(http.Header)
+	header.Set("Allow", cfg.allowHeader())
 	return &http.Response{
 		Status:     "405 Method Not Allowed",
 		StatusCode: http.StatusMethodNotAllowed,
 		Proto:      "HTTP/1.0",
 		ProtoMajor: 1,
 		ProtoMinor: 0,
-		Header:     make(http.Header),
+		Header:     header,
 		Body:       http.NoBody,
 		Close:      true,
-	}, nil
+	}
 }
 
-func (t *Transport) getObject(req *http.Request) (*http.Response, error) {
+// methods are the methods that the Transport serves, in the order of the Allow header.
+var methods = []struct {
+	method string
+	write  bool
+}{
+	{http.MethodGet, false},
+	{http.MethodHead, false},
+	{http.MethodPut, true},
+	{http.MethodDelete, true},
+	{http.MethodPatch, true},
+	{http.MethodPost, true},
+	{http.MethodOptions, false},
+}
+
+// allowsMethod reports whether the Transport serves the method with the configuration.
+func (c *config) allowsMethod(method string) bool {
+	for _, m := range methods {
+		if m.method == method {
+			return !m.write || c.writeMethods
+		}
+	}
+	return false
+}
+
+// isWriteMethod reports whether the method modifies objects.
+func isWriteMethod(method string) bool {
+	for _, m := range methods {
+		if m.method == method {
+			return m.write
+		}
+	}
+	return false
+}
+
+// allowHeader returns the value of the Allow header, the methods that the Transport serves with the configuration.
+func (c *config) allowHeader() string {
+	allowed := make([]string, 0, len(methods))
+	for _, m := range methods {
+		if c.allowsMethod(m.method) {
+			allowed = append(allowed, m.method)
+		}
+	}
+	return strings.Join(allowed, ", ")
+}
+
+func (t *Transport) getObject(req *http.Request, client storageClient) (*http.Response, error) {
 	ctx := req.Context()
-	object, attrs, err := t.objectAttrs(ctx, req)
+	cfg := t.config.forBucket(bucketName(req))
+	if format := req.URL.Query().Get("archive"); format != "" {
+		return t.getArchive(req, client, cfg, format, true)
+	}
+	if isBatchMetadataRequest(req) {
+		return t.getBatchMetadata(req, client, cfg)
+	}
+	if cfg.strictConditionals {
+		if err := checkConflictingConditionals(req); err != nil {
+			return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+		}
+	}
+	changed, resp, err := t.waitForChange(req, client, cfg)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+	if changed != nil {
+		// serve the generation that the long-polling saw, not the stale one in the caches.
+		ctx = WithKnownAttrs(ctx, changed)
+	}
+	decompress, err := cfg.decompression(req)
 	if err != nil {
-		return handleError(err)
+		return newErrorResponse(http.StatusBadRequest, err.Error()), nil
 	}
-	header := makeHeader(attrs)
-	if resp := checkPreconditions(req, header, attrs); resp != nil {
-		return resp, nil
+	if canServeSingleRequest(ctx, req, cfg, decompress) {
+		return t.getObjectSingleRequest(ctx, req, client, cfg)
 	}
+	stats := statsFromContext(ctx)
+	var attrs *storage.ObjectAttrs
+	var header http.Header
+	var body storageReader
+	var object objectHandle
+	var ranges []httpRange
+	var parallel bool
+	for retried := false; ; retried = true {
+		if retried {
+			// the given attributes may be stale.
+			ctx = withoutKnownAttrs(ctx)
+		}
+		start := time.Now()
+		object, attrs, err = t.objectAttrs(ctx, client, req, cfg)
+		stats.recordAttrs(time.Since(start), attrs)
+		if err != nil {
+			return handleError(err)
+		}
+		header = cfg.responseHeader(req, attrs)
+		if decompress != "" {
+			if err := checkDecompressible(attrs); err != nil {
+				return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+			}
+			decompressHeader(header, attrs.Name)
+		}
+		if resp := checkPreconditions(req, header, attrs); resp != nil {
+			return resp, nil
+		}
 
-	body, err := object.NewReader(ctx)
-	if err != nil {
-		return nil, err
+		ranges, err = requestRanges(req, header, attrs, decompress)
+		if err != nil {
+			return newRangeNotSatisfiableResponse(attrs.Size), nil
+		}
+		parallel = len(ranges) == 0 && cfg.parallelDownload(req, attrs, decompress)
+		start = time.Now()
+		if len(ranges) > 0 || parallel {
+			// the offsets are of the generation of attrs, so pin it even WithoutGenerationPin.
+			if isUnpinned(ctx) && req.URL.Fragment == "" {
+				object = object.Generation(attrs.Generation)
+			}
+		}
+		if len(ranges) == 1 && cfg.canCoalesceRange(ranges[0]) {
+			body, err = t.coalescedRange(ctx, client, cfg, object, attrs, ranges[0])
+		} else if len(ranges) > 0 {
+			body, err = object.NewRangeReader(ctx, ranges[0].start, ranges[0].length)
+		} else if parallel {
+			body, err = object.NewRangeReader(ctx, 0, cfg.parallelChunkSize)
+		} else {
+			body, err = object.NewReader(ctx)
+		}
+		stats.recordReader(time.Since(start))
+		if err == nil {
+			break
+		}
+		err = checkGenerationRace(ctx, client, req, attrs, err)
+		var raceErr *generationRaceError
+		if !errors.As(err, &raceErr) {
+			return handleError(err)
+		}
+		// the cached attributes are of the old generation.
+		t.attrsCache.invalidate(bucketName(req), objectName(req.URL))
+		if !(cfg.retryGenerationRace || cfg.attrsCacheEnabled()) || retried {
+			return handleError(err)
+		}
+	}
+
+	var respBody io.ReadCloser = body
+	header.Set("Accept-Ranges", acceptRanges(attrs, decompress))
+	if len(ranges) > 0 {
+		contentLength := ranges[0].length
+		if len(ranges) == 1 {
+			header.Set("Content-Range", ranges[0].contentRange(attrs.Size))
+		} else {
+			var contentType string
+			respBody, contentType, contentLength = newMultipartBody(ctx, object, body, ranges, header.Get("Content-Type"), attrs.Size)
+			header.Set("Content-Type", contentType)
+		}
+		header.Set("Content-Length", strconv.FormatInt(contentLength, 10))
+		return &http.Response{
+			Status:        "206 Partial Content",
+			StatusCode:    http.StatusPartialContent,
+			Proto:         "HTTP/1.0",
+			ProtoMajor:    1,
+			ProtoMinor:    0,
+			Header:        header,
+			Body:          respBody,
+			ContentLength: contentLength,
+			Close:         true,
+		}, nil
+	}
+	contentLength := attrs.Size
+	unpinned := isUnpinned(ctx) && req.URL.Fragment == ""
+	if parallel {
+		respBody = newParallelBody(ctx, object, body, attrs.Size, cfg.parallelChunkSize, cfg.parallelWorkers)
+	} else if retries := cfg.resumeRetriesOrDefault(); retries > 0 && attrs.ContentEncoding != "gzip" {
+		body = newResumableBody(ctx, object, body, unpinned, retries)
+		respBody = body
+	}
+	if unpinned && !parallel {
+		if gen := body.Attrs().Generation; gen != 0 && gen != attrs.Generation {
+			// the cached attributes are of the old generation.
+			t.attrsCache.invalidate(bucketName(req), objectName(req.URL))
+		}
+		contentLength = unpinnedHeader(header, attrs, body.Attrs())
+	}
+	if decompress != "" {
+		respBody, err = newGzipBody(body)
+		if err != nil {
+			body.Close()
+			if _, ok := err.(*notGzipError); ok {
+				return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+			}
+			return nil, err
+		}
+		contentLength = -1
 	}
 
 	return &http.Response{
@@ -84,93 +510,290 @@ func (t *Transport) getObject(req *http.Request) (*http.Response, error) {
 		ProtoMajor:    1,
 		ProtoMinor:    0,
 		Header:        header,
-		Body:          body,
-		ContentLength: attrs.Size,
+		Body:          respBody,
+		ContentLength: contentLength,
 		Close:         true,
 	}, nil
 }
 
-func (t *Transport) headObject(req *http.Request) (*http.Response, error) {
+func (t *Transport) headObject(req *http.Request, client storageClient) (*http.Response, error) {
 	ctx := req.Context()
-	_, attrs, err := t.objectAttrs(ctx, req)
+	cfg := t.config.forBucket(bucketName(req))
+	if format := req.URL.Query().Get("archive"); format != "" {
+		return t.getArchive(req, client, cfg, format, false)
+	}
+	if cfg.strictConditionals {
+		if err := checkConflictingConditionals(req); err != nil {
+			return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+		}
+	}
+	decompress, err := cfg.decompression(req)
+	if err != nil {
+		return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	stats := statsFromContext(ctx)
+	start := time.Now()
+	_, attrs, err := t.objectAttrs(ctx, client, req, cfg)
+	stats.recordAttrs(time.Since(start), attrs)
 	if err != nil {
 		return handleError(err)
 	}
-	header := makeHeader(attrs)
+	header := cfg.responseHeader(req, attrs)
+	if decompress != "" {
+		if err := checkDecompressible(attrs); err != nil {
+			return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+		}
+		decompressHeader(header, attrs.Name)
+	}
 	if resp := checkPreconditions(req, header, attrs); resp != nil {
 		return resp, nil
 	}
 
+	// the same as the response of GET, like net/http does for HEAD requests.
+	header.Set("Accept-Ranges", acceptRanges(attrs, decompress))
+	contentLength := attrs.Size
+	if decompress != "" {
+		contentLength = -1
+	}
 	return &http.Response{
-		Status:     "200 OK",
-		StatusCode: http.StatusOK,
-		Proto:      "HTTP/1.0",
-		ProtoMajor: 1,
-		ProtoMinor: 0,
-		Header:     header,
-		Body:       http.NoBody,
-		Close:      true,
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.0",
+		ProtoMajor:    1,
+		ProtoMinor:    0,
+		Header:        header,
+		Body:          http.NoBody,
+		ContentLength: contentLength,
+		Close:         true,
 	}, nil
 }
 
-func (t *Transport) objectAttrs(ctx context.Context, req *http.Request) (objectHandle, *storage.ObjectAttrs, error) {
+// checkScheme returns an error if the Transport doesn't serve the scheme of u.
+func (t *Transport) checkScheme(u *url.URL) error {
+	if t.config.anyScheme {
+		return nil
+	}
+	schemes := t.config.servedSchemes()
+	for _, scheme := range schemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			return nil
+		}
+	}
+	return fmt.Errorf("gsprotocol: unsupported scheme %q, the Transport serves only %s:// URLs", u.Scheme, strings.Join(schemes, ":// and "))
+}
+
+// bucketName returns the name of the bucket that the request targets.
+func bucketName(req *http.Request) string {
 	host := req.Host
 	if host == "" {
 		host = req.URL.Host
 	}
-	path := strings.TrimPrefix(req.URL.Path, "/")
-	object := t.client.Bucket(host).Object(path)
+	return host
+}
+
+func (t *Transport) objectAttrs(ctx context.Context, client storageClient, req *http.Request, cfg *config) (objectHandle, *storage.ObjectAttrs, error) {
+	host := bucketName(req)
+	path := objectName(req.URL)
+	object := client.Bucket(host).Object(path)
 
+	gen, err := urlGeneration(req.URL)
+	if err != nil {
+		return nil, nil, err
+	}
 	var attrs *storage.ObjectAttrs
-	if fragment := req.URL.Fragment; fragment != "" {
-		gen, err := strconv.ParseInt(fragment, 10, 64)
-		if err != nil {
-			return nil, nil, fmt.Errorf("gsprotocol: invalid generation %s: %v", fragment, err)
-		}
+	if gen != 0 {
 		object = object.Generation(gen)
-		attrs, err = object.Attrs(ctx)
+		key := attrsCacheKey{bucket: host, object: path, generation: gen}
+		if cached, ok := t.attrsCache.get(cfg, key); ok {
+			attrs = cached
+			statsFromContext(ctx).recordAttrsCacheHit()
+		} else if cached, ok := cfg.generationCache.get(host, path, gen); ok {
+			attrs = cached
+		} else {
+			attrs, err = t.sharedAttrs(ctx, client, object, host, path, gen)
+			if err != nil {
+				return nil, nil, err
+			}
+			cfg.generationCache.add(host, path, attrs)
+			t.attrsCache.add(cfg, key, attrs)
+		}
+	} else if known, ok := knownAttrs(ctx, host, path); ok {
+		attrs = known
+		object = object.Generation(attrs.Generation)
+	} else if memo, ok := t.recallHead(req, cfg, host, path); ok {
+		attrs = memo
+		statsFromContext(ctx).recordMemoHit()
+		object = object.Generation(attrs.Generation)
+	} else if cached, ok := t.attrsCache.get(cfg, attrsCacheKey{bucket: host, object: path}); ok {
+		attrs = cached
+		statsFromContext(ctx).recordAttrsCacheHit()
+		if !isUnpinned(ctx) {
+			object = object.Generation(attrs.Generation)
+		}
+	} else {
+		attrs, err = t.sharedAttrs(ctx, client, object, host, path, 0)
 		if err != nil {
 			return nil, nil, err
 		}
-	} else {
+		cfg.generationCache.add(host, path, attrs)
+		t.attrsCache.add(cfg, attrsCacheKey{bucket: host, object: path}, attrs)
+		if req.Method == http.MethodHead {
+			t.memoizeHead(cfg, host, path, attrs)
+		}
+		if !isUnpinned(ctx) {
+			object = object.Generation(attrs.Generation)
+		}
+	}
+	if cfg.symlinkMode != SymlinkNone {
 		var err error
-		attrs, err = object.Attrs(ctx)
+		object, attrs, err = t.resolveSymlink(ctx, client, cfg.symlinkMode, host, path, object, attrs)
 		if err != nil {
 			return nil, nil, err
 		}
-		object = object.Generation(attrs.Generation)
+	}
+	if attrs.CustomerKeySHA256 != "" {
+		key, err := cfg.encryptionKey(attrs)
+		if err != nil {
+			return nil, nil, err
+		}
+		object = object.Key(key)
 	}
 	return object, attrs, nil
 }
 
+// sharedAttrs returns the attributes of object, the generation gen of bucket/path or the live one if gen is zero.
+// The concurrent calls for the same object through the same storage client and user project
+// share one call of Attrs with the context of the first caller,
+// and the errors are shared as well as the attributes.
+// If the shared call fails because the context of the first caller is done, the others call Attrs by themselves.
+func (t *Transport) sharedAttrs(ctx context.Context, client storageClient, object objectHandle, bucket, path string, gen int64) (*storage.ObjectAttrs, error) {
+	id, project := t.clientIdentity(client)
+	key := strconv.FormatUint(id, 10) + "/" + project + "/" + strconv.FormatInt(gen, 10) + "/" + bucket + "/" + path
+	v, err, shared := t.attrsGroup.Do(key, func() (interface{}, error) {
+		return object.Attrs(ctx)
+	})
+	if err != nil {
+		canceled := errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+		if shared && canceled && ctx.Err() == nil {
+			return object.Attrs(ctx)
+		}
+		return nil, err
+	}
+	attrs := v.(*storage.ObjectAttrs)
+	if shared {
+		// the callers may modify the attributes.
+		attrs = copyObjectAttrs(attrs)
+	}
+	return attrs, nil
+}
+
 func handleError(err error) (*http.Response, error) {
-	if err == storage.ErrObjectNotExist || err == storage.ErrBucketNotExist {
+	if errors.Is(err, storage.ErrObjectNotExist) || errors.Is(err, storage.ErrBucketNotExist) {
+		header := make(http.Header)
+		if errors.Is(err, storage.ErrBucketNotExist) {
+			header.Set("x-gsprotocol-error", "bucket-not-found")
+		} else {
+			header.Set("x-gsprotocol-error", "object-not-found")
+		}
 		return &http.Response{
 			Status:     "404 Not Found",
 			StatusCode: http.StatusNotFound,
 			Proto:      "HTTP/1.0",
 			ProtoMajor: 1,
 			ProtoMinor: 0,
+			Header:     header,
+			Body:       http.NoBody,
+			Close:      true,
+		}, nil
+	}
+	if err, ok := err.(*encryptionKeyError); ok {
+		return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	if err, ok := err.(*bucketAccessError); ok {
+		return newForbiddenResponse(err), nil
+	}
+	if err, ok := err.(*kmsKeyError); ok {
+		resp := newErrorResponse(http.StatusForbidden, err.Error())
+		resp.Header.Set("x-gsprotocol-error", "kms-key-forbidden")
+		return resp, nil
+	}
+	if err, ok := err.(*retentionError); ok {
+		return err.newResponse(), nil
+	}
+	if err, ok := err.(*generationRaceError); ok {
+		resp := newErrorResponse(http.StatusConflict, err.Error())
+		resp.Header.Set("x-gsprotocol-error", "generation-race")
+		return resp, nil
+	}
+	if err == errSymlinkLoop {
+		return &http.Response{
+			Status:     "508 Loop Detected",
+			StatusCode: http.StatusLoopDetected,
+			Proto:      "HTTP/1.0",
+			ProtoMajor: 1,
+			ProtoMinor: 0,
 			Header:     make(http.Header),
 			Body:       http.NoBody,
 			Close:      true,
 		}, nil
 	}
-	if err, ok := err.(*googleapi.Error); ok {
+	if err, ok := err.(*symlinkRedirectError); ok {
+		header := make(http.Header)
+		header.Set("Location", err.location)
 		return &http.Response{
-			Status:     fmt.Sprintf("%d %s", err.Code, http.StatusText(err.Code)),
-			StatusCode: err.Code,
+			Status:     "302 Found",
+			StatusCode: http.StatusFound,
 			Proto:      "HTTP/1.0",
 			ProtoMajor: 1,
 			ProtoMinor: 0,
-			Header:     err.Header,
-			Body:       io.NopCloser(strings.NewReader(err.Body)),
+			Header:     header,
+			Body:       http.NoBody,
+			Close:      true,
+		}, nil
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		header := errorHeader(apiErr.Header, err)
+		if apiErr.Code == http.StatusTooManyRequests || apiErr.Code == http.StatusServiceUnavailable {
+			// the clients need the hint to back off, even if Google Cloud Storage doesn't give it.
+			if header.Get("Retry-After") == "" {
+				header.Set("Retry-After", "1")
+			}
+		}
+		return &http.Response{
+			Status:     fmt.Sprintf("%d %s", apiErr.Code, http.StatusText(apiErr.Code)),
+			StatusCode: apiErr.Code,
+			Proto:      "HTTP/1.0",
+			ProtoMajor: 1,
+			ProtoMinor: 0,
+			Header:     header,
+			Body:       io.NopCloser(strings.NewReader(apiErr.Body)),
 			Close:      true,
 		}, nil
 	}
+	if id := requestID(err); id != "" {
+		return nil, fmt.Errorf("%w (request id: %s)", err, id)
+	}
 	return nil, err
 }
 
+// newErrorResponse returns a response with the status code and the plain text message.
+func newErrorResponse(statusCode int, message string) *http.Response {
+	header := make(http.Header)
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.0",
+		ProtoMajor:    1,
+		ProtoMinor:    0,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(message)),
+		ContentLength: int64(len(message)),
+		Close:         true,
+	}
+}
+
 // scanETag determines if a syntactically valid ETag is present at s. If so,
 // the ETag and remaining text after consuming ETag is returned. Otherwise,
 // it returns "", "".
@@ -298,6 +921,18 @@ func checkIfNoneMatch(req *http.Request, header http.Header, attrs *storage.Obje
 	return condTrue
 }
 
+// isExistenceProbe reports whether the request has If-None-Match: *.
+// The clients use it to check the existence of the object without the body,
+// so the 304 response keeps Last-Modified along with ETag and the generations.
+func isExistenceProbe(req *http.Request) bool {
+	for _, etag := range scanETagList(req.Header.Get("If-None-Match")) {
+		if etag == "*" {
+			return true
+		}
+	}
+	return false
+}
+
 func checkIfModifiedSince(req *http.Request, header http.Header, attrs *storage.ObjectAttrs) condResult {
 	ius := req.Header.Get("If-Modified-Since")
 	if ius == "" || attrs.Updated.IsZero() {
@@ -317,36 +952,90 @@ func checkIfModifiedSince(req *http.Request, header http.Header, attrs *storage.
 	return condTrue
 }
 
+// checkIfRange evaluates the If-Range header, either an ETag or a date.
+// The ETag is compared by the strong comparison, and the date must be exactly Last-Modified.
+func checkIfRange(req *http.Request, header http.Header, attrs *storage.ObjectAttrs) condResult {
+	ir := req.Header.Get("If-Range")
+	if ir == "" {
+		return condNone
+	}
+	etag, _ := scanETag(ir)
+	if etag != "" {
+		if etagStrongMatch(etag, header.Get("Etag")) {
+			return condTrue
+		}
+		return condFalse
+	}
+	// RFC 9110 section 13.1.5: the date must be an exact match of Last-Modified.
+	if attrs.Updated.IsZero() {
+		return condFalse
+	}
+	t, err := http.ParseTime(ir)
+	if err != nil {
+		return condFalse
+	}
+	if t.Unix() == attrs.Updated.Unix() {
+		return condTrue
+	}
+	return condFalse
+}
+
+// checkIfGenerationNotMatch evaluates the x-goog-if-generation-not-match header of Google Cloud Storage.
+func checkIfGenerationNotMatch(req *http.Request, header http.Header, attrs *storage.ObjectAttrs) condResult {
+	v := req.Header.Get("x-goog-if-generation-not-match")
+	if v == "" {
+		return condNone
+	}
+	gen, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return condNone
+	}
+	if gen == attrs.Generation {
+		return condFalse
+	}
+	return condTrue
+}
+
 // checkPreconditions handles conditional requests, and return nil if the condition is satisfied.
 // if it's not, return non nil response.
+//
+// The evaluation order follows RFC 9110 section 13.2.2, the same as http.ServeContent:
+//
+//  1. If-Match, or If-Unmodified-Since if If-Match is absent. 412 Precondition Failed if it is false.
+//  2. If-None-Match, or If-Modified-Since if If-None-Match is absent. 304 Not Modified if it is false.
+//  3. x-goog-if-generation-not-match. 304 Not Modified if it is false.
+//
+// After them, requestRange evaluates If-Range, and ignores the Range header if it is false.
+//
+// Invalid dates are ignored.
+// The 304 response to If-None-Match: * keeps Last-Modified, so that existence probes can learn the version.
+// Self-contradictory combinations are evaluated in the same order, unless WithStrictConditionals is given.
 func checkPreconditions(req *http.Request, header http.Header, attrs *storage.ObjectAttrs) *http.Response {
 	ch := checkIfMatch(req, header, attrs)
 	if ch == condNone {
 		ch = checkIfUnmodifiedSince(req, header, attrs)
 	}
 	if ch == condFalse {
+		pruneHeader(header, prunePreconditionFailed)
 		return &http.Response{
-			Status:     "412 Precondition Failed",
-			StatusCode: http.StatusPreconditionFailed,
-			Proto:      "HTTP/1.0",
-			ProtoMajor: 1,
-			ProtoMinor: 0,
-			Header:     header,
-			Body:       http.NoBody,
-			Close:      true,
+			Status:        "412 Precondition Failed",
+			StatusCode:    http.StatusPreconditionFailed,
+			Proto:         "HTTP/1.0",
+			ProtoMajor:    1,
+			ProtoMinor:    0,
+			Header:        header,
+			Body:          http.NoBody,
+			ContentLength: 0,
+			Close:         true,
 		}
 	}
 	ch = checkIfNoneMatch(req, header, attrs)
-	if ch == condFalse || (ch == condNone && checkIfModifiedSince(req, header, attrs) == condFalse) {
-		// RFC 7232 section 4.1:
-		// a sender SHOULD NOT generate representation metadata other than the
-		// above listed fields unless said metadata exists for the purpose of
-		// guiding cache updates (e.g., Last-Modified might be useful if the
-		// response does not have an ETag field).
-		header.Del("Content-Type")
-		header.Del("Content-Length")
-		if header.Get("Etag") != "" {
-			header.Del("Last-Modified")
+	if ch == condFalse || (ch == condNone && checkIfModifiedSince(req, header, attrs) == condFalse) ||
+		checkIfGenerationNotMatch(req, header, attrs) == condFalse {
+		if isExistenceProbe(req) {
+			pruneHeader(header, pruneExistenceProbe)
+		} else {
+			pruneHeader(header, pruneNotModified)
 		}
 		return &http.Response{
 			Status:     "304 Not Modified",
@@ -362,62 +1051,219 @@ func checkPreconditions(req *http.Request, header http.Header, attrs *storage.Ob
 	return nil
 }
 
+// responseHeader returns the header of the response for the object.
+func (c *config) responseHeader(req *http.Request, attrs *storage.ObjectAttrs) http.Header {
+	header := makeHeader(attrs)
+	c.overrideCacheControl(req, header, attrs)
+	c.truncateMetadata(header, attrs)
+	if c.combinedHashHeader {
+		if values := header.Values("x-goog-hash"); len(values) > 0 {
+			header.Set("x-goog-hash", strings.Join(values, ","))
+		}
+	}
+	return header
+}
+
+// immutableCacheControl is the Cache-Control for the requests that pin a specific generation.
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// overrideCacheControl overrides the Cache-Control header if the request pins a specific generation,
+// and attrs is the generation pinned.
+// The target of a symlink is served in its live generation even if the symlink is pinned,
+// so the Cache-Control of the target is left as it is.
+func (c *config) overrideCacheControl(req *http.Request, header http.Header, attrs *storage.ObjectAttrs) {
+	if !c.immutableCacheControl || req.URL.Fragment == "" {
+		return
+	}
+	if gen, err := urlGeneration(req.URL); err != nil || gen != attrs.Generation || attrs.Name != objectName(req.URL) {
+		return
+	}
+	if !c.forceImmutableCacheControl && hasPrivateDirective(header.Get("Cache-Control")) {
+		return
+	}
+	header.Set("Cache-Control", immutableCacheControl)
+}
+
+// hasPrivateDirective reports whether the Cache-Control has the private or no-store directive.
+func hasPrivateDirective(cacheControl string) bool {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, _, _ := strings.Cut(directive, "=")
+		name = strings.ToLower(textproto.TrimString(name))
+		if name == "private" || name == "no-store" {
+			return true
+		}
+	}
+	return false
+}
+
+// truncateMetadata drops the largest x-goog-meta-* headers until their total size fits in the limit.
+func (c *config) truncateMetadata(header http.Header, attrs *storage.ObjectAttrs) {
+	limit := c.maxMetadataBytes
+	if limit == 0 {
+		limit = defaultMaxMetadataBytes
+	}
+	if limit < 0 {
+		return
+	}
+
+	type entry struct {
+		key  string
+		size int
+	}
+	entries := make([]entry, 0, len(attrs.Metadata))
+	total := 0
+	for key, value := range attrs.Metadata {
+		size := len("x-goog-meta-") + len(key) + len(value)
+		entries = append(entries, entry{key: key, size: size})
+		total += size
+	}
+	if total <= limit {
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].size != entries[j].size {
+			return entries[i].size > entries[j].size
+		}
+		return entries[i].key < entries[j].key
+	})
+	for _, e := range entries {
+		if total <= limit {
+			break
+		}
+		header.Del("x-goog-meta-" + e.key)
+		total -= e.size
+	}
+	header.Set("x-goog-meta-truncated", "true")
+}
+
 func makeHeader(attrs *storage.ObjectAttrs) http.Header {
+	// makeHeader is called for every request, so it takes care of allocations.
+	// The keys are canonical to skip canonicalization,
+	// and the values share one backing array.
+	h := headerBuilder{
+		header: make(http.Header, 16+len(attrs.Metadata)),
+		values: make([]string, 0, 16+len(attrs.Metadata)),
+	}
+
 	// common http headers
-	header := make(http.Header)
 	if v := attrs.ContentType; v != "" {
-		header.Set("Content-Type", v)
+		h.set("Content-Type", v)
 	}
 	if v := attrs.ContentLanguage; v != "" {
-		header.Set("Content-Language", v)
+		h.set("Content-Language", v)
 	}
 	if v := attrs.CacheControl; v != "" {
-		header.Set("Cache-Control", v)
+		h.set("Cache-Control", v)
 	}
+	var size string
 	if v := attrs.Size; v != 0 {
-		header.Set("Content-Length", strconv.FormatInt(v, 10))
+		size = strconv.FormatInt(v, 10)
+		h.set("Content-Length", size)
 	}
 	if v := attrs.ContentEncoding; v != "" {
-		header.Set("Content-Encoding", v)
+		h.set("Content-Encoding", v)
 	}
 	if v := attrs.ContentDisposition; v != "" {
-		header.Set("Content-Disposition", v)
+		h.set("Content-Disposition", v)
 	}
 	if v := attrs.Updated; !v.IsZero() {
-		header.Set("Last-Modified", v.Format(http.TimeFormat))
+		h.set("Last-Modified", v.Format(http.TimeFormat))
 	}
 
 	// hash
+	h.setHashValues(attrs)
 	if v := attrs.MD5; len(v) > 0 {
-		header.Add("x-goog-hash", "md5="+base64.StdEncoding.EncodeToString(v))
-
 		// attrs has Etag attribute, but it is invalid form e.g. `CPi68c7s4ugCEAM=`
 		// ETag should be quoted like `"<etag_value>"`.
 		// So we generate ETag from MD5.
-		header.Set("ETag", `"`+hex.EncodeToString(v)+`"`)
+		h.set("Etag", formatETag(v))
+	}
+
+	// customer-supplied encryption key
+	if v := attrs.CustomerKeySHA256; v != "" {
+		h.set("X-Goog-Encryption-Algorithm", "AES256")
+		h.set("X-Goog-Encryption-Key-Sha256", v)
 	}
-	var crc32 [4]byte
-	binary.BigEndian.PutUint32(crc32[:], attrs.CRC32C)
-	header.Add("x-goog-hash", "crc32c="+base64.StdEncoding.EncodeToString(crc32[:]))
 
 	// custom headers by google
 	if v := attrs.Generation; v != 0 {
-		header.Set("x-goog-generation", strconv.FormatInt(v, 10))
+		h.set("X-Goog-Generation", strconv.FormatInt(v, 10))
 	}
 	if v := attrs.Metageneration; v != 0 {
-		header.Set("x-goog-metageneration", strconv.FormatInt(v, 10))
+		h.set("X-Goog-Metageneration", strconv.FormatInt(v, 10))
 	}
 	for key, value := range attrs.Metadata {
-		header.Set("x-goog-meta-"+key, value)
+		h.set(http.CanonicalHeaderKey("x-goog-meta-"+key), value)
 	}
-	if v := attrs.Size; v != 0 {
-		header.Set("x-goog-stored-content-length", strconv.FormatInt(v, 10))
+	if size != "" {
+		h.set("X-Goog-Stored-Content-Length", size)
 	}
 	if v := attrs.ContentEncoding; v != "" {
-		header.Set("x-goog-stored-content-encoding", v)
+		h.set("X-Goog-Stored-Content-Encoding", v)
 	}
 	if v := attrs.StorageClass; v != "" {
-		header.Set("x-goog-storage-class", v)
+		h.set("X-Goog-Storage-Class", v)
 	}
-	return header
+	return h.header
+}
+
+// headerBuilder builds a header whose values share one backing array.
+type headerBuilder struct {
+	header http.Header
+	values []string
+}
+
+// set sets the value of the canonical key.
+// The value slice is capped, so that appending to it doesn't overwrite the others.
+func (h *headerBuilder) set(key, value string) {
+	n := len(h.values)
+	h.values = append(h.values, value)
+	h.header[key] = h.values[n : n+1 : n+1]
+}
+
+// setHashValues sets the x-goog-hash header.
+// The order is stable: crc32c first, and then md5 if the object has it,
+// the same as the XML API of Google Cloud Storage.
+// Composite objects have no md5.
+func (h *headerBuilder) setHashValues(attrs *storage.ObjectAttrs) {
+	n := len(h.values)
+	h.values = appendHashValues(h.values, attrs)
+	h.header["X-Goog-Hash"] = h.values[n:len(h.values):len(h.values)]
+}
+
+// appendHashValues appends the values of the x-goog-hash header to dst.
+// See setHashValues for the order.
+func appendHashValues(dst []string, attrs *storage.ObjectAttrs) []string {
+	// the base64 encoding of a 4-byte crc32c and a 16-byte md5 are 8 and 24 bytes.
+	var crc32 [4]byte
+	binary.BigEndian.PutUint32(crc32[:], attrs.CRC32C)
+	var buf [len("crc32c=") + 8]byte
+	copy(buf[:], "crc32c=")
+	base64.StdEncoding.Encode(buf[len("crc32c="):], crc32[:])
+	dst = append(dst, string(buf[:]))
+
+	if v := attrs.MD5; len(v) > 0 {
+		var buf [len("md5=") + 24]byte
+		if base64.StdEncoding.EncodedLen(len(v)) == 24 {
+			copy(buf[:], "md5=")
+			base64.StdEncoding.Encode(buf[len("md5="):], v)
+			dst = append(dst, string(buf[:]))
+		} else {
+			dst = append(dst, "md5="+base64.StdEncoding.EncodeToString(v))
+		}
+	}
+	return dst
+}
+
+// formatETag returns the ETag of the object whose MD5 hash is md5, e.g. `"0b46f306e92d88515e06d48a62dcc319"`.
+func formatETag(md5 []byte) string {
+	if len(md5) != 16 {
+		return `"` + hex.EncodeToString(md5) + `"`
+	}
+	var buf [34]byte
+	buf[0] = '"'
+	hex.Encode(buf[1:33], md5)
+	buf[33] = '"'
+	return string(buf[:])
 }
@@ -2,11 +2,14 @@ package gsprotocol
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -126,12 +129,15 @@ func TestRoundTrip(t *testing.T) {
 		}
 	}
 	hash := resp.Header["X-Goog-Hash"]
-	if hash[0] != "md5=C0bzBuktiFFeBtSKYtzDGQ==" {
-		t.Errorf("invalid md5: %s", hash[0])
+	if len(hash) != 2 {
+		t.Fatalf("unexpected x-goog-hash: %v", hash)
 	}
-	if hash[1] != "crc32c=f3Yv4g==" {
+	if hash[0] != "crc32c=f3Yv4g==" {
 		t.Errorf("invalid crc32c: %s", hash[0])
 	}
+	if hash[1] != "md5=C0bzBuktiFFeBtSKYtzDGQ==" {
+		t.Errorf("invalid md5: %s", hash[1])
+	}
 
 	if resp.ContentLength != int64(len(content)) {
 		t.Errorf("unexpected Content-Length: want %d, got %d", len(content), resp.ContentLength)
@@ -466,6 +472,98 @@ func TestRoundTrip_IfNoneMatch(t *testing.T) {
 	})
 }
 
+func TestRoundTrip_IfNoneMatchWildcard(t *testing.T) {
+	object := &objectHandleMock{
+		attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+			return &storage.ObjectAttrs{
+				ContentType:    "text/plain",
+				Size:           27,
+				MD5:            []byte{0x0b, 0x46, 0xf3, 0x06, 0xe9, 0x2d, 0x88, 0x51, 0x5e, 0x06, 0xd4, 0x8a, 0x62, 0xdc, 0xc3, 0x19},
+				CRC32C:         0x7f762fe2,
+				Updated:        time.Date(2020, time.April, 18, 12, 34, 56, 0, time.UTC),
+				Generation:     1587160158394554,
+				Metageneration: 3,
+			}, nil
+		},
+		newReaderFunc: func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+			t.Error("the object must not be read")
+			return storage.ReaderObjectAttrs{}, nil, fmt.Errorf("unexpected read")
+		},
+		generationFunc: func(mock *objectHandleMock, gen int64) *objectHandleMock {
+			return mock
+		},
+	}
+	bucket := &bucketHandleMock{
+		objectFunc: func(mock *bucketHandleMock, name string) *objectHandleMock {
+			if name == "object-key" {
+				return object
+			}
+			return objectMockNotFound
+		},
+	}
+	mock := &storageClientMock{
+		bucketFunc: func(mock *storageClientMock, name string) *bucketHandleMock {
+			if name == "bucket-name" {
+				return bucket
+			}
+			return bucketMockNotFount
+		},
+	}
+
+	tr := &http.Transport{}
+	tr.RegisterProtocol("gs", &Transport{client: mock})
+	c := &http.Client{Transport: tr}
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		method := method
+		t.Run(method+" existing", func(t *testing.T) {
+			req, err := http.NewRequest(method, "gs://bucket-name/object-key", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("If-None-Match", "*")
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusNotModified {
+				t.Errorf("unexpected status: want %d, got %d", http.StatusNotModified, resp.StatusCode)
+			}
+			want := map[string]string{
+				"Etag":                  `"0b46f306e92d88515e06d48a62dcc319"`,
+				"Last-Modified":         "Sat, 18 Apr 2020 12:34:56 GMT",
+				"X-Goog-Generation":     "1587160158394554",
+				"X-Goog-Metageneration": "3",
+				"Content-Type":          "",
+			}
+			for key, value := range want {
+				if got := resp.Header.Get(key); got != value {
+					t.Errorf("%s: want %q, got %q", key, value, got)
+				}
+			}
+		})
+
+		t.Run(method+" missing", func(t *testing.T) {
+			req, err := http.NewRequest(method, "gs://bucket-name/missing-key", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("If-None-Match", "*")
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusNotFound {
+				t.Errorf("unexpected status: want %d, got %d", http.StatusNotFound, resp.StatusCode)
+			}
+		})
+	}
+}
+
 func TestRoundTrip_IfModifiedSince(t *testing.T) {
 	const content = "Hello Google Cloud Storage!"
 	object := &objectHandleMock{
@@ -750,3 +848,479 @@ func TestRoundTrip_Error(t *testing.T) {
 		t.Errorf("unexpected status: want %d, got %d", http.StatusNotFound, resp.StatusCode)
 	}
 }
+
+func TestRoundTrip_ImmutableCacheControl(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/public": {
+			attrs: &storage.ObjectAttrs{
+				CacheControl: "public, max-age=60",
+				Generation:   1234567890,
+				MD5:          []byte{0x0b, 0x46, 0xf3, 0x06, 0xe9, 0x2d, 0x88, 0x51, 0x5e, 0x06, 0xd4, 0x8a, 0x62, 0xdc, 0xc3, 0x19},
+			},
+			content: "Hello Google Cloud Storage!",
+		},
+		"bucket-name/private": {
+			attrs: &storage.ObjectAttrs{
+				CacheControl: "private, max-age=60",
+				Generation:   1234567890,
+			},
+			content: "Hello Google Cloud Storage!",
+		},
+	})
+
+	tc := []struct {
+		name    string
+		force   bool
+		method  string
+		url     string
+		header  http.Header
+		status  int
+		control string
+	}{
+		{"unpinned", false, http.MethodGet, "gs://bucket-name/public", nil, http.StatusOK, "public, max-age=60"},
+		{"pinned", false, http.MethodGet, "gs://bucket-name/public#1234567890", nil, http.StatusOK, immutableCacheControl},
+		{"pinned HEAD", false, http.MethodHead, "gs://bucket-name/public#1234567890", nil, http.StatusOK, immutableCacheControl},
+		{
+			"not modified", false, http.MethodGet, "gs://bucket-name/public#1234567890",
+			http.Header{"If-None-Match": []string{`"0b46f306e92d88515e06d48a62dcc319"`}},
+			http.StatusNotModified, immutableCacheControl,
+		},
+		{"private", false, http.MethodGet, "gs://bucket-name/private#1234567890", nil, http.StatusOK, "private, max-age=60"},
+		{"force", true, http.MethodGet, "gs://bucket-name/private#1234567890", nil, http.StatusOK, immutableCacheControl},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &http.Transport{}
+			tr.RegisterProtocol("gs", &Transport{client: mock, config: newConfig([]Option{WithImmutableCacheControl(tt.force)})})
+			c := &http.Client{Transport: tr}
+
+			req, err := http.NewRequest(tt.method, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, values := range tt.header {
+				req.Header[key] = values
+			}
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("unexpected status: want %d, got %d", tt.status, resp.StatusCode)
+			}
+			if got := resp.Header.Get("Cache-Control"); got != tt.control {
+				t.Errorf("unexpected Cache-Control: want %q, got %q", tt.control, got)
+			}
+		})
+	}
+}
+
+func TestRoundTrip_TruncateMetadata(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs: &storage.ObjectAttrs{
+				Generation: 1234567890,
+				Metadata: map[string]string{
+					"small": "foo",
+					"large": strings.Repeat("x", 10<<10),
+				},
+			},
+			content: "Hello Google Cloud Storage!",
+		},
+	})
+
+	tc := []struct {
+		name      string
+		opts      []Option
+		small     string
+		large     string
+		truncated string
+	}{
+		{"default", nil, "foo", "", "true"},
+		{"enough limit", []Option{WithMaxMetadataHeaderBytes(16 << 10)}, "foo", strings.Repeat("x", 10<<10), ""},
+		{"no limit", []Option{WithMaxMetadataHeaderBytes(0)}, "foo", strings.Repeat("x", 10<<10), ""},
+		{"tiny limit", []Option{WithMaxMetadataHeaderBytes(1)}, "", "", "true"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &http.Transport{}
+			tr.RegisterProtocol("gs", &Transport{client: mock, config: newConfig(tt.opts)})
+			c := &http.Client{Transport: tr}
+
+			resp, err := c.Head("gs://bucket-name/object-key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if got := resp.Header.Get("x-goog-meta-small"); got != tt.small {
+				t.Errorf("unexpected x-goog-meta-small: want %q, got %q", tt.small, got)
+			}
+			if got := resp.Header.Get("x-goog-meta-large"); got != tt.large {
+				t.Errorf("unexpected x-goog-meta-large: want %d bytes, got %d bytes", len(tt.large), len(got))
+			}
+			if got := resp.Header.Get("x-goog-meta-truncated"); got != tt.truncated {
+				t.Errorf("unexpected x-goog-meta-truncated: want %q, got %q", tt.truncated, got)
+			}
+		})
+	}
+}
+
+func TestRoundTrip_NotFoundKind(t *testing.T) {
+	tc := []struct {
+		name      string
+		attrErr   error
+		readerErr error
+		want      string
+	}{
+		{"object from Attrs", storage.ErrObjectNotExist, nil, "object-not-found"},
+		{"bucket from Attrs", storage.ErrBucketNotExist, nil, "bucket-not-found"},
+		{"wrapped object from Attrs", fmt.Errorf("wrapped: %w", storage.ErrObjectNotExist), nil, "object-not-found"},
+		{"wrapped bucket from Attrs", fmt.Errorf("wrapped: %w", storage.ErrBucketNotExist), nil, "bucket-not-found"},
+		{"object from NewReader", nil, storage.ErrObjectNotExist, "object-not-found"},
+		{"bucket from NewReader", nil, storage.ErrBucketNotExist, "bucket-not-found"},
+		{"wrapped object from NewReader", nil, fmt.Errorf("wrapped: %w", storage.ErrObjectNotExist), "object-not-found"},
+		{"wrapped bucket from NewReader", nil, fmt.Errorf("wrapped: %w", storage.ErrBucketNotExist), "bucket-not-found"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			object := &objectHandleMock{
+				attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+					if tt.attrErr != nil {
+						return nil, tt.attrErr
+					}
+					return &storage.ObjectAttrs{Generation: 1234567890}, nil
+				},
+				newReaderFunc: func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+					return storage.ReaderObjectAttrs{}, nil, tt.readerErr
+				},
+				generationFunc: func(mock *objectHandleMock, gen int64) *objectHandleMock {
+					return mock
+				},
+			}
+			mock := &storageClientMock{
+				bucketFunc: func(mock *storageClientMock, name string) *bucketHandleMock {
+					return &bucketHandleMock{
+						objectFunc: func(mock *bucketHandleMock, name string) *objectHandleMock {
+							return object
+						},
+					}
+				},
+			}
+
+			tr := &http.Transport{}
+			tr.RegisterProtocol("gs", &Transport{client: mock})
+			c := &http.Client{Transport: tr}
+
+			resp, err := c.Get("gs://bucket-name/object-key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusNotFound {
+				t.Errorf("unexpected status: want %d, got %d", http.StatusNotFound, resp.StatusCode)
+			}
+			if got := resp.Header.Get("x-gsprotocol-error"); got != tt.want {
+				t.Errorf("unexpected x-gsprotocol-error: want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRoundTrip_HashHeader(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs: &storage.ObjectAttrs{
+				Generation: 1234567890,
+				MD5:        []byte{0x0b, 0x46, 0xf3, 0x06, 0xe9, 0x2d, 0x88, 0x51, 0x5e, 0x06, 0xd4, 0x8a, 0x62, 0xdc, 0xc3, 0x19},
+				CRC32C:     0x7f762fe2,
+			},
+			content: "Hello Google Cloud Storage!",
+		},
+		"bucket-name/composite": {
+			attrs: &storage.ObjectAttrs{
+				Generation: 1234567890,
+				CRC32C:     0x7f762fe2,
+			},
+			content: "Hello Google Cloud Storage!",
+		},
+	})
+
+	tc := []struct {
+		name   string
+		opts   []Option
+		object string
+		want   []string
+	}{
+		{"default", nil, "object-key", []string{"crc32c=f3Yv4g==", "md5=C0bzBuktiFFeBtSKYtzDGQ=="}},
+		{"combined", []Option{WithCombinedHashHeader()}, "object-key", []string{"crc32c=f3Yv4g==,md5=C0bzBuktiFFeBtSKYtzDGQ=="}},
+		{"default without md5", nil, "composite", []string{"crc32c=f3Yv4g=="}},
+		{"combined without md5", []Option{WithCombinedHashHeader()}, "composite", []string{"crc32c=f3Yv4g=="}},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &http.Transport{}
+			tr.RegisterProtocol("gs", &Transport{client: mock, config: newConfig(tt.opts)})
+			c := &http.Client{Transport: tr}
+
+			resp, err := c.Head("gs://bucket-name/" + tt.object)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if got := resp.Header.Values("x-goog-hash"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unexpected x-goog-hash: want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRoundTrip_UnsupportedScheme(t *testing.T) {
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"example.com/object-key": {
+			attrs:   &storage.ObjectAttrs{Generation: 1234567890},
+			content: "Hello Google Cloud Storage!",
+		},
+	})
+
+	// the Transport is used as the Transport of http.Client by mistake.
+	c := &http.Client{Transport: &Transport{client: mock}}
+	resp, err := c.Get("https://example.com/object-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `unsupported scheme "https"`) {
+		t.Errorf("unexpected body: %q", string(body))
+	}
+
+	// gcs:// is also served.
+	resp, err = c.Get("gcs://example.com/object-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	// the schemes are configurable.
+	c = &http.Client{Transport: &Transport{client: mock, config: newConfig([]Option{WithSchemes("gs", "artifact")})}}
+	for _, u := range []string{"gs://example.com/object-key", "artifact://example.com/object-key", "gcs://example.com/object-key"} {
+		resp, err = c.Get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		want := http.StatusOK
+		if strings.HasPrefix(u, "gcs:") {
+			want = http.StatusBadRequest
+		}
+		if resp.StatusCode != want {
+			t.Errorf("%s: unexpected status: want %d, got %d", u, want, resp.StatusCode)
+		}
+	}
+
+	// intercept the requests to any scheme.
+	c = &http.Client{Transport: &Transport{client: mock, config: newConfig([]Option{WithAnyScheme()})}}
+	resp, err = c.Get("https://example.com/object-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestRoundTrip_LargeObject(t *testing.T) {
+	const size = 5 << 30 // larger than 4 GiB
+	mock := &storageClientMock{
+		bucketFunc: func(mock *storageClientMock, name string) *bucketHandleMock {
+			return &bucketHandleMock{
+				objectFunc: func(mock *bucketHandleMock, name string) *objectHandleMock {
+					return &objectHandleMock{
+						attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+							return &storage.ObjectAttrs{Bucket: "bucket-name", Name: "object-key", Generation: 1, Size: size}, nil
+						},
+						generationFunc: func(mock *objectHandleMock, gen int64) *objectHandleMock {
+							return mock
+						},
+					}
+				},
+			}
+		},
+	}
+	c := &http.Client{Transport: &Transport{client: mock}}
+	resp, err := c.Head("gs://bucket-name/object-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.ContentLength != size {
+		t.Errorf("unexpected ContentLength: want %d, got %d", int64(size), resp.ContentLength)
+	}
+	for _, key := range []string{"Content-Length", "x-goog-stored-content-length"} {
+		if got := resp.Header.Get(key); got != "5368709120" {
+			t.Errorf("unexpected %s: want %q, got %q", key, "5368709120", got)
+		}
+	}
+}
+
+func TestRoundTrip_SharedAttrs(t *testing.T) {
+	const n = 100
+	var mu sync.Mutex
+	var calls int
+	var release chan struct{}
+	object := newObjectHandleMock("bucket-name", "object-key", singleRequestTestObjects["bucket-name/object-key"])
+	attrFunc := object.attrFunc
+	object.attrFunc = func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		<-release
+		return attrFunc(ctx, mock)
+	}
+	missing := &objectHandleMock{
+		attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+			mu.Lock()
+			calls++
+			mu.Unlock()
+			<-release
+			return nil, storage.ErrObjectNotExist
+		},
+	}
+	tr := &Transport{
+		client: &storageClientMock{
+			bucketFunc: func(mock *storageClientMock, name string) *bucketHandleMock {
+				return &bucketHandleMock{
+					objectFunc: func(mock *bucketHandleMock, name string) *objectHandleMock {
+						if name == "missing-key" {
+							return missing
+						}
+						return object
+					},
+				}
+			},
+		},
+	}
+
+	for _, tt := range []struct {
+		url    string
+		status int
+		body   string
+	}{
+		{"gs://bucket-name/object-key", http.StatusOK, "Hello Google Cloud Storage!"},
+		{"gs://bucket-name/missing-key", http.StatusNotFound, ""},
+	} {
+		calls = 0
+		release = make(chan struct{})
+		var started, wg sync.WaitGroup
+		started.Add(n)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				started.Done()
+				resp, err := tr.RoundTrip(req)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				defer resp.Body.Close()
+				body, err := io.ReadAll(resp.Body)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if resp.StatusCode != tt.status {
+					t.Errorf("unexpected status: want %d, got %d", tt.status, resp.StatusCode)
+				}
+				if string(body) != tt.body {
+					t.Errorf("unexpected body: want %q, got %q", tt.body, body)
+				}
+			}()
+		}
+		started.Wait()
+		// wait for the requests to join the shared call.
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		if calls != 1 {
+			t.Errorf("%s: want 1 call of Attrs, got %d", tt.url, calls)
+		}
+	}
+}
+
+func TestRoundTrip_SharedAttrsUserProjects(t *testing.T) {
+	var mu sync.Mutex
+	projects := make(map[string]int)
+	release := make(chan struct{})
+	tr := &Transport{
+		client: &storageClientMock{
+			bucketFunc: func(mock *storageClientMock, name string) *bucketHandleMock {
+				return &bucketHandleMock{
+					objectFunc: func(mock *bucketHandleMock, name string) *objectHandleMock {
+						object := newObjectHandleMock("bucket-name", "object-key", singleRequestTestObjects["bucket-name/object-key"])
+						attrFunc := object.attrFunc
+						project := mock.userProject
+						object.attrFunc = func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+							mu.Lock()
+							projects[project]++
+							mu.Unlock()
+							<-release
+							return attrFunc(ctx, mock)
+						}
+						return object
+					},
+				}
+			},
+		},
+	}
+
+	var wg sync.WaitGroup
+	for _, project := range []string{"project-a", "project-a", "project-b"} {
+		req, err := http.NewRequest(http.MethodHead, "gs://bucket-name/object-key", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Goog-User-Project", project)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	// wait for the requests to join the shared calls.
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// the requests billing different projects don't share the call.
+	if projects["project-a"] != 1 || projects["project-b"] != 1 {
+		t.Errorf("want 1 call for each project, got %v", projects)
+	}
+}
@@ -0,0 +1,70 @@
+package gsprotocol
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ParseURL splits a URL of an object, e.g. gs://[BUCKET_NAME]/[OBJECT_NAME]#[GENERATION_NUMBER],
+// into the bucket name, the object name and the generation, in the same way as the Transport does.
+// The object name is the percent-decoded path without the leading slash, and the other slashes are kept as is.
+// The generation is taken from the fragment or the generation query parameter, and zero means the live object.
+// The scheme and the other query parameters are not checked.
+func ParseURL(rawurl string) (bucket, object string, generation int64, err error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", "", 0, err
+	}
+	if u.Host == "" {
+		return "", "", 0, errors.New("gsprotocol: the bucket name is empty, the URL must be gs://[BUCKET_NAME]/[OBJECT_NAME]")
+	}
+	generation, err = urlGeneration(u)
+	if err != nil {
+		return "", "", 0, err
+	}
+	return u.Host, objectName(u), generation, nil
+}
+
+// FormatURL returns the gs:// URL of the object, which ParseURL parses back.
+// Zero generation means the live object.
+func FormatURL(bucket, object string, generation int64) string {
+	u := &url.URL{
+		Scheme: "gs",
+		Host:   bucket,
+		Path:   "/" + object,
+	}
+	if generation != 0 {
+		u.Fragment = strconv.FormatInt(generation, 10)
+	}
+	return u.String()
+}
+
+// objectName returns the object name of u.
+func objectName(u *url.URL) string {
+	return strings.TrimPrefix(u.Path, "/")
+}
+
+// urlGeneration returns the generation that u selects by the fragment or the generation query parameter,
+// or zero for the live object.
+func urlGeneration(u *url.URL) (int64, error) {
+	u, err := moveGenerationQuery(u)
+	if err != nil {
+		return 0, err
+	}
+	if u.Fragment == "" {
+		return 0, nil
+	}
+	return parseGeneration(u.Fragment)
+}
+
+// parseGeneration parses the generation number s.
+func parseGeneration(s string) (int64, error) {
+	gen, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("gsprotocol: invalid generation %s: %v", s, err)
+	}
+	return gen, nil
+}
@@ -0,0 +1,79 @@
+package gsprotocol
+
+import "testing"
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		rawurl     string
+		bucket     string
+		object     string
+		generation int64
+		err        bool
+	}{
+		{rawurl: "gs://bucket-name/object-key", bucket: "bucket-name", object: "object-key"},
+		{rawurl: "gs://bucket-name/a/b//c", bucket: "bucket-name", object: "a/b//c"},
+		{rawurl: "gs://bucket-name/some/prefix/", bucket: "bucket-name", object: "some/prefix/"},
+		{rawurl: "gs://bucket-name//leading-slash", bucket: "bucket-name", object: "/leading-slash"},
+		{rawurl: "gs://bucket-name/a%2Fb", bucket: "bucket-name", object: "a/b"},
+		{rawurl: "gs://bucket-name/2020%23Q1.csv", bucket: "bucket-name", object: "2020#Q1.csv"},
+		{rawurl: "gs://bucket-name/object-key#1587160158394554", bucket: "bucket-name", object: "object-key", generation: 1587160158394554},
+		{rawurl: "gs://bucket-name/object-key?generation=1587160158394554", bucket: "bucket-name", object: "object-key", generation: 1587160158394554},
+		{rawurl: "gs://bucket-name/object-key?generation=1587160158394554#1587160158394554", bucket: "bucket-name", object: "object-key", generation: 1587160158394554},
+		{rawurl: "gs://bucket-name", bucket: "bucket-name", object: ""},
+		{rawurl: "gs://bucket-name/object-key#foo", err: true},
+		{rawurl: "gs://bucket-name/object-key?generation=1#2", err: true},
+		{rawurl: "gs:///object-key", err: true},
+		{rawurl: "gs://bucket-name/%zz", err: true},
+	}
+	for _, tt := range tests {
+		bucket, object, generation, err := ParseURL(tt.rawurl)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: want an error, got nil", tt.rawurl)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.rawurl, err)
+			continue
+		}
+		if bucket != tt.bucket || object != tt.object || generation != tt.generation {
+			t.Errorf("%s: want (%q, %q, %d), got (%q, %q, %d)", tt.rawurl, tt.bucket, tt.object, tt.generation, bucket, object, generation)
+		}
+	}
+}
+
+func TestFormatURL(t *testing.T) {
+	tests := []struct {
+		bucket     string
+		object     string
+		generation int64
+		want       string
+	}{
+		{"bucket-name", "object-key", 0, "gs://bucket-name/object-key"},
+		{"bucket-name", "object-key", 1587160158394554, "gs://bucket-name/object-key#1587160158394554"},
+		{"bucket-name", "a/b//c", 0, "gs://bucket-name/a/b//c"},
+		{"bucket-name", "some/prefix/", 0, "gs://bucket-name/some/prefix/"},
+		{"bucket-name", "/leading-slash", 0, "gs://bucket-name//leading-slash"},
+		{"bucket-name", "a%2Fb", 0, "gs://bucket-name/a%252Fb"},
+		{"bucket-name", "2020#Q1.csv", 0, "gs://bucket-name/2020%23Q1.csv"},
+		{"bucket-name", "what?.txt", 0, "gs://bucket-name/what%3F.txt"},
+		{"bucket-name", "with space.txt", 0, "gs://bucket-name/with%20space.txt"},
+	}
+	for _, tt := range tests {
+		got := FormatURL(tt.bucket, tt.object, tt.generation)
+		if got != tt.want {
+			t.Errorf("FormatURL(%q, %q, %d): want %q, got %q", tt.bucket, tt.object, tt.generation, tt.want, got)
+		}
+
+		// round trip
+		bucket, object, generation, err := ParseURL(got)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", got, err)
+			continue
+		}
+		if bucket != tt.bucket || object != tt.object || generation != tt.generation {
+			t.Errorf("%s: want (%q, %q, %d), got (%q, %q, %d)", got, tt.bucket, tt.object, tt.generation, bucket, object, generation)
+		}
+	}
+}
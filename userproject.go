@@ -0,0 +1,45 @@
+package gsprotocol
+
+import "net/http"
+
+// userProjectHeader is the header of requests that overrides the project of WithUserProject.
+const userProjectHeader = "X-Goog-User-Project"
+
+// WithUserProject makes the Transport bill project for the requests, to access requester pays buckets.
+// The x-goog-user-project header of a request overrides it.
+// By default, no project is billed and the requests to requester pays buckets fail.
+// Use it with WithBucketConfig to bill only for specific buckets.
+func WithUserProject(project string) Option {
+	return func(c *config) {
+		c.userProject = project
+	}
+}
+
+// userProjectClient bills the project for the requests of the storage client.
+type userProjectClient struct {
+	storageClient
+	project string
+}
+
+func (c *userProjectClient) unwrap() storageClient {
+	return c.storageClient
+}
+
+// userProjectedClient returns the client that bills the project of req or cfg, if any.
+func userProjectedClient(client storageClient, req *http.Request, cfg *config) storageClient {
+	project := req.Header.Get(userProjectHeader)
+	if project == "" {
+		project = cfg.userProject
+	}
+	if project == "" {
+		return client
+	}
+	return &userProjectClient{
+		storageClient: client,
+		project:       project,
+	}
+}
+
+func (c *userProjectClient) Bucket(name string) bucketHandle {
+	return c.storageClient.Bucket(name).UserProject(c.project)
+}
@@ -0,0 +1,128 @@
+package gsprotocol
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestRoundTrip_UserProject(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Option
+		header string
+		want   string
+	}{
+		{
+			name: "none",
+			want: "",
+		},
+		{
+			name: "transport option",
+			opts: []Option{WithUserProject("transport-project")},
+			want: "transport-project",
+		},
+		{
+			name:   "header",
+			header: "header-project",
+			want:   "header-project",
+		},
+		{
+			name:   "header takes precedence",
+			opts:   []Option{WithUserProject("transport-project")},
+			header: "header-project",
+			want:   "header-project",
+		},
+		{
+			name: "bucket config",
+			opts: []Option{WithBucketConfig("bucket-name", BucketConfig{WithUserProject("bucket-project")})},
+			want: "bucket-project",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			objects := newStorageClientMockWithObjects(map[string]mockObject{
+				"bucket-name/object-key": {
+					attrs:   &storage.ObjectAttrs{Generation: 1},
+					content: "Hello Google Cloud Storage!",
+				},
+			})
+			mock := &storageClientMock{
+				bucketFunc: func(mock *storageClientMock, name string) *bucketHandleMock {
+					bucket := objects.bucketFunc(mock, name)
+					objectFunc := bucket.objectFunc
+					bucket.objectFunc = func(mock *bucketHandleMock, name string) *objectHandleMock {
+						got = append(got, mock.userProject)
+						return objectFunc(mock, name)
+					}
+					return bucket
+				},
+			}
+			tr := newTestTransport(mock, tt.opts...)
+
+			req, err := http.NewRequest(http.MethodGet, "gs://bucket-name/object-key", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" {
+				req.Header.Set("X-Goog-User-Project", tt.header)
+			}
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+			}
+
+			if len(got) == 0 {
+				t.Fatal("no object is accessed")
+			}
+			for _, project := range got {
+				if project != tt.want {
+					t.Errorf("unexpected user project: want %q, got %q", tt.want, project)
+				}
+			}
+		})
+	}
+}
+
+func TestTransport_OpenUserProject(t *testing.T) {
+	var got []string
+	objects := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs:   &storage.ObjectAttrs{Generation: 1},
+			content: "Hello Google Cloud Storage!",
+		},
+	})
+	mock := &storageClientMock{
+		bucketFunc: func(mock *storageClientMock, name string) *bucketHandleMock {
+			bucket := objects.bucketFunc(mock, name)
+			objectFunc := bucket.objectFunc
+			bucket.objectFunc = func(mock *bucketHandleMock, name string) *objectHandleMock {
+				got = append(got, mock.userProject)
+				return objectFunc(mock, name)
+			}
+			return bucket
+		},
+	}
+	tr := newTestTransport(mock, WithUserProject("transport-project"))
+
+	_, body, err := tr.Open(context.Background(), "gs://bucket-name/object-key", Validators{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body.Close()
+	if len(got) == 0 {
+		t.Fatal("no object is accessed")
+	}
+	for _, project := range got {
+		if project != "transport-project" {
+			t.Errorf("unexpected user project: want %q, got %q", "transport-project", project)
+		}
+	}
+}
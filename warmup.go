@@ -0,0 +1,53 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// Warmup gets the attributes of the objects of urls, e.g. gs://[BUCKET_NAME]/[OBJECT_NAME],
+// to fetch the credentials and establish the connections before serving requests.
+// If the Transport has a GenerationCache, it is primed with the attributes.
+//
+// Warmup is safe to call concurrently with RoundTrip, e.g. from a readiness check.
+// The objects that don't exist are not errors, because they warm up the connections as well.
+// Warmup tries all of urls, and returns the errors of them, if any.
+// The Transport keeps serving requests even if Warmup fails.
+func (t *Transport) Warmup(ctx context.Context, urls ...string) error {
+	var msgs []string
+	for _, rawurl := range urls {
+		if err := t.warmup(ctx, rawurl); err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s: %v", rawurl, err))
+		}
+	}
+	if len(msgs) > 0 {
+		return errors.New("gsprotocol: failed to warm up: " + strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+func (t *Transport) warmup(ctx context.Context, rawurl string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, rawurl, nil)
+	if err != nil {
+		return err
+	}
+	if err := t.checkScheme(req.URL); err != nil {
+		return err
+	}
+
+	ctx, client, done := t.trackRequest(req)
+	defer done()
+	bucket := bucketName(req)
+	cfg := t.config.forBucket(bucket)
+	client = t.budgetedClient(client, bucket, cfg)
+	_, _, err = t.objectAttrs(ctx, client, req, cfg)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestTransport_Warmup(t *testing.T) {
+	cache := NewGenerationCache(1 << 20)
+	mock := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs:   &storage.ObjectAttrs{Generation: 1234567890},
+			content: "Hello Google Cloud Storage!",
+		},
+	})
+	tr := newTestTransport(mock, WithGenerationCache(cache))
+
+	if err := tr.Warmup(context.Background(), "gs://bucket-name/object-key", "gs://bucket-name/not-found"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.get("bucket-name", "object-key", 1234567890); !ok {
+		t.Error("the cache is not primed")
+	}
+	if len(tr.inflight) != 0 {
+		t.Errorf("want no in-flight requests, got %d", len(tr.inflight))
+	}
+}
+
+func TestTransport_WarmupError(t *testing.T) {
+	errUpstream := errors.New("could not find default credentials")
+	tr := newTestTransport(newSingleObjectMock(&objectHandleMock{
+		attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+			return nil, errUpstream
+		},
+	}))
+
+	err := tr.Warmup(context.Background(), "gs://bucket-name/a", "https://example.com/b")
+	if err == nil {
+		t.Fatal("want error")
+	}
+	for _, want := range []string{"gs://bucket-name/a: could not find default credentials", "https://example.com/b: gsprotocol: unsupported scheme"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("the error doesn't contain %q: %v", want, err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package gsprotocol
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// watchAttrsTimeout is the timeout of checking the object for long-polling requests.
+const watchAttrsTimeout = 30 * time.Second
+
+// waitForChange holds the long-polling request until the object changes or the wait elapses.
+// It returns nil response and nil error if the request should be served as usual.
+// If it sees the object changed, it also returns the attributes that it saw,
+// and forgets the cached attributes that are older than them.
+func (t *Transport) waitForChange(req *http.Request, client storageClient, cfg *config) (*storage.ObjectAttrs, *http.Response, error) {
+	v := req.URL.Query().Get("wait")
+	if v == "" || cfg.watchInterval <= 0 || req.URL.Fragment != "" {
+		return nil, nil, nil
+	}
+	if req.Header.Get("If-None-Match") == "" && req.Header.Get("x-goog-if-generation-not-match") == "" {
+		return nil, nil, nil
+	}
+	wait, err := time.ParseDuration(v)
+	if err != nil || wait < 0 {
+		return nil, newErrorResponse(http.StatusBadRequest, fmt.Sprintf("gsprotocol: invalid wait %q", v)), nil
+	}
+	if wait > cfg.watchMaxWait {
+		wait = cfg.watchMaxWait
+	}
+
+	if !t.acquireWatcher(cfg.watchMaxWatchers) {
+		resp := newErrorResponse(http.StatusTooManyRequests, "gsprotocol: too many long-polling requests")
+		resp.Header.Set("Retry-After", "1")
+		return nil, resp, nil
+	}
+	defer t.releaseWatcher()
+
+	ctx := req.Context()
+	bucket := bucketName(req)
+	object := objectName(req.URL)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	ticker := time.NewTicker(cfg.watchInterval)
+	defer ticker.Stop()
+	for {
+		attrs, err := t.watchAttrs(client, bucket, object)
+		if err != nil {
+			// serve the request as usual, including the errors.
+			return nil, nil, nil
+		}
+		if objectChanged(req, attrs) {
+			t.attrsCache.invalidate(bucket, object)
+			t.forgetHead(bucket, object)
+			// the attributes are shared with the other watchers.
+			return copyObjectAttrs(attrs), nil, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-timer.C:
+			// the wait elapsed. it will be 304 Not Modified.
+			return nil, nil, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// watchAttrs returns the attributes of the object.
+// The concurrent calls for the same object through the same storage client and user project share one call,
+// so it doesn't depend on the context of any request.
+func (t *Transport) watchAttrs(client storageClient, bucket, object string) (*storage.ObjectAttrs, error) {
+	id, project := t.clientIdentity(client)
+	key := strconv.FormatUint(id, 10) + "/" + project + "/" + bucket + "/" + object
+	v, err, _ := t.watchGroup.Do(key, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), watchAttrsTimeout)
+		defer cancel()
+		return client.Bucket(bucket).Object(object).Attrs(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*storage.ObjectAttrs), nil
+}
+
+// objectChanged reports whether the object doesn't match the validators in the request.
+func objectChanged(req *http.Request, attrs *storage.ObjectAttrs) bool {
+	if v := req.Header.Get("x-goog-if-generation-not-match"); v != "" {
+		gen, err := strconv.ParseInt(v, 10, 64)
+		if err == nil && gen != attrs.Generation {
+			return true
+		}
+	}
+	if req.Header.Get("If-None-Match") != "" {
+		return checkIfNoneMatch(req, makeHeader(attrs), attrs) != condFalse
+	}
+	return false
+}
+
+func (t *Transport) acquireWatcher(max int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if max > 0 && t.watchers >= max {
+		return false
+	}
+	t.watchers++
+	return true
+}
+
+func (t *Transport) releaseWatcher() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.watchers--
+}
@@ -0,0 +1,298 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// newWatchTestObject returns an object whose generation is *gen.
+// calls counts the calls of Attrs, which take delay.
+func newWatchTestObject(gen *int64, calls *int32, delay time.Duration) *objectHandleMock {
+	return &objectHandleMock{
+		attrFunc: func(ctx context.Context, mock *objectHandleMock) (*storage.ObjectAttrs, error) {
+			atomic.AddInt32(calls, 1)
+			time.Sleep(delay)
+			return &storage.ObjectAttrs{
+				Bucket:     "bucket-name",
+				Name:       "config.json",
+				Generation: atomic.LoadInt64(gen),
+			}, nil
+		},
+		newReaderFunc: func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+			content := "generation " + strconv.FormatInt(mock.generation, 10)
+			return storage.ReaderObjectAttrs{Generation: mock.generation}, io.NopCloser(strings.NewReader(content)), nil
+		},
+		generationFunc: withGeneration,
+	}
+}
+
+func newWatchTestRequest(t *testing.T, ctx context.Context, url string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("x-goog-if-generation-not-match", "1")
+	return req
+}
+
+func TestRoundTrip_LongPollChanged(t *testing.T) {
+	gen := int64(1)
+	var calls int32
+	tr := newTestTransport(newSingleObjectMock(newWatchTestObject(&gen, &calls, 0)), WithLongPoll(10*time.Millisecond, time.Minute, 10))
+	c := &http.Client{Transport: tr}
+
+	time.AfterFunc(50*time.Millisecond, func() {
+		atomic.StoreInt64(&gen, 2)
+	})
+	resp, err := c.Do(newWatchTestRequest(t, context.Background(), "gs://bucket-name/config.json?wait=30s"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "generation 2" {
+		t.Errorf("want %q, got %q", "generation 2", string(got))
+	}
+}
+
+func TestRoundTrip_LongPollChangedWarmCache(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Option
+	}{
+		{"attrs cache", WithAttrsCache(100, time.Minute)},
+		{"head memo", WithHeadMemo(time.Minute, 100)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gen := int64(1)
+			var calls int32
+			tr := newTestTransport(newSingleObjectMock(newWatchTestObject(&gen, &calls, 0)), WithLongPoll(10*time.Millisecond, time.Minute, 10), tt.opt)
+			c := &http.Client{Transport: tr}
+
+			// warm the cache with the generation 1.
+			resp, err := c.Head("gs://bucket-name/config.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			time.AfterFunc(50*time.Millisecond, func() {
+				atomic.StoreInt64(&gen, 2)
+			})
+			resp, err = c.Do(newWatchTestRequest(t, context.Background(), "gs://bucket-name/config.json?wait=30s"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+			}
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != "generation 2" {
+				t.Errorf("want %q, got %q", "generation 2", string(got))
+			}
+
+			// the cache doesn't serve the old generation any more.
+			resp, err = c.Head("gs://bucket-name/config.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if got := resp.Header.Get("x-goog-generation"); got != "2" {
+				t.Errorf("unexpected generation: want %q, got %q", "2", got)
+			}
+		})
+	}
+}
+
+func TestRoundTrip_LongPollNotModified(t *testing.T) {
+	gen := int64(1)
+	var calls int32
+	tr := newTestTransport(newSingleObjectMock(newWatchTestObject(&gen, &calls, 0)), WithLongPoll(10*time.Millisecond, 50*time.Millisecond, 10))
+	c := &http.Client{Transport: tr}
+
+	start := time.Now()
+	resp, err := c.Do(newWatchTestRequest(t, context.Background(), "gs://bucket-name/config.json?wait=30s"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusNotModified, resp.StatusCode)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("the request returned before the max wait: %v", d)
+	}
+}
+
+func TestRoundTrip_LongPollCancel(t *testing.T) {
+	gen := int64(1)
+	var calls int32
+	tr := newTestTransport(newSingleObjectMock(newWatchTestObject(&gen, &calls, 0)), WithLongPoll(10*time.Millisecond, time.Minute, 10))
+	c := &http.Client{Transport: tr}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := c.Do(newWatchTestRequest(t, ctx, "gs://bucket-name/config.json?wait=30s"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want context.DeadlineExceeded, got %v", err)
+	}
+	if tr.watchers != 0 {
+		t.Errorf("want no watchers, got %d", tr.watchers)
+	}
+}
+
+func TestRoundTrip_LongPollTooMany(t *testing.T) {
+	gen := int64(1)
+	var calls int32
+	tr := newTestTransport(newSingleObjectMock(newWatchTestObject(&gen, &calls, 0)), WithLongPoll(10*time.Millisecond, time.Minute, 1))
+	c := &http.Client{Transport: tr}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Do(newWatchTestRequest(t, ctx, "gs://bucket-name/config.json?wait=30s"))
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	resp, err := c.Do(newWatchTestRequest(t, context.Background(), "gs://bucket-name/config.json?wait=30s"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusTooManyRequests, resp.StatusCode)
+	}
+}
+
+func TestRoundTrip_LongPollCoalesce(t *testing.T) {
+	gen := int64(1)
+	var calls int32
+	tr := newTestTransport(newSingleObjectMock(newWatchTestObject(&gen, &calls, 5*time.Millisecond)), WithLongPoll(20*time.Millisecond, 100*time.Millisecond, 100))
+	c := &http.Client{Transport: tr}
+
+	const watchers = 10
+	var wg sync.WaitGroup
+	for i := 0; i < watchers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := c.Do(newWatchTestRequest(t, context.Background(), "gs://bucket-name/config.json?wait=30s"))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	// each watcher checks the object about 5 times, and then serves the response with one more call.
+	// without coalescing, there would be about 60 calls.
+	if n := atomic.LoadInt32(&calls); n > 2*watchers {
+		t.Errorf("the checks are not coalesced: %d calls", n)
+	}
+}
+
+func TestRoundTrip_LongPollInvalidWait(t *testing.T) {
+	gen := int64(1)
+	var calls int32
+	tr := newTestTransport(newSingleObjectMock(newWatchTestObject(&gen, &calls, 0)), WithLongPoll(10*time.Millisecond, time.Minute, 10))
+	c := &http.Client{Transport: tr}
+
+	resp, err := c.Do(newWatchTestRequest(t, context.Background(), "gs://bucket-name/config.json?wait=forever"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestRoundTrip_LongPollUserProjects(t *testing.T) {
+	// the object looks different for each user project, e.g. because of the permissions.
+	release := make(chan struct{})
+	mock := &storageClientMock{
+		bucketFunc: func(mock *storageClientMock, name string) *bucketHandleMock {
+			return &bucketHandleMock{
+				objectFunc: func(mock *bucketHandleMock, name string) *objectHandleMock {
+					gen := int64(1)
+					if mock.userProject == "project-b" {
+						gen = 2
+					}
+					return &objectHandleMock{
+						attrFunc: func(ctx context.Context, _ *objectHandleMock) (*storage.ObjectAttrs, error) {
+							<-release
+							return &storage.ObjectAttrs{Bucket: "bucket-name", Name: "config.json", Generation: gen}, nil
+						},
+						newReaderFunc: func(ctx context.Context, mock *objectHandleMock) (storage.ReaderObjectAttrs, io.ReadCloser, error) {
+							content := "generation " + strconv.FormatInt(mock.generation, 10)
+							return storage.ReaderObjectAttrs{Generation: mock.generation}, io.NopCloser(strings.NewReader(content)), nil
+						},
+						generationFunc: withGeneration,
+					}
+				},
+			}
+		},
+	}
+	tr := newTestTransport(mock, WithLongPoll(10*time.Millisecond, 100*time.Millisecond, 10))
+	c := &http.Client{Transport: tr}
+
+	status := make(map[string]int)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, project := range []string{"project-a", "project-b"} {
+		project := project
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := newWatchTestRequest(t, context.Background(), "gs://bucket-name/config.json?wait=30s")
+			req.Header.Set("X-Goog-User-Project", project)
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			mu.Lock()
+			status[project] = resp.StatusCode
+			mu.Unlock()
+		}()
+	}
+	// let both of the watches start before the first check returns.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if status["project-a"] != http.StatusNotModified {
+		t.Errorf("unexpected status of project-a: want %d, got %d", http.StatusNotModified, status["project-a"])
+	}
+	if status["project-b"] != http.StatusOK {
+		t.Errorf("unexpected status of project-b: want %d, got %d", http.StatusOK, status["project-b"])
+	}
+}
@@ -0,0 +1,569 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// WithWriteMethods makes the Transport accept the requests that modify objects:
+// PUT gs://[BUCKET_NAME]/[OBJECT_NAME] uploads the request body as the object,
+// or copies the object in the x-goog-copy-source header in Google Cloud Storage,
+// DELETE gs://[BUCKET_NAME]/[OBJECT_NAME] deletes the object, or only the generation with #[GENERATION], and
+// PATCH gs://[BUCKET_NAME]/[OBJECT_NAME] updates the metadata of the object from the request headers, and
+// POST gs://[BUCKET_NAME]/[OBJECT_NAME] composes the objects in the request body into the object.
+// The x-goog-if-generation-match and x-goog-if-metageneration-match headers are the preconditions of the writes,
+// and x-goog-if-generation-match: 0 writes the object only if it doesn't exist.
+// By default, the Transport is read-only and responds 405 Method Not Allowed to them.
+// Use it with WithBucketConfig to allow writing to specific buckets.
+func WithWriteMethods() Option {
+	return func(c *config) {
+		c.writeMethods = true
+	}
+}
+
+// errWriteGeneration is returned if a write request has a generation.
+var errWriteGeneration = fmt.Errorf("gsprotocol: cannot write a specific generation")
+
+// putObject uploads the request body as the object.
+// The Content-Type, Content-Language, Cache-Control, Content-Encoding, Content-Disposition and x-goog-meta-* headers
+// of the request are the attributes of the object. See WithMetadataLimits for the limits of the x-goog-meta-* headers.
+// See parseRetentionHeader for the holds and the custom time.
+// See ifChangedHeader for skipping the upload of the same content,
+// and WithIdempotencyKeys for the retries of the upload.
+// The x-goog-encryption-kms-key-name header encrypts the object with the Cloud KMS key,
+// and the x-goog-encryption-* headers encrypt it with the customer-supplied encryption key.
+func (t *Transport) putObject(req *http.Request, client storageClient) (*http.Response, error) {
+	if req.URL.Fragment != "" {
+		return newErrorResponse(http.StatusBadRequest, errWriteGeneration.Error()), nil
+	}
+	cfg := t.config.forBucket(bucketName(req))
+	// check the headers before uploading any bytes.
+	if err := checkMetadataHeader(req.Header, cfg); err != nil {
+		return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	retention, err := parseRetentionHeader(req.Header)
+	if err != nil {
+		return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	kmsKeyName := req.Header.Get(kmsKeyNameHeader)
+	if kmsKeyName != "" {
+		if err := parseKMSKeyName(kmsKeyName); err != nil {
+			return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+		}
+	}
+	key, err := customerKeyFromHeader(req.Header)
+	if err != nil {
+		return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	if key != nil && kmsKeyName != "" {
+		msg := "gsprotocol: a request cannot have both of the customer-supplied encryption key and the Cloud KMS key"
+		return newErrorResponse(http.StatusBadRequest, msg), nil
+	}
+	conds, hasConds, err := writeConditions(req.Header)
+	if err != nil {
+		return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	ifChanged, err := parseIfChanged(req.Header)
+	if err != nil {
+		return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	idempotencyKey, hasIdempotencyKey := idempotencyCacheKeyOf(req)
+	if hasIdempotencyKey {
+		if attrs, ok := t.idempotencyKeys.get(cfg, idempotencyKey); ok {
+			return newReplayedResponse(attrs), nil
+		}
+	}
+	if src := req.Header.Get(copySourceHeader); src != "" {
+		if kmsKeyName != "" || key != nil {
+			msg := "gsprotocol: a copy request cannot have the encryption headers"
+			return newErrorResponse(http.StatusBadRequest, msg), nil
+		}
+		return t.copyObject(req, client, src, retention)
+	}
+	if cfg.maxUploadSize > 0 && req.ContentLength > cfg.maxUploadSize {
+		return newUploadTooLargeResponse(cfg.maxUploadSize), nil
+	}
+	if ifChanged != "" {
+		attrs, err := unchangedObject(req, client, ifChanged)
+		if err != nil {
+			return handleError(err)
+		}
+		if attrs != nil {
+			return newUnchangedResponse(attrs), nil
+		}
+	}
+
+	// canceling ctx aborts the upload, and the object is not modified.
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+
+	path := objectName(req.URL)
+	object := client.Bucket(bucketName(req)).Object(path)
+	if key != nil {
+		object = object.Key(key)
+	}
+	if hasConds {
+		object = object.If(conds)
+	}
+	w := object.NewWriter(ctx)
+	setObjectAttrsFromHeader(w.ObjectAttrs(), req.Header)
+	retention.apply(w.ObjectAttrs())
+	w.ObjectAttrs().KMSKeyName = kmsKeyName
+
+	var body io.Reader = http.NoBody
+	if req.Body != nil {
+		body = req.Body
+		if cfg.maxUploadSize > 0 {
+			body = http.MaxBytesReader(nil, req.Body, cfg.maxUploadSize)
+		}
+	}
+	n, err := io.Copy(w, body)
+	if err == nil && req.ContentLength > 0 && n != req.ContentLength {
+		err = fmt.Errorf("gsprotocol: the request body is %d bytes, want %d bytes: %w", n, req.ContentLength, io.ErrUnexpectedEOF)
+	}
+	if err != nil {
+		cancel()
+		w.Close()
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return newUploadTooLargeResponse(cfg.maxUploadSize), nil
+		}
+		return handleError(err)
+	}
+	if err := w.Close(); err != nil {
+		return handleError(wrapKMSKeyError(err, kmsKeyName))
+	}
+	if hasIdempotencyKey {
+		t.idempotencyKeys.add(cfg, idempotencyKey, w.Attrs())
+	}
+	t.publishWrite(req, bucketName(req), path, w.Attrs())
+	return newWrittenResponse(w.Attrs()), nil
+}
+
+// WithMaxUploadSize limits the size of the objects uploaded by PUT requests to maxBytes bytes.
+// The Transport responds 413 Request Entity Too Large to the requests whose Content-Length exceeds the limit
+// without uploading any bytes, and aborts the upload as soon as the request body crosses the limit,
+// so that no partial object is left behind.
+// Zero or negative maxBytes means unlimited, the default.
+func WithMaxUploadSize(maxBytes int64) Option {
+	return func(c *config) {
+		c.maxUploadSize = maxBytes
+	}
+}
+
+// newUploadTooLargeResponse returns the 413 Request Entity Too Large response to the upload over the limit.
+func newUploadTooLargeResponse(limit int64) *http.Response {
+	msg := fmt.Sprintf("gsprotocol: the upload is too large, the limit is %d bytes", limit)
+	return newErrorResponse(http.StatusRequestEntityTooLarge, msg)
+}
+
+// copySourceHeader is the header of PUT requests that copies the object in it, e.g. /[BUCKET_NAME]/[OBJECT_NAME]#[GENERATION].
+const copySourceHeader = "X-Goog-Copy-Source"
+
+// copyObject copies the object src to the object of the request, without downloading it.
+// The Content-Type, Content-Language, Cache-Control, Content-Encoding, Content-Disposition, x-goog-meta-*
+// and x-goog-storage-class headers of the request override the attributes of the source object.
+// Copying an object onto itself with x-goog-storage-class changes its storage class.
+// See copySourceConditions for the preconditions of the source object,
+// and a 412 Precondition Failed response tells which of the source and the destination doesn't satisfy them
+// in the x-gsprotocol-error header.
+func (t *Transport) copyObject(req *http.Request, client storageClient, src string, retention retentionAttrs) (*http.Response, error) {
+	if req.ContentLength > 0 {
+		msg := "gsprotocol: a copy request cannot have a body"
+		return newErrorResponse(http.StatusBadRequest, msg), nil
+	}
+	srcBucket, srcName, srcGen, err := parseCopySource(src)
+	if err != nil {
+		return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	srcConds, err := parseCopySourceConditions(req.Header)
+	if err != nil {
+		return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	var class string
+	if v := req.Header.Get("X-Goog-Storage-Class"); v != "" {
+		if class, err = parseStorageClass(v); err != nil {
+			return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+		}
+	}
+	resolved, ok := t.config.resolveBucket(srcBucket)
+	if !ok {
+		return newNotAliasedResponse(srcBucket), nil
+	}
+	srcBucket = resolved
+	if err := t.config.checkBucketAccess(srcBucket); err != nil {
+		return newForbiddenResponse(err), nil
+	}
+	srcObject := client.Bucket(srcBucket).Object(srcName)
+	if srcGen != 0 {
+		srcObject = srcObject.Generation(srcGen)
+	}
+	if !srcConds.empty() {
+		attrs, err := srcObject.Attrs(req.Context())
+		if err != nil {
+			return handleError(err)
+		}
+		if header := srcConds.check(attrs); header != "" {
+			return newSourcePreconditionFailedResponse(header), nil
+		}
+		// copy the generation checked, even if the source object is overwritten.
+		srcObject = client.Bucket(srcBucket).Object(srcName).Generation(attrs.Generation)
+	}
+
+	dst := client.Bucket(bucketName(req)).Object(objectName(req.URL))
+	if conds, ok, _ := writeConditions(req.Header); ok {
+		// putObject has checked the conditions.
+		dst = dst.If(conds)
+	}
+	c := dst.CopierFrom(srcObject)
+	attrs := c.ObjectAttrs()
+	setObjectAttrsFromHeader(attrs, req.Header)
+	retention.apply(attrs)
+	attrs.StorageClass = class
+
+	written, err := c.Run(req.Context())
+	if err != nil {
+		return handleCopyError(err)
+	}
+	t.publishWrite(req, bucketName(req), objectName(req.URL), written)
+	if key, ok := idempotencyCacheKeyOf(req); ok {
+		cfg := t.config.forBucket(bucketName(req))
+		t.idempotencyKeys.add(cfg, key, written)
+	}
+	return newWrittenResponse(written), nil
+}
+
+// parseCopySource returns the bucket name, the object name and the generation of the x-goog-copy-source header,
+// e.g. /[BUCKET_NAME]/[OBJECT_NAME] or /[BUCKET_NAME]/[OBJECT_NAME]#[GENERATION].
+// The bucket and object names are percent-encoded.
+func parseCopySource(src string) (bucket, name string, gen int64, err error) {
+	raw := src
+	var fragment string
+	if i := strings.IndexByte(src, '#'); i >= 0 {
+		src, fragment = src[:i], src[i+1:]
+	}
+	src = strings.TrimPrefix(src, "/")
+	i := strings.IndexByte(src, '/')
+	if i <= 0 || i == len(src)-1 {
+		return "", "", 0, fmt.Errorf("gsprotocol: invalid copy source %q: want /[BUCKET_NAME]/[OBJECT_NAME]", raw)
+	}
+	bucket, err = url.PathUnescape(src[:i])
+	if err != nil {
+		return "", "", 0, fmt.Errorf("gsprotocol: invalid copy source %q: %v", raw, err)
+	}
+	name, err = url.PathUnescape(src[i+1:])
+	if err != nil {
+		return "", "", 0, fmt.Errorf("gsprotocol: invalid copy source %q: %v", raw, err)
+	}
+	if fragment != "" {
+		gen, err = parseGeneration(fragment)
+		if err != nil {
+			return "", "", 0, err
+		}
+	}
+	return bucket, name, gen, nil
+}
+
+// deleteObject deletes the object.
+// gs://[BUCKET_NAME]/[OBJECT_NAME]#[GENERATION] deletes only the generation.
+// See WithBulkDelete for the recursive query parameter.
+// The objects under a hold or a retention policy get 409 Conflict with the x-gsprotocol-error header
+// "temporary-hold", "event-based-hold" or "retention-policy".
+func (t *Transport) deleteObject(req *http.Request, client storageClient) (*http.Response, error) {
+	if req.URL.Query().Has("recursive") {
+		return t.deletePrefix(req, client)
+	}
+	object, err := writeObjectHandleOf(client, req)
+	if err != nil {
+		return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	if err := object.Delete(req.Context()); err != nil {
+		return handleError(wrapRetentionError(req.Context(), object, err))
+	}
+	t.publishWrite(req, bucketName(req), objectName(req.URL), nil)
+	return &http.Response{
+		Status:     "204 No Content",
+		StatusCode: http.StatusNoContent,
+		Proto:      "HTTP/1.0",
+		ProtoMajor: 1,
+		ProtoMinor: 0,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Close:      true,
+	}, nil
+}
+
+// patchObject updates the metadata of the object from the request headers.
+// See objectAttrsToUpdateFromHeader for the headers.
+// The x-goog-storage-class header changes the storage class of the object by rewriting it in place.
+func (t *Transport) patchObject(req *http.Request, client storageClient) (*http.Response, error) {
+	ctx := req.Context()
+	var class string
+	if v := req.Header.Get("X-Goog-Storage-Class"); v != "" {
+		if req.URL.Fragment != "" {
+			return newErrorResponse(http.StatusBadRequest, errWriteGeneration.Error()), nil
+		}
+		var err error
+		if class, err = parseStorageClass(v); err != nil {
+			return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+		}
+	}
+	if err := checkMetadataHeader(req.Header, t.config.forBucket(bucketName(req))); err != nil {
+		return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	object, err := writeObjectHandleOf(client, req)
+	if err != nil {
+		return newErrorResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	if class != "" {
+		return t.changeStorageClass(req, object, class)
+	}
+	// the metadata keys to delete have the empty values,
+	// so one update deletes them, and a failed update leaves the other keys as they are.
+	attrs, err := object.Update(ctx, objectAttrsToUpdateFromHeader(req.Header))
+	if err != nil {
+		return handleError(err)
+	}
+	t.publishWrite(req, bucketName(req), objectName(req.URL), attrs)
+	return newPatchedResponse(attrs), nil
+}
+
+// newPatchedResponse returns the response to a successful PATCH request.
+func newPatchedResponse(attrs *storage.ObjectAttrs) *http.Response {
+	// the response has no body, so it describes the object by the x-goog-stored-* headers.
+	header := makeHeader(attrs)
+	header.Del("Content-Encoding")
+	header.Set("Content-Length", "0")
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.0",
+		ProtoMajor:    1,
+		ProtoMinor:    0,
+		Header:        header,
+		Body:          http.NoBody,
+		ContentLength: 0,
+		Close:         true,
+	}
+}
+
+// objectAttrsToUpdateFromHeader returns the attributes to update from the request header.
+// The Content-Type, Content-Language, Cache-Control, Content-Encoding and Content-Disposition headers
+// in the request are updated, and the empty values clear them.
+// The x-goog-meta-* headers are merged into the existing metadata, and the empty values delete the keys;
+// Google Cloud Storage deletes the keys whose values are empty in the update.
+// The headers absent from the request are left untouched.
+func objectAttrsToUpdateFromHeader(header http.Header) storage.ObjectAttrsToUpdate {
+	var uattrs storage.ObjectAttrsToUpdate
+	if v, ok := header["Content-Type"]; ok {
+		uattrs.ContentType = firstValue(v)
+	}
+	if v, ok := header["Content-Language"]; ok {
+		uattrs.ContentLanguage = firstValue(v)
+	}
+	if v, ok := header["Cache-Control"]; ok {
+		uattrs.CacheControl = firstValue(v)
+	}
+	if v, ok := header["Content-Encoding"]; ok {
+		uattrs.ContentEncoding = firstValue(v)
+	}
+	if v, ok := header["Content-Disposition"]; ok {
+		uattrs.ContentDisposition = firstValue(v)
+	}
+
+	for key, values := range header {
+		name, ok := metadataName(key)
+		if !ok {
+			continue
+		}
+		if uattrs.Metadata == nil {
+			uattrs.Metadata = make(map[string]string)
+		}
+		uattrs.Metadata[name] = firstValue(values)
+	}
+	return uattrs
+}
+
+func firstValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// objectHandleOf returns the handle of the object of req, with the generation in the fragment if any.
+func objectHandleOf(client storageClient, req *http.Request) (objectHandle, error) {
+	path := objectName(req.URL)
+	object := client.Bucket(bucketName(req)).Object(path)
+	gen, err := urlGeneration(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	if gen != 0 {
+		object = object.Generation(gen)
+	}
+	return object, nil
+}
+
+// writeObjectHandleOf returns the handle of the object of req in the same way as objectHandleOf,
+// with the preconditions of the request.
+func writeObjectHandleOf(client storageClient, req *http.Request) (objectHandle, error) {
+	object, err := objectHandleOf(client, req)
+	if err != nil {
+		return nil, err
+	}
+	conds, ok, err := writeConditions(req.Header)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		object = object.If(conds)
+	}
+	return object, nil
+}
+
+// writeConditions returns the preconditions of the write request
+// from the x-goog-if-generation-match and x-goog-if-metageneration-match headers,
+// and whether the request has any of them.
+// x-goog-if-generation-match: 0 means that the object must not exist.
+func writeConditions(header http.Header) (conds storage.Conditions, ok bool, err error) {
+	if v := header.Get("X-Goog-If-Generation-Match"); v != "" {
+		gen, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || gen < 0 {
+			return storage.Conditions{}, false, fmt.Errorf("gsprotocol: invalid x-goog-if-generation-match %q", v)
+		}
+		if gen == 0 {
+			conds.DoesNotExist = true
+		} else {
+			conds.GenerationMatch = gen
+		}
+		ok = true
+	}
+	if v := header.Get("X-Goog-If-Metageneration-Match"); v != "" {
+		metagen, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || metagen <= 0 {
+			return storage.Conditions{}, false, fmt.Errorf("gsprotocol: invalid x-goog-if-metageneration-match %q", v)
+		}
+		if conds.DoesNotExist {
+			return storage.Conditions{}, false, fmt.Errorf("gsprotocol: x-goog-if-metageneration-match cannot be used with x-goog-if-generation-match: 0")
+		}
+		conds.MetagenerationMatch = metagen
+		ok = true
+	}
+	return conds, ok, nil
+}
+
+// setObjectAttrsFromHeader sets the attributes of the object to write from the request header.
+func setObjectAttrsFromHeader(attrs *storage.ObjectAttrs, header http.Header) {
+	attrs.ContentType = header.Get("Content-Type")
+	attrs.ContentLanguage = header.Get("Content-Language")
+	attrs.CacheControl = header.Get("Cache-Control")
+	attrs.ContentEncoding = header.Get("Content-Encoding")
+	attrs.ContentDisposition = header.Get("Content-Disposition")
+	for key, values := range header {
+		if name, ok := metadataName(key); ok && len(values) > 0 {
+			if attrs.Metadata == nil {
+				attrs.Metadata = make(map[string]string)
+			}
+			attrs.Metadata[name] = values[0]
+		}
+	}
+}
+
+// retentionAttrs are the holds and the custom time of the object to write.
+type retentionAttrs struct {
+	temporaryHold  bool
+	eventBasedHold bool
+	customTime     time.Time
+}
+
+// parseRetentionHeader returns the holds and the custom time of the object to write
+// from the x-goog-temporary-hold, x-goog-event-based-hold (true or false) and x-goog-custom-time (RFC 3339) headers,
+// so that they are set atomically at the creation of the object.
+func parseRetentionHeader(header http.Header) (retentionAttrs, error) {
+	var r retentionAttrs
+	var err error
+	if v := header.Get("X-Goog-Temporary-Hold"); v != "" {
+		if r.temporaryHold, err = parseHoldHeader("x-goog-temporary-hold", v); err != nil {
+			return retentionAttrs{}, err
+		}
+	}
+	if v := header.Get("X-Goog-Event-Based-Hold"); v != "" {
+		if r.eventBasedHold, err = parseHoldHeader("x-goog-event-based-hold", v); err != nil {
+			return retentionAttrs{}, err
+		}
+	}
+	if v := header.Get("X-Goog-Custom-Time"); v != "" {
+		if r.customTime, err = time.Parse(time.RFC3339, v); err != nil {
+			return retentionAttrs{}, fmt.Errorf("gsprotocol: invalid x-goog-custom-time %q: want RFC 3339", v)
+		}
+	}
+	return r, nil
+}
+
+func (r retentionAttrs) apply(attrs *storage.ObjectAttrs) {
+	attrs.TemporaryHold = r.temporaryHold
+	attrs.EventBasedHold = r.eventBasedHold
+	attrs.CustomTime = r.customTime
+}
+
+func parseHoldHeader(key, value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("gsprotocol: invalid %s %q: want true or false", key, value)
+}
+
+// metadataName returns the name of the custom metadata of the x-goog-meta-* header key.
+// The names are lower case, the same as the XML API of Google Cloud Storage.
+func metadataName(key string) (string, bool) {
+	const prefix = "x-goog-meta-"
+	if len(key) <= len(prefix) || !strings.EqualFold(key[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.ToLower(key[len(prefix):]), true
+}
+
+// newWrittenResponse returns the response to a successful write request,
+// with the validators of the object written.
+func newWrittenResponse(attrs *storage.ObjectAttrs) *http.Response {
+	header := makeHeader(attrs)
+	pruneHeader(header, pruneWritten)
+	// echo the retention attributes stored, so that the callers can confirm them.
+	if attrs.TemporaryHold {
+		header.Set("X-Goog-Temporary-Hold", "true")
+	}
+	if attrs.EventBasedHold {
+		header.Set("X-Goog-Event-Based-Hold", "true")
+	}
+	if v := attrs.CustomTime; !v.IsZero() {
+		header.Set("X-Goog-Custom-Time", v.Format(time.RFC3339Nano))
+	}
+	// the key name used, for the audit.
+	if v := attrs.KMSKeyName; v != "" {
+		header.Set("X-Goog-Kms-Key-Name", v)
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.0",
+		ProtoMajor:    1,
+		ProtoMinor:    0,
+		Header:        header,
+		Body:          http.NoBody,
+		ContentLength: 0,
+		Close:         true,
+	}
+}
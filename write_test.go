@@ -0,0 +1,838 @@
+package gsprotocol
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+func TestRoundTrip_PutDisabled(t *testing.T) {
+	tr := newTestTransport(newSingleObjectMock(&objectHandleMock{}))
+	req, err := http.NewRequest(http.MethodPut, "gs://bucket-name/object-key", strings.NewReader("Hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+	if got, want := resp.Header.Get("Allow"), "GET, HEAD, OPTIONS"; got != want {
+		t.Errorf("unexpected Allow: want %q, got %q", want, got)
+	}
+}
+
+func TestRoundTrip_Options(t *testing.T) {
+	tests := []struct {
+		opts  []Option
+		allow string
+	}{
+		{
+			allow: "GET, HEAD, OPTIONS",
+		},
+		{
+			opts:  []Option{WithWriteMethods()},
+			allow: "GET, HEAD, PUT, DELETE, PATCH, POST, OPTIONS",
+		},
+	}
+	for _, tt := range tests {
+		tr := newTestTransport(newSingleObjectMock(&objectHandleMock{}), tt.opts...)
+		req, err := http.NewRequest(http.MethodOptions, "gs://bucket-name/object-key", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("unexpected status: want %d, got %d", http.StatusNoContent, resp.StatusCode)
+		}
+		if got := resp.Header.Get("Allow"); got != tt.allow {
+			t.Errorf("unexpected Allow: want %q, got %q", tt.allow, got)
+		}
+
+		// the methods not allowed get 405 with the same Allow header.
+		req, err = http.NewRequest(http.MethodTrace, "gs://bucket-name/object-key", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err = tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("unexpected status: want %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+		}
+		if got := resp.Header.Get("Allow"); got != tt.allow {
+			t.Errorf("unexpected Allow: want %q, got %q", tt.allow, got)
+		}
+	}
+}
+
+func TestRoundTrip_Put(t *testing.T) {
+	var w *storageWriterMock
+	object := &objectHandleMock{
+		newWriterFunc: func(ctx context.Context, mock *objectHandleMock) *storageWriterMock {
+			w = &storageWriterMock{
+				ctx: ctx,
+				closeFunc: func(w *storageWriterMock) (*storage.ObjectAttrs, error) {
+					attrs := w.attrs
+					attrs.Bucket = "bucket-name"
+					attrs.Name = "object-key"
+					attrs.Size = int64(w.buf.Len())
+					attrs.Generation = 1587160158394554
+					attrs.Metageneration = 1
+					attrs.MD5 = []byte{0x0b, 0x46, 0xf3, 0x06, 0xe9, 0x2d, 0x88, 0x51, 0x5e, 0x06, 0xd4, 0x8a, 0x62, 0xdc, 0xc3, 0x19}
+					attrs.CRC32C = 0x7f762fe2
+					attrs.Updated = time.Date(2020, time.April, 18, 12, 34, 56, 0, time.UTC)
+					return &attrs, nil
+				},
+			}
+			return w
+		},
+	}
+	tr := newTestTransport(newSingleObjectMock(object), WithWriteMethods())
+
+	req, err := http.NewRequest(http.MethodPut, "gs://bucket-name/object-key", strings.NewReader("Hello Google Cloud Storage!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Cache-Control", "no-cache")
+	req.Header.Set("Content-Encoding", "identity")
+	req.Header.Set("Content-Disposition", "inline")
+	req.Header.Set("X-Goog-Meta-Foo", "bar")
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if got := w.buf.String(); got != "Hello Google Cloud Storage!" {
+		t.Errorf("unexpected content: %q", got)
+	}
+	if w.attrs.ContentType != "text/plain" || w.attrs.CacheControl != "no-cache" ||
+		w.attrs.ContentEncoding != "identity" || w.attrs.ContentDisposition != "inline" {
+		t.Errorf("unexpected attributes: %#v", w.attrs)
+	}
+	if got := w.attrs.Metadata["foo"]; got != "bar" {
+		t.Errorf("unexpected metadata: %v", w.attrs.Metadata)
+	}
+	if got := resp.Header.Get("x-goog-generation"); got != "1587160158394554" {
+		t.Errorf("unexpected generation: %q", got)
+	}
+	if got := resp.Header.Get("ETag"); got != `"0b46f306e92d88515e06d48a62dcc319"` {
+		t.Errorf("unexpected ETag: %q", got)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "" {
+		t.Errorf("the response has no body, but Content-Type is %q", got)
+	}
+}
+
+func TestRoundTrip_PutRetention(t *testing.T) {
+	var w *storageWriterMock
+	object := &objectHandleMock{
+		newWriterFunc: func(ctx context.Context, mock *objectHandleMock) *storageWriterMock {
+			w = &storageWriterMock{
+				ctx: ctx,
+				closeFunc: func(w *storageWriterMock) (*storage.ObjectAttrs, error) {
+					attrs := w.attrs
+					attrs.Generation = 1587160158394554
+					return &attrs, nil
+				},
+			}
+			return w
+		},
+	}
+	tr := newTestTransport(newSingleObjectMock(object), WithWriteMethods())
+
+	req, err := http.NewRequest(http.MethodPut, "gs://bucket-name/object-key", strings.NewReader("Hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Goog-Temporary-Hold", "true")
+	req.Header.Set("X-Goog-Event-Based-Hold", "false")
+	req.Header.Set("X-Goog-Custom-Time", "2020-04-18T12:34:56Z")
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	customTime := time.Date(2020, time.April, 18, 12, 34, 56, 0, time.UTC)
+	if !w.attrs.TemporaryHold || w.attrs.EventBasedHold || !w.attrs.CustomTime.Equal(customTime) {
+		t.Errorf("unexpected attributes: %#v", w.attrs)
+	}
+	if got := resp.Header.Get("X-Goog-Temporary-Hold"); got != "true" {
+		t.Errorf("unexpected x-goog-temporary-hold: %q", got)
+	}
+	if got := resp.Header.Get("X-Goog-Event-Based-Hold"); got != "" {
+		t.Errorf("unexpected x-goog-event-based-hold: %q", got)
+	}
+	if got := resp.Header.Get("X-Goog-Custom-Time"); got != "2020-04-18T12:34:56Z" {
+		t.Errorf("unexpected x-goog-custom-time: %q", got)
+	}
+
+	tests := []struct {
+		key, value string
+	}{
+		{"X-Goog-Temporary-Hold", "yes"},
+		{"X-Goog-Event-Based-Hold", "1"},
+		{"X-Goog-Custom-Time", "2020-04-18"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			// the writer must not be created.
+			tr := newTestTransport(newSingleObjectMock(&objectHandleMock{}), WithWriteMethods())
+			req, err := http.NewRequest(http.MethodPut, "gs://bucket-name/object-key", strings.NewReader("Hello"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set(tt.key, tt.value)
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("unexpected status: want %d, got %d", http.StatusBadRequest, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestRoundTrip_PutError(t *testing.T) {
+	t.Run("upload error", func(t *testing.T) {
+		var w *storageWriterMock
+		object := &objectHandleMock{
+			newWriterFunc: func(ctx context.Context, mock *objectHandleMock) *storageWriterMock {
+				w = &storageWriterMock{
+					ctx: ctx,
+					closeFunc: func(w *storageWriterMock) (*storage.ObjectAttrs, error) {
+						return nil, &googleapi.Error{Code: http.StatusForbidden, Message: "forbidden"}
+					},
+				}
+				return w
+			},
+		}
+		tr := newTestTransport(newSingleObjectMock(object), WithWriteMethods())
+		req, err := http.NewRequest(http.MethodPut, "gs://bucket-name/object-key", strings.NewReader("Hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("unexpected status: want %d, got %d", http.StatusForbidden, resp.StatusCode)
+		}
+		if !w.closed {
+			t.Error("the writer is not closed")
+		}
+	})
+
+	t.Run("truncated body", func(t *testing.T) {
+		var w *storageWriterMock
+		object := &objectHandleMock{
+			newWriterFunc: func(ctx context.Context, mock *objectHandleMock) *storageWriterMock {
+				w = &storageWriterMock{
+					ctx: ctx,
+					closeFunc: func(w *storageWriterMock) (*storage.ObjectAttrs, error) {
+						t.Error("the upload must be aborted")
+						return &w.attrs, nil
+					},
+				}
+				return w
+			},
+		}
+		tr := newTestTransport(newSingleObjectMock(object), WithWriteMethods())
+		req, err := http.NewRequest(http.MethodPut, "gs://bucket-name/object-key", strings.NewReader("Hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.ContentLength = 100
+		_, err = tr.RoundTrip(req)
+		if err == nil {
+			t.Fatal("want error, got nil")
+		}
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("want io.ErrUnexpectedEOF, got %v", err)
+		}
+		if !w.closed {
+			t.Error("the writer is not closed")
+		}
+	})
+
+	t.Run("generation", func(t *testing.T) {
+		tr := newTestTransport(newSingleObjectMock(&objectHandleMock{}), WithWriteMethods())
+		req, err := http.NewRequest(http.MethodPut, "gs://bucket-name/object-key#1587160158394554", strings.NewReader("Hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("unexpected status: want %d, got %d", http.StatusBadRequest, resp.StatusCode)
+		}
+	})
+}
+
+func TestRoundTrip_Delete(t *testing.T) {
+	var deleted []int64
+	object := &objectHandleMock{
+		deleteFunc: func(ctx context.Context, mock *objectHandleMock) error {
+			deleted = append(deleted, mock.generation)
+			return nil
+		},
+		generationFunc: withGeneration,
+	}
+	missing := &objectHandleMock{
+		deleteFunc: func(ctx context.Context, mock *objectHandleMock) error {
+			return storage.ErrObjectNotExist
+		},
+	}
+	forbidden := &objectHandleMock{
+		deleteFunc: func(ctx context.Context, mock *objectHandleMock) error {
+			return &googleapi.Error{Code: http.StatusForbidden, Message: "forbidden"}
+		},
+	}
+	mock := &storageClientMock{
+		bucketFunc: func(mock *storageClientMock, name string) *bucketHandleMock {
+			return &bucketHandleMock{
+				objectFunc: func(mock *bucketHandleMock, name string) *objectHandleMock {
+					switch name {
+					case "object-key":
+						return object
+					case "forbidden-key":
+						return forbidden
+					}
+					return missing
+				},
+			}
+		},
+	}
+
+	tests := []struct {
+		url    string
+		status int
+	}{
+		{"gs://bucket-name/object-key", http.StatusNoContent},
+		{"gs://bucket-name/object-key#1587160158394554", http.StatusNoContent},
+		{"gs://bucket-name/object-key#invalid", http.StatusBadRequest},
+		{"gs://bucket-name/missing-key", http.StatusNotFound},
+		{"gs://bucket-name/forbidden-key", http.StatusForbidden},
+	}
+	tr := newTestTransport(mock, WithWriteMethods())
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodDelete, tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: unexpected status: want %d, got %d", tt.url, tt.status, resp.StatusCode)
+		}
+	}
+	if len(deleted) != 2 || deleted[0] != 0 || deleted[1] != 1587160158394554 {
+		t.Errorf("unexpected deleted generations: %v", deleted)
+	}
+
+	// DELETE is not allowed without WithWriteMethods.
+	req, err := http.NewRequest(http.MethodDelete, "gs://bucket-name/object-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (newTestTransport(mock)).RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
+
+func TestRoundTrip_Patch(t *testing.T) {
+	type update struct {
+		attrs storage.ObjectAttrsToUpdate
+	}
+	var updates []update
+	var updateErr error
+	current := &storage.ObjectAttrs{
+		Bucket:         "bucket-name",
+		Name:           "object-key",
+		ContentType:    "text/plain",
+		Size:           27,
+		Metageneration: 3,
+		Metadata:       map[string]string{"foo": "bar", "hoge": "fuga"},
+	}
+	object := &objectHandleMock{
+		updateFunc: func(ctx context.Context, mock *objectHandleMock, attrs storage.ObjectAttrsToUpdate) (*storage.ObjectAttrs, error) {
+			updates = append(updates, update{attrs: attrs})
+			if updateErr != nil {
+				return nil, updateErr
+			}
+			updated := *current
+			updated.Metageneration += int64(len(updates))
+			if v, ok := attrs.CacheControl.(string); ok {
+				updated.CacheControl = v
+			}
+			return &updated, nil
+		},
+	}
+	mock := newSingleObjectMock(object)
+	tr := newTestTransport(mock, WithWriteMethods())
+	patch := func(t *testing.T, header map[string]string) *http.Response {
+		t.Helper()
+		updates = nil
+		req, err := http.NewRequest(http.MethodPatch, "gs://bucket-name/object-key", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header[http.CanonicalHeaderKey(k)] = []string{v}
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		return resp
+	}
+
+	t.Run("update", func(t *testing.T) {
+		resp := patch(t, map[string]string{
+			"Cache-Control":   "no-cache",
+			"X-Goog-Meta-Foo": "baz",
+		})
+		if len(updates) != 1 {
+			t.Fatalf("want 1 update, got %d", len(updates))
+		}
+		u := updates[0]
+		if u.attrs.CacheControl != "no-cache" {
+			t.Errorf("unexpected CacheControl: %v", u.attrs.CacheControl)
+		}
+		if u.attrs.ContentType != nil || u.attrs.ContentDisposition != nil {
+			t.Errorf("the absent headers must be left untouched: %#v", u.attrs)
+		}
+		if len(u.attrs.Metadata) != 1 || u.attrs.Metadata["foo"] != "baz" {
+			t.Errorf("unexpected Metadata: %v", u.attrs.Metadata)
+		}
+		if got := resp.Header.Get("Cache-Control"); got != "no-cache" {
+			t.Errorf("the response has the stale Cache-Control: %q", got)
+		}
+		if got := resp.Header.Get("Content-Length"); got != "0" {
+			t.Errorf("unexpected Content-Length: %q", got)
+		}
+	})
+
+	t.Run("clear", func(t *testing.T) {
+		patch(t, map[string]string{
+			"Content-Disposition": "",
+		})
+		if len(updates) != 1 {
+			t.Fatalf("want 1 update, got %d", len(updates))
+		}
+		if updates[0].attrs.ContentDisposition != "" {
+			t.Errorf("unexpected ContentDisposition: %#v", updates[0].attrs.ContentDisposition)
+		}
+	})
+
+	t.Run("delete a key", func(t *testing.T) {
+		patch(t, map[string]string{
+			"X-Goog-Meta-Foo":  "",
+			"X-Goog-Meta-Hoge": "piyo",
+		})
+		if len(updates) != 1 {
+			t.Fatalf("want 1 update, got %d", len(updates))
+		}
+		m := updates[0].attrs.Metadata
+		if v, ok := m["foo"]; len(m) != 2 || !ok || v != "" || m["hoge"] != "piyo" {
+			t.Errorf("the update must delete the key by the empty value: %v", m)
+		}
+	})
+
+	t.Run("failed update", func(t *testing.T) {
+		updateErr = &googleapi.Error{Code: http.StatusPreconditionFailed}
+		defer func() { updateErr = nil }()
+		updates = nil
+		req, err := http.NewRequest(http.MethodPatch, "gs://bucket-name/object-key", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Goog-Meta-Foo", "")
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusPreconditionFailed {
+			t.Errorf("unexpected status: want %d, got %d", http.StatusPreconditionFailed, resp.StatusCode)
+		}
+		// no update clears the metadata before the failed one.
+		if len(updates) != 1 {
+			t.Fatalf("want 1 update, got %d", len(updates))
+		}
+		if m := updates[0].attrs.Metadata; len(m) != 1 {
+			t.Errorf("the update must touch only the deleted key: %v", m)
+		}
+	})
+}
+
+func TestRoundTrip_Copy(t *testing.T) {
+	type copied struct {
+		src, dst     string
+		srcGen       int64
+		storageClass string
+		contentType  string
+	}
+	var runs []copied
+	names := make(map[*objectHandleMock]string)
+	var newObject func(key string) *objectHandleMock
+	newObject = func(key string) *objectHandleMock {
+		object := &objectHandleMock{
+			generationFunc: func(mock *objectHandleMock, gen int64) *objectHandleMock {
+				cp := *mock
+				cp.generation = gen
+				names[&cp] = names[mock]
+				return &cp
+			},
+			copierFunc: func(dst *objectHandleMock, src *objectHandleMock) *storageCopierMock {
+				return &storageCopierMock{
+					runFunc: func(ctx context.Context, c *storageCopierMock) (*storage.ObjectAttrs, error) {
+						if names[src] == "src-bucket/missing-key" {
+							return nil, storage.ErrObjectNotExist
+						}
+						runs = append(runs, copied{
+							src:          names[src],
+							dst:          names[dst],
+							srcGen:       src.generation,
+							storageClass: c.attrs.StorageClass,
+							contentType:  c.attrs.ContentType,
+						})
+						return &storage.ObjectAttrs{
+							Bucket:         "bucket-name",
+							Name:           "object-key",
+							Generation:     1587160158394555,
+							Metageneration: 1,
+							MD5:            []byte{0x0b, 0x46, 0xf3, 0x06, 0xe9, 0x2d, 0x88, 0x51, 0x5e, 0x06, 0xd4, 0x8a, 0x62, 0xdc, 0xc3, 0x19},
+							Updated:        time.Date(2020, time.April, 18, 12, 34, 56, 0, time.UTC),
+						}, nil
+					},
+				}
+			},
+		}
+		names[object] = key
+		return object
+	}
+	mock := &storageClientMock{
+		bucketFunc: func(mock *storageClientMock, bucket string) *bucketHandleMock {
+			return &bucketHandleMock{
+				objectFunc: func(mock *bucketHandleMock, name string) *objectHandleMock {
+					return newObject(bucket + "/" + name)
+				},
+			}
+		},
+	}
+	tr := newTestTransport(mock, WithWriteMethods())
+
+	t.Run("copy", func(t *testing.T) {
+		runs = nil
+		req, err := http.NewRequest(http.MethodPut, "gs://bucket-name/object-key", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Goog-Copy-Source", "/src-bucket/src%20key#1587160158394554")
+		req.Header.Set("Content-Type", "text/plain")
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		if got, want := resp.Header.Get("X-Goog-Generation"), "1587160158394555"; got != want {
+			t.Errorf("unexpected X-Goog-Generation: want %q, got %q", want, got)
+		}
+		if got, want := resp.Header.Get("Etag"), `"0b46f306e92d88515e06d48a62dcc319"`; got != want {
+			t.Errorf("unexpected Etag: want %q, got %q", want, got)
+		}
+		want := []copied{{
+			src:         "src-bucket/src key",
+			dst:         "bucket-name/object-key",
+			srcGen:      1587160158394554,
+			contentType: "text/plain",
+		}}
+		if !reflect.DeepEqual(runs, want) {
+			t.Errorf("unexpected copies: want %#v, got %#v", want, runs)
+		}
+	})
+
+	t.Run("storage class", func(t *testing.T) {
+		runs = nil
+		req, err := http.NewRequest(http.MethodPut, "gs://bucket-name/object-key", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Goog-Copy-Source", "/bucket-name/object-key")
+		req.Header.Set("X-Goog-Storage-Class", "nearline")
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		want := []copied{{
+			src:          "bucket-name/object-key",
+			dst:          "bucket-name/object-key",
+			storageClass: "NEARLINE",
+		}}
+		if !reflect.DeepEqual(runs, want) {
+			t.Errorf("unexpected copies: want %#v, got %#v", want, runs)
+		}
+	})
+
+	t.Run("invalid storage class", func(t *testing.T) {
+		runs = nil
+		req, err := http.NewRequest(http.MethodPut, "gs://bucket-name/object-key", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Goog-Copy-Source", "/bucket-name/object-key")
+		req.Header.Set("X-Goog-Storage-Class", "FROZEN")
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("unexpected status: want %d, got %d", http.StatusBadRequest, resp.StatusCode)
+		}
+		if len(runs) != 0 {
+			t.Errorf("want no copies, got %#v", runs)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		src    string
+		status int
+	}{
+		{"missing", "/src-bucket/missing-key", http.StatusNotFound},
+		{"no object", "/src-bucket/", http.StatusBadRequest},
+		{"no bucket", "/src-key", http.StatusBadRequest},
+		{"invalid generation", "/src-bucket/src-key#foo", http.StatusBadRequest},
+		{"invalid encoding", "/src-bucket/src%zzkey", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPut, "gs://bucket-name/object-key", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-Goog-Copy-Source", tt.src)
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("unexpected status: want %d, got %d", tt.status, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestRoundTrip_WritePreconditions(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		conds  storage.Conditions
+		status int
+	}{
+		{
+			name:   "does not exist",
+			header: http.Header{"X-Goog-If-Generation-Match": {"0"}},
+			conds:  storage.Conditions{DoesNotExist: true},
+			status: http.StatusOK,
+		},
+		{
+			name:   "generation match",
+			header: http.Header{"X-Goog-If-Generation-Match": {"1587160158394554"}, "X-Goog-If-Metageneration-Match": {"2"}},
+			conds:  storage.Conditions{GenerationMatch: 1587160158394554, MetagenerationMatch: 2},
+			status: http.StatusOK,
+		},
+		{
+			name:   "invalid generation",
+			header: http.Header{"X-Goog-If-Generation-Match": {"latest"}},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "does not exist with metageneration",
+			header: http.Header{"X-Goog-If-Generation-Match": {"0"}, "X-Goog-If-Metageneration-Match": {"2"}},
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var conds storage.Conditions
+			object := &objectHandleMock{
+				newWriterFunc: func(ctx context.Context, mock *objectHandleMock) *storageWriterMock {
+					conds = mock.conds
+					return &storageWriterMock{
+						ctx: ctx,
+						closeFunc: func(w *storageWriterMock) (*storage.ObjectAttrs, error) {
+							return &storage.ObjectAttrs{Generation: 1587160158394555}, nil
+						},
+					}
+				},
+			}
+			tr := newTestTransport(newSingleObjectMock(object), WithWriteMethods())
+			req, err := http.NewRequest(http.MethodPut, "gs://bucket-name/object-key", strings.NewReader("Hello"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("unexpected status: want %d, got %d", tt.status, resp.StatusCode)
+			}
+			if conds != tt.conds {
+				t.Errorf("unexpected conditions: want %+v, got %+v", tt.conds, conds)
+			}
+		})
+	}
+}
+
+func TestRoundTrip_PutMaxUploadSize(t *testing.T) {
+	t.Run("content length", func(t *testing.T) {
+		// the writer must not be created.
+		tr := newTestTransport(newSingleObjectMock(&objectHandleMock{}), WithWriteMethods(), WithMaxUploadSize(4))
+		req, err := http.NewRequest(http.MethodPut, "gs://bucket-name/object-key", strings.NewReader("Hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Errorf("unexpected status: want %d, got %d", http.StatusRequestEntityTooLarge, resp.StatusCode)
+		}
+	})
+
+	t.Run("body", func(t *testing.T) {
+		var w *storageWriterMock
+		object := &objectHandleMock{
+			newWriterFunc: func(ctx context.Context, mock *objectHandleMock) *storageWriterMock {
+				w = &storageWriterMock{
+					ctx: ctx,
+					closeFunc: func(w *storageWriterMock) (*storage.ObjectAttrs, error) {
+						t.Error("the upload must be aborted")
+						return &w.attrs, nil
+					},
+				}
+				return w
+			},
+		}
+		tr := newTestTransport(newSingleObjectMock(object), WithWriteMethods(), WithMaxUploadSize(4))
+		// the size of the body is unknown.
+		req, err := http.NewRequest(http.MethodPut, "gs://bucket-name/object-key", io.MultiReader(strings.NewReader("Hello")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Errorf("unexpected status: want %d, got %d", http.StatusRequestEntityTooLarge, resp.StatusCode)
+		}
+		if !w.closed {
+			t.Error("the writer is not closed")
+		}
+		if w.buf.Len() > 4 {
+			t.Errorf("the writer got %d bytes over the limit", w.buf.Len())
+		}
+	})
+
+	t.Run("within the limit", func(t *testing.T) {
+		object := &objectHandleMock{
+			newWriterFunc: func(ctx context.Context, mock *objectHandleMock) *storageWriterMock {
+				return &storageWriterMock{
+					ctx: ctx,
+					closeFunc: func(w *storageWriterMock) (*storage.ObjectAttrs, error) {
+						return &storage.ObjectAttrs{Generation: 1587160158394554}, nil
+					},
+				}
+			},
+		}
+		tr := newTestTransport(newSingleObjectMock(object), WithWriteMethods(), WithMaxUploadSize(5))
+		req, err := http.NewRequest(http.MethodPut, "gs://bucket-name/object-key", io.MultiReader(strings.NewReader("Hello")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("unexpected status: want %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+	})
+}
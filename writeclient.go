@@ -0,0 +1,62 @@
+package gsprotocol
+
+import (
+	"fmt"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+)
+
+// WithWriteClient makes the Transport serve the write requests, PUT, DELETE, PATCH and POST, including copies,
+// with client, while GET and HEAD requests and listings keep using the storage client of the Transport,
+// e.g. to separate the service account of publishing from the read-only one of serving.
+// Once WithWriteClient is given, the Transport responds 403 Forbidden to the write requests for the buckets
+// without the write client, e.g. with WithBucketConfig(bucket, BucketConfig{WithWriteClient(nil)}),
+// instead of falling back to the storage client of the Transport.
+// The requests recorded by WithRequestRecorder are labeled by RequestRecord.Client.
+// The Transport never closes client.
+func WithWriteClient(client *storage.Client) Option {
+	if client == nil {
+		return WithWriteStorage(nil)
+	}
+	return WithWriteStorage(newStorageClientImpl(client))
+}
+
+// WithWriteStorage is WithWriteClient with the storage client for NewTransportWithStorage, e.g. a fake.
+func WithWriteStorage(client StorageClient) Option {
+	return func(c *config) {
+		c.writeClient = client
+		c.splitClients = true
+	}
+}
+
+// the labels of the storage clients, recorded in RequestRecord.Client.
+const (
+	clientLabelRead  = "read"
+	clientLabelWrite = "write"
+)
+
+// writeClientFor returns the storage client for the write request to the bucket.
+// It returns nil response if client serves the request, without WithWriteClient.
+func (t *Transport) writeClientFor(client storageClient, bucket string, cfg *config) (storageClient, *http.Response) {
+	if !t.config.splitClients {
+		return client, nil
+	}
+	if cfg.writeClient == nil {
+		msg := fmt.Sprintf("gsprotocol: no write client is configured for the bucket %q", bucket)
+		return nil, newErrorResponse(http.StatusForbidden, msg)
+	}
+	return cfg.writeClient, nil
+}
+
+// clientLabel returns the label of the storage client that serves req.
+// It returns the empty string without WithWriteClient.
+func (t *Transport) clientLabel(req *http.Request) string {
+	if !t.config.splitClients {
+		return ""
+	}
+	if isWriteMethod(req.Method) {
+		return clientLabelWrite
+	}
+	return clientLabelRead
+}
@@ -0,0 +1,84 @@
+package gsprotocol
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestRoundTrip_WriteClient(t *testing.T) {
+	read := newStorageClientMockWithObjects(map[string]mockObject{
+		"bucket-name/object-key": {
+			attrs:   &storage.ObjectAttrs{Generation: 1},
+			content: "Hello Google Cloud Storage!",
+		},
+	})
+	var written []string
+	write := &storageClientMock{
+		bucketFunc: func(mock *storageClientMock, bucket string) *bucketHandleMock {
+			return &bucketHandleMock{
+				objectFunc: func(mock *bucketHandleMock, name string) *objectHandleMock {
+					return &objectHandleMock{
+						newWriterFunc: func(ctx context.Context, mock *objectHandleMock) *storageWriterMock {
+							return &storageWriterMock{
+								ctx: ctx,
+								closeFunc: func(w *storageWriterMock) (*storage.ObjectAttrs, error) {
+									written = append(written, bucket+"/"+name)
+									return &storage.ObjectAttrs{Generation: 2}, nil
+								},
+							}
+						},
+					}
+				},
+			}
+		},
+	}
+	labels := make(map[string]string)
+	tr := newTestTransport(read,
+		WithWriteMethods(),
+		WithWriteStorage(write),
+		WithBucketConfig("read-only", BucketConfig{WithWriteClient(nil)}),
+		WithRequestRecorder(func(record *RequestRecord) {
+			labels[record.Method+" "+record.Bucket] = record.Client
+		}),
+	)
+
+	tests := []struct {
+		method string
+		url    string
+		status int
+		label  string
+	}{
+		{http.MethodGet, "gs://bucket-name/object-key", http.StatusOK, clientLabelRead},
+		{http.MethodPut, "gs://bucket-name/object-key", http.StatusOK, clientLabelWrite},
+		{http.MethodPut, "gs://read-only/object-key", http.StatusForbidden, clientLabelWrite},
+	}
+	for _, tt := range tests {
+		var body io.Reader
+		if tt.method == http.MethodPut {
+			body = strings.NewReader("Hello")
+		}
+		req, err := http.NewRequest(tt.method, tt.url, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s: unexpected status: want %d, got %d", tt.method, tt.url, tt.status, resp.StatusCode)
+		}
+		if got := labels[tt.method+" "+req.URL.Host]; got != tt.label {
+			t.Errorf("%s %s: unexpected label: want %q, got %q", tt.method, tt.url, tt.label, got)
+		}
+	}
+	if len(written) != 1 || written[0] != "bucket-name/object-key" {
+		t.Errorf("unexpected writes by the write client: %v", written)
+	}
+}